@@ -6,12 +6,15 @@ package attestation
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"math/big"
 	"slices"
 	"strings"
@@ -60,7 +63,6 @@ const (
 	// See: https://github.com/NVIDIA/nvtrust/blob/d0d6536e8e52c53f5302d15db6074ff66d36adeb/guest_tools/gpu_verifiers/local_gpu_verifier/src/verifier/attestation/spdm_msrt_resp_msg.py#L162
 	dmtfMeasurementSpecification                = 0x01
 	spdmGetRequestMeasurementRequestMessageSize = 37
-	signatureLength                             = 96
 )
 
 // VerificationSettings holds the configuration to verify a GPU attestation report.
@@ -179,6 +181,42 @@ func (r *Report) ChipSKU() string {
 	return opaqueDataToString(r.SPDMResponse.OpaqueData.Fields[OpaqueFieldIDChipSku])
 }
 
+// ChipSKUMod returns the chip SKU modifier.
+func (r *Report) ChipSKUMod() string {
+	return opaqueDataToString(r.SPDMResponse.OpaqueData.Fields[OpaqueFieldIDChipSkuMod])
+}
+
+// BoardID returns the board ID.
+func (r *Report) BoardID() string {
+	return opaqueDataToString(r.SPDMResponse.OpaqueData.Fields[OpaqueFieldIDBoardID])
+}
+
+// Summary holds the known opaque fields of a report in a human-readable format.
+type Summary struct {
+	DriverVersion string `json:"driverVersion"`
+	VBIOSVersion  string `json:"vbiosVersion"`
+	Project       string `json:"project"`
+	ProjectSKU    string `json:"projectSKU"`
+	ChipSKU       string `json:"chipSKU"`
+	ChipSKUMod    string `json:"chipSKUMod"`
+	BoardID       string `json:"boardID"`
+}
+
+// Summary returns the known opaque fields of the report, e.g., for inventory and debugging purposes.
+// Fields that can't be decoded are left empty.
+func (r *Report) Summary() Summary {
+	vbiosVersion, _ := r.VBIOSVersion()
+	return Summary{
+		DriverVersion: r.DriverVersion(),
+		VBIOSVersion:  vbiosVersion,
+		Project:       r.Project(),
+		ProjectSKU:    r.ProjectSKU(),
+		ChipSKU:       r.ChipSKU(),
+		ChipSKUMod:    r.ChipSKUMod(),
+		BoardID:       r.BoardID(),
+	}
+}
+
 // VBIOSVersion returns the VBIOS version in the format "XX.XX.XX.XX".
 func (r *Report) VBIOSVersion() (string, error) {
 	opaqueFieldVBIOS, ok := r.SPDMResponse.OpaqueData.Fields[OpaqueFieldIDVbiosVersion].([]byte)
@@ -268,31 +306,54 @@ func (r *Report) ValidateMeasurements(vbiosRefs, driverRefs *rim.SoftwareIdentit
 }
 
 // verifySignature checks the signature of the report against the provided signing certificate.
+// The hash function and signature size are derived from the curve of the certificate's public key.
 func (r *Report) verifySignature(signingCert *x509.Certificate) error {
+	pubKey, ok := signingCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("invalid public key type: expected ecdsa.PublicKey, got %T", signingCert.PublicKey)
+	}
+	newHash, signatureLength, err := signatureParams(pubKey)
+	if err != nil {
+		return err
+	}
+
 	if len(r.ResponseData) < signatureLength {
 		return fmt.Errorf("invalid report: response data too short, expected at least %d bytes, got %d", signatureLength, len(r.ResponseData))
 	}
+	signedLength := len(r.ResponseData) - signatureLength
 
-	toVerify := make([]byte, len(r.RequestData)+len(r.ResponseData)-signatureLength)
-	copy(toVerify, r.RequestData)
-	copy(toVerify[len(r.RequestData):], r.ResponseData[:len(r.ResponseData)-signatureLength])
-	digest := sha512.Sum384(toVerify)
+	h := newHash()
+	h.Write(r.RequestData)
+	h.Write(r.ResponseData[:signedLength])
+	digest := h.Sum(nil)
 
-	signature := r.SPDMResponse.Signature
-	sigR := big.NewInt(0).SetBytes(signature[:len(signature)/2])
-	sigS := big.NewInt(0).SetBytes(signature[len(signature)/2:])
+	// The signature is the raw concatenation of r and s, each padded to the size of the curve.
+	signature := r.ResponseData[signedLength:]
+	sigR := big.NewInt(0).SetBytes(signature[:signatureLength/2])
+	sigS := big.NewInt(0).SetBytes(signature[signatureLength/2:])
 
-	pubKey, ok := signingCert.PublicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("invalid public key type: expected ecdsa.PublicKey, got %T", signingCert.PublicKey)
-	}
-	if !ecdsa.Verify(pubKey, digest[:], sigR, sigS) {
+	if !ecdsa.Verify(pubKey, digest, sigR, sigS) {
 		return errors.New("ECDSA signature verification failed")
 	}
 
 	return nil
 }
 
+// signatureParams returns the hash function and the length of a raw ECDSA signature for the curve of pubKey.
+// Hopper GPUs sign their reports using P-384 with SHA-384.
+func signatureParams(pubKey *ecdsa.PublicKey) (func() hash.Hash, int, error) {
+	switch pubKey.Curve {
+	case elliptic.P256():
+		return sha256.New, 2 * 32, nil
+	case elliptic.P384():
+		return sha512.New384, 2 * 48, nil
+	case elliptic.P521():
+		return sha512.New, 2 * 66, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported curve %s", pubKey.Curve.Params().Name)
+	}
+}
+
 func parseOpaqueData(data []byte) (OpaqueData, error) {
 	var od OpaqueData
 	od.Fields = make(map[OpaqueFieldID]any)
@@ -390,12 +451,13 @@ func parseSPDMResponse(report []byte) (SPDMMeasurementResponseMessage, error) {
 	}
 	idx += opaqueDataLength
 
-	// Bytes 42 + mrLength + opaqueLength -> 42 + mrLength + opaqueLength + [signatureLength]: Signature
-	if reportLen < idx+signatureLength {
-		return SPDMMeasurementResponseMessage{}, fmt.Errorf("report too short for signature, expected %d bytes, got %d", idx+signatureLength, reportLen)
+	// Bytes 42 + mrLength + opaqueLength -> end: Signature
+	// The signature length depends on the signing key, so we take the remaining data.
+	if reportLen <= idx {
+		return SPDMMeasurementResponseMessage{}, fmt.Errorf("report too short for signature, expected more than %d bytes, got %d", idx, reportLen)
 	}
-	signature := make([]byte, signatureLength)
-	copy(signature, report[idx:idx+signatureLength])
+	signature := make([]byte, reportLen-idx)
+	copy(signature, report[idx:])
 
 	return SPDMMeasurementResponseMessage{
 		SPDMVersion:             report[0],
@@ -414,6 +476,8 @@ func parseSPDMResponse(report []byte) (SPDMMeasurementResponseMessage, error) {
 func opaqueDataToString(i any) string {
 	var ret string
 	switch v := i.(type) {
+	case nil:
+		return ""
 	case []byte:
 		ret = string(v)
 	case string:
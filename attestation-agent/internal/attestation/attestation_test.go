@@ -0,0 +1,240 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	p256Key := newTestKey(t, elliptic.P256())
+	p384Key := newTestKey(t, elliptic.P384())
+	otherP256Key := newTestKey(t, elliptic.P256())
+
+	testCases := map[string]struct {
+		signingKey *ecdsa.PrivateKey
+		newHash    func() hash.Hash
+		certKey    *ecdsa.PrivateKey
+		tamper     bool
+		wantErr    bool
+	}{
+		"P-384 with SHA-384": {
+			signingKey: p384Key,
+			newHash:    sha512.New384,
+			certKey:    p384Key,
+		},
+		"P-256 with SHA-256": {
+			signingKey: p256Key,
+			newHash:    sha256.New,
+			certKey:    p256Key,
+		},
+		"P-256 signed by other key": {
+			signingKey: otherP256Key,
+			newHash:    sha256.New,
+			certKey:    p256Key,
+			wantErr:    true,
+		},
+		"P-256 signature for P-384 certificate": {
+			signingKey: p256Key,
+			newHash:    sha256.New,
+			certKey:    p384Key,
+			wantErr:    true,
+		},
+		"P-256 with wrong hash": {
+			signingKey: p256Key,
+			newHash:    sha512.New384,
+			certKey:    p256Key,
+			wantErr:    true,
+		},
+		"tampered report": {
+			signingKey: p256Key,
+			newHash:    sha256.New,
+			certKey:    p256Key,
+			tamper:     true,
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			data := newSignedTestReport(t, tc.signingKey, tc.newHash)
+			if tc.tamper {
+				data[10] ^= 0xFF // inside the request nonce
+			}
+
+			report, err := ParseReport(data)
+			require.NoError(err)
+
+			err = report.verifySignature(newTestCert(t, tc.certKey))
+			if tc.wantErr {
+				require.Error(err)
+			} else {
+				require.NoError(err)
+			}
+		})
+	}
+}
+
+func TestParseReportSignature(t *testing.T) {
+	key := newTestKey(t, elliptic.P256())
+	report, err := ParseReport(newSignedTestReport(t, key, sha256.New))
+	require.NoError(t, err)
+	assert.Len(t, report.SPDMResponse.Signature, 64)
+}
+
+func TestOpaqueFieldAccessors(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	data := newSignedTestReportWithOpaqueData(t, newTestKey(t, elliptic.P384()), sha512.New384, map[OpaqueFieldID][]byte{
+		OpaqueFieldIDDriverVersion: []byte("550.90.07\x00"),
+		OpaqueFieldIDVbiosVersion:  {0x00, 0x74, 0x00, 0x96, 0x01, 0x00, 0x00, 0x00},
+		OpaqueFieldIDProject:       []byte("g520\x00"),
+		OpaqueFieldIDProjectSku:    []byte("0200"),
+		OpaqueFieldIDChipSku:       []byte("882\x00"),
+		OpaqueFieldIDChipSkuMod:    []byte("0000"),
+		OpaqueFieldIDBoardID:       []byte("pg520 a"),
+	})
+	report, err := ParseReport(data)
+	require.NoError(err)
+
+	assert.Equal("PG520 A", report.BoardID())
+	assert.Equal("0000", report.ChipSKUMod())
+
+	summary := report.Summary()
+	assert.Equal(Summary{
+		DriverVersion: "550.90.07",
+		VBIOSVersion:  "96.00.74.00.01",
+		Project:       "G520",
+		ProjectSKU:    "0200",
+		ChipSKU:       "882",
+		ChipSKUMod:    "0000",
+		BoardID:       "PG520 A",
+	}, summary)
+
+	summaryJSON, err := json.Marshal(summary)
+	require.NoError(err)
+	assert.JSONEq(`{
+		"driverVersion": "550.90.07",
+		"vbiosVersion": "96.00.74.00.01",
+		"project": "G520",
+		"projectSKU": "0200",
+		"chipSKU": "882",
+		"chipSKUMod": "0000",
+		"boardID": "PG520 A"
+	}`, string(summaryJSON))
+}
+
+func TestSummaryMissingFields(t *testing.T) {
+	report, err := ParseReport(newSignedTestReport(t, newTestKey(t, elliptic.P256()), sha256.New))
+	require.NoError(t, err)
+
+	summary := report.Summary()
+	assert.Equal(t, "550.0.0", summary.DriverVersion)
+	assert.Empty(t, summary.VBIOSVersion)
+	assert.Empty(t, summary.BoardID)
+}
+
+// newSignedTestReport builds a minimal synthetic SPDM measurement report signed with key.
+func newSignedTestReport(t *testing.T, key *ecdsa.PrivateKey, newHash func() hash.Hash) []byte {
+	t.Helper()
+	return newSignedTestReportWithOpaqueData(t, key, newHash, map[OpaqueFieldID][]byte{
+		OpaqueFieldIDDriverVersion: []byte("550.0.0"),
+	})
+}
+
+// newSignedTestReportWithOpaqueData builds a synthetic SPDM measurement report with the given
+// opaque fields, signed with key.
+func newSignedTestReportWithOpaqueData(t *testing.T, key *ecdsa.PrivateKey, newHash func() hash.Hash, fields map[OpaqueFieldID][]byte) []byte {
+	t.Helper()
+	return newSignedTestReportWithMeasurements(t, key, newHash, fields, nil)
+}
+
+// newSignedTestReportWithMeasurements builds a synthetic SPDM measurement report with the given
+// opaque fields and measurement records, signed with key.
+func newSignedTestReportWithMeasurements(t *testing.T, key *ecdsa.PrivateKey, newHash func() hash.Hash,
+	fields map[OpaqueFieldID][]byte, measurements map[uint8][]byte,
+) []byte {
+	t.Helper()
+
+	request := make([]byte, spdmGetRequestMeasurementRequestMessageSize)
+	_, err := rand.Read(request[4:36]) // nonce
+	require.NoError(t, err)
+
+	var opaqueData []byte
+	for id, value := range fields {
+		opaqueData = binary.LittleEndian.AppendUint16(opaqueData, uint16(id))
+		opaqueData = binary.LittleEndian.AppendUint16(opaqueData, uint16(len(value)))
+		opaqueData = append(opaqueData, value...)
+	}
+
+	var records []byte
+	for idx, value := range measurements {
+		// The index is stored incremented by one, see parseMeasurementRecords.
+		records = append(records, idx+1, dmtfMeasurementSpecification)
+		records = binary.LittleEndian.AppendUint16(records, uint16(3+len(value)))
+		records = append(records, 0x01) // DMTF value type
+		records = binary.LittleEndian.AppendUint16(records, uint16(len(value)))
+		records = append(records, value...)
+	}
+
+	// Header with the measurement records length, followed by records, nonce and opaque data.
+	response := make([]byte, 8)
+	response[4] = byte(len(measurements))
+	response[5] = byte(len(records))
+	response[6] = byte(len(records) >> 8)
+	response[7] = byte(len(records) >> 16)
+	response = append(response, records...)
+	response = append(response, make([]byte, 32)...)
+	response = binary.LittleEndian.AppendUint16(response, uint16(len(opaqueData)))
+	response = append(response, opaqueData...)
+
+	h := newHash()
+	h.Write(request)
+	h.Write(response)
+	r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+	require.NoError(t, err)
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	response = append(response, r.FillBytes(make([]byte, size))...)
+	response = append(response, s.FillBytes(make([]byte, size))...)
+
+	return append(request, response...)
+}
+
+func newTestKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func newTestCert(t *testing.T, key *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test GPU"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
@@ -0,0 +1,45 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/edgelesssys/continuum/attestation-agent/internal/gpu"
+	"github.com/edgelesssys/continuum/attestation-agent/internal/rim"
+)
+
+// RIMFetcher fetches reference integrity measurements by their RIM ID.
+type RIMFetcher interface {
+	FetchRIM(ctx context.Context, id string) (*rim.SoftwareIdentity, error)
+}
+
+// References holds the reference integrity measurements of a single GPU.
+type References struct {
+	Driver *rim.SoftwareIdentity
+	VBIOS  *rim.SoftwareIdentity
+}
+
+// FetchReferences fetches the driver and VBIOS RIMs for a GPU of the given architecture.
+// The RIM IDs are derived from the architecture and the report of the GPU,
+// so GPUs of different architectures or projects on the same node each get their own references.
+func FetchReferences(ctx context.Context, fetcher RIMFetcher, arch gpu.Architecture, report *Report) (References, error) {
+	driverID, err := rim.DriverRIMID(arch, report.DriverVersion())
+	if err != nil {
+		return References{}, err
+	}
+	driverRIM, err := fetcher.FetchRIM(ctx, driverID)
+	if err != nil {
+		return References{}, fmt.Errorf("fetching driver RIM: %w", err)
+	}
+
+	vbiosVersion, err := report.VBIOSVersion()
+	if err != nil {
+		return References{}, fmt.Errorf("getting VBIOS version: %w", err)
+	}
+	vbiosRIM, err := fetcher.FetchRIM(ctx, rim.VBIOSRIMID(report.Project(), report.ProjectSKU(), report.ChipSKU(), vbiosVersion))
+	if err != nil {
+		return References{}, fmt.Errorf("fetching VBIOS RIM: %w", err)
+	}
+
+	return References{Driver: driverRIM, VBIOS: vbiosRIM}, nil
+}
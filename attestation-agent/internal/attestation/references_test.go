@@ -0,0 +1,102 @@
+package attestation
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"errors"
+	"testing"
+
+	"github.com/edgelesssys/continuum/attestation-agent/internal/gpu"
+	"github.com/edgelesssys/continuum/attestation-agent/internal/rim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchReferencesMixedArchitectures(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	key := newTestKey(t, elliptic.P384())
+	newGPU := func(arch gpu.Architecture, driverVersion, project string, measurement byte) mixedGPU {
+		data := newSignedTestReportWithMeasurements(t, key, sha512.New384, map[OpaqueFieldID][]byte{
+			OpaqueFieldIDDriverVersion: []byte(driverVersion + "\x00"),
+			OpaqueFieldIDVbiosVersion:  {0x00, 0x74, 0x00, 0x96, 0x01, 0x00, 0x00, 0x00},
+			OpaqueFieldIDProject:       []byte(project + "\x00"),
+			OpaqueFieldIDProjectSku:    []byte("0200"),
+			OpaqueFieldIDChipSku:       []byte("882\x00"),
+		}, map[uint8][]byte{
+			0: {measurement, 0x01},
+			1: {measurement, 0x02},
+		})
+		report, err := ParseReport(data)
+		require.NoError(err)
+		return mixedGPU{arch: arch, report: report}
+	}
+	gpus := []mixedGPU{
+		newGPU(gpu.ArchHopper, "550.90.07", "G520", 0xaa),
+		newGPU(gpu.ArchBlackwell, "570.124.06", "G525", 0xbb),
+	}
+
+	fetcher := &stubRIMFetcher{rims: map[string]*rim.SoftwareIdentity{
+		"NV_GPU_DRIVER_GH100_550.90.07":         newTestRIM(1, "aa02"),
+		"NV_GPU_VBIOS_G520_0200_882_9600740001": newTestRIM(0, "aa01"),
+		"NV_GPU_CC_DRIVER_GB100_570.124.06":     newTestRIM(1, "bb02"),
+		"NV_GPU_VBIOS_G525_0200_882_9600740001": newTestRIM(0, "bb01"),
+		"NV_GPU_DRIVER_GH100_570.124.06":        newTestRIM(1, "aa02"), // must not be used for the Blackwell GPU
+	}}
+
+	refs := make([]References, len(gpus))
+	for i, g := range gpus {
+		var err error
+		refs[i], err = FetchReferences(t.Context(), fetcher, g.arch, g.report)
+		require.NoError(err)
+		assert.NoError(g.report.ValidateMeasurements(refs[i].VBIOS, refs[i].Driver, nil))
+	}
+	assert.Equal([]string{
+		"NV_GPU_DRIVER_GH100_550.90.07",
+		"NV_GPU_VBIOS_G520_0200_882_9600740001",
+		"NV_GPU_CC_DRIVER_GB100_570.124.06",
+		"NV_GPU_VBIOS_G525_0200_882_9600740001",
+	}, fetcher.fetched)
+
+	// The references of one GPU don't match the measurements of the other.
+	assert.Error(gpus[0].report.ValidateMeasurements(refs[1].VBIOS, refs[1].Driver, nil))
+	assert.Error(gpus[1].report.ValidateMeasurements(refs[0].VBIOS, refs[0].Driver, nil))
+}
+
+func TestFetchReferencesUnsupportedArchitecture(t *testing.T) {
+	report, err := ParseReport(newSignedTestReport(t, newTestKey(t, elliptic.P256()), sha512.New384))
+	require.NoError(t, err)
+
+	fetcher := &stubRIMFetcher{}
+	_, err = FetchReferences(t.Context(), fetcher, gpu.Architecture(8), report)
+	assert.Error(t, err)
+	assert.Empty(t, fetcher.fetched)
+}
+
+type mixedGPU struct {
+	arch   gpu.Architecture
+	report *Report
+}
+
+type stubRIMFetcher struct {
+	rims    map[string]*rim.SoftwareIdentity
+	fetched []string
+}
+
+func (s *stubRIMFetcher) FetchRIM(_ context.Context, id string) (*rim.SoftwareIdentity, error) {
+	s.fetched = append(s.fetched, id)
+	identity, ok := s.rims[id]
+	if !ok {
+		return nil, errors.New("RIM not found")
+	}
+	return identity, nil
+}
+
+// newTestRIM returns a RIM with a single active resource at idx with the given hash.
+func newTestRIM(idx uint8, hash string) *rim.SoftwareIdentity {
+	var identity rim.SoftwareIdentity
+	identity.Payload.Resource = []rim.Resource{{Index: idx, Active: true, Hashes: []string{hash}}}
+	return &identity
+}
@@ -34,25 +34,22 @@ func New(baseURL string, log *slog.Logger) *Client {
 	}
 }
 
-// FetchDriverRIM fetches reference values for the given GPU architecture and version.
-func (c *Client) FetchDriverRIM(ctx context.Context, gpuArch gpu.Architecture, version string) (*SoftwareIdentity, error) {
-	var driverID string
+// DriverRIMID returns the RIM ID of the driver with the given version for the given GPU architecture.
+func DriverRIMID(gpuArch gpu.Architecture, version string) (string, error) {
 	switch gpuArch {
 	case gpu.ArchHopper:
-		driverID = "NV_GPU_DRIVER_GH100_" + version
+		return "NV_GPU_DRIVER_GH100_" + version, nil
 	case gpu.ArchBlackwell:
-		driverID = "NV_GPU_CC_DRIVER_GB100_" + version
+		return "NV_GPU_CC_DRIVER_GB100_" + version, nil
 	default:
-		return nil, fmt.Errorf("unsupported GPU architecture: %d", gpuArch)
+		return "", fmt.Errorf("unsupported GPU architecture: %d", gpuArch)
 	}
-
-	return c.FetchRIM(ctx, driverID)
 }
 
-// FetchVBIOSRIM fetches reference values for the VBIOS of the given GPU architecture and version.
-func (c *Client) FetchVBIOSRIM(ctx context.Context, project, projectSku, chipSku, vbiosVersion string) (*SoftwareIdentity, error) {
+// VBIOSRIMID returns the RIM ID of the VBIOS with the given version for the given GPU project and SKUs.
+func VBIOSRIMID(project, projectSku, chipSku, vbiosVersion string) string {
 	vbiosRIMString := strings.ToUpper(strings.ReplaceAll(vbiosVersion, ".", ""))
-	return c.FetchRIM(ctx, "NV_GPU_VBIOS_"+project+"_"+projectSku+"_"+chipSku+"_"+vbiosRIMString)
+	return "NV_GPU_VBIOS_" + project + "_" + projectSku + "_" + chipSku + "_" + vbiosRIMString
 }
 
 // FetchRIM fetches the reference values for the given RIM ID.
@@ -134,36 +134,29 @@ func verifyAndEnable(ctx context.Context, log *slog.Logger) ([]internalOCSP.Stat
 			return nil, fmt.Errorf("verifying GPU report: %w", err)
 		}
 
+		// The GPUs of a node may differ in architecture and VBIOS,
+		// so the references are fetched for each GPU individually.
 		arch, err := gpuIssuer.Arch()
 		if err != nil {
 			return nil, fmt.Errorf("getting GPU architecture: %w", err)
 		}
-		log.Info("Fetching RIM data for GPU", "architecture", arch)
-
-		driverRIM, err := rimClient.FetchDriverRIM(ctx, arch, parsedReport.DriverVersion())
-		if err != nil {
-			return nil, fmt.Errorf("fetching driver RIM: %w", err)
-		}
-		statusInfos[i].Driver, err = verifyRIMCertChain(ctx, driverRIM, ocsp.VerificationModeDriverRIM, ocspClient)
+		log.Info("Fetching RIM data for GPU", "index", i, "architecture", arch, "project", parsedReport.Project())
+		refs, err := attestation.FetchReferences(ctx, rimClient, arch, parsedReport)
 		if err != nil {
-			return nil, fmt.Errorf("verifying driver RIM certificate chain: %w", err)
+			return nil, fmt.Errorf("fetching RIM data for GPU %d: %w", i, err)
 		}
 
-		vbiosVersion, err := parsedReport.VBIOSVersion()
+		statusInfos[i].Driver, err = verifyRIMCertChain(ctx, refs.Driver, ocsp.VerificationModeDriverRIM, ocspClient)
 		if err != nil {
-			return nil, fmt.Errorf("getting VBIOS version: %w", err)
-		}
-		vbiosRIM, err := rimClient.FetchVBIOSRIM(ctx, parsedReport.Project(), parsedReport.ProjectSKU(), parsedReport.ChipSKU(), vbiosVersion)
-		if err != nil {
-			return nil, fmt.Errorf("fetching VBIOS RIM: %w", err)
+			return nil, fmt.Errorf("verifying driver RIM certificate chain: %w", err)
 		}
-		statusInfos[i].VBIOS, err = verifyRIMCertChain(ctx, vbiosRIM, ocsp.VerificationModeVBIOSRIM, ocspClient)
+		statusInfos[i].VBIOS, err = verifyRIMCertChain(ctx, refs.VBIOS, ocsp.VerificationModeVBIOSRIM, ocspClient)
 		if err != nil {
 			return nil, fmt.Errorf("verifying VBIOS RIM certificate chain: %w", err)
 		}
 
 		log.Info("Validating GPU attestation report measurements")
-		if err := parsedReport.ValidateMeasurements(driverRIM, vbiosRIM, nil); err != nil {
+		if err := parsedReport.ValidateMeasurements(refs.VBIOS, refs.Driver, nil); err != nil {
 			return nil, fmt.Errorf("validating measurements: %w", err)
 		}
 	}
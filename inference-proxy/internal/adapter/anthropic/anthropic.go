@@ -13,6 +13,7 @@ import (
 	"github.com/edgelesssys/continuum/inference-proxy/internal/adapter/inference"
 	"github.com/edgelesssys/continuum/internal/oss/anthropic"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
 	"github.com/edgelesssys/continuum/internal/oss/openai"
 	"github.com/edgelesssys/continuum/internal/oss/usage"
 )
@@ -25,9 +26,9 @@ type Adapter struct {
 }
 
 // New creates a new [Adapter] for the Anthropic API.
-func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspStatusFile string, forwarder inference.MutatingForwarder, log *slog.Logger) (*Adapter, error) {
+func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspStatusFile string, minOCSPPolicy []ocspheader.AllowStatus, forwarder inference.MutatingForwarder, log *slog.Logger) (*Adapter, error) {
 	// No endpoints are excluded from OCSP verification for Anthropic
-	baseAdapter, err := inference.New(workloadTasks, cipher, ocspStatusFile, forwarder, log)
+	baseAdapter, err := inference.New(workloadTasks, cipher, ocspStatusFile, minOCSPPolicy, forwarder, log)
 	if err != nil {
 		return nil, err
 	}
@@ -40,10 +41,10 @@ func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspSta
 }
 
 // RegisterRoutes registers the Anthropic API handlers on the given ServeMux.
-// Each handler is wrapped with OCSP verification middleware.
+// Each handler is wrapped with model name validation and OCSP verification middleware.
 func (a *Adapter) RegisterRoutes(mux *http.ServeMux) {
 	// Create message: https://docs.anthropic.com/en/api/messages
-	mux.Handle("POST "+anthropic.MessagesEndpoint, a.VerifyOCSP(http.HandlerFunc(a.forwardMessagesRequest)))
+	mux.Handle("POST "+anthropic.MessagesEndpoint, a.ValidateModel(a.VerifyOCSP(http.HandlerFunc(a.forwardMessagesRequest))))
 }
 
 // HandlesCatchAll returns false because Anthropic adapter only handles specific endpoints.
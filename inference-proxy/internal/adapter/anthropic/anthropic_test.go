@@ -144,7 +144,7 @@ func TestForwardMessagesRequest(t *testing.T) {
 
 			log := slog.Default()
 			fwd := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, fwd, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, fwd, log)
 			require.NoError(err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, anthropic.MessagesEndpoint, strings.NewReader(tc.clientRequest))
@@ -302,7 +302,7 @@ func TestUsageExtraction(t *testing.T) {
 			require.NoError(t, os.WriteFile(ocspFile, ocspStatus, 0o644))
 
 			fwd := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, fwd, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, fwd, log)
 			require.NoError(t, err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, anthropic.MessagesEndpoint, strings.NewReader(clientRequest))
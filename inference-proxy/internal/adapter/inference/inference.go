@@ -2,6 +2,7 @@
 package inference
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +11,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/edgelesssys/continuum/internal/oss/ocsp"
 	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/edgelesssys/continuum/internal/oss/requestid"
 	"github.com/edgelesssys/continuum/internal/oss/sse"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -48,24 +51,23 @@ type Adapter struct {
 	Forwarder     MutatingForwarder
 	WorkloadTasks []string
 	OCSPStatus    []ocsp.StatusInfo
+	// MinOCSPPolicy lists the OCSP statuses the server accepts at most.
+	// Requests with a policy allowing other statuses are rejected. If nil, the client policy is trusted.
+	MinOCSPPolicy []ocspheader.AllowStatus
 	Log           *slog.Logger
 }
 
 // New creates a new base Adapter with common functionality.
 func New(workloadTasks []string, cipher ResponseCipherCreator, ocspStatusFile string,
-	forwarder MutatingForwarder, log *slog.Logger,
+	minOCSPPolicy []ocspheader.AllowStatus, forwarder MutatingForwarder, log *slog.Logger,
 ) (*Adapter, error) {
 	if len(workloadTasks) == 0 {
 		return nil, errors.New("no workload tasks provided")
 	}
 
-	ocspStatusJSON, err := os.ReadFile(ocspStatusFile)
+	ocspStatus, err := readOCSPStatusFile(ocspStatusFile)
 	if err != nil {
-		return nil, fmt.Errorf("reading OCSP status file: %w", err)
-	}
-	var ocspStatus []ocsp.StatusInfo
-	if err := json.Unmarshal(ocspStatusJSON, &ocspStatus); err != nil {
-		return nil, fmt.Errorf("unmarshalling OCSP status JSON: %w", err)
+		return nil, err
 	}
 
 	for i, statusInfo := range ocspStatus {
@@ -79,12 +81,92 @@ func New(workloadTasks []string, cipher ResponseCipherCreator, ocspStatusFile st
 		Forwarder:     forwarder,
 		WorkloadTasks: workloadTasks,
 		OCSPStatus:    ocspStatus,
+		MinOCSPPolicy: minOCSPPolicy,
 		Log:           log,
 	}, nil
 }
 
+// CheckOCSPStatusFileAge returns an error if the OCSP status file does not exist
+// or was last modified more than maxAge before now.
+func CheckOCSPStatusFileAge(ocspStatusFile string, maxAge time.Duration, now time.Time) error {
+	info, err := os.Stat(ocspStatusFile)
+	if err != nil {
+		return fmt.Errorf("checking OCSP status file: %w", err)
+	}
+	if age := now.Sub(info.ModTime()); age > maxAge {
+		return fmt.Errorf("OCSP status file %q is stale: last updated %s ago, maximum age is %s", ocspStatusFile, age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// ReadinessHandler returns a handler for readiness probes. It responds with 503 Service Unavailable
+// until the OCSP status file written by the attestation agent can be loaded and all statuses in it are
+// accepted by minOCSPPolicy. If minOCSPPolicy is nil, any valid status is accepted, since the client policy is trusted.
+// The file is read again on every probe.
+func ReadinessHandler(ocspStatusFile string, minOCSPPolicy []ocspheader.AllowStatus, log *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := checkOCSPReadiness(ocspStatusFile, minOCSPPolicy); err != nil {
+			log.Debug("Not ready", "error", err)
+			http.Error(w, fmt.Sprintf("not ready: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func checkOCSPReadiness(ocspStatusFile string, minOCSPPolicy []ocspheader.AllowStatus) error {
+	ocspStatus, err := readOCSPStatusFile(ocspStatusFile)
+	if err != nil {
+		return err
+	}
+
+	acceptedStatuses := []ocsp.Status{ocsp.StatusGood, ocsp.StatusUnknown, ocsp.StatusRevoked(time.Time{})}
+	if minOCSPPolicy != nil {
+		acceptedStatuses = nil
+		for _, allowedStatus := range minOCSPPolicy {
+			switch allowedStatus {
+			case ocspheader.AllowStatusGood:
+				acceptedStatuses = append(acceptedStatuses, ocsp.StatusGood)
+			case ocspheader.AllowStatusUnknown:
+				acceptedStatuses = append(acceptedStatuses, ocsp.StatusUnknown)
+			case ocspheader.AllowStatusRevoked:
+				acceptedStatuses = append(acceptedStatuses, ocsp.StatusRevoked(time.Time{}))
+			}
+		}
+	}
+
+	for i, status := range ocspStatus {
+		if !status.Driver.AcceptedBy(acceptedStatuses) {
+			return fmt.Errorf("driver status of GPU %d is not accepted: %s", i, status.Driver)
+		}
+		if !status.GPU.AcceptedBy(acceptedStatuses) {
+			return fmt.Errorf("GPU status of GPU %d is not accepted: %s", i, status.GPU)
+		}
+		if !status.VBIOS.AcceptedBy(acceptedStatuses) {
+			return fmt.Errorf("VBIOS status of GPU %d is not accepted: %s", i, status.VBIOS)
+		}
+	}
+	return nil
+}
+
+func readOCSPStatusFile(ocspStatusFile string) ([]ocsp.StatusInfo, error) {
+	ocspStatusJSON, err := os.ReadFile(ocspStatusFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP status file: %w", err)
+	}
+	if len(bytes.TrimSpace(ocspStatusJSON)) == 0 {
+		return nil, fmt.Errorf("OCSP status file %q is empty", ocspStatusFile)
+	}
+	var ocspStatus []ocsp.StatusInfo
+	if err := json.Unmarshal(ocspStatusJSON, &ocspStatus); err != nil {
+		return nil, fmt.Errorf("unmarshalling OCSP status JSON: %w", err)
+	}
+	return ocspStatus, nil
+}
+
 // VerifyOCSP returns OCSP verification middleware that wraps the given handler.
 // This should be applied per-route by adapters that require OCSP verification.
+// Each decision is logged at debug level together with the allowed and actual statuses.
 func (a *Adapter) VerifyOCSP(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ocspPolicy := r.Header.Get(constants.PrivatemodeNvidiaOCSPPolicyHeader)
@@ -92,26 +174,46 @@ func (a *Adapter) VerifyOCSP(h http.Handler) http.Handler {
 		secretID := r.Header.Get(constants.PrivatemodeSecretIDHeader)
 
 		var acceptedStatuses []ocsp.Status
+		logDecision := func(decision, reason string) {
+			a.Log.Debug("OCSP policy decision",
+				"requestID", requestid.FromHeader(r),
+				"decision", decision,
+				"reason", reason,
+				"policyHeaderSet", ocspPolicy != "" || ocspMAC != "",
+				"allowedStatuses", statusStrings(acceptedStatuses),
+				"componentStatuses", componentStatusStrings(a.OCSPStatus),
+			)
+		}
+		reject := func(format string, args ...any) {
+			logDecision("rejected", fmt.Sprintf(format, args...))
+			forwarder.HTTPError(w, r, http.StatusInternalServerError, format, args...)
+		}
+
 		if ocspPolicy == "" && ocspMAC == "" {
 			acceptedStatuses = []ocsp.Status{ocsp.StatusGood} // Old clients won't set the header, only accept good status
 		} else {
 			secret, err := a.Cipher.Secret(r.Context(), secretID)
 			if err != nil {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "getting secret for OCSP verification: %s", err)
+				reject("getting secret for OCSP verification: %s", err)
 				return
 			}
-			if len(secret) != 32 {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "invalid secret length for OCSP verification: expected 32 bytes, got %d", len(secret))
+			macKey, err := ocspheader.MACKey(secret)
+			if err != nil {
+				reject("invalid secret for OCSP verification: %s", err)
 				return
 			}
 
-			requestedOCSPStatus, err := ocspheader.UnmarshalAndVerify(ocspPolicy, ocspMAC, [32]byte(secret))
+			requestedOCSPStatus, err := ocspheader.UnmarshalAndVerify(ocspPolicy, ocspMAC, macKey)
 			if err != nil {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "verifying OCSP header: %s", err)
+				reject("verifying OCSP header: %s", err)
 				return
 			}
 
 			for _, allowedStatus := range requestedOCSPStatus.AllowedStatuses {
+				if a.MinOCSPPolicy != nil && !slices.Contains(a.MinOCSPPolicy, allowedStatus) {
+					reject("%s: %s is not allowed", constants.ErrorOCSPPolicyTooWeak, allowedStatus)
+					return
+				}
 				switch allowedStatus {
 				case ocspheader.AllowStatusGood:
 					acceptedStatuses = append(acceptedStatuses, ocsp.StatusGood)
@@ -125,23 +227,40 @@ func (a *Adapter) VerifyOCSP(h http.Handler) http.Handler {
 
 		for _, status := range a.OCSPStatus {
 			if !status.Driver.AcceptedBy(acceptedStatuses) {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "GPU attestation returned a driver OCSP status that is not accepted by the client: %s", status.Driver)
+				reject("GPU attestation returned a driver %s: %s", constants.ErrorOCSPStatusNotAccepted, status.Driver)
 				return
 			}
 			if !status.GPU.AcceptedBy(acceptedStatuses) {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "GPU attestation returned a GPU OCSP status that is not accepted by the client: %s", status.GPU)
+				reject("GPU attestation returned a GPU %s: %s", constants.ErrorOCSPStatusNotAccepted, status.GPU)
 				return
 			}
 			if !status.VBIOS.AcceptedBy(acceptedStatuses) {
-				forwarder.HTTPError(w, r, http.StatusInternalServerError, "GPU attestation returned a VBIOS OCSP status that is not accepted by the client: %s", status.VBIOS)
+				reject("GPU attestation returned a VBIOS %s: %s", constants.ErrorOCSPStatusNotAccepted, status.VBIOS)
 				return
 			}
 		}
 
+		logDecision("accepted", "")
 		h.ServeHTTP(w, r)
 	})
 }
 
+func statusStrings(statuses []ocsp.Status) []string {
+	out := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		out = append(out, status.String())
+	}
+	return out
+}
+
+func componentStatusStrings(statusInfos []ocsp.StatusInfo) []string {
+	out := make([]string, 0, len(statusInfos))
+	for i, info := range statusInfos {
+		out = append(out, fmt.Sprintf("gpu_index_%d: gpu=%s, driver=%s, vbios=%s", i, info.GPU, info.Driver, info.VBIOS))
+	}
+	return out
+}
+
 // UnsupportedEndpoint returns 501 Not Implemented.
 // To be used as the default handler for every endpoint that is not explicitly supported.
 func (a *Adapter) UnsupportedEndpoint(w http.ResponseWriter, _ *http.Request) {
@@ -3,9 +3,12 @@ package inference
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -14,10 +17,151 @@ import (
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/edgelesssys/continuum/internal/oss/ocsp"
 	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/edgelesssys/continuum/internal/oss/requestid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestCheckOCSPStatusFileAge(t *testing.T) {
+	now := time.Now()
+	maxAge := 24 * time.Hour
+
+	testCases := map[string]struct {
+		modTime     time.Time
+		missingFile bool
+		wantErr     bool
+	}{
+		"fresh": {
+			modTime: now.Add(-time.Hour),
+		},
+		"exactly max age": {
+			modTime: now.Add(-maxAge),
+		},
+		"stale": {
+			modTime: now.Add(-maxAge - time.Second),
+			wantErr: true,
+		},
+		"missing": {
+			missingFile: true,
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			path := filepath.Join(t.TempDir(), "ocsp-status.json")
+			if !tc.missingFile {
+				require.NoError(os.WriteFile(path, []byte("[]"), 0o644))
+				require.NoError(os.Chtimes(path, tc.modTime, tc.modTime))
+			}
+
+			err := CheckOCSPStatusFileAge(path, maxAge, now)
+			if tc.wantErr {
+				require.Error(err)
+			} else {
+				require.NoError(err)
+			}
+		})
+	}
+}
+
+func TestNewEmptyOCSPStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ocsp-status.json")
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0o644))
+
+	_, err := New([]string{constants.WorkloadTaskGenerate}, nil, path, nil, nil, slog.Default())
+	require.ErrorContains(t, err, "empty")
+}
+
+func TestReadinessHandler(t *testing.T) {
+	good := ocsp.StatusInfo{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood}
+	unknown := ocsp.StatusInfo{GPU: ocsp.StatusUnknown, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood}
+
+	testCases := map[string]struct {
+		content       []byte
+		missingFile   bool
+		minOCSPPolicy []ocspheader.AllowStatus
+		wantStatus    int
+	}{
+		"missing file": {
+			missingFile: true,
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		"empty file": {
+			content:    []byte("\n"),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		"invalid JSON": {
+			content:    []byte("[{"),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		"invalid status": {
+			content:    []byte(`[{"GPU":{"Value":"INVALID"},"VBIOS":{"Value":"GOOD"},"Driver":{"Value":"GOOD"}}]`),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		"good status": {
+			content:    mustMarshal(t, []ocsp.StatusInfo{good}),
+			wantStatus: http.StatusOK,
+		},
+		"unknown status without minimum policy": {
+			content:    mustMarshal(t, []ocsp.StatusInfo{good, unknown}),
+			wantStatus: http.StatusOK,
+		},
+		"unknown status not accepted by minimum policy": {
+			content:       mustMarshal(t, []ocsp.StatusInfo{good, unknown}),
+			minOCSPPolicy: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			wantStatus:    http.StatusServiceUnavailable,
+		},
+		"unknown status accepted by minimum policy": {
+			content:       mustMarshal(t, []ocsp.StatusInfo{good, unknown}),
+			minOCSPPolicy: []ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusUnknown},
+			wantStatus:    http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ocsp-status.json")
+			if !tc.missingFile {
+				require.NoError(t, os.WriteFile(path, tc.content, 0o644))
+			}
+
+			rec := httptest.NewRecorder()
+			ReadinessHandler(path, tc.minOCSPPolicy, slog.Default()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, constants.ReadinessEndpoint, nil))
+			assert.Equal(t, tc.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestReadinessHandlerBecomesReady(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ocsp-status.json")
+	handler := ReadinessHandler(path, nil, slog.Default())
+	probe := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, constants.ReadinessEndpoint, nil))
+		return rec.Code
+	}
+
+	// attestation agent hasn't written the file yet
+	assert.Equal(t, http.StatusServiceUnavailable, probe())
+
+	// attestation agent created, but hasn't finished writing the file
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	assert.Equal(t, http.StatusServiceUnavailable, probe())
+
+	require.NoError(t, os.WriteFile(path, mustMarshal(t, []ocsp.StatusInfo{{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood}}), 0o644))
+	assert.Equal(t, http.StatusOK, probe())
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
 func TestVerifyOCSP(t *testing.T) {
 	gpuPolicyFailure := "GPU attestation returned a GPU OCSP status that is not accepted by the client"
 	driverPolicyFailure := "GPU attestation returned a driver OCSP status that is not accepted by the client"
@@ -26,9 +170,36 @@ func TestVerifyOCSP(t *testing.T) {
 	testCases := map[string]struct {
 		ocspStatus     ocsp.StatusInfo
 		acceptedStatus []ocspheader.AllowStatus
+		minOCSPPolicy  []ocspheader.AllowStatus
+		shortSecret    bool
 		expectedCode   int
 		expectedBody   string
 	}{
+		"accept revoked, server minimum forbids revoked": {
+			ocspStatus:     ocsp.StatusInfo{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood},
+			acceptedStatus: []ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusRevoked},
+			minOCSPPolicy:  []ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusUnknown},
+			expectedCode:   http.StatusInternalServerError,
+			expectedBody:   "OCSP policy of the client is weaker than the minimum policy of the server: allow-revoked is not allowed",
+		},
+		"accept unknown, server minimum allows unknown": {
+			ocspStatus:     ocsp.StatusInfo{GPU: ocsp.StatusUnknown, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood},
+			acceptedStatus: []ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusUnknown},
+			minOCSPPolicy:  []ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusUnknown},
+			expectedCode:   http.StatusOK,
+		},
+		"no header set, server minimum": {
+			ocspStatus:    ocsp.StatusInfo{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood},
+			minOCSPPolicy: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			expectedCode:  http.StatusOK,
+		},
+		"secret too short": {
+			ocspStatus:     ocsp.StatusInfo{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood},
+			acceptedStatus: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			shortSecret:    true,
+			expectedCode:   http.StatusInternalServerError,
+			expectedBody:   "invalid secret for OCSP verification: secret data too short",
+		},
 		"all good, accepted good": {
 			ocspStatus:     ocsp.StatusInfo{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusGood, Driver: ocsp.StatusGood},
 			acceptedStatus: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
@@ -143,15 +314,20 @@ func TestVerifyOCSP(t *testing.T) {
 
 			secret := bytes.Repeat([]byte{0x01}, 32)
 			secretID := "test"
+			cipherSecret := secret
+			if tc.shortSecret {
+				cipherSecret = secret[:16]
+			}
 
 			a := &Adapter{
 				Cipher: &stubCipher{
-					secretMap: map[string][]byte{secretID: secret},
+					secretMap: map[string][]byte{secretID: cipherSecret},
 				},
 				Forwarder:     &stubForwarder{},
 				WorkloadTasks: []string{"generate"},
 				Log:           slog.Default(),
 				OCSPStatus:    []ocsp.StatusInfo{tc.ocspStatus},
+				MinOCSPPolicy: tc.minOCSPPolicy,
 			}
 
 			// Create a simple handler that returns 200 OK
@@ -183,6 +359,64 @@ func TestVerifyOCSP(t *testing.T) {
 	}
 }
 
+func TestVerifyOCSPLogging(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := bytes.Repeat([]byte{0x01}, 32)
+	secretID := "test"
+
+	var logs bytes.Buffer
+	revokedAt := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	a := &Adapter{
+		Cipher:        &stubCipher{secretMap: map[string][]byte{secretID: secret}},
+		Forwarder:     &stubForwarder{},
+		WorkloadTasks: []string{"generate"},
+		Log:           slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		OCSPStatus:    []ocsp.StatusInfo{{GPU: ocsp.StatusGood, VBIOS: ocsp.StatusRevoked(revokedAt), Driver: ocsp.StatusGood}},
+	}
+	handler := a.VerifyOCSP(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ocspHeader := ocspheader.NewHeader([]ocspheader.AllowStatus{ocspheader.AllowStatusGood, ocspheader.AllowStatusUnknown}, time.Time{})
+	policyHeader, err := ocspHeader.Marshal()
+	require.NoError(err)
+	policyMACHeader, err := ocspHeader.MarshalMACHeader([32]byte(secret))
+	require.NoError(err)
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", http.NoBody)
+	request.Header.Set(constants.PrivatemodeNvidiaOCSPPolicyHeader, policyHeader)
+	request.Header.Set(constants.PrivatemodeNvidiaOCSPPolicyMACHeader, policyMACHeader)
+	request.Header.Set(constants.PrivatemodeSecretIDHeader, secretID)
+	request.Header.Set(requestid.Header, "req-123")
+
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, request)
+	require.Equal(http.StatusInternalServerError, responseRecorder.Code)
+
+	var record struct {
+		Level             string   `json:"level"`
+		Msg               string   `json:"msg"`
+		RequestID         string   `json:"requestID"`
+		Decision          string   `json:"decision"`
+		Reason            string   `json:"reason"`
+		PolicyHeaderSet   bool     `json:"policyHeaderSet"`
+		AllowedStatuses   []string `json:"allowedStatuses"`
+		ComponentStatuses []string `json:"componentStatuses"`
+	}
+	require.NoError(json.Unmarshal(logs.Bytes(), &record))
+
+	assert.Equal("DEBUG", record.Level)
+	assert.Equal("OCSP policy decision", record.Msg)
+	assert.Equal("req-123", record.RequestID)
+	assert.Equal("rejected", record.Decision)
+	assert.Contains(record.Reason, "VBIOS OCSP status that is not accepted")
+	assert.True(record.PolicyHeaderSet)
+	assert.Equal([]string{"GOOD", "UNKNOWN"}, record.AllowedStatuses)
+	assert.Equal([]string{"gpu_index_0: gpu=GOOD, driver=GOOD, vbios=REVOKED at 2025-01-01T00:00:00Z"}, record.ComponentStatuses)
+}
+
 func TestUnsupportedEndpoint(t *testing.T) {
 	assert := assert.New(t)
 
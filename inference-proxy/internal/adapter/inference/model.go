@@ -0,0 +1,105 @@
+package inference
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/persist"
+	"github.com/tidwall/gjson"
+)
+
+// maxModelNameLength is the maximum length of a model name accepted by [ValidModelName].
+const maxModelNameLength = 256
+
+// modelNamePattern matches model names that are safe to use in URL paths and headers.
+// Slashes are allowed, as model names are namespaced by organization, e.g., "openai/gpt-oss-120b".
+// Names must not start with a slash. Whitespace and control characters such as CR and LF are not allowed.
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/-]*$`)
+
+// ValidModelName reports whether name only consists of characters that are safe to forward
+// in URL paths and headers, and doesn't contain empty, "." or ".." path segments.
+func ValidModelName(name string) bool {
+	if len(name) > maxModelNameLength || !modelNamePattern.MatchString(name) {
+		return false
+	}
+	for segment := range strings.SplitSeq(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateModel returns middleware that rejects requests with an unsafe model name with 400.
+// The model name is taken from the {model} path value and from the "model" field of JSON and
+// multipart form request bodies. Requests without a model name are passed on.
+func (a *Adapter) ValidateModel(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if model := r.PathValue("model"); model != "" && !ValidModelName(model) {
+			a.Log.Warn("Rejecting request with invalid model name in path", "model", fmt.Sprintf("%q", model))
+			forwarder.HTTPError(w, r, http.StatusBadRequest, "invalid model name %q", model)
+			return
+		}
+
+		model, err := modelFromBody(r)
+		if err != nil {
+			forwarder.HTTPError(w, r, http.StatusBadRequest, "reading model from request: %s", err)
+			return
+		}
+		if model != "" && !ValidModelName(model) {
+			a.Log.Warn("Rejecting request with invalid model name in body", "model", fmt.Sprintf("%q", model))
+			forwarder.HTTPError(w, r, http.StatusBadRequest, "invalid model name %q", model)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// modelFromBody returns the "model" field of a JSON or multipart form request body.
+// The body of r is restored, so it can be read again.
+func modelFromBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", nil
+	}
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		return "", err
+	}
+	if len(body) == 0 {
+		return "", nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return gjson.GetBytes(body, "model").String(), nil
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("parsing multipart form: %w", err)
+		}
+		if part.FormName() != "model" {
+			continue
+		}
+		// Read one byte more than allowed, so that overlong names are rejected.
+		model, err := io.ReadAll(io.LimitReader(part, maxModelNameLength+1))
+		if err != nil {
+			return "", fmt.Errorf("reading model form field: %w", err)
+		}
+		return string(model), nil
+	}
+}
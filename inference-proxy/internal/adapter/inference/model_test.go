@@ -0,0 +1,154 @@
+package inference
+
+import (
+	"bytes"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidModelName(t *testing.T) {
+	testCases := map[string]struct {
+		model string
+		want  bool
+	}{
+		"simple":             {model: "gpt-oss-120b", want: true},
+		"dots and colons":    {model: "llama3.3:70b-instruct_q4", want: true},
+		"empty":              {model: ""},
+		"gpt-oss":            {model: "openai/gpt-oss-120b", want: true},
+		"gemma":              {model: "leon-se/gemma-3-27b-it-fp8-dynamic", want: true},
+		"whisper":            {model: "openai/whisper-large-v3", want: true},
+		"leading slash":      {model: "/openai/gpt-oss-120b"},
+		"trailing slash":     {model: "openai/"},
+		"empty segment":      {model: "openai//gpt-oss-120b"},
+		"dot segment":        {model: "openai/./gpt-oss-120b"},
+		"path traversal":     {model: "../../admin"},
+		"nested traversal":   {model: "openai/../../admin"},
+		"dot dot":            {model: ".."},
+		"tab":                {model: "openai/gpt-oss\t120b"},
+		"CRLF":               {model: "model\r\nX-Injected: true"},
+		"LF":                 {model: "model\n"},
+		"space":              {model: "some model"},
+		"percent encoding":   {model: "model%2F..%2Fadmin"},
+		"query":              {model: "model?x=1"},
+		"max length":         {model: strings.Repeat("a", maxModelNameLength), want: true},
+		"exceeds max length": {model: strings.Repeat("a", maxModelNameLength+1)},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ValidModelName(tc.model))
+		})
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	multipartBody := func(t *testing.T, model string) (string, string) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		require.NoError(t, writer.WriteField("model", model))
+		require.NoError(t, writer.WriteField("language", "en"))
+		require.NoError(t, writer.Close())
+		return body.String(), writer.FormDataContentType()
+	}
+
+	testCases := map[string]struct {
+		path      string
+		body      string
+		multipart string
+		wantCode  int
+	}{
+		"valid model in body": {
+			path:     "/v1/chat/completions",
+			body:     `{"model":"openai/gpt-oss-120b","messages":"encrypted"}`,
+			wantCode: http.StatusOK,
+		},
+		"no model": {
+			path:     "/v1/chat/completions",
+			body:     `{"messages":"encrypted"}`,
+			wantCode: http.StatusOK,
+		},
+		"empty body": {
+			path:     "/v1/chat/completions",
+			wantCode: http.StatusOK,
+		},
+		"path traversal in body": {
+			path:     "/v1/chat/completions",
+			body:     `{"model":"../../v1/admin","messages":"encrypted"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"CRLF in body": {
+			path:     "/v1/chat/completions",
+			body:     `{"model":"gpt-oss-120b\r\nX-Injected: true","messages":"encrypted"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"object as model": {
+			path:     "/v1/chat/completions",
+			body:     `{"model":{"name":"gpt-oss-120b"}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"valid model in multipart form": {
+			path:      "/v1/audio/transcriptions",
+			multipart: "openai/whisper-large-v3",
+			wantCode:  http.StatusOK,
+		},
+		"CRLF in multipart form": {
+			path:      "/v1/audio/transcriptions",
+			multipart: "whisper\r\nX-Injected: true",
+			wantCode:  http.StatusBadRequest,
+		},
+		"valid model in path": {
+			path:     "/v1/models/gpt-oss-120b",
+			wantCode: http.StatusOK,
+		},
+		"encoded slash in path": {
+			path:     "/v1/models/..%2F..%2Fadmin",
+			wantCode: http.StatusBadRequest,
+		},
+		"encoded CRLF in path": {
+			path:     "/v1/models/model%0D%0AX-Injected:%20true",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			a := &Adapter{Log: slog.Default()}
+			var gotBody string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body bytes.Buffer
+				_, _ = body.ReadFrom(r.Body)
+				gotBody = body.String()
+				w.WriteHeader(http.StatusOK)
+			})
+			mux := http.NewServeMux()
+			mux.Handle("/v1/models/{model}", a.ValidateModel(next))
+			mux.Handle("/", a.ValidateModel(next))
+
+			body, contentType := tc.body, "application/json"
+			if tc.multipart != "" {
+				body, contentType = multipartBody(t, tc.multipart)
+			}
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, tc.path, strings.NewReader(body))
+			request.Header.Set("Content-Type", contentType)
+			responseRecorder := httptest.NewRecorder()
+
+			mux.ServeHTTP(responseRecorder, request)
+
+			assert.Equal(tc.wantCode, responseRecorder.Code, responseRecorder.Body.String())
+			if tc.wantCode == http.StatusOK {
+				assert.Equal(body, gotBody, "the body must be passed on unchanged")
+			} else {
+				assert.Contains(responseRecorder.Body.String(), "invalid model name")
+			}
+		})
+	}
+}
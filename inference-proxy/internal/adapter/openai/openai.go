@@ -12,6 +12,7 @@ import (
 
 	"github.com/edgelesssys/continuum/inference-proxy/internal/adapter/inference"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
 	"github.com/edgelesssys/continuum/internal/oss/openai"
 	"github.com/edgelesssys/continuum/internal/oss/usage"
 	"github.com/tidwall/gjson"
@@ -25,8 +26,8 @@ type Adapter struct {
 }
 
 // New creates a new InferenceAdapter for the OpenAI API.
-func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspStatusFile string, forwarder inference.MutatingForwarder, log *slog.Logger) (*Adapter, error) {
-	baseAdapter, err := inference.New(workloadTasks, cipher, ocspStatusFile, forwarder, log)
+func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspStatusFile string, minOCSPPolicy []ocspheader.AllowStatus, forwarder inference.MutatingForwarder, log *slog.Logger) (*Adapter, error) {
+	baseAdapter, err := inference.New(workloadTasks, cipher, ocspStatusFile, minOCSPPolicy, forwarder, log)
 	if err != nil {
 		return nil, err
 	}
@@ -40,23 +41,24 @@ func New(workloadTasks []string, cipher inference.ResponseCipherCreator, ocspSta
 // RegisterRoutes registers the OpenAI API handlers on the given ServeMux.
 // Each handler is wrapped with OCSP verification middleware, except for /v1/models
 // which is used for health checks and doesn't require GPU attestation.
+// Requests with an unsafe model name are rejected before OCSP verification.
 func (a *Adapter) RegisterRoutes(mux *http.ServeMux) {
 	// List models: https://platform.openai.com/docs/api-reference/models/list
 	// Not wrapped with OCSP verification - used for health checks
 	mux.HandleFunc("GET /v1/models", a.forwardModelsRequest)
-	mux.HandleFunc("GET /v1/models/{model}", a.forwardSpecificModelRequest) // Also handle model details endpoint
+	mux.Handle("GET /v1/models/{model}", a.ValidateModel(http.HandlerFunc(a.forwardSpecificModelRequest))) // Also handle model details endpoint
 
 	// Create chat completion: https://platform.openai.com/docs/api-reference/chat/create
-	mux.Handle("/v1/chat/completions", a.VerifyOCSP(http.HandlerFunc(a.forwardChatCompletionsRequest)))
+	mux.Handle("/v1/chat/completions", a.ValidateModel(a.VerifyOCSP(http.HandlerFunc(a.forwardChatCompletionsRequest))))
 
 	// Legacy chat completions endpoint: https://platform.openai.com/docs/api-reference/completions/create
 	// Reuse the same handler as for /v1/chat/completions since the unencrypted fields are the same.
-	mux.Handle(openai.LegacyCompletionsEndpoint, a.VerifyOCSP(http.HandlerFunc(a.forwardChatCompletionsRequest)))
+	mux.Handle(openai.LegacyCompletionsEndpoint, a.ValidateModel(a.VerifyOCSP(http.HandlerFunc(a.forwardChatCompletionsRequest))))
 
 	// Create embeddings: https://platform.openai.com/docs/api-reference/embeddings/create
-	mux.Handle("POST /v1/embeddings", a.VerifyOCSP(http.HandlerFunc(a.forwardEmbeddingsRequest)))
+	mux.Handle("POST /v1/embeddings", a.ValidateModel(a.VerifyOCSP(http.HandlerFunc(a.forwardEmbeddingsRequest))))
 
-	mux.Handle(openai.TranscriptionsEndpoint, a.VerifyOCSP(http.HandlerFunc(a.forwardTranscriptionsRequest)))
+	mux.Handle(openai.TranscriptionsEndpoint, a.ValidateModel(a.VerifyOCSP(http.HandlerFunc(a.forwardTranscriptionsRequest))))
 }
 
 // HandlesCatchAll returns false because OpenAI adapter only handles specific endpoints.
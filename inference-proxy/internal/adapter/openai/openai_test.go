@@ -148,7 +148,7 @@ func TestForwardModelsRequest(t *testing.T) {
 
 			log := slog.Default()
 			forwarder := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New(tc.workloadTasks, nil, ocspFile, forwarder, log)
+			adapter, err := New(tc.workloadTasks, nil, ocspFile, nil, forwarder, log)
 			require.NoError(err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tc.path, nil)
@@ -229,7 +229,7 @@ func TestForwardSpecificModelRequest(t *testing.T) {
 
 			log := slog.Default()
 			forwarder := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New(tc.workloadTasks, nil, ocspFile, forwarder, log)
+			adapter, err := New(tc.workloadTasks, nil, ocspFile, nil, forwarder, log)
 			require.NoError(err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tc.path, nil)
@@ -364,7 +364,7 @@ func TestForwardChatCompletionsRequest(t *testing.T) {
 
 			log := slog.Default()
 			forwarder := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, forwarder, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, forwarder, log)
 			require.NoError(err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", strings.NewReader(tc.clientRequest))
@@ -425,7 +425,7 @@ func TestForwardChatCompletionsRequestDuplicatesReasoningContent(t *testing.T) {
 
 			log := slog.Default()
 			forwarder := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, forwarder, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, forwarder, log)
 			require.NoError(err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"some-model","messages":[{"role":"user","content":"hello"}],"cache_salt":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`))
@@ -566,7 +566,7 @@ func TestUsageExtraction(t *testing.T) {
 			require.NoError(t, os.WriteFile(ocspFile, ocspStatus, 0o644))
 
 			fwd := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, fwd, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, fwd, log)
 			require.NoError(t, err)
 
 			request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", strings.NewReader(clientRequest))
@@ -626,7 +626,7 @@ func TestTranscriptionUsageExtraction(t *testing.T) {
 			require.NoError(t, os.WriteFile(ocspFile, ocspStatus, 0o644))
 
 			fwd := forwarder.New(http.DefaultClient, srv.Listener.Addr().String(), forwarder.SchemeHTTP, log)
-			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, fwd, log)
+			adapter, err := New([]string{constants.WorkloadTaskGenerate}, &stubCipher{}, ocspFile, nil, fwd, log)
 			require.NoError(t, err)
 
 			// Build multipart form request with a model field.
@@ -31,7 +31,8 @@ func New(forwarder mutatingForwarder, log *slog.Logger) (*Adapter, error) {
 }
 
 // RegisterRoutes registers the unencrypted adapter handlers on the given ServeMux.
-// No middleware is applied for unencrypted adapter.
+// No middleware is applied for unencrypted adapter. In particular, OCSP policy headers aren't verified,
+// since there are no inference secrets to verify them with.
 func (t *Adapter) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/", t.forwardRequest)
 }
@@ -0,0 +1,61 @@
+package unencrypted
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOCSPVerificationSkipped checks that requests are forwarded regardless of their OCSP policy headers,
+// since the unencrypted adapter has no secrets to verify them with.
+func TestOCSPVerificationSkipped(t *testing.T) {
+	testCases := map[string]map[string]string{
+		"no OCSP headers": nil,
+		"invalid OCSP headers": {
+			constants.PrivatemodeNvidiaOCSPPolicyHeader:    "invalid",
+			constants.PrivatemodeNvidiaOCSPPolicyMACHeader: "invalid",
+			constants.PrivatemodeSecretIDHeader:            "unknown",
+		},
+	}
+
+	for name, headers := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			fwd := &stubForwarder{}
+			a, err := New(fwd, slog.Default())
+			require.NoError(err)
+			mux := http.NewServeMux()
+			a.RegisterRoutes(mux)
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, openai.ChatCompletionsEndpoint,
+				strings.NewReader(`{"model":"some-model","messages":[]}`))
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			assert.Equal(http.StatusOK, resp.Code)
+			assert.Equal(1, fwd.calls)
+		})
+	}
+}
+
+type stubForwarder struct {
+	calls int
+}
+
+func (f *stubForwarder) Forward(w http.ResponseWriter, _ *http.Request, _ forwarder.RequestMutator, _ forwarder.ResponseMapper, _ ...forwarder.Opts) {
+	f.calls++
+	w.WriteHeader(http.StatusOK)
+}
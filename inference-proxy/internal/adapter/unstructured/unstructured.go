@@ -48,8 +48,8 @@ func (t *Adapter) forwardRequest(w http.ResponseWriter, r *http.Request) {
 	t.forwarder.Forward(
 		w, r,
 		forwarder.WithRawRequestMutation(session.DecryptRequest(r.Context()), t.log),
-		// currently only JSON responses are supported
-		forwarder.JSONResponseMapper(session.EncryptResponse(r.Context()), nil),
+		// JSON responses are encrypted per field, other responses (e.g., text/plain or CSV) as a whole
+		forwarder.ContentTypeResponseMapper(session.EncryptResponse(r.Context()), nil),
 	)
 }
 
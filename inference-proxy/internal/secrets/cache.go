@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHitsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "privatemode_inference_secret_cache_hits_total",
+		Help: "Number of secrets not synced from etcd yet that were served from the secret cache",
+	})
+	cacheMissesMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "privatemode_inference_secret_cache_misses_total",
+		Help: "Number of secrets not synced from etcd yet that weren't in the secret cache",
+	})
+	cacheEvictionsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "privatemode_inference_secret_cache_evictions_total",
+		Help: "Number of secrets evicted from the secret cache because it was full or they expired",
+	})
+)
+
+// cache is a thread-safe LRU cache of secrets with a bounded size and a TTL.
+// It holds its own copies of the secrets, which are zeroed when they are removed from the cache.
+type cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	key     string
+	secret  []byte
+	expires time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{
+		size:      size,
+		ttl:       ttl,
+		now:       time.Now,
+		hits:      cacheHitsMetric,
+		misses:    cacheMissesMetric,
+		evictions: cacheEvictionsMetric,
+		entries:   map[string]*list.Element{},
+		lru:       list.New(),
+	}
+}
+
+// get returns a copy of the secret for key if it is cached and not expired.
+func (c *cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && !c.now().Before(elem.Value.(*cacheEntry).expires) {
+		c.remove(elem)
+		c.evictions.Inc()
+		ok = false
+	}
+	if !ok {
+		c.misses.Inc()
+		return nil, false
+	}
+	c.hits.Inc()
+	c.lru.MoveToFront(elem)
+	return bytes.Clone(elem.Value.(*cacheEntry).secret), true
+}
+
+// add caches a copy of secret for key. If the cache is full, the least recently used secret is evicted.
+func (c *cache) add(key string, secret []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+		c.evictions.Inc()
+	}
+	entry := &cacheEntry{key: key, secret: bytes.Clone(secret), expires: c.now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+// delete removes the secret for key from the cache.
+func (c *cache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// remove removes elem from the cache and zeroes its secret. c.mu must be held.
+func (c *cache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	clear(entry.secret)
+}
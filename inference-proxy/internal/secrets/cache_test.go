@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	a, b, d := []byte("secret-a"), []byte("secret-b"), []byte("secret-d")
+	c.add("a", a)
+	c.add("b", b)
+	_, ok := c.get("a") // "b" is now the least recently used secret
+	assert.True(ok)
+	cachedB := c.entries["b"].Value.(*cacheEntry).secret
+
+	c.add("d", d)
+	_, ok = c.get("b")
+	assert.False(ok)
+	assert.Equal(make([]byte, len(b)), cachedB, "evicted secret must be zeroed")
+	assert.Equal([]byte("secret-b"), b, "secret of the caller must not be modified")
+
+	got, ok := c.get("a")
+	assert.True(ok)
+	assert.Equal(a, got)
+	got, ok = c.get("d")
+	assert.True(ok)
+	assert.Equal(d, got)
+
+	// secrets expire after the TTL
+	now = now.Add(time.Minute)
+	_, ok = c.get("a")
+	assert.False(ok)
+	assert.Empty(c.entries["a"])
+
+	assert.Equal(3.0, counterValue(t, c.hits))
+	assert.Equal(2.0, counterValue(t, c.misses))
+	assert.Equal(2.0, counterValue(t, c.evictions))
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestCache(1, time.Minute)
+	c.add("a", []byte("secret"))
+	got, ok := c.get("a")
+	assert.True(ok)
+	clear(got)
+
+	got, ok = c.get("a")
+	assert.True(ok)
+	assert.Equal([]byte("secret"), got)
+}
+
+func TestSecretsCache(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	getter := &countingSecretGetter{secrets: map[string][]byte{"fetched": []byte("secret")}}
+	s := New(getter, map[string][]byte{"synced": []byte("synced-secret")})
+	s.EnableCache(4, time.Minute)
+	s.cache.hits, s.cache.misses, s.cache.evictions = newTestCounters()
+
+	for range 3 {
+		secret, ok := s.Get(t.Context(), "fetched")
+		require.True(ok)
+		assert.Equal([]byte("secret"), secret)
+	}
+	assert.Equal(1, getter.calls)
+	assert.Equal(2.0, counterValue(t, s.cache.hits))
+	assert.Equal(1.0, counterValue(t, s.cache.misses))
+
+	// synced secrets don't use the cache
+	_, ok := s.Get(t.Context(), "synced")
+	assert.True(ok)
+	assert.Equal(2.0, counterValue(t, s.cache.hits))
+	assert.Equal(1.0, counterValue(t, s.cache.misses))
+
+	// deleting a secret removes it from the cache
+	s.Delete("fetched")
+	delete(getter.secrets, "fetched")
+	_, ok = s.Get(t.Context(), "fetched")
+	assert.False(ok)
+	assert.Equal(2, getter.calls)
+}
+
+func newTestCache(size int, ttl time.Duration) *cache {
+	c := newCache(size, ttl)
+	c.hits, c.misses, c.evictions = newTestCounters()
+	return c
+}
+
+func newTestCounters() (hits, misses, evictions prometheus.Counter) {
+	return prometheus.NewCounter(prometheus.CounterOpts{Name: "hits"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "misses"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"})
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	var metric dto.Metric
+	require.NoError(t, counter.Write(&metric))
+	return metric.GetCounter().GetValue()
+}
+
+type countingSecretGetter struct {
+	secrets map[string][]byte
+	calls   int
+}
+
+func (g *countingSecretGetter) GetSecret(_ context.Context, key string) ([]byte, error) {
+	g.calls++
+	secret, ok := g.secrets[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return secret, nil
+}
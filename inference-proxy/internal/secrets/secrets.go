@@ -5,12 +5,14 @@ import (
 	"context"
 	"maps"
 	"sync"
+	"time"
 )
 
 // Secrets is a thread-safe map of secrets.
 type Secrets struct {
 	inferenceSecrets map[string][]byte
 	secretGetter     secretGetter
+	cache            *cache // caches secrets retrieved by secretGetter, nil if disabled
 	rwLock           sync.RWMutex
 }
 
@@ -38,13 +40,34 @@ func (s *Secrets) Get(ctx context.Context, key string) ([]byte, bool) {
 	// The etcd watch mechanism we use to populate the secret cache
 	// may not have been triggered yet
 	// In that case, try to retrieve the secret directly from etcd
+	if s.cache != nil {
+		if secret, ok := s.cache.get(key); ok {
+			return secret, true
+		}
+	}
 	secret, err := s.secretGetter.GetSecret(ctx, key)
 	if err != nil {
 		return nil, false
 	}
+	if s.cache != nil {
+		s.cache.add(key, secret)
+	}
 	return secret, true
 }
 
+// EnableCache caches up to size secrets that are retrieved directly because they weren't synced yet.
+// Cached secrets expire after ttl. The least recently used secret is evicted if the cache is full.
+// A size or ttl of 0 disables the cache.
+func (s *Secrets) EnableCache(size int, ttl time.Duration) {
+	s.rwLock.Lock()
+	defer s.rwLock.Unlock()
+	if size <= 0 || ttl <= 0 {
+		s.cache = nil
+		return
+	}
+	s.cache = newCache(size, ttl)
+}
+
 // Set sets the secret for the given key.
 func (s *Secrets) Set(key string, secret []byte) {
 	s.rwLock.Lock()
@@ -57,6 +80,9 @@ func (s *Secrets) Delete(key string) {
 	s.rwLock.Lock()
 	defer s.rwLock.Unlock()
 	delete(s.inferenceSecrets, key)
+	if s.cache != nil {
+		s.cache.delete(key)
+	}
 }
 
 // Keys returns the keys of the secrets.
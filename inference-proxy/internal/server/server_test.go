@@ -131,7 +131,7 @@ func setup(b *testing.B, apiType, workloadEndpoint string, log *slog.Logger) ([]
 	ocspFile := filepath.Join(b.TempDir(), "ocsp.json")
 	require.NoError(os.WriteFile(ocspFile, ocspStatus, 0o644))
 
-	adapters, err := adapter.New([]string{apiType}, []string{"generate"}, c, ocspFile, fw, log)
+	adapters, err := adapter.New([]string{apiType}, []string{"generate"}, c, ocspFile, nil, fw, log)
 	require.NoError(err)
 
 	server := New(adapters, nil, log)
@@ -12,8 +12,10 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/edgelesssys/continuum/inference-proxy/internal/adapter"
+	"github.com/edgelesssys/continuum/inference-proxy/internal/adapter/inference"
 	"github.com/edgelesssys/continuum/inference-proxy/internal/cipher"
 	"github.com/edgelesssys/continuum/inference-proxy/internal/etcd"
 	"github.com/edgelesssys/continuum/inference-proxy/internal/secrets"
@@ -22,6 +24,7 @@ import (
 	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/edgelesssys/continuum/internal/oss/logging"
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
 	"github.com/edgelesssys/continuum/internal/oss/process"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/afero"
@@ -58,6 +61,13 @@ func newRootCmd() *cobra.Command {
 	cmd.Flags().StringVar(&cfg.identityCAPath, "identity-ca-path", "", "path to the workload identity CA bundle (used to verify peer identity certs)")
 	cmd.Flags().StringVar(&cfg.workloadTasks, "workload-tasks", "", "comma separated list of tasks the workload supports")
 	cmd.Flags().StringVar(&cfg.ocspStatusFile, "ocsp-status-file", constants.OCSPStatusFile(), "path to read the OCSP status file from")
+	cmd.Flags().BoolVar(&cfg.requireFreshOCSP, "require-fresh-ocsp", false, "fail startup if the OCSP status file is older than --ocsp-status-max-age")
+	cmd.Flags().DurationVar(&cfg.ocspStatusMaxAge, "ocsp-status-max-age", 24*time.Hour, "maximum age of the OCSP status file if --require-fresh-ocsp is set")
+	cmd.Flags().StringSliceVar(&cfg.minOCSPPolicy, "min-ocsp-policy", nil,
+		"OCSP statuses the server accepts at most, regardless of the client policy (comma-separated, e.g. 'allow-good,allow-unknown'). "+
+			"Requests with a policy allowing other statuses are rejected. If not set, the client policy is trusted")
+	cmd.Flags().IntVar(&cfg.secretCacheSize, "secret-cache-size", 256, "maximum number of secrets cached after retrieving them from etcd before they were synced (0 disables the cache)")
+	cmd.Flags().DurationVar(&cfg.secretCacheTTL, "secret-cache-ttl", time.Minute, "time after which cached secrets expire, see --secret-cache-size")
 	cmd.Flags().StringVar(&cfg.logLevel, logging.Flag, logging.DefaultFlagValue, logging.FlagInfo)
 
 	must(cmd.MarkFlagRequired("workload-address"))
@@ -90,6 +100,11 @@ type runConfig struct {
 	identityCAPath   string
 	workloadTasks    string
 	ocspStatusFile   string
+	requireFreshOCSP bool
+	ocspStatusMaxAge time.Duration
+	minOCSPPolicy    []string
+	secretCacheSize  int
+	secretCacheTTL   time.Duration
 	logLevel         string
 }
 
@@ -99,6 +114,9 @@ func run(ctx context.Context, cfg runConfig, log *slog.Logger) error {
 			return fmt.Errorf("unsupported adapter type: %v", adapterType)
 		}
 	}
+	if cfg.secretCacheSize < 0 || cfg.secretCacheTTL < 0 {
+		return errors.New("secret cache size and TTL must not be negative")
+	}
 	log.Info("Starting inference proxy", "port", cfg.listenPort, "workloadPort", cfg.workloadPort, "adapterTypes", cfg.adapterTypes, "workloadAddress", cfg.workloadAddress)
 
 	ctx, cancel := process.SignalContext(ctx, os.Interrupt)
@@ -120,6 +138,7 @@ func run(ctx context.Context, cfg runConfig, log *slog.Logger) error {
 			return fmt.Errorf("setting up etcd sync: %w", err)
 		}
 		defer closeClient()
+		secrets.EnableCache(cfg.secretCacheSize, cfg.secretCacheTTL)
 	} else {
 		fmt.Println("-----------------------------------------------------")
 		fmt.Println("-----------------------WARNING-----------------------")
@@ -130,9 +149,20 @@ func run(ctx context.Context, cfg runConfig, log *slog.Logger) error {
 		log.Warn("Skipping etcd set up since the inference proxy is running an unencrypted API adapter")
 	}
 
+	if cfg.requireFreshOCSP {
+		if err := inference.CheckOCSPStatusFileAge(cfg.ocspStatusFile, cfg.ocspStatusMaxAge, time.Now()); err != nil {
+			return fmt.Errorf("requiring fresh OCSP status: %w", err)
+		}
+	}
+
+	minOCSPPolicy, err := parseMinOCSPPolicy(cfg.minOCSPPolicy)
+	if err != nil {
+		return fmt.Errorf("parsing minimum OCSP policy: %w", err)
+	}
+
 	forwarder := forwarder.New(&http.Client{}, net.JoinHostPort(cfg.workloadAddress, cfg.workloadPort), forwarder.SchemeHTTP, log)
 
-	adapters, err := adapter.New(cfg.adapterTypes, tasks, cipher.New(secrets), cfg.ocspStatusFile, forwarder, log)
+	adapters, err := adapter.New(cfg.adapterTypes, tasks, cipher.New(secrets), cfg.ocspStatusFile, minOCSPPolicy, forwarder, log)
 	if err != nil {
 		return fmt.Errorf("creating adapters: %w", err)
 	}
@@ -148,6 +178,12 @@ func run(ctx context.Context, cfg runConfig, log *slog.Logger) error {
 		log.Info("Starting metrics server", "port", cfg.metricsPort)
 		mux := http.NewServeMux()
 		mux.Handle(constants.MetricsEndpoint, promhttp.Handler())
+		if needsEtcd {
+			mux.Handle(constants.ReadinessEndpoint, inference.ReadinessHandler(cfg.ocspStatusFile, minOCSPPolicy, log))
+		} else {
+			// The unencrypted adapter doesn't use the OCSP status.
+			mux.HandleFunc(constants.ReadinessEndpoint, func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+		}
 
 		listener, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", cfg.metricsPort))
 		if err != nil {
@@ -180,6 +216,27 @@ func run(ctx context.Context, cfg runConfig, log *slog.Logger) error {
 	return wg.Wait()
 }
 
+// parseMinOCSPPolicy parses the statuses of the --min-ocsp-policy flag.
+// An empty policy returns nil, meaning that the client policy is trusted.
+func parseMinOCSPPolicy(statuses []string) ([]ocspheader.AllowStatus, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	var policy []ocspheader.AllowStatus
+	for _, s := range statuses {
+		status, err := ocspheader.AllowStatusFromString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, status)
+	}
+	// Clients that don't send a policy only accept good statuses, which must always be possible.
+	if !slices.Contains(policy, ocspheader.AllowStatusGood) {
+		return nil, fmt.Errorf("policy must include %s", ocspheader.AllowStatusGood)
+	}
+	return policy, nil
+}
+
 func setUpEtcdSync(ctx context.Context, address, etcdMemberCert, etcdMemberKey, etcdCA string, log *slog.Logger) (*secrets.Secrets, func(), error) {
 	log.Info("Setting up sync of inference secrets from etcd")
 	fs := afero.Afero{Fs: afero.NewOsFs()}
@@ -99,9 +99,18 @@ const (
 	// ErrorNoSecretForID is the error message returned when no secret is found for a given ID.
 	// NOTE: This is used for error checking in the PM proxy and should not be changed lightly for backwards compatibility.
 	ErrorNoSecretForID = "no secret for ID"
+	// ErrorOCSPStatusNotAccepted is part of the error message returned when the OCSP status of an attested component
+	// isn't accepted by the OCSP policy of the client. It is preceded by the name of the component.
+	// NOTE: This is used for metrics in the PM proxy and should not be changed lightly for backwards compatibility.
+	ErrorOCSPStatusNotAccepted = "OCSP status that is not accepted by the client"
+	// ErrorOCSPPolicyTooWeak is the error message returned when the OCSP policy of the client is weaker than the server's minimum.
+	// NOTE: This is used for metrics in the PM proxy and should not be changed lightly for backwards compatibility.
+	ErrorOCSPPolicyTooWeak = "OCSP policy of the client is weaker than the minimum policy of the server"
 
 	// CacheSaltHashLength is the length of the cache salt hash, i.e., the first bytes of the shard key.
 	CacheSaltHashLength = 16
+	// ShardKeyMaxTokens is the maximum estimated number of prompt tokens a shard key can be generated for.
+	ShardKeyMaxTokens = 1_000_000
 	// CacheBlockSizeTokens is the number of tokens in a cache block.
 	CacheBlockSizeTokens = 16
 	// ShardKeyFirstBoundaryBlocksPerChar is the number of blocks per character before the first boundary.
@@ -117,7 +126,7 @@ const (
 	// 32 blocks * 16 tokens = 512 tokens.
 	ShardKeyThirdBoundaryBlocksPerChar = 32
 	// ShardKeyThirdBoundaryBlocks is the number of cache blocks after the second boundary.
-	ShardKeyThirdBoundaryBlocks = 1_000_000 / CacheBlockSizeTokens
+	ShardKeyThirdBoundaryBlocks = ShardKeyMaxTokens / CacheBlockSizeTokens
 
 	// MaxFileSizeBytes is the maximum file size that users may upload.
 	// The user-facing limit is 50 MiB of file content; this higher value (128 MiB)
@@ -145,6 +154,8 @@ const (
 
 	// MetricsEndpoint is the endpoint where Prometheus metrics are exposed by default.
 	MetricsEndpoint = "/metrics"
+	// ReadinessEndpoint is the endpoint where the inference proxy serves readiness probes.
+	ReadinessEndpoint = "/readyz"
 )
 
 // ContinuumBaseDir is the base directory for files created or used by Continuum.
@@ -33,7 +33,7 @@ import (
 // RequestCipher provides encryption for all messages of a single request and decryption for its response messages.
 // You can't reuse the object to encrypt another request. You must create a new one for a new request.
 type RequestCipher struct {
-	secret []byte
+	sealer cipher.AEAD // derived from the inference secret, so the caller may zero the secret afterwards
 	id     string
 
 	nonce     []byte // nonce that is included in all encrypted messages and authenticated on decryption
@@ -42,13 +42,18 @@ type RequestCipher struct {
 }
 
 // NewRequestCipher creates a new RequestCipher.
+// The RequestCipher doesn't retain inferenceSecret, so the caller may zero it once this function returns.
 func NewRequestCipher(inferenceSecret []byte, inferenceSecretID string) (*RequestCipher, error) {
+	sealer, err := getSealer(inferenceSecret)
+	if err != nil {
+		return nil, err
+	}
 	nonce, err := GenerateNonce()
 	if err != nil {
 		return nil, err
 	}
 	return &RequestCipher{
-		secret:    inferenceSecret,
+		sealer:    sealer,
 		id:        inferenceSecretID,
 		nonce:     nonce,
 		encSeqNum: 0,
@@ -64,7 +69,7 @@ func (c *RequestCipher) Encrypt(plaintext string) (string, error) {
 	if c.decSeqNum != 0 {
 		return "", errors.New("can't encrypt another request after decrypting a response")
 	}
-	ciphertext, err := EncryptMessage(plaintext, c.secret, c.id, c.nonce, c.encSeqNum)
+	ciphertext, err := sealMessage(c.sealer, plaintext, c.id, c.nonce, c.encSeqNum)
 	if err != nil {
 		return "", err
 	}
@@ -74,7 +79,7 @@ func (c *RequestCipher) Encrypt(plaintext string) (string, error) {
 
 // DecryptResponse decrypts a response.
 func (c *RequestCipher) DecryptResponse(ciphertext string) (string, error) {
-	plaintext, err := DecryptMessage(ciphertext, c.secret, c.nonce, c.decSeqNum)
+	plaintext, err := openMessage(c.sealer, ciphertext, c.nonce, c.decSeqNum)
 	if err != nil {
 		return "", err
 	}
@@ -90,6 +95,20 @@ func EncryptMessage(plainText string, inferenceSecret []byte, inferenceSecretID
 	if err != nil {
 		return "", err
 	}
+	return sealMessage(sealer, plainText, inferenceSecretID, nonce, sequenceNumber)
+}
+
+// DecryptMessage decrypts a message using the given inference secret.
+// The message is expected to be in the format '"id:nonce:iv:ciphertext"'.
+func DecryptMessage(cipherText string, inferenceSecret []byte, nonce []byte, sequenceNumber uint32) (string, error) {
+	sealer, err := getSealer(inferenceSecret)
+	if err != nil {
+		return "", err
+	}
+	return openMessage(sealer, cipherText, nonce, sequenceNumber)
+}
+
+func sealMessage(sealer cipher.AEAD, plainText string, inferenceSecretID string, nonce []byte, sequenceNumber uint32) (string, error) {
 	iv, err := GenerateNonce()
 	if err != nil {
 		return "", err
@@ -104,9 +123,7 @@ func EncryptMessage(plainText string, inferenceSecret []byte, inferenceSecretID
 	), nil
 }
 
-// DecryptMessage decrypts a message using the given inference secret.
-// The message is expected to be in the format '"id:nonce:iv:ciphertext"'.
-func DecryptMessage(cipherText string, inferenceSecret []byte, nonce []byte, sequenceNumber uint32) (string, error) {
+func openMessage(sealer cipher.AEAD, cipherText string, nonce []byte, sequenceNumber uint32) (string, error) {
 	cipherText = strings.Trim(cipherText, `"`)
 	parts := strings.Split(cipherText, ":")
 	if len(parts) != 4 {
@@ -117,17 +134,12 @@ func DecryptMessage(cipherText string, inferenceSecret []byte, nonce []byte, seq
 	if err != nil {
 		return "", err
 	}
-	cipher, err := hex.DecodeString(parts[3])
-	if err != nil {
-		return "", err
-	}
-
-	sealer, err := getSealer(inferenceSecret)
+	cipherBytes, err := hex.DecodeString(parts[3])
 	if err != nil {
 		return "", err
 	}
 
-	plainText, err := sealer.Open(nil, iv, cipher, makeAdditionalData(nonce, sequenceNumber))
+	plainText, err := sealer.Open(nil, iv, cipherBytes, makeAdditionalData(nonce, sequenceNumber))
 	return string(plainText), err
 }
 
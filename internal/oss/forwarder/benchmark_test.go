@@ -5,6 +5,10 @@ package forwarder
 
 import (
 	"bytes"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/edgelesssys/continuum/internal/oss/crypto"
@@ -60,3 +64,36 @@ func BenchmarkAllJSONFieldMutation(b *testing.B) {
 		require.NoError(err)
 	}
 }
+
+func BenchmarkFormRequestMutation(b *testing.B) {
+	const fileSize = 100 * 1024 * 1024
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(b, writer.WriteField("model", "whisper"))
+	fileWriter, err := writer.CreateFormFile("file", "audio.mp3")
+	require.NoError(b, err)
+	_, err = fileWriter.Write(bytes.Repeat([]byte{0x42}, fileSize))
+	require.NoError(b, err)
+	require.NoError(b, writer.Close())
+	bodyBytes := body.Bytes()
+
+	formMutators := map[string]func(MutationFunc, FieldSelector, *slog.Logger) RequestMutator{
+		"buffered":   WithFormRequestMutation,
+		"low memory": WithLowMemoryFormRequestMutation,
+	}
+	log := slog.New(slog.DiscardHandler)
+
+	for name, newMutator := range formMutators {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				rc, err := crypto.NewRequestCipher(bytes.Repeat([]byte{0x42}, 32), "testing")
+				require.NoError(b, err)
+				req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(bodyBytes))
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				require.NoError(b, newMutator(rc.Encrypt, FieldSelector{{"model"}}, log)(req))
+			}
+		})
+	}
+}
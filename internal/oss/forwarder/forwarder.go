@@ -10,20 +10,24 @@ package forwarder
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/edgelesssys/continuum/internal/oss/persist"
 	"github.com/edgelesssys/continuum/internal/oss/requestid"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -32,15 +36,23 @@ const (
 	// SchemeHTTP protocol scheme.
 	SchemeHTTP ProtocolScheme = "http"
 
-	// copyBufferSize is the buffer size used for copying the response body.
+	// DefaultStreamBufferSize is the default buffer size used for copying streaming response bodies.
 	// It is specifically chosen to be smaller than the default buffer used by Go,
 	// to ensure streaming responses are comparatively smooth to directly interacting with the server.
 	// Size was chosen through experimentation with vllm benchmarks.
-	copyBufferSize = 1024 * 8
-	// privateModeEncryptedHeader is the header used to indicate whether a response is encrypted.
-	privateModeEncryptedHeader = "Privatemode-Encrypted"
+	DefaultStreamBufferSize = 1024 * 8
+	// EncryptedHeader is the header used to indicate whether a response is encrypted.
+	// Responses are encrypted unless it is set to "false". See [EncryptedHeaderMapper] for other conventions.
+	EncryptedHeader = "Privatemode-Encrypted"
+	// sseHeartbeat is an SSE comment line, which clients ignore.
+	sseHeartbeat = ": keepalive\n\n"
+	// defaultMaxRetryAfter caps the delay taken from an upstream Retry-After header.
+	defaultMaxRetryAfter = time.Minute
 )
 
+// errRequestTimeout is the cancellation cause of requests exceeding [WithRequestTimeout].
+var errRequestTimeout = errors.New("request timed out")
+
 // ProtocolScheme is the protocol scheme used for the forwarding.
 type ProtocolScheme string
 
@@ -73,12 +85,94 @@ func WithMaxBodyBytes(n int64, msg string) Opts {
 	}
 }
 
+// WithMaxResponseBytes limits non-streaming upstream response bodies to n bytes.
+// Responses with a body exceeding this limit are answered with a 502 response.
+// Streaming (SSE) responses are exempt.
+func WithMaxResponseBytes(n int64) Opts {
+	return func(o *opts) {
+		o.maxResponseBytes = n
+	}
+}
+
+// WithMaxRetryAfter caps the retry delay taken from an upstream Retry-After header to d.
+// A Retry-After header on 429 and 503 responses overrides the delay returned by the [RetryCallback].
+func WithMaxRetryAfter(d time.Duration) Opts {
+	return func(o *opts) {
+		o.maxRetryAfter = d
+	}
+}
+
+// WithRetryBudget limits the total time spent on a request including retries to d.
+// A retry is only attempted if its backoff delay ends within the budget and before the deadline of
+// the request context, if any. Otherwise, the last response or error is returned without waiting.
+func WithRetryBudget(d time.Duration) Opts {
+	return func(o *opts) {
+		o.retryBudget = d
+	}
+}
+
+// WithStreamHeartbeat sends an SSE comment to the client for streaming (SSE) responses whenever no
+// data has been sent for the given interval. This keeps intermediaries from closing idle
+// connections, e.g., while a model is thinking. Heartbeats are only sent between events.
+func WithStreamHeartbeat(interval time.Duration) Opts {
+	return func(o *opts) {
+		o.streamHeartbeat = interval
+	}
+}
+
+// WithRequestTimeout limits the time for forwarding a request to d, including retries and reading
+// the upstream response. Requests exceeding the limit receive a 504 response.
+// Streaming (SSE) responses are exempt once the upstream response headers have been received.
+func WithRequestTimeout(d time.Duration) Opts {
+	return func(o *opts) {
+		o.requestTimeout = d
+	}
+}
+
+// WithStripForwardedHeaders removes the X-Forwarded-For and Forwarded headers from the request
+// instead of appending the client's IP address. This avoids propagating client IPs upstream.
+func WithStripForwardedHeaders() Opts {
+	return func(o *opts) {
+		o.stripForwardedHeaders = true
+	}
+}
+
+// WithTrailers forwards the HTTP trailers of non-streaming upstream responses to clients that
+// accept them, as indicated by a "TE: trailers" request header.
+func WithTrailers() Opts {
+	return func(o *opts) {
+		o.forwardTrailers = true
+	}
+}
+
+// WithActiveStreams tracks the number of streaming (SSE) responses that are currently being sent
+// to clients in gauge. It is incremented when sending starts, and decremented when the stream
+// completes or is aborted.
+func WithActiveStreams(gauge prometheus.Gauge) Opts {
+	return func(o *opts) {
+		o.activeStreams = gauge
+	}
+}
+
+// RetryReasonConnectionError is the reason label of retries after a request failed without a response.
+// Retries after an error response are labeled with the class of the status code, e.g., "5xx".
+const RetryReasonConnectionError = "connection_error"
+
+// WithRetryCounter counts retries of requests in counter, which must have a single "reason" label.
+// See [RetryReasonConnectionError] for the values of the label.
+func WithRetryCounter(counter *prometheus.CounterVec) Opts {
+	return func(o *opts) {
+		o.retryCounter = counter
+	}
+}
+
 // NoRequestMutation skips any mutation on the [*http.Request].
 func NoRequestMutation(*http.Request) error { return nil }
 
 // RetryCallback determines whether a request should be retried based on status code and attempt number.
 // attempt is the number of requests made / the index of the next attempt, i.e., starting from 1.
 // Returns (shouldRetry, delay) where shouldRetry indicates if retry should happen and delay is the backoff duration.
+// If the upstream responds with 429 or 503 and a valid Retry-After header, the header takes precedence over delay.
 type RetryCallback func(statusCode int, errMsg string, attempt int) (bool, time.Duration)
 
 // NoRetry is a RetryCallback that never retries.
@@ -123,18 +217,48 @@ func (f *Forwarder) Forward(
 		return
 	}
 
+	timedOut := func() bool { return false }
+	stopTimeout := func() {}
+	if options.requestTimeout > 0 {
+		ctx, cancel := context.WithCancelCause(baseReq.Context())
+		defer cancel(nil)
+		timer := time.AfterFunc(options.requestTimeout, func() { cancel(errRequestTimeout) })
+		defer timer.Stop()
+		baseReq = baseReq.WithContext(ctx)
+		timedOut = func() bool { return errors.Is(context.Cause(ctx), errRequestTimeout) }
+		stopTimeout = func() { timer.Stop() }
+	}
+
 	// Prepare request for forwarding to upstream server
 	baseReq.RequestURI = ""
 	delHopHeaders(baseReq.Header)
-	updateForwardedHeader(baseReq.Header, baseReq.RemoteAddr)
+	if options.stripForwardedHeaders {
+		stripForwardedHeaders(baseReq.Header)
+	} else {
+		updateForwardedHeader(baseReq.Header, baseReq.RemoteAddr)
+	}
+	// Response bodies may be mutated, e.g., decrypted, so they must not be compressed by the upstream.
+	// The Accept-Encoding of req is left untouched, so the response can still be compressed toward the client.
+	baseReq.Header.Set("Accept-Encoding", "identity")
 
 	// Not setting the host here leads to "no Host in request URL" errors.
 	baseReq.URL.Host = options.host
 	// Not setting the scheme here leads to "http: no Host in request URL" errors.
 	baseReq.URL.Scheme = string(f.protocolScheme)
 
-	resp, err := f.sendWithRetry(baseReq, requestMutator, options.retryCallback)
+	resp, err := f.sendWithRetry(baseReq, requestMutator, options)
 	if err != nil {
+		if timedOut() {
+			f.logWarning("Request timed out", err, req)
+			HTTPError(w, req, http.StatusGatewayTimeout, "request timed out after %s", options.requestTimeout)
+			return
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			f.logWarning("Rejecting request", err, req)
+			HTTPError(w, req, statusErr.StatusCode, "%s", statusErr.Err)
+			return
+		}
 		if errors.Is(err, context.Canceled) {
 			f.logWarning("Connection closed by client before request could be fully forwarded", err, req)
 		} else {
@@ -145,14 +269,48 @@ func (f *Forwarder) Forward(
 	}
 	// Response body closing happens below, dependent on the mapper.
 
+	if isEventStream(resp) {
+		stopTimeout()
+
+		// The upstream may compress event streams although identity encoding was requested.
+		// Decompress them, so the response mapper can mutate the events.
+		if err := gunzipResponse(resp); err != nil {
+			_ = resp.Body.Close()
+			f.logError("Failed to decompress upstream response", err, req)
+			HTTPError(w, req, http.StatusBadGateway, "decompressing upstream response: %s", err)
+			return
+		}
+	}
+
+	if options.maxResponseBytes > 0 && !isEventStream(resp) {
+		// http.MaxBytesReader: passing nil for the ResponseWriter is explicitly supported though not documented
+		resp.Body = http.MaxBytesReader(nil, resp.Body, options.maxResponseBytes)
+	}
+
 	// Produce the downstream response from the upstream response.
 	dsResp, err := responseMapper(resp)
 	if err != nil {
 		_ = resp.Body.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			f.logWarning("Upstream response body too large", err, req)
+			HTTPError(w, req, http.StatusBadGateway, "upstream response too large: exceeds limit of %d bytes", maxBytesErr.Limit)
+			return
+		}
+		if timedOut() {
+			f.logWarning("Request timed out", err, req)
+			HTTPError(w, req, http.StatusGatewayTimeout, "request timed out after %s", options.requestTimeout)
+			return
+		}
 		f.logError("Failed to map upstream response to downstream response", err, req)
 		HTTPError(w, req, http.StatusInternalServerError, "mapping response: %s", err)
 		return
 	}
+	if sr, ok := dsResp.(*StreamingResponse); ok && options.streamErrorEvents && isEventStream(resp) {
+		sr.Body = newStreamErrorEventReader(sr.Body, func(err error) {
+			f.logWarning("Upstream stream failed, sending error event", err, req)
+		})
+	}
 	switch r := dsResp.(type) {
 	case *StreamingResponse:
 		// Wrapped body must be closed after sending, cascades down to the http.Response
@@ -166,7 +324,22 @@ func (f *Forwarder) Forward(
 		defer resp.Body.Close()
 	}
 
-	if err := SendResponse(w, dsResp); err != nil {
+	// The body of unary responses has been read to EOF, so the upstream trailers are available.
+	if ur, ok := dsResp.(*UnaryResponse); ok && options.forwardTrailers && len(resp.Trailer) > 0 && acceptsTrailers(req.Header) {
+		ur.Trailer = resp.Trailer.Clone()
+	}
+
+	if _, ok := dsResp.(*StreamingResponse); ok && options.activeStreams != nil && isEventStream(resp) {
+		options.activeStreams.Inc()
+		defer options.activeStreams.Dec()
+	}
+
+	if sr, ok := dsResp.(*StreamingResponse); ok && options.streamHeartbeat > 0 && isEventStream(resp) {
+		err = sendStreamingResponseWithHeartbeat(w, sr, options.streamHeartbeat, options.streamBufferSize)
+	} else {
+		err = sendResponse(w, dsResp, options.streamBufferSize)
+	}
+	if err != nil {
 		if errors.Is(err, context.Canceled) || req.Context().Err() == context.Canceled {
 			f.logWarning("Connection closed by client before forwarding finished", err, req)
 		} else {
@@ -253,7 +426,10 @@ func (f *Forwarder) cloneIncomingRequest(w http.ResponseWriter, req *http.Reques
 }
 
 // trySend attempts to send a request and returns whether to retry and any error.
-func (f *Forwarder) trySend(req *http.Request, requestMutator RequestMutator, attempt int, retryCallback RetryCallback) (bool, *http.Response, error) {
+// Retries are only attempted if their backoff delay ends before retryDeadline, unless it is zero.
+func (f *Forwarder) trySend(
+	req *http.Request, requestMutator RequestMutator, attempt int, retryDeadline time.Time, options *opts,
+) (bool, *http.Response, error) {
 	// Mutate request for this attempt
 	if err := requestMutator(req); err != nil {
 		return false, nil, fmt.Errorf("mutating request: %w", err)
@@ -263,7 +439,7 @@ func (f *Forwarder) trySend(req *http.Request, requestMutator RequestMutator, at
 
 	resp, err := f.client.Do(req)
 	if err != nil {
-		shouldRetry, retryErr := f.shouldRetry(req.Context(), retryCallback, err.Error(), -1, requestID, attempt)
+		shouldRetry, retryErr := f.shouldRetry(req.Context(), options, err.Error(), -1, nil, requestID, attempt, retryDeadline)
 		return shouldRetry, nil, errors.Join(err, retryErr)
 	}
 
@@ -281,18 +457,26 @@ func (f *Forwarder) trySend(req *http.Request, requestMutator RequestMutator, at
 		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 	}
 
-	shouldRetry, retryErr := f.shouldRetry(req.Context(), retryCallback, string(bodyBytes), resp.StatusCode, requestID, attempt)
+	shouldRetry, retryErr := f.shouldRetry(req.Context(), options, string(bodyBytes), resp.StatusCode, resp.Header, requestID, attempt, retryDeadline)
 	return shouldRetry, resp, errors.Join(readErr, retryErr)
 }
 
 // sendWithRetry handles the retry logic for forwarding requests.
-func (f *Forwarder) sendWithRetry(req *http.Request, requestMutator RequestMutator, retryCallback RetryCallback) (*http.Response, error) {
+func (f *Forwarder) sendWithRetry(req *http.Request, requestMutator RequestMutator, options *opts) (*http.Response, error) {
 	// Shortcut if there is no retry configured to skip cloning the request.
-	if retryCallback == nil {
-		_, resp, err := f.trySend(req, requestMutator, 1, retryCallback)
+	if options.retryCallback == nil {
+		_, resp, err := f.trySend(req, requestMutator, 1, time.Time{}, options)
 		return resp, err
 	}
 
+	var retryDeadline time.Time
+	if options.retryBudget > 0 {
+		retryDeadline = time.Now().Add(options.retryBudget)
+	}
+	if deadline, ok := req.Context().Deadline(); ok && (retryDeadline.IsZero() || deadline.Before(retryDeadline)) {
+		retryDeadline = deadline
+	}
+
 	// Forward request to inference server with retry logic.
 	attempt := 0
 
@@ -305,7 +489,7 @@ func (f *Forwarder) sendWithRetry(req *http.Request, requestMutator RequestMutat
 			return nil, fmt.Errorf("cloning request: %w", err)
 		}
 
-		retry, resp, err := f.trySend(reqCopy, requestMutator, attempt, retryCallback)
+		retry, resp, err := f.trySend(reqCopy, requestMutator, attempt, retryDeadline, options)
 		if retry {
 			continue
 		}
@@ -314,14 +498,19 @@ func (f *Forwarder) sendWithRetry(req *http.Request, requestMutator RequestMutat
 }
 
 func (f *Forwarder) shouldRetry(
-	ctx context.Context, retryCallback RetryCallback,
-	errMsg string, statusCode int, requestID string, attempt int,
+	ctx context.Context, options *opts,
+	errMsg string, statusCode int, header http.Header, requestID string, attempt int, retryDeadline time.Time,
 ) (bool, error) {
-	if retryCallback == nil {
+	if options.retryCallback == nil {
 		return false, nil
 	}
 
-	shouldRetry, delay := retryCallback(statusCode, errMsg, attempt)
+	shouldRetry, delay := options.retryCallback(statusCode, errMsg, attempt)
+	if shouldRetry && (statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable) {
+		if retryAfter, ok := parseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+			delay = min(retryAfter, options.maxRetryAfter)
+		}
+	}
 	f.log.Warn("Request failed, checking retry conditions",
 		"message", errMsg,
 		"statusCode", statusCode,
@@ -333,13 +522,50 @@ func (f *Forwarder) shouldRetry(
 	if !shouldRetry {
 		return false, nil
 	}
+	if !retryDeadline.IsZero() && time.Now().Add(delay).After(retryDeadline) {
+		f.log.Warn("Not retrying request, retry budget exceeded", "attempt", attempt, "delay", delay, "requestID", requestID)
+		return false, nil
+	}
 
 	if err := f.applyBackoffDelay(ctx, delay, attempt, requestID); err != nil {
 		return false, fmt.Errorf("request cancelled during backoff: %w", err)
 	}
+	if options.retryCounter != nil {
+		options.retryCounter.WithLabelValues(retryReason(statusCode)).Inc()
+	}
 	return true, nil
 }
 
+// retryReason returns the reason label of a retry after a request failed with statusCode,
+// which is negative if no response was received.
+func retryReason(statusCode int) string {
+	if statusCode < 0 {
+		return RetryReasonConnectionError
+	}
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP-date.
+// A date in the past results in a zero delay.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		// Avoid overflowing time.Duration for absurdly large values.
+		return time.Duration(min(seconds, int64(math.MaxInt64/time.Second))) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
+
 // openAIAPIErrorResponse represents the error response returned by the /v1/chat/completions endpoint.
 // It is a copy internal/oss/openai.APIErrorResponse for serialization only.
 type openAIAPIErrorResponse struct {
@@ -378,7 +604,7 @@ func HTTPError(w http.ResponseWriter, r *http.Request, code int, msg string, arg
 		w.Header().Set("Content-Type", "application/json")
 	}
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set(privateModeEncryptedHeader, "false")
+	w.Header().Set(EncryptedHeader, "false")
 	w.WriteHeader(code)
 	fmt.Fprint(w, formattedMsg)
 }
@@ -442,6 +668,36 @@ func delHopHeaders(header http.Header) {
 	}
 }
 
+// gunzipResponse decompresses the body of resp in place if its Content-Encoding is gzip.
+// The Content-Encoding and Content-Length headers are removed, since they don't apply to the decompressed body.
+func gunzipResponse(resp *http.Response) error {
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = readCloser{Reader: zr, Closer: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// acceptsTrailers reports whether the client accepts trailers in the response.
+func acceptsTrailers(header http.Header) bool {
+	for _, v := range header.Values("Te") {
+		for token := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // updateForwardedHeader updates the X-Forwarded-For header with the client's IP address.
 func updateForwardedHeader(header http.Header, remoteAddr string) {
 	if clientIP, _, err := net.SplitHostPort(remoteAddr); err == nil {
@@ -452,11 +708,28 @@ func updateForwardedHeader(header http.Header, remoteAddr string) {
 	}
 }
 
+// stripForwardedHeaders deletes headers which carry the IP addresses of the client and prior proxies.
+func stripForwardedHeaders(header http.Header) {
+	header.Del("X-Forwarded-For")
+	header.Del("Forwarded")
+}
+
 type opts struct {
-	host               string
-	retryCallback      RetryCallback
-	maxBodyBytes       int64
-	maxBodyExceededMsg string
+	host                  string
+	retryCallback         RetryCallback
+	maxBodyBytes          int64
+	maxBodyExceededMsg    string
+	maxResponseBytes      int64
+	maxRetryAfter         time.Duration
+	retryBudget           time.Duration
+	streamHeartbeat       time.Duration
+	streamBufferSize      int
+	requestTimeout        time.Duration
+	stripForwardedHeaders bool
+	streamErrorEvents     bool
+	forwardTrailers       bool
+	activeStreams         prometheus.Gauge
+	retryCounter          *prometheus.CounterVec
 }
 
 func defaultOpts(fw *Forwarder) *opts {
@@ -464,6 +737,8 @@ func defaultOpts(fw *Forwarder) *opts {
 		host:               fw.host,
 		retryCallback:      NoRetry,
 		maxBodyExceededMsg: "request body too large",
+		maxRetryAfter:      defaultMaxRetryAfter,
+		streamBufferSize:   DefaultStreamBufferSize,
 	}
 }
 
@@ -488,6 +763,9 @@ type UnaryResponse struct {
 	StatusCode int
 	Header     http.Header
 	Body       []byte
+	// Trailer is sent after the body. If set, the response is sent without Content-Length,
+	// since trailers require chunked transfer encoding.
+	Trailer http.Header
 }
 
 // responseSeal is the seal of the [Response] interface.
@@ -628,22 +906,44 @@ type Response interface {
 // with additional parameters and may return an error.
 type ResponseMapper func(*http.Response) (Response, error)
 
+// WithStreamBufferSize sets the buffer size used for copying streaming response bodies to n bytes.
+// Smaller buffers pass on data in smaller chunks. A value <= 0 keeps the [DefaultStreamBufferSize].
+func WithStreamBufferSize(n int) Opts {
+	return func(o *opts) {
+		if n > 0 {
+			o.streamBufferSize = n
+		}
+	}
+}
+
 // SendResponse sends out the resp to the downstream client via w.
 // For [StreamingResponse], it expects w to support flushing, and flushes after each Write() on w.
 // Because [io.Copy] performs one Write() for each Read(), resp can control flushing.
 // Alternatively, if resp implements [io.WriterTo], it can call Write() appropriately itself.
 func SendResponse(w http.ResponseWriter, resp Response) error {
+	return sendResponse(w, resp, DefaultStreamBufferSize)
+}
+
+// sendResponse is like [SendResponse], but copies streaming response bodies with a buffer of bufferSize bytes.
+func sendResponse(w http.ResponseWriter, resp Response, bufferSize int) error {
 	if resp == nil {
 		return errors.New("nil response")
 	}
 	switch r := resp.(type) {
 	case *UnaryResponse:
 		writeHeaderTo(w.Header(), r.Header)
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(r.Body)))
+		if len(r.Trailer) == 0 {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(r.Body)))
+		}
+		for k := range r.Trailer {
+			w.Header().Add("Trailer", k)
+		}
 		w.WriteHeader(r.StatusCode)
 		if _, err := w.Write(r.Body); err != nil {
 			return fmt.Errorf("writing response body: %w", err)
 		}
+		// Values of keys announced in the Trailer header are sent as trailers.
+		writeHeaderTo(w.Header(), r.Trailer)
 	case *StreamingResponse:
 		flusher, ok := w.(http.Flusher)
 		if !ok {
@@ -652,7 +952,7 @@ func SendResponse(w http.ResponseWriter, resp Response) error {
 		writeHeaderTo(w.Header(), r.Header)
 		w.WriteHeader(r.StatusCode)
 		fw := &flushingWriter{w: w, flusher: flusher}
-		copyBuffer := make([]byte, copyBufferSize)
+		copyBuffer := make([]byte, bufferSize)
 		if _, err := io.CopyBuffer(fw, r.Body, copyBuffer); err != nil {
 			return fmt.Errorf("streaming response body: %w", err)
 		}
@@ -662,6 +962,84 @@ func SendResponse(w http.ResponseWriter, resp Response) error {
 	return nil
 }
 
+// sendStreamingResponseWithHeartbeat is like [sendResponse] for a [StreamingResponse], but writes
+// an SSE comment whenever no data has been sent for interval. Heartbeats are only written if the
+// previously sent data ended on an event boundary, so they never end up inside an event.
+func sendStreamingResponseWithHeartbeat(w http.ResponseWriter, r *StreamingResponse, interval time.Duration, bufferSize int) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("ResponseWriter does not support flushing")
+	}
+	writeHeaderTo(w.Header(), r.Header)
+	w.WriteHeader(r.StatusCode)
+	flusher.Flush()
+
+	type readResult struct {
+		data []byte
+		err  error
+	}
+	reads := make(chan readResult)
+	readNext := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+
+	// Read in a separate goroutine so that waiting for upstream data doesn't block heartbeats.
+	// The goroutine is unblocked by the caller closing the body.
+	go func() {
+		buf := make([]byte, bufferSize)
+		for {
+			n, err := r.Body.Read(buf)
+			select {
+			case reads <- readResult{data: buf[:n], err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			// Wait until buf has been written before reusing it.
+			select {
+			case <-readNext:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	atEventBoundary := true
+
+	for {
+		select {
+		case res := <-reads:
+			if len(res.data) > 0 {
+				if _, err := w.Write(res.data); err != nil {
+					return fmt.Errorf("streaming response body: %w", err)
+				}
+				flusher.Flush()
+				atEventBoundary = bytes.HasSuffix(res.data, []byte("\n\n")) || bytes.HasSuffix(res.data, []byte("\r\n\r\n"))
+			}
+			if errors.Is(res.err, io.EOF) {
+				return nil
+			}
+			if res.err != nil {
+				return fmt.Errorf("streaming response body: %w", res.err)
+			}
+			readNext <- struct{}{}
+			timer.Reset(interval)
+		case <-timer.C:
+			if atEventBoundary {
+				if _, err := io.WriteString(w, sseHeartbeat); err != nil {
+					return fmt.Errorf("writing heartbeat: %w", err)
+				}
+				flusher.Flush()
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
 func writeHeaderTo(dst, src http.Header) {
 	for k, vs := range src {
 		// No cloning here: The header is written out immediately after this call, so any changes
@@ -676,3 +1054,8 @@ func requestID(r *http.Request) string {
 	}
 	return requestid.FromUserHeader(r)
 }
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -5,16 +5,24 @@ package forwarder
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -123,6 +131,237 @@ func TestForwardStreamingAborted(t *testing.T) {
 	assert.Equal(1, chunkCount, "Should have received 1 complete chunk before abort")
 }
 
+func TestForwardStreamingHeartbeat(t *testing.T) {
+	const event = "data: {\"field\": \"encryptedData\"}\n\n"
+
+	testCases := map[string]struct {
+		// chunks are sent by the upstream with a stall after each one
+		chunks         []string
+		mapper         ResponseMapper
+		wantBody       string
+		wantHeartbeats bool
+	}{
+		"stall between events": {
+			chunks:         []string{event, event},
+			mapper:         JSONResponseMapper((&stubMutator{mutateResponse: `"plainText"`}).mutate, nil),
+			wantBody:       strings.Repeat(`data: {"field": "plainText"}`+"\n\n", 2),
+			wantHeartbeats: true,
+		},
+		"stall before first event": {
+			chunks:         []string{"", event},
+			mapper:         PassthroughResponseMapper,
+			wantBody:       event,
+			wantHeartbeats: true,
+		},
+		"stall inside event": {
+			chunks:   []string{`data: {"field": `, `"encryptedData"}` + "\n\n"},
+			mapper:   PassthroughResponseMapper,
+			wantBody: event,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				for i, chunk := range tc.chunks {
+					_, _ = w.Write([]byte(chunk))
+					w.(http.Flusher).Flush()
+					if i < len(tc.chunks)-1 {
+						time.Sleep(100 * time.Millisecond)
+					}
+				}
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+
+			forwarder.Forward(
+				resp,
+				req,
+				NoRequestMutation,
+				tc.mapper,
+				WithStreamHeartbeat(10*time.Millisecond),
+			)
+
+			assert.Equal(http.StatusOK, resp.Code)
+			body := resp.Body.String()
+			if tc.wantHeartbeats {
+				assert.Contains(body, sseHeartbeat)
+			} else {
+				assert.NotContains(body, sseHeartbeat)
+			}
+			// Heartbeats are separate comment events and leave the data events untouched.
+			assert.Equal(tc.wantBody, strings.ReplaceAll(body, sseHeartbeat, ""))
+		})
+	}
+}
+
+func TestForwardStreamBufferSize(t *testing.T) {
+	body := strings.Repeat("data: {\"field\": \"encryptedData\"}\n\n", 1000)
+
+	testCases := map[string]struct {
+		opts           []Opts
+		wantBufferSize int
+	}{
+		"default": {
+			wantBufferSize: DefaultStreamBufferSize,
+		},
+		"custom": {
+			opts:           []Opts{WithStreamBufferSize(1024)},
+			wantBufferSize: 1024,
+		},
+		"custom with heartbeat": {
+			opts:           []Opts{WithStreamBufferSize(1024), WithStreamHeartbeat(time.Minute)},
+			wantBufferSize: 1024,
+		},
+		"invalid size keeps default": {
+			opts:           []Opts{WithStreamBufferSize(0)},
+			wantBufferSize: DefaultStreamBufferSize,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(body))
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			var reader *bufferSizeRecorder
+			mapper := func(resp *http.Response) (Response, error) {
+				reader = &bufferSizeRecorder{ReadCloser: resp.Body}
+				resp.Body = reader
+				return NewStreamingResponse(resp), nil
+			}
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+
+			forwarder.Forward(resp, req, NoRequestMutation, mapper, tc.opts...)
+
+			assert.Equal(http.StatusOK, resp.Code)
+			assert.Equal(body, resp.Body.String())
+			assert.NotEmpty(reader.sizes)
+			for _, size := range reader.sizes {
+				assert.Equal(tc.wantBufferSize, size)
+			}
+		})
+	}
+}
+
+// bufferSizeRecorder records the size of the buffers passed to Read.
+type bufferSizeRecorder struct {
+	io.ReadCloser
+	sizes []int
+}
+
+func (r *bufferSizeRecorder) Read(p []byte) (int, error) {
+	r.sizes = append(r.sizes, len(p))
+	return r.ReadCloser.Read(p)
+}
+
+func TestForwardNonStreamingNoHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"field": "value"}`))
+	}))
+	defer stubServer.Close()
+
+	forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+	resp := httptest.NewRecorder()
+
+	forwarder.Forward(resp, req, NoRequestMutation, PassthroughResponseMapper, WithStreamHeartbeat(time.Millisecond))
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(`{"field": "value"}`, resp.Body.String())
+}
+
+func TestForwardRequestTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	const stall = 200 * time.Millisecond
+
+	testCases := map[string]struct {
+		contentType    string
+		stallHeaders   bool
+		stallBody      bool
+		mapper         ResponseMapper
+		wantStatusCode int
+	}{
+		"fast response": {
+			contentType:    "application/json",
+			mapper:         PassthroughResponseMapper,
+			wantStatusCode: http.StatusOK,
+		},
+		"slow response headers": {
+			contentType:    "application/json",
+			stallHeaders:   true,
+			mapper:         PassthroughResponseMapper,
+			wantStatusCode: http.StatusGatewayTimeout,
+		},
+		"slow response body": {
+			contentType:    "application/json",
+			stallBody:      true,
+			mapper:         JSONResponseMapper((&stubMutator{mutateResponse: `"plainText"`}).mutate, nil),
+			wantStatusCode: http.StatusGatewayTimeout,
+		},
+		"streaming response is exempt": {
+			contentType:    "text/event-stream",
+			stallBody:      true,
+			mapper:         PassthroughResponseMapper,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tc.stallHeaders {
+					time.Sleep(stall)
+				}
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				if tc.stallBody {
+					time.Sleep(stall)
+				}
+				_, _ = w.Write([]byte(`{"field": "value"}`))
+			}))
+			defer stubServer.Close()
+
+			fwd := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+
+			fwd.Forward(resp, req, NoRequestMutation, tc.mapper, WithRequestTimeout(timeout))
+
+			assert.Equal(tc.wantStatusCode, resp.Code)
+			if tc.wantStatusCode == http.StatusGatewayTimeout {
+				assert.Contains(resp.Body.String(), "request timed out")
+			}
+		})
+	}
+}
+
 func TestForwardNonStreaming(t *testing.T) {
 	assert := assert.New(t)
 
@@ -186,6 +425,57 @@ func TestForwardMutationError(t *testing.T) {
 	assert.Equal(http.StatusInternalServerError, resp.Code)
 }
 
+func TestForwardRequestMutationStatusError(t *testing.T) {
+	testCases := map[string]struct {
+		mutateErr      error
+		wantStatusCode int
+		wantMessage    string
+	}{
+		"plain error": {
+			mutateErr:      assert.AnError,
+			wantStatusCode: http.StatusInternalServerError,
+		},
+		"status error": {
+			mutateErr:      &StatusError{StatusCode: http.StatusRequestHeaderFieldsTooLarge, Err: errors.New("headers too large")},
+			wantStatusCode: http.StatusRequestHeaderFieldsTooLarge,
+			wantMessage:    `{"error":{"message":"headers too large","type":""}}`,
+		},
+		"wrapped status error": {
+			mutateErr:      fmt.Errorf("checking headers: %w", &StatusError{StatusCode: http.StatusBadRequest, Err: errors.New("invalid header")}),
+			wantStatusCode: http.StatusBadRequest,
+			wantMessage:    `{"error":{"message":"invalid header","type":""}}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			called := false
+			stubServer := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				called = true
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+			forwarder.Forward(
+				resp, req,
+				func(*http.Request) error { return tc.mutateErr },
+				PassthroughResponseMapper,
+			)
+
+			assert.False(called, "request must not be sent")
+			assert.Equal(tc.wantStatusCode, resp.Code)
+			if tc.wantMessage != "" {
+				assert.JSONEq(tc.wantMessage, resp.Body.String())
+			}
+		})
+	}
+}
+
 func TestHTTPError(t *testing.T) {
 	tests := map[string]struct {
 		acceptHeader        string
@@ -297,6 +587,341 @@ func TestForwardMaxBodyBytes(t *testing.T) {
 	}
 }
 
+func TestForwardForwardedHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		opts              []Opts
+		priorForwardedFor string
+		wantForwardedFor  string
+		wantForwarded     string
+	}{
+		"append client IP": {
+			wantForwardedFor: "192.0.2.1",
+		},
+		"append client IP to prior header": {
+			priorForwardedFor: "198.51.100.7",
+			wantForwardedFor:  "198.51.100.7, 192.0.2.1",
+			wantForwarded:     "for=198.51.100.7",
+		},
+		"strip headers": {
+			opts: []Opts{WithStripForwardedHeaders()},
+		},
+		"strip prior headers": {
+			opts:              []Opts{WithStripForwardedHeaders()},
+			priorForwardedFor: "198.51.100.7",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var gotForwardedFor, gotForwarded string
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotForwardedFor = r.Header.Get("X-Forwarded-For")
+				gotForwarded = r.Header.Get("Forwarded")
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer stubServer.Close()
+
+			fwd := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tc.priorForwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.priorForwardedFor)
+				req.Header.Set("Forwarded", "for="+tc.priorForwardedFor)
+			}
+			resp := httptest.NewRecorder()
+
+			fwd.Forward(resp, req, NoRequestMutation, PassthroughResponseMapper, tc.opts...)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, tc.wantForwardedFor, gotForwardedFor)
+			assert.Equal(t, tc.wantForwarded, gotForwarded)
+		})
+	}
+}
+
+func TestForwardAcceptEncoding(t *testing.T) {
+	testCases := map[string]struct {
+		acceptEncoding string
+	}{
+		"no accept encoding":   {},
+		"gzip":                 {acceptEncoding: "gzip"},
+		"multiple encodings":   {acceptEncoding: "gzip, deflate, br"},
+		"identity from client": {acceptEncoding: "identity"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var gotAcceptEncoding []string
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAcceptEncoding = r.Header.Values("Accept-Encoding")
+				_, _ = w.Write([]byte(`{"hello":"world"}`))
+			}))
+			defer stubServer.Close()
+
+			fwd := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			resp := httptest.NewRecorder()
+
+			fwd.Forward(resp, req, NoRequestMutation, PassthroughResponseMapper)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, []string{"identity"}, gotAcceptEncoding)
+			assert.Equal(t, `{"hello":"world"}`, resp.Body.String())
+			assert.Equal(t, tc.acceptEncoding, req.Header.Get("Accept-Encoding"), "the header of the client request must not be modified")
+		})
+	}
+}
+
+func TestForwardGzipEventStream(t *testing.T) {
+	testCases := map[string]struct {
+		contentEncoding string
+		body            func(w http.ResponseWriter) io.WriteCloser
+		wantCode        int
+	}{
+		"gzip": {
+			contentEncoding: "gzip",
+			body:            func(w http.ResponseWriter) io.WriteCloser { return gzip.NewWriter(w) },
+			wantCode:        http.StatusOK,
+		},
+		"uncompressed": {
+			body:     func(w http.ResponseWriter) io.WriteCloser { return nopWriteCloser{w} },
+			wantCode: http.StatusOK,
+		},
+		"invalid gzip": {
+			contentEncoding: "gzip",
+			body:            func(w http.ResponseWriter) io.WriteCloser { return nopWriteCloser{w} },
+			wantCode:        http.StatusBadGateway,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mutator := &stubMutator{
+				mutateResponse: `"plainText"`,
+			}
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				if tc.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", tc.contentEncoding)
+				}
+				body := tc.body(w)
+				for range 3 {
+					_, _ = body.Write([]byte("data: {\"field\": \"encryptedData\"}\n\n"))
+					if zw, ok := body.(*gzip.Writer); ok {
+						_ = zw.Flush()
+					}
+					w.(http.Flusher).Flush()
+				}
+				_ = body.Close()
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+
+			forwarder.Forward(resp, req, NoRequestMutation, JSONResponseMapper(mutator.mutate, nil))
+
+			assert.Equal(tc.wantCode, resp.Code)
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			assert.Empty(resp.Header().Get("Content-Encoding"))
+			assert.Equal(strings.Repeat(`data: {"field": "plainText"}`+"\n\n", 3), resp.Body.String())
+		})
+	}
+}
+
+func TestForwardActiveStreams(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "active_streams"})
+	release := make(chan struct{})
+	stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"field\": \"encryptedData\"}\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer stubServer.Close()
+
+	forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarder.Forward(w, r, NoRequestMutation, PassthroughResponseMapper, WithActiveStreams(gauge))
+	}))
+	defer proxy.Close()
+
+	assert.InDelta(0, gaugeValue(t, gauge), 0)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, proxy.URL, nil)
+	require.NoError(err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(err)
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(err)
+	assert.Contains(line, "encryptedData")
+
+	// the stream is still open
+	assert.InDelta(1, gaugeValue(t, gauge), 0)
+
+	close(release)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(err)
+	assert.Eventually(func() bool { return gaugeValue(t, gauge) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	t.Helper()
+	var metric dto.Metric
+	require.NoError(t, gauge.Write(&metric))
+	return metric.GetGauge().GetValue()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestForwardTrailers(t *testing.T) {
+	testCases := map[string]struct {
+		opts        []Opts
+		te          string
+		stream      bool
+		wantTrailer string
+	}{
+		"forward trailers": {
+			opts:        []Opts{WithTrailers()},
+			te:          "trailers",
+			wantTrailer: "42",
+		},
+		"client doesn't accept trailers": {
+			opts: []Opts{WithTrailers()},
+		},
+		"trailers disabled": {
+			te: "trailers",
+		},
+		"streaming response": {
+			opts:   []Opts{WithTrailers()},
+			te:     "trailers",
+			stream: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Trailer", "Usage-Tokens")
+				if tc.stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+				}
+				_, _ = w.Write([]byte("data: hello\n\n"))
+				w.Header().Set("Usage-Tokens", "42")
+			}))
+			defer stubServer.Close()
+
+			fwd := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			// Serve the forwarder through a real server, since the recorder doesn't send trailers.
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fwd.Forward(w, r, NoRequestMutation, PassthroughResponseMapper, tc.opts...)
+			}))
+			defer proxy.Close()
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, proxy.URL, nil)
+			require.NoError(err)
+			if tc.te != "" {
+				req.Header.Set("TE", tc.te)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(err)
+
+			assert.Equal(http.StatusOK, resp.StatusCode)
+			assert.Equal("data: hello\n\n", string(body))
+			assert.Equal(tc.wantTrailer, resp.Trailer.Get("Usage-Tokens"))
+		})
+	}
+}
+
+func TestForwardMaxResponseBytes(t *testing.T) {
+	const maxBytes = 1024
+
+	testCases := map[string]struct {
+		contentType        string
+		bodySize           int
+		expectedStatusCode int
+	}{
+		"just under the limit": {
+			contentType:        "application/json",
+			bodySize:           maxBytes - 1,
+			expectedStatusCode: http.StatusOK,
+		},
+		"exactly the limit": {
+			contentType:        "application/json",
+			bodySize:           maxBytes,
+			expectedStatusCode: http.StatusOK,
+		},
+		"just over the limit": {
+			contentType:        "application/json",
+			bodySize:           maxBytes + 1,
+			expectedStatusCode: http.StatusBadGateway,
+		},
+		"streaming response is exempt": {
+			contentType:        "text/event-stream",
+			bodySize:           10 * maxBytes,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			body := strings.Repeat("A", tc.bodySize)
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				_, _ = w.Write([]byte(body))
+			}))
+			defer stubServer.Close()
+
+			fwd := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+			resp := httptest.NewRecorder()
+
+			fwd.Forward(
+				resp, req,
+				NoRequestMutation, PassthroughResponseMapper,
+				WithMaxResponseBytes(maxBytes),
+			)
+
+			assert.Equal(tc.expectedStatusCode, resp.Code)
+			if tc.expectedStatusCode == http.StatusOK {
+				assert.Equal(body, resp.Body.String())
+			} else {
+				assert.Contains(resp.Body.String(), "upstream response too large")
+			}
+		})
+	}
+}
+
 func TestForwardRetry(t *testing.T) {
 	testCases := map[string]struct {
 		delay            time.Duration
@@ -383,3 +1008,380 @@ func TestForwardRetry(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		value     string
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		"seconds":          {"5", 5 * time.Second, true},
+		"zero seconds":     {"0", 0, true},
+		"whitespace":       {" 7 ", 7 * time.Second, true},
+		"http date":        {now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		"rfc850 date":      {now.Add(2 * time.Minute).Format(time.RFC850), 2 * time.Minute, true},
+		"date in past":     {now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		"empty":            {"", 0, false},
+		"negative":         {"-1", 0, false},
+		"fractional":       {"1.5", 0, false},
+		"invalid":          {"soon", 0, false},
+		"huge seconds":     {"99999999999999999", time.Duration(math.MaxInt64/time.Second) * time.Second, true},
+		"seconds overflow": {"999999999999999999999", 0, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantDelay, delay)
+		})
+	}
+}
+
+func TestForwardRetryAfter(t *testing.T) {
+	testCases := map[string]struct {
+		statusCode    int
+		retryAfter    func() string
+		maxRetryAfter time.Duration
+		wantMinDelay  time.Duration
+		wantMaxDelay  time.Duration
+	}{
+		"numeric on 429": {
+			statusCode:    http.StatusTooManyRequests,
+			retryAfter:    func() string { return "1" },
+			maxRetryAfter: 100 * time.Millisecond,
+			wantMinDelay:  100 * time.Millisecond,
+			wantMaxDelay:  time.Second,
+		},
+		"date on 503": {
+			statusCode:    http.StatusServiceUnavailable,
+			retryAfter:    func() string { return time.Now().Add(time.Hour).Format(http.TimeFormat) },
+			maxRetryAfter: 100 * time.Millisecond,
+			wantMinDelay:  100 * time.Millisecond,
+			wantMaxDelay:  time.Second,
+		},
+		"date in past on 503": {
+			statusCode:    http.StatusServiceUnavailable,
+			retryAfter:    func() string { return time.Now().Add(-time.Hour).Format(http.TimeFormat) },
+			maxRetryAfter: time.Minute,
+			wantMaxDelay:  500 * time.Millisecond,
+		},
+		"ignored on 500": {
+			statusCode:    http.StatusInternalServerError,
+			retryAfter:    func() string { return "60" },
+			maxRetryAfter: time.Minute,
+			wantMaxDelay:  500 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			attemptCount := 0
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attemptCount++
+				if attemptCount == 1 {
+					w.Header().Set("Retry-After", tc.retryAfter())
+					w.WriteHeader(tc.statusCode)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			// The callback's own backoff is 0, so any delay must come from the Retry-After header.
+			retryCallback := func(_ int, _ string, attempt int) (bool, time.Duration) {
+				return attempt < 2, 0
+			}
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+			resp := httptest.NewRecorder()
+
+			startTime := time.Now()
+			forwarder.Forward(
+				resp,
+				req,
+				NoRequestMutation,
+				PassthroughResponseMapper,
+				WithRetryCallback(retryCallback),
+				WithMaxRetryAfter(tc.maxRetryAfter),
+			)
+			elapsed := time.Since(startTime)
+
+			assert.Equal(http.StatusOK, resp.Code)
+			assert.Equal(2, attemptCount)
+			assert.GreaterOrEqual(elapsed, tc.wantMinDelay)
+			assert.Less(elapsed, tc.wantMaxDelay)
+		})
+	}
+}
+
+func TestForwardRetryBudget(t *testing.T) {
+	testCases := map[string]struct {
+		retryBudget     time.Duration
+		contextTimeout  time.Duration
+		retryAfter      string
+		backoff         time.Duration
+		wantMinAttempts int
+		wantMaxAttempts int
+	}{
+		"Retry-After exceeds budget": {
+			retryBudget:     500 * time.Millisecond,
+			retryAfter:      "1",
+			wantMinAttempts: 1,
+			wantMaxAttempts: 1,
+		},
+		"backoff exhausts budget": {
+			retryBudget:     300 * time.Millisecond,
+			backoff:         50 * time.Millisecond,
+			wantMinAttempts: 2,
+			wantMaxAttempts: 7,
+		},
+		"Retry-After exceeds context deadline": {
+			contextTimeout:  500 * time.Millisecond,
+			retryAfter:      "1",
+			wantMinAttempts: 1,
+			wantMaxAttempts: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var attemptCount atomic.Int32
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attemptCount.Add(1)
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			// Retry forever, so only the budget stops the retry loop.
+			retryCallback := func(_ int, _ string, _ int) (bool, time.Duration) {
+				return true, tc.backoff
+			}
+
+			ctx := t.Context()
+			if tc.contextTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.contextTimeout)
+				defer cancel()
+			}
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/test", nil)
+			resp := httptest.NewRecorder()
+
+			startTime := time.Now()
+			forwarder.Forward(
+				resp,
+				req,
+				NoRequestMutation,
+				PassthroughResponseMapper,
+				WithRetryCallback(retryCallback),
+				WithRetryBudget(tc.retryBudget),
+			)
+			elapsed := time.Since(startTime)
+
+			assert.Equal(http.StatusServiceUnavailable, resp.Code)
+			assert.GreaterOrEqual(int(attemptCount.Load()), tc.wantMinAttempts)
+			assert.LessOrEqual(int(attemptCount.Load()), tc.wantMaxAttempts)
+			assert.Less(elapsed, max(tc.retryBudget, tc.contextTimeout))
+		})
+	}
+}
+
+func TestForwardStreamErrorEvents(t *testing.T) {
+	const event = "data: {\"field\": \"value\"}\n\n"
+
+	// upstreamErrorMapper simulates a stream failing with the given error once the upstream body is read.
+	upstreamErrorMapper := func(err error) ResponseMapper {
+		return func(resp *http.Response) (Response, error) {
+			r := NewStreamingResponseWithHeaders(resp)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(r.Body, iotest.ErrReader(err)), r.Body}
+			return r, nil
+		}
+	}
+
+	testCases := map[string]struct {
+		partialEvent   bool
+		abort          bool
+		mapper         ResponseMapper
+		disabled       bool
+		heartbeat      time.Duration
+		wantErrorEvent bool
+		wantTerminate  bool
+		wantStatusCode int
+		wantRetriable  bool
+	}{
+		"upstream 503 mid-stream": {
+			mapper:         upstreamErrorMapper(&StatusError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("model overloaded")}),
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantRetriable:  true,
+		},
+		"upstream 503 mid-stream with heartbeat": {
+			mapper:         upstreamErrorMapper(&StatusError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("model overloaded")}),
+			heartbeat:      time.Hour,
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantRetriable:  true,
+		},
+		"upstream 400 mid-stream is not retriable": {
+			mapper:         upstreamErrorMapper(fmt.Errorf("mapping: %w", &StatusError{StatusCode: http.StatusBadRequest, Err: errors.New("invalid token")})),
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"read error mid-stream": {
+			mapper:         upstreamErrorMapper(errors.New("read failed")),
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusBadGateway,
+			wantRetriable:  true,
+		},
+		"permanent stream error": {
+			mapper:         upstreamErrorMapper(&StreamError{Err: errors.New("invalid event")}),
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusBadGateway,
+		},
+		"retriable stream error": {
+			mapper:         upstreamErrorMapper(fmt.Errorf("mapping: %w", &StreamError{Retriable: true, Err: errors.New("overloaded")})),
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusBadGateway,
+			wantRetriable:  true,
+		},
+		"upstream connection aborted": {
+			abort:          true,
+			mapper:         PassthroughResponseMapper,
+			wantErrorEvent: true,
+			wantStatusCode: http.StatusBadGateway,
+			wantRetriable:  true,
+		},
+		"partial event is terminated": {
+			partialEvent:   true,
+			abort:          true,
+			mapper:         PassthroughResponseMapper,
+			wantErrorEvent: true,
+			wantTerminate:  true,
+			wantStatusCode: http.StatusBadGateway,
+			wantRetriable:  true,
+		},
+		"disabled": {
+			mapper:   upstreamErrorMapper(errors.New("read failed")),
+			disabled: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte(event))
+				if tc.partialEvent {
+					_, _ = w.Write([]byte("data: {\"field\": "))
+				}
+				w.(http.Flusher).Flush()
+				if tc.abort {
+					// Abort the connection without finishing the chunked body.
+					panic(http.ErrAbortHandler)
+				}
+			}))
+			defer stubServer.Close()
+
+			forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+
+			opts := []Opts{WithStreamHeartbeat(tc.heartbeat)}
+			if !tc.disabled {
+				opts = append(opts, WithStreamErrorEvents())
+			}
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/chat/completions", nil)
+			resp := httptest.NewRecorder()
+			forwarder.Forward(resp, req, NoRequestMutation, tc.mapper, opts...)
+
+			assert.Equal(http.StatusOK, resp.Code)
+			body := resp.Body.String()
+			require.True(strings.HasPrefix(body, event), body)
+			if !tc.wantErrorEvent {
+				assert.Equal(event, body)
+				return
+			}
+
+			rest := strings.TrimPrefix(body, event)
+			if tc.wantTerminate {
+				rest = strings.TrimPrefix(rest, "data: {\"field\": \n\n")
+			}
+			eventData, ok := strings.CutPrefix(rest, "event: "+StreamErrorEvent+"\ndata: ")
+			require.True(ok, body)
+			eventData, ok = strings.CutSuffix(eventData, "\n\n")
+			require.True(ok, body)
+
+			var data StreamErrorEventData
+			require.NoError(json.Unmarshal([]byte(eventData), &data))
+			assert.Equal(tc.wantStatusCode, data.StatusCode)
+			assert.Equal(tc.wantRetriable, data.Retriable)
+			assert.Equal(StreamErrorEvent, data.Error.Type)
+			assert.NotEmpty(data.Error.Message)
+		})
+	}
+}
+
+func TestForwardRetryCounter(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// The upstream fails with these responses before succeeding. 0 closes the connection without a response.
+	failures := []int{http.StatusServiceUnavailable, 0, http.StatusTooManyRequests, http.StatusBadGateway}
+	var attemptCount atomic.Int32
+	stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempt := int(attemptCount.Add(1)) - 1
+		if attempt >= len(failures) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if failures[attempt] == 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(err)
+			_ = conn.Close()
+			return
+		}
+		w.WriteHeader(failures[attempt])
+	}))
+	defer stubServer.Close()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "retries_total"}, []string{"reason"})
+	forwarder := New(http.DefaultClient, stubServer.Listener.Addr().String(), SchemeHTTP, slog.Default())
+	retryCallback := func(_ int, _ string, _ int) (bool, time.Duration) {
+		return true, time.Millisecond
+	}
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+	resp := httptest.NewRecorder()
+	forwarder.Forward(resp, req, NoRequestMutation, PassthroughResponseMapper,
+		WithRetryCallback(retryCallback), WithRetryCounter(counter))
+
+	require.Equal(http.StatusOK, resp.Code)
+	assert.Equal(int32(len(failures)+1), attemptCount.Load())
+	assert.InDelta(2, counterValue(t, counter.WithLabelValues("5xx")), 0)
+	assert.InDelta(1, counterValue(t, counter.WithLabelValues("4xx")), 0)
+	assert.InDelta(1, counterValue(t, counter.WithLabelValues(RetryReasonConnectionError)), 0)
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	var metric dto.Metric
+	require.NoError(t, counter.Write(&metric))
+	return metric.GetCounter().GetValue()
+}
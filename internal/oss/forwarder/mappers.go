@@ -5,6 +5,7 @@ package forwarder
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 	"strings"
 
@@ -35,7 +36,7 @@ func PassthroughResponseMapper(resp *http.Response) (Response, error) {
 // [PassthroughResponseMapper].
 func JSONResponseMapper(mutate MutationFunc, skipFields FieldSelector) ResponseMapper {
 	return func(resp *http.Response) (Response, error) {
-		if resp.Header.Get(privateModeEncryptedHeader) == "false" {
+		if resp.Header.Get(EncryptedHeader) == "false" {
 			return PassthroughResponseMapper(resp)
 		}
 
@@ -67,7 +68,7 @@ func JSONResponseMapper(mutate MutationFunc, skipFields FieldSelector) ResponseM
 // [PassthroughResponseMapper].
 func RawResponseMapper(mutate MutationFunc) ResponseMapper {
 	return func(resp *http.Response) (Response, error) {
-		if resp.Header.Get(privateModeEncryptedHeader) == "false" {
+		if resp.Header.Get(EncryptedHeader) == "false" {
 			return PassthroughResponseMapper(resp)
 		}
 
@@ -91,6 +92,47 @@ func RawResponseMapper(mutate MutationFunc) ResponseMapper {
 	}
 }
 
+// EncryptedHeaderMapper wraps next for upstreams that use the header name instead of [EncryptedHeader]
+// to indicate whether a response is encrypted. If defaultEncrypted is set, responses are encrypted unless
+// the header is "false". Otherwise, responses are only encrypted if the header is "true".
+// The upstream response is passed to next with [EncryptedHeader] set to "false" if it isn't encrypted.
+func EncryptedHeaderMapper(next ResponseMapper, name string, defaultEncrypted bool) ResponseMapper {
+	return func(resp *http.Response) (Response, error) {
+		value := strings.ToLower(strings.TrimSpace(resp.Header.Get(name)))
+		if value == "true" || defaultEncrypted && value != "false" {
+			resp.Header.Del(EncryptedHeader)
+		} else {
+			resp.Header.Set(EncryptedHeader, "false")
+		}
+		return next(resp)
+	}
+}
+
+// ContentTypeResponseMapper selects the mapper based on the upstream Content-Type.
+// JSON responses, event streams and responses without Content-Type are handled by
+// [JSONResponseMapper], all other responses, e.g., plain text or CSV, by [RawResponseMapper].
+// Both sides of a connection must use this mapper to agree on how the body is mutated.
+func ContentTypeResponseMapper(mutate MutationFunc, skipFields FieldSelector) ResponseMapper {
+	jsonMapper := JSONResponseMapper(mutate, skipFields)
+	rawMapper := RawResponseMapper(mutate)
+	return func(resp *http.Response) (Response, error) {
+		contentType := resp.Header.Get("Content-Type")
+		if contentType == "" || isEventStream(resp) || isJSONContentType(contentType) {
+			return jsonMapper(resp)
+		}
+		return rawMapper(resp)
+	}
+}
+
+// isJSONContentType reports whether contentType is application/json or a structured +json type.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 // isEventStream reports whether the response is a SSE event stream.
 func isEventStream(resp *http.Response) bool {
 	return strings.Contains(resp.Header.Get("Content-Type"), "event-stream")
@@ -136,11 +136,119 @@ func TestResponseMappersSSEMutationPerEvent(t *testing.T) {
 	}
 }
 
+func TestContentTypeResponseMapper(t *testing.T) {
+	mapper := ContentTypeResponseMapper(func(in string) (string, error) { return strings.ToUpper(in), nil }, nil)
+
+	cases := map[string]struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		"json": {
+			contentType: "application/json",
+			body:        `{"a":"hi"}`,
+			want:        `{"a":"HI"}`,
+		},
+		"json with charset": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"a":"hi"}`,
+			want:        `{"a":"HI"}`,
+		},
+		"structured json": {
+			contentType: "application/problem+json",
+			body:        `{"a":"hi"}`,
+			want:        `{"a":"HI"}`,
+		},
+		"no content type": {
+			body: `{"a":"hi"}`,
+			want: `{"a":"HI"}`,
+		},
+		"sse": {
+			contentType: "text/event-stream",
+			body:        "data: {\"a\":\"hi\"}\n\n",
+			want:        "data: {\"a\":\"HI\"}\n\n",
+		},
+		"plain text": {
+			contentType: "text/plain; charset=utf-8",
+			body:        "hello world",
+			want:        "HELLO WORLD",
+		},
+		"csv": {
+			contentType: "text/csv",
+			body:        "a,b\nc,d\n",
+			want:        "A,B\nC,D\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			//nolint:bodyclose // it's a NopCloser
+			upstream := buildResp(tc.contentType, "", tc.body)
+			resp, err := mapper(upstream)
+			require.NoError(t, err)
+			defer closeMapped(t, upstream, resp)
+			assert.Equal(t, tc.want, readBody(t, resp))
+		})
+	}
+}
+
+func TestEncryptedHeaderMapper(t *testing.T) {
+	const customHeader = "X-Backend-Encrypted"
+	mutate := func(in string) (string, error) { return `"decrypted"`, nil }
+
+	cases := map[string]struct {
+		defaultEncrypted bool
+		headers          map[string]string
+		wantDecrypted    bool
+	}{
+		"default encrypted without header": {
+			defaultEncrypted: true,
+			wantDecrypted:    true,
+		},
+		"default encrypted with plaintext header": {
+			defaultEncrypted: true,
+			headers:          map[string]string{customHeader: "false"},
+		},
+		"default encrypted ignores standard header": {
+			defaultEncrypted: true,
+			headers:          map[string]string{EncryptedHeader: "false"},
+			wantDecrypted:    true,
+		},
+		"default plaintext without header": {},
+		"default plaintext with encrypted header": {
+			headers:       map[string]string{customHeader: "True"},
+			wantDecrypted: true,
+		},
+		"default plaintext with plaintext header": {
+			headers: map[string]string{customHeader: "false"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			mapper := EncryptedHeaderMapper(JSONResponseMapper(mutate, nil), customHeader, tc.defaultEncrypted)
+
+			//nolint:bodyclose // it's a NopCloser
+			upstream := buildResp("application/json", "", `{"a":"encrypted"}`)
+			for key, value := range tc.headers {
+				upstream.Header.Set(key, value)
+			}
+			resp, err := mapper(upstream)
+			require.NoError(t, err)
+			defer closeMapped(t, upstream, resp)
+
+			want := `{"a":"encrypted"}`
+			if tc.wantDecrypted {
+				want = `{"a":"decrypted"}`
+			}
+			assert.Equal(t, want, readBody(t, resp))
+		})
+	}
+}
+
 func buildResp(contentType, encrypted, body string) *http.Response {
 	h := http.Header{}
 	h.Set("Content-Type", contentType)
 	if encrypted != "" {
-		h.Set(privateModeEncryptedHeader, encrypted)
+		h.Set(EncryptedHeader, encrypted)
 	}
 	return &http.Response{
 		StatusCode: http.StatusOK,
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -56,8 +57,26 @@ type FieldSelector [][]string
 type MutationFunc func(in string) (out string, err error)
 
 // RequestMutator mutates an [*http.Request].
+// Errors are answered with a 500 response, unless they wrap a [*StatusError].
 type RequestMutator func(request *http.Request) error
 
+// StatusError is returned by a [RequestMutator] to reject a request with StatusCode instead of 500.
+// The message of Err is sent to the client.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
 // RequestMutatorChain is a chain of [RequestMutator]s.
 func RequestMutatorChain(
 	mutators ...RequestMutator,
@@ -217,6 +236,154 @@ func WithFormRequestMutation(mutate MutationFunc, skipFields FieldSelector, log
 	}
 }
 
+// WithLowMemoryFormRequestMutation mutates each individual field in requests with HTTP form data,
+// like [WithFormRequestMutation], but reads the form parts directly from the request body instead of
+// parsing the full form first, so form files aren't copied into a [multipart.Form].
+//
+// The request body is still read completely: the mutation order depends on the names of all parts,
+// and [MutationFunc] operates on complete values, so file content can't be mutated in chunks. Besides the
+// request body and the mutated output, only the form fields and a single file are held in memory at a time.
+// For encryption, the peak memory per request is therefore roughly the request body, plus the largest file,
+// plus the mutated body (twice the body size, due to hex encoding).
+//
+// Mutation order and output are the same as for [WithFormRequestMutation], so both variants
+// can be used interchangeably on either side of a connection.
+// Request bodies larger than [constants.MaxFileSizeBytes] are rejected with a [StatusError].
+func WithLowMemoryFormRequestMutation(mutate MutationFunc, skipFields FieldSelector, log *slog.Logger) RequestMutator {
+	return func(r *http.Request) error {
+		log.Info("Mutating HTTP form request")
+
+		// http.MaxBytesReader: passing nil for the ResponseWriter is explicitly supported though not documented
+		r.Body = http.MaxBytesReader(nil, r.Body, constants.MaxFileSizeBytes)
+		body, err := persist.ReadBodyUnlimited(r)
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			return &StatusError{StatusCode: http.StatusRequestEntityTooLarge, Err: errors.New(constants.MaxBodySizeExceededMsg)}
+		}
+		if err != nil {
+			return fmt.Errorf("reading request: %w", err)
+		}
+
+		boundary, err := parseMultipartBoundaryFromContentType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return fmt.Errorf("parsing Content-Type header: %w", err)
+		}
+
+		// Mutation order must be deterministic, so we first index all parts.
+		form, err := indexFormParts(body, boundary)
+		if err != nil {
+			return fmt.Errorf("parsing form: %w", err)
+		}
+
+		mutatedBody := &bytes.Buffer{}
+		// Hex encoding of encrypted data doubles the size. Reserve some space for the multipart overhead.
+		mutatedBody.Grow(2*len(body) + 4096)
+		writer := multipart.NewWriter(mutatedBody)
+
+		for _, formKey := range slices.Sorted(maps.Keys(form.values)) {
+			log.Info("Mutating form field", "key", formKey)
+			if err := mutateFormField(writer, formKey, form.values[formKey], mutate, skipFields); err != nil {
+				return fmt.Errorf("mutating form field %q: %w", formKey, err)
+			}
+		}
+
+		for _, fileKey := range slices.Sorted(maps.Keys(form.fileSizes)) {
+			log.Info("Mutating form file", "key", fileKey)
+			part, err := form.openFile(fileKey)
+			if err != nil {
+				return fmt.Errorf("finding form file %q: %w", fileKey, err)
+			}
+			// mutateFormFile() always closes part
+			if err := mutateFormFile(writer, fileKey, sizedPart{Part: part, size: form.fileSizes[fileKey]}, mutate, skipFields); err != nil {
+				return fmt.Errorf("mutating form file %q: %w", fileKey, err)
+			}
+		}
+
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("closing writer: %w", err)
+		}
+
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		persist.SetBody(r, mutatedBody.Bytes())
+		return nil
+	}
+}
+
+// sizedPart is a [*multipart.Part] of a known size.
+type sizedPart struct {
+	*multipart.Part
+	size int64
+}
+
+// Size returns the size of the part's content.
+func (p sizedPart) Size() int64 { return p.size }
+
+// formParts indexes the parts of a multipart body. Like [multipart.Reader.ReadForm], only the first
+// part with a name is used.
+type formParts struct {
+	body      []byte
+	boundary  string
+	values    map[string]string // content of each form field
+	fileSizes map[string]int64  // content size of each form file
+}
+
+// indexFormParts reads all parts of body in a single pass. The content of form fields is kept,
+// while form files are only measured, so they can be read one at a time with [formParts.openFile].
+func indexFormParts(body []byte, boundary string) (*formParts, error) {
+	form := &formParts{
+		body:      body,
+		boundary:  boundary,
+		values:    map[string]string{},
+		fileSizes: map[string]int64{},
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			if _, ok := form.values[name]; ok {
+				continue
+			}
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return nil, fmt.Errorf("reading form field %q: %w", name, err)
+			}
+			form.values[name] = string(value)
+			continue
+		}
+		if _, ok := form.fileSizes[name]; ok {
+			continue
+		}
+		size, err := io.Copy(io.Discard, part)
+		if err != nil {
+			return nil, fmt.Errorf("reading form file %q: %w", name, err)
+		}
+		form.fileSizes[name] = size
+	}
+}
+
+// openFile returns the form file with the given name.
+func (f *formParts) openFile(name string) (*multipart.Part, error) {
+	reader := multipart.NewReader(bytes.NewReader(f.body), f.boundary)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
 func withJSONRequestMutation(
 	mutate MutationFunc, fields FieldSelector,
 	mutateFunc func([]byte, MutationFunc, FieldSelector) ([]byte, error),
@@ -246,7 +413,8 @@ func withJSONRequestMutation(
 }
 
 // MutatingReader implements a wrapper for an [io.ReadCloser], which transparently mutates data
-// chunks.
+// chunks. Chunks are complete lines, which are buffered until their newline was read. Thus, a chunk is
+// never mutated partially, e.g., when a multibyte UTF-8 character is split across reads of the wrapped reader.
 type MutatingReader struct {
 	scanner  *bufio.Scanner
 	leftover []byte
@@ -441,7 +609,32 @@ func isValidJSON(data []byte) error {
 	return fmt.Errorf("mutation on invalid JSON data: %w", err)
 }
 
+// FindDuplicateTopLevelKey returns the first top-level key that occurs more than once in the
+// JSON object data. gjson, and thus [MutateJSONFields], silently uses the last value of a
+// duplicate key, which would allow smuggling a second value for a key past mutation.
+func FindDuplicateTopLevelKey(data []byte) (string, bool) {
+	result := gjson.ParseBytes(data)
+	if !result.IsObject() {
+		return "", false
+	}
+
+	seen := map[string]struct{}{}
+	var duplicate string
+	var found bool
+	result.ForEach(func(key, _ gjson.Result) bool {
+		if _, ok := seen[key.Str]; ok {
+			duplicate, found = key.Str, true
+			return false
+		}
+		seen[key.Str] = struct{}{}
+		return true
+	})
+	return duplicate, found
+}
+
 // MutateJSONFields mutates all JSON fields in data, skipping fields matched by skipFields.
+// Fields are passed to mutate and written back as raw JSON without decoding them, so numbers keep their
+// exact representation, e.g., integers that don't fit into a float64.
 func MutateJSONFields(data []byte, mutate MutationFunc, skipFields FieldSelector) ([]byte, error) {
 	if err := isValidJSON(data); err != nil {
 		return nil, err
@@ -522,6 +715,38 @@ func EvaluateArrayPaths(gjsonData gjson.Result, path []string) FieldSelector {
 	return subPaths
 }
 
+// Overlap returns the first pair of paths of s and other that select the same field or one of its subfields.
+// The array index placeholder '#' matches any index.
+func (s FieldSelector) Overlap(other FieldSelector) (path, otherPath []string, ok bool) {
+	for _, path := range s {
+		for _, otherPath := range other {
+			if pathsOverlap(path, otherPath) {
+				return path, otherPath, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// pathsOverlap reports whether one of the paths is a prefix of the other.
+func pathsOverlap(a, b []string) bool {
+	for i := range min(len(a), len(b)) {
+		if a[i] == b[i] {
+			continue
+		}
+		if a[i] == "#" && isArrayIndex(b[i]) || b[i] == "#" && isArrayIndex(a[i]) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isArrayIndex(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 0)
+	return err == nil
+}
+
 func sortedIndices(jsonData []byte) []string {
 	indices := []string{}
 	gjson.ParseBytes(jsonData).ForEach(func(key, _ gjson.Result) bool {
@@ -562,7 +787,7 @@ func mutateFormField(
 }
 
 func mutateFormFile(
-	writer *multipart.Writer, formKey string, formFile multipart.File, mutate MutationFunc, skipFields FieldSelector,
+	writer *multipart.Writer, formKey string, formFile io.ReadCloser, mutate MutationFunc, skipFields FieldSelector,
 ) (retErr error) {
 	defer func() {
 		if closeErr := formFile.Close(); closeErr != nil {
@@ -583,12 +808,16 @@ func mutateFormFile(
 		return nil
 	}
 
-	formData, err := io.ReadAll(formFile)
-	if err != nil {
+	// Read into a strings.Builder to avoid copying the data when converting it to a string.
+	var formData strings.Builder
+	if sizer, ok := formFile.(interface{ Size() int64 }); ok {
+		formData.Grow(int(sizer.Size()))
+	}
+	if _, err := io.Copy(&formData, formFile); err != nil {
 		return fmt.Errorf("reading form file %q: %w", formKey, err)
 	}
 
-	mutatedData, err := mutate(string(formData))
+	mutatedData, err := mutate(formData.String())
 	if err != nil {
 		return fmt.Errorf("mutating form file %q: %w", formKey, err)
 	}
@@ -12,8 +12,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -348,6 +353,72 @@ func TestMutateJSONFields(t *testing.T) {
 	}
 }
 
+func TestMutateJSONFieldsPreservesNumbers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Integers beyond 2^53 and floats with many digits change if they are decoded as float64.
+	body := []byte(`{"model":"gpt-oss-120b","seed":18446744073709551615,"temperature":0.10000000000000000555,` +
+		`"prompt":[128000,9007199254740993,12345678901234567890],"logit_bias":{"50256":-100,"9007199254740993":1e2},` +
+		`"metadata":{"user_id":1234567890123456789012345678901234567890}}`)
+	plainFields := FieldSelector{{"model"}, {"seed"}, {"temperature"}, {"metadata", "user_id"}}
+
+	rc, err := crypto.NewRequestCipher(bytes.Repeat([]byte{0x42}, 32), "testing")
+	require.NoError(err)
+	decryptCipher := *rc
+	encrypted, err := MutateJSONFields(body, rc.Encrypt, plainFields)
+	require.NoError(err)
+	assert.NotContains(string(encrypted), "9007199254740993")
+	assert.Contains(string(encrypted), `"seed":18446744073709551615`)
+	assert.Contains(string(encrypted), `"temperature":0.10000000000000000555`)
+
+	decrypted, err := MutateJSONFields(encrypted, decryptCipher.DecryptResponse, plainFields)
+	require.NoError(err)
+	assert.Equal(string(body), string(decrypted))
+}
+
+func TestMutatingReaderMultibyteCharactersSplitAcrossReads(t *testing.T) {
+	// Streamed transcription deltas with characters of 2, 3 and 4 bytes in UTF-8.
+	stream := "data: {\"type\":\"transcript.text.delta\",\"delta\":\"Grüße aus \"}\n\n" +
+		"data: {\"type\":\"transcript.text.delta\",\"delta\":\"東京 🎙️\"}\n\n" +
+		"data: {\"type\":\"transcript.text.done\",\"text\":\"Grüße aus 東京 🎙️\"}\n\n" +
+		"data: [DONE]\n\n"
+	skipFields := FieldSelector{{"type"}}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"one byte per read":  iotest.OneByteReader,
+		"half of each read":  iotest.HalfReader,
+		"data error on last": iotest.DataErrReader,
+	}
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			rc, err := crypto.NewRequestCipher(bytes.Repeat([]byte{0x42}, 32), "testing")
+			require.NoError(err)
+			decryptCipher := *rc
+			validUTF8 := func(mutate MutationFunc) MutationFunc {
+				return func(in string) (string, error) {
+					assert.True(utf8.ValidString(in), "mutation input isn't valid UTF-8: %q", in)
+					return mutate(in)
+				}
+			}
+
+			encrypter := NewJSONMutatingReader(validUTF8(rc.Encrypt), skipFields)
+			encrypted, err := io.ReadAll(encrypter.Reader(io.NopCloser(newReader(strings.NewReader(stream)))))
+			require.NoError(err)
+			assert.NotContains(string(encrypted), "東京")
+
+			decrypter := NewJSONMutatingReader(validUTF8(decryptCipher.DecryptResponse), skipFields)
+			var decrypted bytes.Buffer
+			_, err = io.Copy(&decrypted, decrypter.Reader(io.NopCloser(newReader(bytes.NewReader(encrypted)))))
+			require.NoError(err)
+			assert.Equal(stream, decrypted.String())
+		})
+	}
+}
+
 func TestMutationFuncChain(t *testing.T) {
 	testCases := map[string]struct {
 		mutators []MutationFunc
@@ -513,62 +584,172 @@ func TestWithFormRequestMutation(t *testing.T) {
 		},
 	}
 
+	formMutators := map[string]func(MutationFunc, FieldSelector, *slog.Logger) RequestMutator{
+		"buffered":   WithFormRequestMutation,
+		"low memory": WithLowMemoryFormRequestMutation,
+	}
+
 	for name, tc := range testCases {
-		t.Run(name, func(t *testing.T) {
-			assert := assert.New(t)
-			require := require.New(t)
+		for mutatorName, newMutator := range formMutators {
+			t.Run(name+"/"+mutatorName, func(t *testing.T) {
+				testFormRequestMutation(t, newMutator(tc.mutator.mutate, tc.skipFields, slog.Default()), tc.request, tc.expectedForm, tc.wantErr)
+			})
+		}
+	}
+}
 
-			mutate := WithFormRequestMutation(tc.mutator.mutate, tc.skipFields, slog.Default())
+func testFormRequestMutation(
+	t *testing.T, mutate RequestMutator, request func(*testing.T, *require.Assertions) *http.Request,
+	expectedForm map[string]string, wantErr bool,
+) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	req := request(t, require)
+	err := mutate(req)
+	if wantErr {
+		assert.Error(err)
+		return
+	}
+	assert.NoError(err)
 
-			req := tc.request(t, require)
-			err := mutate(req)
-			if tc.wantErr {
-				assert.Error(err)
-				return
-			}
-			assert.NoError(err)
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(64 * 1024 * 1024)
+		assert.NoError(err)
 
-			handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
-				err := r.ParseMultipartForm(64 * 1024 * 1024)
-				assert.NoError(err)
+		var formFields []string
+		for key := range r.MultipartForm.Value {
+			formFields = append(formFields, key)
+			assert.Equal(expectedForm[key], r.FormValue(key))
+		}
 
-				var formFields []string
-				for key := range r.MultipartForm.Value {
-					formFields = append(formFields, key)
-					assert.Equal(tc.expectedForm[key], r.FormValue(key))
-				}
+		for key := range r.MultipartForm.File {
+			formFields = append(formFields, key)
+			file, _, err := r.FormFile(key)
+			assert.NoError(err)
+			defer file.Close()
+			fileData, err := io.ReadAll(file)
+			assert.NoError(err)
+			assert.Equal(expectedForm[key], string(fileData))
+		}
+
+		var expectedFields []string
+		for key := range expectedForm {
+			expectedFields = append(expectedFields, key)
+		}
+		assert.ElementsMatch(expectedFields, formFields)
+	})
+
+	client := &http.Client{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	req.URL, err = url.Parse(server.URL)
+	require.NoError(err)
+
+	res, err := client.Do(req)
+	require.NoError(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+}
 
-				for key := range r.MultipartForm.File {
-					formFields = append(formFields, key)
-					file, _, err := r.FormFile(key)
-					assert.NoError(err)
-					defer file.Close()
-					fileData, err := io.ReadAll(file)
-					assert.NoError(err)
-					assert.Equal(tc.expectedForm[key], string(fileData))
-				}
+func TestLowMemoryFormRequestMutationRoundTrip(t *testing.T) {
+	testCases := map[string]struct {
+		fileSize int
+	}{
+		"small files": {
+			fileSize: 16,
+		},
+		// Larger than the buffer of multipart.Reader and the memory limit used by the receiving side below.
+		"large files": {
+			fileSize: 8 * 1024 * 1024,
+		},
+	}
 
-				var expectedFields []string
-				for key := range tc.expectedForm {
-					expectedFields = append(expectedFields, key)
-				}
-				assert.ElementsMatch(expectedFields, formFields)
-			})
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
 
-			client := &http.Client{}
-			server := httptest.NewServer(handler)
-			defer server.Close()
-			req.URL, err = url.Parse(server.URL)
+			secret := bytes.Repeat([]byte{0x42}, 32)
+			encryptCipher, err := crypto.NewRequestCipher(secret, "id")
 			require.NoError(err)
 
-			res, err := client.Do(req)
+			files := map[string][]byte{
+				"file":  bytes.Repeat([]byte{'a'}, tc.fileSize),
+				"audio": bytes.Repeat([]byte{'b'}, tc.fileSize/2),
+			}
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			// Write parts out of order to verify mutation order is independent of the request layout.
+			for _, name := range []string{"file", "audio"} {
+				fileWriter, err := writer.CreateFormFile(name, name+".mp3")
+				require.NoError(err)
+				_, err = fileWriter.Write(files[name])
+				require.NoError(err)
+			}
+			require.NoError(writer.WriteField("prompt", "plain prompt"))
+			require.NoError(writer.WriteField("model", "plain model"))
+			require.NoError(writer.WriteField("language", "en"))
+			require.NoError(writer.WriteField("prompt", "ignored duplicate"))
+			require.NoError(writer.Close())
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			skipFields := FieldSelector{{"model"}}
+			require.NoError(WithLowMemoryFormRequestMutation(encryptCipher.Encrypt, skipFields, slog.Default())(req))
+
+			// Decrypt on the receiving side with the buffered variant.
+			nonce, err := crypto.GenerateNonce()
 			require.NoError(err)
-			defer res.Body.Close()
-			assert.Equal(http.StatusOK, res.StatusCode)
+			var seqNum uint32
+			decrypt := func(in string) (string, error) {
+				recvNonce, err := crypto.GetNonceFromCipher(in)
+				if err != nil {
+					return "", err
+				}
+				nonce = recvNonce
+				out, err := crypto.DecryptMessage(in, secret, nonce, seqNum)
+				seqNum++
+				return out, err
+			}
+			require.NoError(WithFormRequestMutation(decrypt, skipFields, slog.Default())(req))
+
+			require.NoError(req.ParseMultipartForm(1024))
+			assert.Equal("plain model", req.FormValue("model"))
+			assert.Equal("plain prompt", req.FormValue("prompt"))
+			assert.Equal("en", req.FormValue("language"))
+			for name, want := range files {
+				file, _, err := req.FormFile(name)
+				require.NoError(err)
+				fileData, err := io.ReadAll(file)
+				require.NoError(err)
+				require.NoError(file.Close())
+				assert.Equal(want, fileData, name)
+			}
 		})
 	}
 }
 
+func TestLowMemoryFormRequestMutationBodyLimit(t *testing.T) {
+	body := io.LimitReader(zeroReader{}, constants.MaxFileSizeBytes+1)
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+
+	err := WithLowMemoryFormRequestMutation(stubMutator{}.mutate, nil, slog.Default())(req)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusErr.StatusCode)
+}
+
+// zeroReader is an [io.Reader] returning an infinite stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
 type stubMutator struct {
 	mutateResponse string
 	mutateErr      error
@@ -577,3 +758,95 @@ type stubMutator struct {
 func (s stubMutator) mutate(_ string) (string, error) {
 	return s.mutateResponse, s.mutateErr
 }
+
+func TestFindDuplicateTopLevelKey(t *testing.T) {
+	testCases := map[string]struct {
+		data          string
+		wantKey       string
+		wantDuplicate bool
+	}{
+		"no duplicates": {
+			data: `{"model": "gpt", "messages": [{"role": "user", "content": "Hello"}]}`,
+		},
+		"duplicate top-level key": {
+			data:          `{"messages": "encrypted", "model": "gpt", "messages": [{"role": "user", "content": "Hello"}]}`,
+			wantKey:       "messages",
+			wantDuplicate: true,
+		},
+		"escaped duplicate key": {
+			data:          `{"messages": "encrypted", "mess\u0061ges": "plain"}`,
+			wantKey:       "messages",
+			wantDuplicate: true,
+		},
+		"duplicate empty key": {
+			data:          `{"": 1, "": 2}`,
+			wantKey:       "",
+			wantDuplicate: true,
+		},
+		"duplicate nested key is ignored": {
+			data: `{"messages": [{"role": "user", "role": "system"}]}`,
+		},
+		"array": {
+			data: `[{"a": 1}, {"a": 2}]`,
+		},
+		"empty": {
+			data: ``,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			key, ok := FindDuplicateTopLevelKey([]byte(tc.data))
+			assert.Equal(t, tc.wantDuplicate, ok)
+			assert.Equal(t, tc.wantKey, key)
+		})
+	}
+}
+
+func TestFieldSelectorOverlap(t *testing.T) {
+	testCases := map[string]struct {
+		selector    FieldSelector
+		other       FieldSelector
+		wantOverlap bool
+	}{
+		"disjoint": {
+			selector: FieldSelector{{"id"}, {"usage"}},
+			other:    FieldSelector{{"choices", "#", "message", "content"}},
+		},
+		"equal": {
+			selector:    FieldSelector{{"choices", "#", "message", "content"}},
+			other:       FieldSelector{{"choices", "#", "message", "content"}},
+			wantOverlap: true,
+		},
+		"parent": {
+			selector:    FieldSelector{{"choices"}},
+			other:       FieldSelector{{"choices", "#", "message", "content"}},
+			wantOverlap: true,
+		},
+		"subfield": {
+			selector:    FieldSelector{{"choices", "#", "message", "content", "0"}},
+			other:       FieldSelector{{"choices", "#", "message"}},
+			wantOverlap: true,
+		},
+		"index matches placeholder": {
+			selector:    FieldSelector{{"choices", "1", "message"}},
+			other:       FieldSelector{{"choices", "#", "message", "content"}},
+			wantOverlap: true,
+		},
+		"sibling": {
+			selector: FieldSelector{{"choices", "#", "index"}},
+			other:    FieldSelector{{"choices", "#", "message", "content"}},
+		},
+		"name doesn't match placeholder": {
+			selector: FieldSelector{{"choices", "first", "message"}},
+			other:    FieldSelector{{"choices", "#", "message"}},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, _, ok := tc.selector.Overlap(tc.other)
+			assert.Equal(t, tc.wantOverlap, ok)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamErrorEvent is the name of the terminal SSE event sent by [WithStreamErrorEvents].
+// It differs from the "error" event of [HTTPError], which is sent instead of a response.
+const StreamErrorEvent = "stream_error"
+
+// StreamError is an error of an event stream that has already started.
+// Readers of streaming response bodies can return it to report whether the failure is transient.
+// Other errors are reported as retriable, unless they wrap a [StatusError] with a status other than
+// [http.StatusTooManyRequests] or 5xx.
+type StreamError struct {
+	Retriable bool
+	Err       error
+}
+
+// Error implements the error interface.
+func (e *StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// StreamErrorEventData is the data of a [StreamErrorEvent].
+type StreamErrorEventData struct {
+	Error openAIAPIError `json:"error"`
+	// StatusCode is the HTTP status code of the failure. It is the status of a [StatusError] returned
+	// by the reader of the stream, e.g., for an upstream 503, and [http.StatusBadGateway] for other
+	// failures, e.g., of the upstream connection.
+	StatusCode int `json:"status_code"`
+	// Retriable indicates whether the request may succeed if it is sent again.
+	Retriable bool `json:"retriable"`
+}
+
+// WithStreamErrorEvents ends event streams with a [StreamErrorEvent] if reading the upstream
+// stream fails after the response has started. Otherwise, clients only see a truncated stream.
+func WithStreamErrorEvents() Opts {
+	return func(o *opts) {
+		o.streamErrorEvents = true
+	}
+}
+
+// streamErrorEventReader reads from an upstream event stream. If reading fails, it returns a
+// [StreamErrorEvent] describing the failure, followed by [io.EOF].
+type streamErrorEventReader struct {
+	body            io.ReadCloser
+	onError         func(error)
+	atEventBoundary bool
+	event           []byte
+	done            bool
+}
+
+func newStreamErrorEventReader(body io.ReadCloser, onError func(error)) *streamErrorEventReader {
+	return &streamErrorEventReader{body: body, onError: onError, atEventBoundary: true}
+}
+
+// Read implements [io.Reader].
+func (r *streamErrorEventReader) Read(b []byte) (int, error) {
+	if r.done {
+		if len(r.event) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(b, r.event)
+		r.event = r.event[n:]
+		return n, nil
+	}
+
+	n, err := r.body.Read(b)
+	if n > 0 {
+		r.atEventBoundary = bytes.HasSuffix(b[:n], []byte("\n\n")) || bytes.HasSuffix(b[:n], []byte("\r\n\r\n"))
+	}
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+		// Client cancellations can't be reported to the client.
+		return n, err
+	}
+
+	r.onError(err)
+	r.done = true
+	r.event = streamErrorEvent(err, !r.atEventBoundary)
+	return n, nil
+}
+
+// Close closes the upstream body.
+func (r *streamErrorEventReader) Close() error {
+	return r.body.Close()
+}
+
+// streamErrorEvent returns the [StreamErrorEvent] for err.
+// If terminate is set, the event is preceded by a blank line terminating the partially sent event.
+func streamErrorEvent(err error, terminate bool) []byte {
+	statusCode := http.StatusBadGateway
+	retriable := true
+	if statusErr := (*StatusError)(nil); errors.As(err, &statusErr) {
+		statusCode = statusErr.StatusCode
+		retriable = statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+	}
+	if streamErr := (*StreamError)(nil); errors.As(err, &streamErr) {
+		retriable = streamErr.Retriable
+	}
+	data, marshalErr := json.Marshal(StreamErrorEventData{
+		Error: openAIAPIError{
+			Message: fmt.Sprintf("stream failed: %s", err),
+			Type:    StreamErrorEvent,
+		},
+		StatusCode: statusCode,
+		Retriable:  retriable,
+	})
+	if marshalErr != nil {
+		// Can't happen: the event only contains strings, numbers, and bools.
+		data = []byte(`{}`)
+	}
+
+	var event bytes.Buffer
+	if terminate {
+		event.WriteString("\n\n")
+	}
+	fmt.Fprintf(&event, "event: %s\ndata: %s\n\n", StreamErrorEvent, data)
+	return event.Bytes()
+}
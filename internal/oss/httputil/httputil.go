@@ -3,7 +3,9 @@ package httputil
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"net/url"
 )
 
 // Clone the default transport on package init to ensure it's unmodified. Panic on type failure is desired.
@@ -20,3 +22,21 @@ func InsecureNewSkipVerifyClient() *http.Client {
 	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	return &http.Client{Transport: transport}
 }
+
+// ParseProxyURL parses the URL of an upstream proxy.
+// Supported schemes are http, https and socks5.
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected one of http, https, socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q is missing a host", rawURL)
+	}
+	return proxyURL, nil
+}
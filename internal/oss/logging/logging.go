@@ -5,6 +5,7 @@
 package logging
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -123,3 +124,38 @@ func NewFileLogger(logLevel string, output io.Writer, filename string) *slog.Log
 		Level: LevelFromString(logLevel, slog.LevelInfo),
 	}))
 }
+
+// InfoAsDebug returns a logger that logs info messages of log at debug level.
+// Warnings and errors are logged unchanged. This is useful for loggers used once per request,
+// which would otherwise flood the logs at high load.
+func InfoAsDebug(log *slog.Logger) *slog.Logger {
+	return slog.New(infoAsDebugHandler{log.Handler()})
+}
+
+type infoAsDebugHandler struct {
+	slog.Handler
+}
+
+func (h infoAsDebugHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.Handler.Enabled(ctx, demoteInfo(level))
+}
+
+func (h infoAsDebugHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.Level = demoteInfo(record.Level)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h infoAsDebugHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return infoAsDebugHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h infoAsDebugHandler) WithGroup(name string) slog.Handler {
+	return infoAsDebugHandler{h.Handler.WithGroup(name)}
+}
+
+func demoteInfo(level slog.Level) slog.Level {
+	if level >= slog.LevelInfo && level < slog.LevelWarn {
+		return slog.LevelDebug
+	}
+	return level
+}
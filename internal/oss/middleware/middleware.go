@@ -63,10 +63,44 @@ func (w *ResponseRecorder) Flush() {
 	}
 }
 
+// DumpOpts configures [DumpRequestAndResponse].
+type DumpOpts func(*dumper)
+
+// WithDumpCompression writes gzip-compressed dump files with a ".gz" suffix.
+func WithDumpCompression() DumpOpts {
+	return func(d *dumper) {
+		d.compress = true
+	}
+}
+
+// WithDumpRotation bounds the disk usage of the dump files. After each dump, the oldest dumps are
+// removed until there are at most maxFiles files with a total size of at most maxBytes.
+// The request and response files of a dump are removed together, as are empty date directories.
+// A limit <= 0 disables the respective bound.
+func WithDumpRotation(maxBytes int64, maxFiles int) DumpOpts {
+	return func(d *dumper) {
+		d.maxBytes = maxBytes
+		d.maxFiles = maxFiles
+	}
+}
+
 // DumpRequestAndResponse is an HTTP middleware that writes the raw request to a file,
 // then forwards the request to the next handler while capturing the response,
 // and finally writes the captured response to a matching file in the given dumpDir.
-func DumpRequestAndResponse(next http.Handler, logger *slog.Logger, dumpDir string) http.Handler {
+func DumpRequestAndResponse(next http.Handler, logger *slog.Logger, dumpDir string, opts ...DumpOpts) http.Handler {
+	d := &dumper{dir: dumpDir}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.rotationEnabled() {
+		if err := d.loadDumps(); err != nil {
+			logger.Error("failed to load existing dump files for rotation",
+				"error", err,
+				"dumpDir", dumpDir,
+			)
+		}
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ts := time.Now().UTC()
 		dir := filepath.Join(dumpDir, ts.Format("2006-01-02"))
@@ -74,23 +108,37 @@ func DumpRequestAndResponse(next http.Handler, logger *slog.Logger, dumpDir stri
 		reqPath := filepath.Join(dir, fmt.Sprintf("%s_req.txt", base))
 		respPath := filepath.Join(dir, fmt.Sprintf("%s_resp.txt", base))
 
-		if err := dumpRequestToFile(r, reqPath); err != nil {
+		var written dump
+		if path, size, err := d.dumpRequestToFile(r, reqPath); err != nil {
 			logger.Error("failed to dump request",
 				"error", err,
 				"path", r.URL.Path,
 				"method", r.Method,
 			)
+		} else {
+			written.paths = append(written.paths, path)
+			written.size += size
 		}
 
 		rec := NewResponseRecorder(w)
 		next.ServeHTTP(rec, r)
 
-		if err := dumpResponseRecorderToFile(rec, respPath); err != nil {
+		if path, size, err := d.dumpResponseRecorderToFile(rec, respPath); err != nil {
 			logger.Error("failed to dump response",
 				"error", err,
 				"status", rec.Status,
 				"dumpDir", dumpDir,
 			)
+		} else {
+			written.paths = append(written.paths, path)
+			written.size += size
+		}
+
+		if err := d.rotate(written); err != nil {
+			logger.Error("failed to rotate dump files",
+				"error", err,
+				"dumpDir", dumpDir,
+			)
 		}
 	})
 }
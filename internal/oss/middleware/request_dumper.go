@@ -5,39 +5,62 @@ package middleware
 
 import (
 	"bytes"
+	"cmp"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 )
 
-// dumpRequestToFile writes the HTTP request (including body) to the given file path.
-func dumpRequestToFile(req *http.Request, dumpRequestFilePath string) error {
-	if err := os.MkdirAll(filepath.Dir(dumpRequestFilePath), 0o755); err != nil {
-		return fmt.Errorf("creating dump directory: %w", err)
-	}
+// dumper writes request and response dumps to files in dir.
+type dumper struct {
+	dir      string
+	compress bool
+	maxBytes int64
+	maxFiles int
 
+	// mu guards the fields below, which track the dump files for rotation.
+	mu         sync.Mutex
+	dumps      []dump // oldest first
+	dirDumps   map[string]int
+	totalFiles int
+	totalBytes int64
+}
+
+// dump is the set of files written for a single request.
+type dump struct {
+	paths []string
+	size  int64
+}
+
+// dumpRequestToFile writes the HTTP request (including body) to the given file path.
+// It returns the path and size of the written file.
+func (d *dumper) dumpRequestToFile(req *http.Request, dumpRequestFilePath string) (string, int64, error) {
 	// Dump the request (includeBody = true)
 	data, err := httputil.DumpRequest(req, true)
 	if err != nil {
-		return fmt.Errorf("dumping request: %w", err)
+		return "", 0, fmt.Errorf("dumping request: %w", err)
 	}
 
-	if err := os.WriteFile(dumpRequestFilePath, data, 0o644); err != nil {
-		return fmt.Errorf("writing request dump file: %w", err)
+	path, size, err := d.writeFile(dumpRequestFilePath, data)
+	if err != nil {
+		return "", 0, fmt.Errorf("writing request dump file: %w", err)
 	}
-	return nil
+	return path, size, nil
 }
 
 // dumpResponseRecorderToFile writes the HTTP response captured by a ResponseRecorder
-// to the given file path.
-func dumpResponseRecorderToFile(rec *ResponseRecorder, dumpResponseFilePath string) error {
-	if err := os.MkdirAll(filepath.Dir(dumpResponseFilePath), 0o755); err != nil {
-		return fmt.Errorf("creating dump directory: %w", err)
-	}
-
+// to the given file path. It returns the path and size of the written file.
+func (d *dumper) dumpResponseRecorderToFile(rec *ResponseRecorder, dumpResponseFilePath string) (string, int64, error) {
 	// Construct a minimal http.Response that httputil.DumpResponse can understand.
 	resp := &http.Response{
 		StatusCode: rec.Status,
@@ -47,11 +70,142 @@ func dumpResponseRecorderToFile(rec *ResponseRecorder, dumpResponseFilePath stri
 	// DumpResponse includes the status line, headers and body.
 	data, err := httputil.DumpResponse(resp, true)
 	if err != nil {
-		return fmt.Errorf("dumping response: %w", err)
+		return "", 0, fmt.Errorf("dumping response: %w", err)
+	}
+
+	path, size, err := d.writeFile(dumpResponseFilePath, data)
+	if err != nil {
+		return "", 0, fmt.Errorf("writing response dump file: %w", err)
 	}
+	return path, size, nil
+}
 
-	if err := os.WriteFile(dumpResponseFilePath, data, 0o644); err != nil {
-		return fmt.Errorf("writing response dump file: %w", err)
+// writeFile writes data to path, creating its directory if needed.
+// If compression is enabled, data is gzip-compressed and written to path with a ".gz" suffix.
+// It returns the path and size of the written file.
+func (d *dumper) writeFile(path string, data []byte) (string, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", 0, fmt.Errorf("creating dump directory: %w", err)
+	}
+	if d.compress {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(data); err != nil {
+			return "", 0, fmt.Errorf("compressing dump: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return "", 0, fmt.Errorf("compressing dump: %w", err)
+		}
+		path, data = path+".gz", compressed.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", 0, err
+	}
+	return path, int64(len(data)), nil
+}
+
+// rotationEnabled reports whether any rotation limit is configured.
+func (d *dumper) rotationEnabled() bool {
+	return d.maxBytes > 0 || d.maxFiles > 0
+}
+
+// loadDumps tracks the dump files already present in the dump directory, e.g., from a previous run,
+// so that they are rotated as well. Files which aren't request or response dumps are ignored.
+func (d *dumper) loadDumps() error {
+	dumps := map[string]*dump{}
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == d.dir {
+			return fs.SkipAll
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name := filepath.Base(path)
+		base, _, isReq := strings.Cut(name, "_req.")
+		if !isReq {
+			var isResp bool
+			if base, _, isResp = strings.Cut(name, "_resp."); !isResp {
+				return nil
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		key := filepath.Join(filepath.Dir(path), base)
+		if dumps[key] == nil {
+			dumps[key] = &dump{}
+		}
+		dumps[key].paths = append(dumps[key].paths, path)
+		dumps[key].size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing dump files: %w", err)
+	}
+
+	// Dump file names start with their timestamp, so sorting by name sorts them by age.
+	keys := slices.Collect(maps.Keys(dumps))
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Compare(filepath.Base(a), filepath.Base(b))
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range keys {
+		d.track(*dumps[key])
 	}
 	return nil
 }
+
+// rotate tracks newDump and removes the oldest dumps until the configured limits are met.
+// The files of a dump are removed together, and dump directories are removed once their last
+// dump has been removed.
+func (d *dumper) rotate(newDump dump) error {
+	if !d.rotationEnabled() {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.track(newDump)
+
+	var errs []error
+	for len(d.dumps) > 0 && ((d.maxFiles > 0 && d.totalFiles > d.maxFiles) || (d.maxBytes > 0 && d.totalBytes > d.maxBytes)) {
+		oldest := d.dumps[0]
+		d.dumps = d.dumps[1:]
+		d.totalFiles -= len(oldest.paths)
+		d.totalBytes -= oldest.size
+		for _, path := range oldest.paths {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		if len(oldest.paths) == 0 {
+			continue
+		}
+		dir := filepath.Dir(oldest.paths[0])
+		d.dirDumps[dir]--
+		if d.dirDumps[dir] > 0 {
+			continue
+		}
+		delete(d.dirDumps, dir)
+		// Keep the directory of the new dump, which concurrent requests may be writing to.
+		if dir != d.dir && (len(newDump.paths) == 0 || dir != filepath.Dir(newDump.paths[0])) {
+			// Fails if the directory contains other files, which are kept.
+			_ = os.Remove(dir)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// track adds dump to the tracked dumps. Caller must hold d.mu.
+func (d *dumper) track(dump dump) {
+	d.dumps = append(d.dumps, dump)
+	d.totalFiles += len(dump.paths)
+	d.totalBytes += dump.size
+	if len(dump.paths) > 0 {
+		if d.dirDumps == nil {
+			d.dirDumps = map[string]int{}
+		}
+		d.dirDumps[filepath.Dir(dump.paths[0])]++
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpRequestAndResponse(t *testing.T) {
+	testCases := map[string]struct {
+		compress   bool
+		wantSuffix string
+	}{
+		"uncompressed": {
+			wantSuffix: ".txt",
+		},
+		"compressed": {
+			compress:   true,
+			wantSuffix: ".txt.gz",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			dumpDir := t.TempDir()
+			var opts []DumpOpts
+			if tc.compress {
+				opts = append(opts, WithDumpCompression())
+			}
+			handler := DumpRequestAndResponse(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+				_, _ = w.Write([]byte("response body"))
+			}), slog.Default(), dumpDir, opts...)
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader("request body")))
+			assert.Equal(http.StatusTeapot, resp.Code)
+			assert.Equal("response body", resp.Body.String())
+
+			files := dumpFiles(t, dumpDir)
+			require.Len(files, 2)
+			var reqDump, respDump string
+			for _, file := range files {
+				assert.True(strings.HasSuffix(file, tc.wantSuffix), file)
+				content := readDump(t, file, tc.compress)
+				switch {
+				case strings.Contains(file, "_req."):
+					reqDump = content
+				case strings.Contains(file, "_resp."):
+					respDump = content
+				}
+			}
+			assert.True(strings.HasPrefix(reqDump, "POST /v1/chat/completions HTTP/1.1\r\n"), reqDump)
+			assert.True(strings.HasSuffix(reqDump, "\r\n\r\nrequest body"), reqDump)
+			assert.True(strings.HasPrefix(respDump, "HTTP/0.0 418 I'm a teapot\r\n"), respDump)
+			assert.True(strings.HasSuffix(respDump, "\r\n\r\nresponse body"), respDump)
+		})
+	}
+}
+
+func TestDumpRotation(t *testing.T) {
+	testCases := map[string]struct {
+		compress  bool
+		maxBytes  int64
+		maxFiles  int
+		wantFiles int
+	}{
+		"no limits": {
+			wantFiles: 10,
+		},
+		"max files": {
+			maxFiles:  4,
+			wantFiles: 4,
+		},
+		"max bytes": {
+			// Each dump file is a little larger than the body of 100 bytes.
+			maxBytes:  350,
+			wantFiles: 2,
+		},
+		"compressed max files": {
+			compress:  true,
+			maxFiles:  4,
+			wantFiles: 4,
+		},
+		"request and response are removed together": {
+			maxFiles:  3,
+			wantFiles: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			dumpDir := t.TempDir()
+			opts := []DumpOpts{WithDumpRotation(tc.maxBytes, tc.maxFiles)}
+			if tc.compress {
+				opts = append(opts, WithDumpCompression())
+			}
+			handler := DumpRequestAndResponse(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(strings.Repeat("b", 100)))
+			}), slog.Default(), dumpDir, opts...)
+
+			for range 5 {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 100)))
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			files := dumpFiles(t, dumpDir)
+			require.Len(files, tc.wantFiles)
+			var totalBytes int64
+			for _, file := range files {
+				info, err := os.Stat(file)
+				require.NoError(err)
+				totalBytes += info.Size()
+			}
+			if tc.maxBytes > 0 {
+				assert.LessOrEqual(t, totalBytes, tc.maxBytes)
+			}
+			for _, file := range files {
+				if reqFile, ok := strings.CutSuffix(file, "_resp.txt"+compressedSuffix(tc.compress)); ok {
+					assert.FileExists(t, reqFile+"_req.txt"+compressedSuffix(tc.compress))
+				}
+			}
+		})
+	}
+}
+
+func TestDumpRotationExistingDumps(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dumpDir := t.TempDir()
+	oldDir := filepath.Join(dumpDir, "2020-01-01")
+	require.NoError(os.MkdirAll(oldDir, 0o755))
+	for _, name := range []string{"2020-01-01_000000.000000_00_req.txt", "2020-01-01_000000.000000_00_resp.txt"} {
+		require.NoError(os.WriteFile(filepath.Join(oldDir, name), []byte("old dump"), 0o644))
+	}
+	otherFile := filepath.Join(dumpDir, "notes.txt")
+	require.NoError(os.WriteFile(otherFile, []byte("not a dump"), 0o644))
+
+	handler := DumpRequestAndResponse(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("response body"))
+	}), slog.Default(), dumpDir, WithDumpRotation(0, 2))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request body")))
+
+	// The old dump was rotated and its empty directory removed. Other files are kept.
+	assert.NoDirExists(oldDir)
+	assert.FileExists(otherFile)
+	files := dumpFiles(t, dumpDir)
+	assert.Len(files, 3)
+}
+
+func compressedSuffix(compressed bool) string {
+	if compressed {
+		return ".gz"
+	}
+	return ""
+}
+
+// dumpFiles returns the paths of all files in dumpDir.
+func dumpFiles(t *testing.T, dumpDir string) []string {
+	t.Helper()
+	var files []string
+	require.NoError(t, filepath.WalkDir(dumpDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	}))
+	return files
+}
+
+// readDump returns the content of the dump file at path, decompressing it if needed.
+func readDump(t *testing.T, path string, compressed bool) string {
+	t.Helper()
+	require := require.New(t)
+
+	file, err := os.Open(path)
+	require.NoError(err)
+	defer file.Close()
+	var r io.Reader = file
+	if compressed {
+		zr, err := gzip.NewReader(file)
+		require.NoError(err)
+		defer zr.Close()
+		r = zr
+	}
+	content, err := io.ReadAll(r)
+	require.NoError(err)
+	return string(content)
+}
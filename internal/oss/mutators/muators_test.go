@@ -5,7 +5,14 @@ package mutators
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -57,7 +64,7 @@ func TestGenerateShardKey(t *testing.T) {
 			assert := assert.New(t)
 			content := string(bytes.Repeat([]byte("a"), tc.contentLength))
 
-			shardKey, err := generateShardKey(cacheSalt, content, slog.Default())
+			shardKey, err := generateShardKey(0, cacheSalt, content, 0, slog.Default())
 
 			if tc.expectError {
 				require.Error(err)
@@ -81,6 +88,95 @@ func TestGenerateShardKey(t *testing.T) {
 	}
 }
 
+func TestGenerateShardKeyWarning(t *testing.T) {
+	testCases := map[string]struct {
+		tokens       int
+		warnFraction float64
+		wantWarning  bool
+	}{
+		"below threshold": {
+			tokens:       800_000,
+			warnFraction: 0.8,
+		},
+		"above threshold": {
+			tokens:       800_001,
+			warnFraction: 0.8,
+			wantWarning:  true,
+		},
+		"at limit": {
+			tokens:       constants.ShardKeyMaxTokens,
+			warnFraction: 0.8,
+			wantWarning:  true,
+		},
+		"warning disabled": {
+			tokens: 900_000,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			var logs bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&logs, nil))
+			content := string(bytes.Repeat([]byte("a"), tc.tokens*4))
+
+			_, err := generateShardKey(ShardKeyVersion, "test-salt", content, tc.warnFraction, log)
+			require.NoError(err)
+
+			if tc.wantWarning {
+				assert.Contains(logs.String(), "level=WARN")
+				assert.Contains(logs.String(), "Context approaching the maximum size for shard key generation")
+			} else {
+				assert.Empty(logs.String())
+			}
+		})
+	}
+}
+
+func TestShardKeyVersions(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Long enough content for a shard key with content hash characters.
+	content := string(bytes.Repeat([]byte("a"), 4096*4))
+	versions := []uint8{0, 1, 2, 255}
+
+	keys := make(map[uint8]string, len(versions))
+	for _, version := range versions {
+		shardKey, err := generateShardKey(version, "test-salt", content, 0, slog.Default())
+		require.NoError(err)
+		keys[version] = shardKey
+
+		// The format is the same for all versions.
+		saltHash, contentHash, ok := strings.Cut(shardKey, "-")
+		require.True(ok)
+		assert.Len(saltHash, constants.CacheSaltHashLength)
+		assert.NotEmpty(contentHash)
+	}
+
+	// Version 0 keeps the original derivation.
+	legacyHash := sha256.Sum256([]byte("test-salt"))
+	assert.True(strings.HasPrefix(keys[0], hex.EncodeToString(legacyHash[:])[:constants.CacheSaltHashLength]))
+
+	// Different versions use different cache salt hashes, so their keys never share a namespace.
+	for _, a := range versions {
+		for _, b := range versions {
+			if a == b {
+				continue
+			}
+			assert.NotEqual(keys[a][:constants.CacheSaltHashLength], keys[b][:constants.CacheSaltHashLength], "versions %d and %d", a, b)
+			assert.NotEqual(keys[a], keys[b], "versions %d and %d", a, b)
+		}
+	}
+
+	// The derivation of a version is deterministic.
+	again, err := generateShardKey(1, "test-salt", content, 0, slog.Default())
+	require.NoError(err)
+	assert.Equal(keys[1], again)
+}
+
 func BenchmarkGenerateShardKey_1M(b *testing.B) {
 	cacheSalt := "test-salt"
 	// 1M tokens -> contentLength: 1_000_000 * 4 (see unit test)
@@ -88,7 +184,7 @@ func BenchmarkGenerateShardKey_1M(b *testing.B) {
 
 	start := time.Now()
 	for b.Loop() {
-		if _, err := generateShardKey(cacheSalt, content, slog.Default()); err != nil {
+		if _, err := generateShardKey(ShardKeyVersion, cacheSalt, content, 0, slog.Default()); err != nil {
 			b.Fatalf("unexpected error: %v", err)
 		}
 	}
@@ -97,3 +193,174 @@ func BenchmarkGenerateShardKey_1M(b *testing.B) {
 	// 1 Mio tokens take about 2.5ms on a Mac M2 and ~20ms in CI; enforce <50ms per op.
 	assert.Less(b, avg, 50*time.Millisecond, "shard key generation too slow")
 }
+
+func TestPromptContent(t *testing.T) {
+	testCases := map[string]struct {
+		body string
+		want string
+	}{
+		"chat": {
+			body: `{"model":"m","tools":[{"type":"function"}],"messages":[{"role":"user","content":"hi"}]}`,
+			want: `[{"type":"function"}][{"role":"user","content":"hi"}]`,
+		},
+		"completions": {
+			body: `{"model":"m","prompt":"Once upon","suffix":"the end"}`,
+			want: "Once uponthe end",
+		},
+		"completions with prompt array": {
+			body: `{"model":"m","prompt":["Once upon"],"suffix":"the end"}`,
+			want: "Once uponthe end",
+		},
+		"completions with multiple prompts": {
+			body: `{"model":"m","prompt":["Once upon","a time"]}`,
+			want: "Once upona time",
+		},
+		"completions with tokens": {
+			body: `{"model":"m","prompt":[1,258]}`,
+			want: "\x00\x00\x00\x01\x00\x00\x01\x02",
+		},
+		"completions with multiple token arrays": {
+			body: `{"model":"m","prompt":[[1],[258]]}`,
+			want: "\x00\x00\x00\x01\x00\x00\x01\x02",
+		},
+		"anthropic messages": {
+			body: `{"model":"m","system":"be nice","messages":[{"role":"user","content":"hi"}]}`,
+			want: `be nice[{"role":"user","content":"hi"}]`,
+		},
+		"no prompt": {
+			body: `{"model":"m"}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, PromptContent(tc.body))
+		})
+	}
+}
+
+func TestParseModelDefaults(t *testing.T) {
+	testCases := map[string]struct {
+		data    string
+		want    ModelDefaults
+		wantErr bool
+	}{
+		"valid": {
+			data: `{"gpt-oss-120b": {"temperature": 0.2, "reasoning_effort": "low"}}`,
+			want: ModelDefaults{
+				"gpt-oss-120b": {
+					"temperature":      json.RawMessage(`0.2`),
+					"reasoning_effort": json.RawMessage(`"low"`),
+				},
+			},
+		},
+		"invalid json": {
+			data:    `{"gpt-oss-120b": `,
+			wantErr: true,
+		},
+		"parameters not an object": {
+			data:    `{"gpt-oss-120b": 0.2}`,
+			wantErr: true,
+		},
+		"nested path as parameter": {
+			data:    `{"gpt-oss-120b": {"stream_options.include_usage": true}}`,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseModelDefaults([]byte(tc.data))
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestModelPrefixStripper(t *testing.T) {
+	testCases := map[string]struct {
+		body string
+		want string
+	}{
+		"prefixed model": {
+			body: `{"model":"privatemode/gpt-oss-120b","messages":[]}`,
+			want: `{"model":"gpt-oss-120b","messages":[]}`,
+		},
+		"model without prefix": {
+			body: `{"model":"gpt-oss-120b","messages":[]}`,
+			want: `{"model":"gpt-oss-120b","messages":[]}`,
+		},
+		"prefix not at start": {
+			body: `{"model":"org/privatemode/gpt-oss-120b"}`,
+			want: `{"model":"org/privatemode/gpt-oss-120b"}`,
+		},
+		"no model": {
+			body: `{"messages":[]}`,
+			want: `{"messages":[]}`,
+		},
+		"empty body": {},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tc.body))
+			require.NoError(ModelPrefixStripper("privatemode/", slog.Default())(req))
+			body, err := io.ReadAll(req.Body)
+			require.NoError(err)
+			assert.Equal(t, tc.want, string(body))
+		})
+	}
+}
+
+func TestModelHeaderDefaulter(t *testing.T) {
+	testCases := map[string]struct {
+		body        string
+		headerModel string
+		want        string
+	}{
+		"header model without body model": {
+			body:        `{"messages":[]}`,
+			headerModel: "gpt-oss-120b",
+			want:        `{"messages":[],"model":"gpt-oss-120b"}`,
+		},
+		"header model with empty body model": {
+			body:        `{"model":"","messages":[]}`,
+			headerModel: "gpt-oss-120b",
+			want:        `{"model":"gpt-oss-120b","messages":[]}`,
+		},
+		"body model takes precedence": {
+			body:        `{"model":"qwen3-coder","messages":[]}`,
+			headerModel: "gpt-oss-120b",
+			want:        `{"model":"qwen3-coder","messages":[]}`,
+		},
+		"no header": {
+			body: `{"messages":[]}`,
+			want: `{"messages":[]}`,
+		},
+		"empty body": {
+			headerModel: "gpt-oss-120b",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tc.body))
+			if tc.headerModel != "" {
+				req.Header.Set("X-Model", tc.headerModel)
+			}
+			require.NoError(ModelHeaderDefaulter("X-Model", slog.Default())(req))
+			body, err := io.ReadAll(req.Body)
+			require.NoError(err)
+			assert.Equal(t, tc.want, string(body))
+			assert.Empty(t, req.Header.Get("X-Model"))
+		})
+	}
+}
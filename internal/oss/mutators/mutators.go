@@ -8,22 +8,134 @@ package mutators
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/edgelesssys/continuum/internal/oss/persist"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
+// ModelDefaults maps a model name to default top-level request parameters for that model.
+type ModelDefaults map[string]map[string]json.RawMessage
+
+// ParseModelDefaults parses [ModelDefaults] from a JSON object of the form
+// {"<model>": {"<parameter>": <value>, ...}, ...}.
+func ParseModelDefaults(data []byte) (ModelDefaults, error) {
+	var defaults ModelDefaults
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("unmarshaling model defaults: %w", err)
+	}
+	for model, params := range defaults {
+		for param := range params {
+			// Parameters are set via sjson, so reject names that would be interpreted as a path.
+			if param == "" || strings.ContainsAny(param, ".*?|#@\\") {
+				return nil, fmt.Errorf("invalid parameter name %q for model %q", param, model)
+			}
+		}
+	}
+	return defaults, nil
+}
+
+// ModelDefaultsInjector returns a [forwarder.RequestMutator] that sets the
+// default parameters configured for the requested model. Only top-level
+// fields the client didn't set are injected.
+func ModelDefaultsInjector(defaults ModelDefaults, log *slog.Logger) forwarder.RequestMutator {
+	injectDefaults := func(httpBody string) (string, error) {
+		// Skip empty body, e.g., for OPTIONS requests
+		if len(httpBody) == 0 {
+			return httpBody, nil
+		}
+		params, ok := defaults[gjson.Get(httpBody, "model").String()]
+		if !ok {
+			return httpBody, nil
+		}
+
+		// Inject in a fixed order so the resulting request is deterministic.
+		for _, param := range slices.Sorted(maps.Keys(params)) {
+			if gjson.Get(httpBody, param).Exists() {
+				continue
+			}
+			var err error
+			httpBody, err = sjson.SetRaw(httpBody, param, string(params[param]))
+			if err != nil {
+				return "", fmt.Errorf("injecting default for %q: %w", param, err)
+			}
+		}
+		return httpBody, nil
+	}
+	return forwarder.WithRawRequestMutation(injectDefaults, log)
+}
+
+// ModelPrefixStripper returns a [forwarder.RequestMutator] that removes prefix from the
+// model of the request. Requests for models without the prefix are forwarded unchanged.
+func ModelPrefixStripper(prefix string, log *slog.Logger) forwarder.RequestMutator {
+	stripPrefix := func(httpBody string) (string, error) {
+		// Skip empty body, e.g., for OPTIONS requests
+		if len(httpBody) == 0 {
+			return httpBody, nil
+		}
+		model, ok := strings.CutPrefix(gjson.Get(httpBody, "model").String(), prefix)
+		if !ok {
+			return httpBody, nil
+		}
+		return sjson.Set(httpBody, "model", model)
+	}
+	return forwarder.WithRawRequestMutation(stripPrefix, log)
+}
+
+// ModelHeaderDefaulter returns a [forwarder.RequestMutator] that sets the model of the request to the
+// value of header if the request doesn't specify a model. This supports gateways setting the model
+// via a header. The header is removed from the request, so it isn't forwarded.
+func ModelHeaderDefaulter(header string, log *slog.Logger) forwarder.RequestMutator {
+	return func(r *http.Request) error {
+		model := r.Header.Get(header)
+		r.Header.Del(header)
+		if model == "" {
+			return nil
+		}
+		setModel := func(httpBody string) (string, error) {
+			// Skip empty body, e.g., for OPTIONS requests
+			if len(httpBody) == 0 || gjson.Get(httpBody, "model").String() != "" {
+				return httpBody, nil
+			}
+			return sjson.Set(httpBody, "model", model)
+		}
+		return forwarder.WithRawRequestMutation(setModel, log)(r)
+	}
+}
+
+// DefaultShardKeyWarnFraction is the default fraction of [constants.ShardKeyMaxTokens]
+// above which a warning is logged during shard key generation.
+const DefaultShardKeyWarnFraction = 0.8
+
+// ShardKeyVersion is the version of the shard key derivation used by [ShardKeyInjector].
+//
+// Shard keys of different versions start with different cache salt hashes, so they route to disjoint cache namespaces.
+// Version 0 is the original derivation hashing the plain cache salt. Later versions prefix the
+// cache salt with a version tag before hashing.
+//
+// Migration: Bump the version when changing the derivation and implement the change for the new
+// version only, keeping older versions unchanged. Requests with the new version are routed
+// independently of existing caches, so caches are rebuilt once after the rollout. Don't reuse versions.
+const ShardKeyVersion = 0
+
 // ShardKeyInjector returns a [forwarder.RequestMutator] that injects a
 // shard key header into the request. When defaultCacheSalt is empty, a
 // random cache salt is assumed and no shard key is set unless the
 // request already contains one.
-func ShardKeyInjector(defaultCacheSalt string, log *slog.Logger) forwarder.RequestMutator {
+// A warning is logged for prompts exceeding warnFraction of [constants.ShardKeyMaxTokens].
+// A warnFraction <= 0 disables the warning.
+func ShardKeyInjector(defaultCacheSalt string, warnFraction float64, log *slog.Logger) forwarder.RequestMutator {
 	// Reads the cache salt and generates a shard key using sha256.
 	// Returns an error if there is no cache salt in the request body.
 	return func(r *http.Request) error {
@@ -45,28 +157,7 @@ func ShardKeyInjector(defaultCacheSalt string, log *slog.Logger) forwarder.Reque
 
 		// If there is no cache salt, we use default sharding without a shard key.
 		if cacheSalt != "" {
-			// /chat/completions
-			tools := gjson.Get(httpBody, "tools").String()
-			messages := gjson.Get(httpBody, "messages").String()
-
-			// /completions
-			prompt := gjson.Get(httpBody, "prompt").String()
-			suffix := gjson.Get(httpBody, "suffix").String()
-
-			// /v1/messages sends the system prompt as its own field
-			systemPrompt := gjson.Get(httpBody, "system").String()
-
-			// NOTE: The order is important and must match the chat template of the model.
-			// For many models, tools are defined first, whithin or after the system message.
-			// This is the case for Llama and DeepSeek. Gemma does not have tools right now.
-			//
-			// Mistral puts tools right before the last user message. Once we use a model
-			// that does not store tools in the beginning, we may want to create a
-			// model-specific shard key to avoid cache misses due to changing tools.
-			// Potentially, we may also adjust the chat template for such models but this
-			// could have a performance impact.
-			content := systemPrompt + tools + messages + prompt + suffix
-			shardKey, err := generateShardKey(cacheSalt, content, log)
+			shardKey, err := generateShardKey(ShardKeyVersion, cacheSalt, PromptContent(httpBody), warnFraction, log)
 			if err != nil {
 				return fmt.Errorf("generating shard key: %w", err)
 			}
@@ -78,6 +169,56 @@ func ShardKeyInjector(defaultCacheSalt string, log *slog.Logger) forwarder.Reque
 	}
 }
 
+// PromptContent assembles the prompt of a chat or completions request body in the order it is
+// processed by the model. Fields with structured content, e.g., messages and tools, are included as JSON.
+func PromptContent(body string) string {
+	// /chat/completions
+	tools := gjson.Get(body, "tools").String()
+	messages := gjson.Get(body, "messages").String()
+
+	// /completions
+	prompt := completionsPrompt(gjson.Get(body, "prompt"))
+	suffix := gjson.Get(body, "suffix").String()
+
+	// /v1/messages sends the system prompt as its own field
+	systemPrompt := gjson.Get(body, "system").String()
+
+	// NOTE: The order is important and must match the chat template of the model.
+	// For many models, tools are defined first, whithin or after the system message.
+	// This is the case for Llama and DeepSeek. Gemma does not have tools right now.
+	//
+	// Mistral puts tools right before the last user message. Once we use a model
+	// that does not store tools in the beginning, we may want to create a
+	// model-specific shard key to avoid cache misses due to changing tools.
+	// Potentially, we may also adjust the chat template for such models but this
+	// could have a performance impact.
+	return systemPrompt + tools + messages + prompt + suffix
+}
+
+// completionsPrompt returns the prompt of a /completions request, which is either a string, an array
+// of strings, an array of tokens, or an array of token arrays. The prompts of an array are concatenated,
+// so a single prompt in an array results in the same content as the plain string.
+// Tokens are encoded as 4 bytes each, matching the estimate of 4 characters per token.
+func completionsPrompt(prompt gjson.Result) string {
+	if !prompt.IsArray() {
+		return prompt.String()
+	}
+	var content []byte
+	for _, element := range prompt.Array() {
+		switch {
+		case element.Type == gjson.Number:
+			content = binary.BigEndian.AppendUint32(content, uint32(element.Uint()))
+		case element.IsArray():
+			for _, token := range element.Array() {
+				content = binary.BigEndian.AppendUint32(content, uint32(token.Uint()))
+			}
+		default:
+			content = append(content, element.String()...)
+		}
+	}
+	return string(content)
+}
+
 // ModelHeaderInjector returns a [forwarder.RequestMutator] that
 // extracts the model name from the request and sets it as a header.
 func ModelHeaderInjector(extractor func(*http.Request) (string, error)) forwarder.RequestMutator {
@@ -91,10 +232,11 @@ func ModelHeaderInjector(extractor func(*http.Request) (string, error)) forwarde
 	}
 }
 
-// generateShardKey generates a shard key from a cache salt and content
-// string.
-func generateShardKey(cacheSalt string, content string, log *slog.Logger) (string, error) {
-	cacheSaltHash := sha256.Sum256([]byte(cacheSalt))
+// generateShardKey generates a shard key from a cache salt and content string using the
+// derivation of the given version (see [ShardKeyVersion]).
+// It logs a warning if the content exceeds warnFraction of the maximum size.
+func generateShardKey(version uint8, cacheSalt string, content string, warnFraction float64, log *slog.Logger) (string, error) {
+	cacheSaltHash := sha256.Sum256(versionedCacheSalt(version, cacheSalt))
 	shardKeyStr := hex.EncodeToString(cacheSaltHash[:])[:constants.CacheSaltHashLength]
 
 	// Estimate number of tokens n as content length // 4
@@ -107,10 +249,14 @@ func generateShardKey(cacheSalt string, content string, log *slog.Logger) (strin
 	//
 	// For extending this beyond 1Mio token context size we should have a clear plan on how to
 	// support larger keys and/or compress a bit more for large context (e.g., > 100k tokens).
-	if n > 1_000_000 {
+	if n > constants.ShardKeyMaxTokens {
 		log.Error("Context too large for shard key generation", slog.Int("tokens", n))
 		return "", fmt.Errorf("context too large: ~%d tokens", n)
 	}
+	if warnFraction > 0 && float64(n) > warnFraction*constants.ShardKeyMaxTokens {
+		log.Warn("Context approaching the maximum size for shard key generation",
+			slog.Int("tokens", n), slog.Int("maxTokens", constants.ShardKeyMaxTokens))
+	}
 
 	blockSize := constants.ShardKeyFirstBoundaryBlocksPerChar * constants.CacheBlockSizeTokens
 
@@ -155,3 +301,13 @@ func generateShardKey(cacheSalt string, content string, log *slog.Logger) (strin
 
 	return shardKeyStr, nil
 }
+
+// versionedCacheSalt returns the input of the cache salt hash for the given shard key version.
+// Version 0 uses the plain cache salt to keep existing shard keys. Later versions are prefixed with a
+// tag that can't be confused with a cache salt of another version, as it contains a NUL byte.
+func versionedCacheSalt(version uint8, cacheSalt string) []byte {
+	if version == 0 {
+		return []byte(cacheSalt)
+	}
+	return fmt.Appendf(nil, "privatemode-shard-key-v%d\x00%s", version, cacheSalt)
+}
@@ -8,11 +8,24 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 )
 
+// ErrSecretTooShort is returned if a secret is too short to be used as MAC key for the header.
+var ErrSecretTooShort = errors.New("secret data too short")
+
+// MACKey returns the first 32 bytes of secret, which are used as MAC key for the header.
+// It returns an error wrapping [ErrSecretTooShort] if secret is shorter.
+func MACKey(secret []byte) ([32]byte, error) {
+	if len(secret) < 32 {
+		return [32]byte{}, fmt.Errorf("%w: got %d bytes, need at least 32", ErrSecretTooShort, len(secret))
+	}
+	return [32]byte(secret), nil
+}
+
 // AllowStatus defines what [ocsp.Status]es are allowed, and is used in the
 // Privatemode-OCSP-Allow-Status header.
 type AllowStatus string
@@ -122,7 +135,7 @@ func UnmarshalAndVerify(policyHeader, policyMACHeader string, secret [32]byte) (
 
 	allowedStatuses := make([]AllowStatus, 0, len(allowedStatusStrings))
 	for _, statusStr := range allowedStatusStrings {
-		status, err := allowStatusFromString(statusStr)
+		status, err := AllowStatusFromString(statusStr)
 		if err != nil {
 			return nil, fmt.Errorf("parsing OCSP status: %w", err)
 		}
@@ -143,8 +156,8 @@ func UnmarshalAndVerify(policyHeader, policyMACHeader string, secret [32]byte) (
 	return header, nil
 }
 
-// allowStatusFromString converts a string representation of an OCSP allow status to the [AllowStatus] type.
-func allowStatusFromString(status string) (AllowStatus, error) {
+// AllowStatusFromString converts a string representation of an OCSP allow status to the [AllowStatus] type.
+func AllowStatusFromString(status string) (AllowStatus, error) {
 	switch strings.ToLower(status) {
 	case string(AllowStatusGood):
 		return AllowStatusGood, nil
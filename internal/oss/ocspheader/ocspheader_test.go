@@ -4,6 +4,7 @@
 package ocspheader
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 	"time"
@@ -12,6 +13,41 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestMACKey(t *testing.T) {
+	testCases := map[string]struct {
+		secret  []byte
+		wantErr error
+	}{
+		"32 bytes": {
+			secret: bytes.Repeat([]byte{0x01}, 32),
+		},
+		"longer secret": {
+			secret: bytes.Repeat([]byte{0x01}, 48),
+		},
+		"too short": {
+			secret:  bytes.Repeat([]byte{0x01}, 16),
+			wantErr: ErrSecretTooShort,
+		},
+		"empty": {
+			wantErr: ErrSecretTooShort,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			key, err := MACKey(tc.secret)
+			if tc.wantErr != nil {
+				assert.ErrorIs(err, tc.wantErr)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.secret[:32], key[:])
+		})
+	}
+}
+
 func TestHeader(t *testing.T) {
 	t.Run("MarshalUnmarshal", func(t *testing.T) {
 		require := require.New(t)
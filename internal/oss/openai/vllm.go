@@ -22,6 +22,7 @@ import (
 	"math"
 	"mime/multipart"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -37,6 +38,10 @@ const (
 	ChatRequestMessagesField = "messages"
 	// ChatRequestToolsField is the tools field in the request that is encrypted / decrypted.
 	ChatRequestToolsField = "tools"
+	// CompletionsRequestPromptField is the prompt field in a legacy completions request that is encrypted / decrypted.
+	CompletionsRequestPromptField = "prompt"
+	// CompletionsRequestSuffixField is the suffix field in a legacy completions request that is encrypted / decrypted.
+	CompletionsRequestSuffixField = "suffix"
 	// ChatResponseEncryptionField is the field in the response that is encrypted / decrypted.
 	ChatResponseEncryptionField = "choices"
 	// ChatCompletionsEndpoint is the endpoint for chat completions.
@@ -62,6 +67,8 @@ func IsStreamDone(value []byte) bool {
 }
 
 // PlainCompletionsRequestFields is a field selector for all fields in an OpenAI chat completions request that are not encrypted.
+// It is also used for legacy completions requests, so it must not include their content fields
+// [CompletionsRequestPromptField] and [CompletionsRequestSuffixField].
 var PlainCompletionsRequestFields = forwarder.FieldSelector{
 	{"model"},
 	{"stream_options"},
@@ -69,6 +76,7 @@ var PlainCompletionsRequestFields = forwarder.FieldSelector{
 	{"max_completion_tokens"},
 	{"n"},
 	{"stream"},
+	{"response_format"}, // the JSON schema constrains generation, see [ResponseFormatValidator]
 }
 
 // PlainCompletionsResponseFields is a field selector for all fields in an OpenAI chat completions response that are not encrypted.
@@ -102,6 +110,35 @@ var PlainTranscriptionResponseFields = forwarder.FieldSelector{
 	{"usage"},
 }
 
+// AlwaysEncryptedFields selects the fields of OpenAI requests and responses that contain user content.
+// They must never be selected by a plain field selector, see [ValidatePlainFields].
+var AlwaysEncryptedFields = forwarder.FieldSelector{
+	{ChatRequestMessagesField},
+	{ChatRequestToolsField},
+	{CompletionsRequestPromptField},
+	{CompletionsRequestSuffixField},
+	{"input"},
+	{"choices", "#", "message", "content"},
+	{"choices", "#", "message", "reasoning_content"},
+	{"choices", "#", "message", "tool_calls"},
+	{"choices", "#", "delta", "content"},
+	{"choices", "#", "delta", "reasoning_content"},
+	{"choices", "#", "delta", "tool_calls"},
+	{"choices", "#", "text"},
+	{"data", "#", "embedding"},
+	{"text"},
+	{"segments"},
+}
+
+// ValidatePlainFields returns an error if plain selects a field of [AlwaysEncryptedFields], a parent of one, or one of its subfields.
+func ValidatePlainFields(plain forwarder.FieldSelector) error {
+	if plainPath, encryptedPath, ok := plain.Overlap(AlwaysEncryptedFields); ok {
+		return fmt.Errorf("plain field %q would expose encrypted content %q",
+			strings.Join(plainPath, "."), strings.Join(encryptedPath, "."))
+	}
+	return nil
+}
+
 // RandomPromptCacheSalt generates a random salt for prompt caching and
 // returns it as a base64-encoded string.
 func RandomPromptCacheSalt() string {
@@ -146,6 +183,7 @@ type ChatRequestPlainData struct {
 	N                   int            `json:"n,omitzero"`
 	Stream              bool           `json:"stream"`
 	StreamOptions       *StreamOptions `json:"stream_options,omitempty"`
+	ResponseFormat      any            `json:"response_format,omitempty"`
 }
 
 // EncryptedEmbeddingsRequest is the request structure for an OpenAI embeddings call.
@@ -405,6 +443,23 @@ func CacheSaltInjector(cacheSaltGenerator CacheSaltGenerator, log *slog.Logger)
 	return forwarder.WithRawRequestMutation(injectSalt, log)
 }
 
+// RandomCacheSaltInjector creates a [forwarder.RequestMutator] that injects a random cache salt,
+// replacing any cache salt set by the client. This prevents the request from sharing a prompt cache.
+func RandomCacheSaltInjector(log *slog.Logger) forwarder.RequestMutator {
+	injectSalt := func(httpBody string) (mutatedRequest string, err error) {
+		// Skip empty body, e.g., for OPTIONS requests
+		if len(httpBody) == 0 {
+			return httpBody, nil
+		}
+		mutatedBody, err := sjson.Set(httpBody, "cache_salt", RandomPromptCacheSalt())
+		if err != nil {
+			return "", fmt.Errorf("injecting cache salt: %w", err)
+		}
+		return mutatedBody, nil
+	}
+	return forwarder.WithRawRequestMutation(injectSalt, log)
+}
+
 // CacheSaltValidator creates a [forwarder.RequestMutator] that ensures a non-empty cache salt.
 func CacheSaltValidator(log *slog.Logger) forwarder.RequestMutator {
 	validateSalt := func(httpBody string) (mutatedRequest string, err error) {
@@ -425,6 +480,83 @@ func CacheSaltValidator(log *slog.Logger) forwarder.RequestMutator {
 	return forwarder.WithRawRequestMutation(validateSalt, log)
 }
 
+// ResponseFormatValidator creates a [forwarder.RequestMutator] that ensures the 'response_format'
+// of a request doesn't contain free text, since it is sent in plaintext.
+// JSON schemas may only describe the structure of the output. Annotations such as descriptions
+// and literal values such as enums and defaults are rejected, see [sensitiveSchemaKeywords].
+func ResponseFormatValidator(log *slog.Logger) forwarder.RequestMutator {
+	validate := func(httpBody string) (mutatedRequest string, err error) {
+		// Skip empty body, e.g., for OPTIONS requests
+		if len(httpBody) == 0 {
+			return httpBody, nil
+		}
+
+		responseFormat := gjson.Get(httpBody, "response_format")
+		if !responseFormat.Exists() {
+			return httpBody, nil
+		}
+		if !responseFormat.IsObject() {
+			return "", fmt.Errorf("response_format must be an object")
+		}
+
+		switch formatType := responseFormat.Get("type").String(); formatType {
+		case "text", "json_object":
+			return httpBody, nil
+		case "json_schema":
+			jsonSchema := responseFormat.Get("json_schema")
+			if jsonSchema.Get("description").Exists() {
+				return "", fmt.Errorf("response_format.json_schema.description is not allowed, since response_format is sent in plaintext")
+			}
+			if err := validateSchema(jsonSchema.Get("schema"), "response_format.json_schema.schema"); err != nil {
+				return "", err
+			}
+			return httpBody, nil
+		default:
+			return "", fmt.Errorf("unsupported response_format type %q", formatType)
+		}
+	}
+
+	return forwarder.WithRawRequestMutation(validate, log)
+}
+
+// sensitiveSchemaKeywords are JSON schema keywords that carry free text or literal values
+// instead of describing the structure of a document.
+var sensitiveSchemaKeywords = []string{"$comment", "const", "default", "description", "enum", "examples", "title"}
+
+// schemaMapKeywords are JSON schema keywords whose values map names to subschemas.
+var schemaMapKeywords = []string{"$defs", "definitions", "dependentSchemas", "patternProperties", "properties"}
+
+// validateSchema recursively checks that schema doesn't contain any of [sensitiveSchemaKeywords].
+// path is the location of schema in the request and is used for error messages.
+func validateSchema(schema gjson.Result, path string) error {
+	var retErr error
+	switch {
+	case schema.IsArray():
+		schema.ForEach(func(key, value gjson.Result) bool {
+			retErr = validateSchema(value, fmt.Sprintf("%s.%d", path, key.Int()))
+			return retErr == nil
+		})
+	case schema.IsObject():
+		schema.ForEach(func(key, value gjson.Result) bool {
+			keyPath := path + "." + key.String()
+			switch {
+			case slices.Contains(sensitiveSchemaKeywords, key.String()):
+				retErr = fmt.Errorf("%s is not allowed, since response_format is sent in plaintext", keyPath)
+			case slices.Contains(schemaMapKeywords, key.String()) && value.IsObject():
+				// Skip the keys of the map: they are names, not keywords.
+				value.ForEach(func(name, subschema gjson.Result) bool {
+					retErr = validateSchema(subschema, keyPath+"."+name.String())
+					return retErr == nil
+				})
+			default:
+				retErr = validateSchema(value, keyPath)
+			}
+			return retErr == nil
+		})
+	}
+	return retErr
+}
+
 // MediaContentValidator creates a [forwarder.RequestMutator] that enforces policy on media
 // content blocks in the request. Image URLs must use https or data schemes via [validateImageURL]
 // and audio via [validateAudioURL] and video via [validateVideoURL] content is not allowed.
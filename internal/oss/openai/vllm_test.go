@@ -11,10 +11,12 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -175,6 +177,81 @@ func TestMediaContentValidator(t *testing.T) {
 	}
 }
 
+func TestResponseFormatValidator(t *testing.T) {
+	testCases := map[string]struct {
+		responseFormat string
+		wantErr        string
+	}{
+		"no response format": {},
+		"text": {
+			responseFormat: `{"type":"text"}`,
+		},
+		"json object": {
+			responseFormat: `{"type":"json_object"}`,
+		},
+		"json schema": {
+			responseFormat: `{"type":"json_schema","json_schema":{"name":"person","strict":true,"schema":{
+				"type":"object",
+				"properties":{"name":{"type":"string"},"description":{"type":"string"},"tags":{"type":"array","items":{"type":"string"}}},
+				"required":["name","description"],
+				"$defs":{"title":{"type":"string","maxLength":10}}
+			}}}`,
+		},
+		"schema description": {
+			responseFormat: `{"type":"json_schema","json_schema":{"name":"person","description":"secret","schema":{"type":"object"}}}`,
+			wantErr:        "response_format.json_schema.description is not allowed",
+		},
+		"nested property description": {
+			responseFormat: `{"type":"json_schema","json_schema":{"name":"person","schema":{"type":"object","properties":{"name":{"type":"string","description":"secret"}}}}}`,
+			wantErr:        "response_format.json_schema.schema.properties.name.description is not allowed",
+		},
+		"enum in array subschema": {
+			responseFormat: `{"type":"json_schema","json_schema":{"name":"person","schema":{"anyOf":[{"type":"string"},{"enum":["secret"]}]}}}`,
+			wantErr:        "response_format.json_schema.schema.anyOf.1.enum is not allowed",
+		},
+		"default in definitions": {
+			responseFormat: `{"type":"json_schema","json_schema":{"name":"person","schema":{"$defs":{"name":{"type":"string","default":"secret"}}}}}`,
+			wantErr:        "response_format.json_schema.schema.$defs.name.default is not allowed",
+		},
+		"unsupported type": {
+			responseFormat: `{"type":"regex"}`,
+			wantErr:        `unsupported response_format type "regex"`,
+		},
+		"not an object": {
+			responseFormat: `"json_object"`,
+			wantErr:        "response_format must be an object",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			body := `{"model":"gpt-oss-120b","messages":[{"role":"user","content":"hi"}]}`
+			if tc.responseFormat != "" {
+				body = `{"model":"gpt-oss-120b","messages":[{"role":"user","content":"hi"}],"response_format":` + tc.responseFormat + `}`
+			}
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodPost,
+				"https://foo.bar/v1/chat/completions", strings.NewReader(body))
+			require.NoError(t, err)
+
+			err = ResponseFormatValidator(slog.Default())(req)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPlainCompletionsRequestFieldsExcludeContent(t *testing.T) {
+	for _, contentField := range []string{
+		ChatRequestMessagesField, ChatRequestToolsField, CompletionsRequestPromptField, CompletionsRequestSuffixField,
+	} {
+		assert.False(t, slices.ContainsFunc(PlainCompletionsRequestFields, func(field []string) bool { return field[0] == contentField }),
+			"content field %q must be encrypted", contentField)
+	}
+}
+
 func TestStreamUsageReportingInjector(t *testing.T) {
 	testCases := map[string]struct {
 		request           EncryptedChatRequest
@@ -395,3 +472,56 @@ func TestAudioStreamUsageReportingInjector(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatePlainFields(t *testing.T) {
+	testCases := map[string]struct {
+		plain   forwarder.FieldSelector
+		wantErr bool
+	}{
+		"completions request": {
+			plain: PlainCompletionsRequestFields,
+		},
+		"completions response": {
+			plain: PlainCompletionsResponseFields,
+		},
+		"embeddings request": {
+			plain: PlainEmbeddingsRequestFields,
+		},
+		"embeddings response": {
+			plain: PlainEmbeddingsResponseFields,
+		},
+		"transcription request": {
+			plain: PlainTranscriptionRequestFields,
+		},
+		"transcription response": {
+			plain: PlainTranscriptionResponseFields,
+		},
+		"message content": {
+			plain:   forwarder.FieldSelector{{"id"}, {"choices", "#", "message", "content"}},
+			wantErr: true,
+		},
+		"all choices": {
+			plain:   forwarder.FieldSelector{{"choices"}},
+			wantErr: true,
+		},
+		"single delta": {
+			plain:   forwarder.FieldSelector{{"choices", "0", "delta"}},
+			wantErr: true,
+		},
+		"messages": {
+			plain:   forwarder.FieldSelector{{ChatRequestMessagesField}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidatePlainFields(tc.plain)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
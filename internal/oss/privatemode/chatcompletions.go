@@ -118,9 +118,10 @@ func (c *Client) prepareChatCompletionsRequest(ctx context.Context, body []byte)
 		return plainData.Model, nil
 	}
 	mutator := forwarder.RequestMutatorChain(
-		mutators.ShardKeyInjector(c.promptCacheSalt, c.log),
+		mutators.ShardKeyInjector(c.promptCacheSalt, mutators.DefaultShardKeyWarnFraction, c.log),
 		openai.CacheSaltInjector(func() string { return c.promptCacheSalt }, c.log),
 		mutators.ModelHeaderInjector(chatModelExtractor),
+		openai.ResponseFormatValidator(c.log),
 		forwarder.WithJSONRequestMutation(cipher.Encrypt, openai.PlainCompletionsRequestFields, c.log),
 	)
 	if err := mutator(req); err != nil {
@@ -5,8 +5,10 @@
 package privatemode
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -149,31 +151,65 @@ func (c *Client) WithHTTPClient(httpClient *http.Client) *Client {
 
 // FetchManifest fetches the manifest from the CDN.
 func (c *Client) FetchManifest(ctx context.Context) ([]byte, error) {
+	body, statusCode, err := c.fetchFromCDN(ctx, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d: %s", statusCode, body)
+	}
+	return body, nil
+}
+
+// VersionInfo is the version information of the deployment published on the CDN.
+type VersionInfo struct {
+	// MinimumClientVersion is the oldest client version supported by the deployment, e.g., "v1.40.0".
+	MinimumClientVersion string `json:"minimumClientVersion"`
+}
+
+// FetchVersionInfo fetches the version information from the CDN.
+// It returns an empty [VersionInfo] if the CDN doesn't publish version information.
+func (c *Client) FetchVersionInfo(ctx context.Context) (VersionInfo, error) {
+	body, statusCode, err := c.fetchFromCDN(ctx, "version.json")
+	if err != nil {
+		return VersionInfo{}, err
+	}
+	if statusCode == http.StatusNotFound {
+		return VersionInfo{}, nil
+	}
+	if statusCode != http.StatusOK {
+		return VersionInfo{}, fmt.Errorf("unexpected status code: %d: %s", statusCode, body)
+	}
+
+	var info VersionInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return VersionInfo{}, fmt.Errorf("unmarshaling version info: %w", err)
+	}
+	return info, nil
+}
+
+// fetchFromCDN fetches file from the CDN and returns the response body and status code.
+func (c *Client) fetchFromCDN(ctx context.Context, file string) ([]byte, int, error) {
 	// Random query parameter is required to circumvent browser caching when called from the web app.
 	// TODO(msanft): Consider disabling browser caching via response headers in S3 instead.
-	manifestURL := c.cdnBaseURL + "/manifest.json?t=" + fmt.Sprint(time.Now().UnixMilli())
-	c.log.Debug("Fetching manifest from CDN", "url", manifestURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	fileURL := c.cdnBaseURL + "/" + file + "?t=" + fmt.Sprint(time.Now().UnixMilli())
+	c.log.Debug("Fetching file from CDN", "url", fileURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+		return nil, 0, fmt.Errorf("creating request: %w", err)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("doing request: %w", err)
+		return nil, 0, fmt.Errorf("doing request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %v: %s", resp.Status, body)
+		return nil, 0, fmt.Errorf("reading response body: %w", err)
 	}
-
-	return body, nil
+	return body, resp.StatusCode, nil
 }
 
 // Initialize the connection to the Privatemode deployment by setting
@@ -223,6 +259,8 @@ func (c *Client) UpdateSecret(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("getting latest secret: %w", err)
 	}
+	// The secret manager zeroes the data once the secret is superseded.
+	secret.Data = bytes.Clone(secret.Data)
 	c.currentSecret = secret
 	return nil
 }
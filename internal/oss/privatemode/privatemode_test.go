@@ -5,6 +5,8 @@ package privatemode
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/edgelesssys/continuum/internal/oss/crypto"
@@ -96,3 +98,51 @@ func TestTryDecryptResponseError(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchVersionInfo(t *testing.T) {
+	testCases := map[string]struct {
+		statusCode int
+		body       string
+		wantInfo   VersionInfo
+		wantErr    bool
+	}{
+		"published": {
+			statusCode: http.StatusOK,
+			body:       `{"minimumClientVersion":"v1.40.0"}`,
+			wantInfo:   VersionInfo{MinimumClientVersion: "v1.40.0"},
+		},
+		"not published": {
+			statusCode: http.StatusNotFound,
+		},
+		"server error": {
+			statusCode: http.StatusInternalServerError,
+			wantErr:    true,
+		},
+		"invalid JSON": {
+			statusCode: http.StatusOK,
+			body:       `{`,
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal("/version.json", r.URL.Path)
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer cdn.Close()
+
+			info, err := New("").WithCDNBaseURL(cdn.URL).FetchVersionInfo(t.Context())
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantInfo, info)
+		})
+	}
+}
@@ -70,6 +70,16 @@ func FromUserHeader(req *http.Request) string {
 	return fromHeaderWithDefault(req, UserHeader)
 }
 
+// FromClientHeaders returns the request ID supplied by a client in [UserHeader] or, if unset, in [Header].
+// Returned values are sanitized for logging.
+// Returns [Unknown] if the client didn't supply a request ID.
+func FromClientHeaders(req *http.Request) string {
+	if id := fromHeaderWithDefault(req, UserHeader); id != Unknown {
+		return id
+	}
+	return fromHeaderWithDefault(req, Header)
+}
+
 func fromHeaderWithDefault(req *http.Request, header string) string {
 	if id := req.Header.Get(header); id != "" {
 		return sanitizeString(id)
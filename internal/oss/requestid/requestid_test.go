@@ -4,6 +4,8 @@
 package requestid
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -38,3 +40,36 @@ func TestSanitizeString(t *testing.T) {
 		})
 	}
 }
+
+func TestFromClientHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		headers  map[string]string
+		expected string
+	}{
+		"user header": {
+			headers:  map[string]string{UserHeader: "user-id"},
+			expected: "user-id",
+		},
+		"request ID header": {
+			headers:  map[string]string{Header: "request-id"},
+			expected: "request-id",
+		},
+		"user header takes precedence": {
+			headers:  map[string]string{UserHeader: "user-id", Header: "request-id"},
+			expected: "user-id",
+		},
+		"no header": {
+			expected: Unknown,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			assert.Equal(t, tc.expected, FromClientHeaders(req))
+		})
+	}
+}
@@ -5,9 +5,12 @@
 package secretmanager
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 	"time"
 
@@ -18,17 +21,29 @@ import (
 const (
 	secretLifetime      = time.Hour
 	secretRefreshBuffer = 15 * time.Minute
+	// MaxRefreshJitter is the largest fraction accepted by [SecretManager.SetRefreshJitter].
+	// It ensures that a refresh delayed by jitter still happens before the secret's lifetime ends.
+	MaxRefreshJitter = 0.3
 )
 
+// ErrNoAPIKey is returned by [SecretManager.LatestSecret] while the SecretManager has no API key,
+// e.g., before a client offered one. Unlike errors of the secret service, it doesn't involve a
+// network call and resolves once an API key is offered.
+var ErrNoAPIKey = errors.New("don't have an API key yet")
+
 // SecretManager manages the lifetime of a secret and always returns an up-to-date secret.
 type SecretManager struct {
 	secret                   *Secret
+	secretObtainedAt         time.Time
 	updateSecretFn           updateSecretFn
 	mut                      sync.Mutex
 	clock                    clock.Clock
 	apiKey                   string
 	apiKeyDropOnUnauthorized bool
 	apiKeyChan               chan struct{} // signals the Loop that an API key has been set
+	refreshJitter            float64
+	randFloat                func() float64 // returns a number in [0.0, 1.0)
+	reportAge                func(time.Duration)
 }
 
 // Secret includes all the information needed to identify and use a secret.
@@ -45,6 +60,13 @@ func (s Secret) Map() map[string][]byte {
 	}
 }
 
+// Zero overwrites the secret data with zeros, so the key material doesn't stay in memory after use.
+// This is best effort: the Go runtime may have copied the data, e.g., when growing a slice,
+// and copies made by [Secret.Map] or by callers aren't zeroed.
+func (s Secret) Zero() {
+	clear(s.Data)
+}
+
 type updateSecretFn func(ctx context.Context, apiKey string) (string, []byte, error)
 
 // New creates a new SecretManager.
@@ -54,16 +76,42 @@ func New(updateSecretFn updateSecretFn, apiKeyDropOnUnauthorized bool) *SecretMa
 		clock:                    clock.RealClock{},
 		apiKeyDropOnUnauthorized: apiKeyDropOnUnauthorized,
 		apiKeyChan:               make(chan struct{}),
+		randFloat:                rand.Float64,
+	}
+}
+
+// SetRefreshJitter randomizes the refresh interval of each secret by up to ±fraction,
+// so that many instances started at the same time stagger their refreshes.
+// fraction must be in [0, MaxRefreshJitter].
+func (sm *SecretManager) SetRefreshJitter(fraction float64) error {
+	if fraction < 0 || fraction > MaxRefreshJitter {
+		return fmt.Errorf("refresh jitter must be between 0 and %v, got %v", MaxRefreshJitter, fraction)
 	}
+	sm.mut.Lock()
+	defer sm.mut.Unlock()
+	sm.refreshJitter = fraction
+	return nil
+}
+
+// SetSecretAgeReporter sets a function that receives the time since the current secret was obtained.
+// It is called with sm's lock held and must not call methods of sm.
+func (sm *SecretManager) SetSecretAgeReporter(report func(age time.Duration)) {
+	sm.mut.Lock()
+	defer sm.mut.Unlock()
+	sm.reportAge = report
 }
 
 // LatestSecret returns the current secret. If the secret is older than the lifetime, a new secret is generated.
+// It also reports the secret age, see [SecretManager.SetSecretAgeReporter], which is thereby refreshed
+// on every request and on each iteration of [SecretManager.Loop].
+// The data of the returned secret is zeroed once the secret is superseded. Callers must derive the state
+// they need right away, e.g., a request cipher, or clone the data to keep it.
 func (sm *SecretManager) LatestSecret(ctx context.Context) (Secret, error) {
 	sm.mut.Lock()
 	defer sm.mut.Unlock()
 
 	if sm.apiKey == "" {
-		return Secret{}, errors.New("don't have an API key yet")
+		return Secret{}, ErrNoAPIKey
 	}
 
 	now := sm.clock.Now()
@@ -72,6 +120,7 @@ func (sm *SecretManager) LatestSecret(ctx context.Context) (Secret, error) {
 			return Secret{}, err
 		}
 	}
+	sm.reportSecretAge(now)
 	return *sm.secret, nil
 }
 
@@ -138,15 +187,39 @@ func (sm *SecretManager) updateSecret(ctx context.Context, now time.Time, apiKey
 	if err != nil {
 		return err
 	}
+	if sm.secret != nil {
+		sm.secret.Zero()
+	}
 	sm.secret = &Secret{
-		ID:   id,
-		Data: data,
+		ID: id,
+		// updateSecretFn may hand out the same data again, so only zero a copy we own.
+		Data: bytes.Clone(data),
 		// Clock.Now() returns the current time with monotonic time. Some operations on monotonic
 		// times do not work on MacOS as the OS stops the monotonic clock when the system goes
 		// to sleep. This leads to expiration time comparison failure after sleep.
 		// To prevent this, we must remove the monotonic part using Round(0).
 		// Cf. https://pkg.go.dev/time#hdr-Monotonic_Clocks
-		ExpirationDate: now.Round(0).Add(secretLifetime - secretRefreshBuffer),
+		ExpirationDate: now.Round(0).Add(sm.refreshInterval()),
 	}
+	sm.secretObtainedAt = now.Round(0)
 	return nil
 }
+
+// refreshInterval returns the time until a new secret is refreshed, randomized by the refresh jitter.
+// Caller must hold sm.mut.
+func (sm *SecretManager) refreshInterval() time.Duration {
+	interval := secretLifetime - secretRefreshBuffer
+	if sm.refreshJitter == 0 {
+		return interval
+	}
+	factor := 1 + sm.refreshJitter*(2*sm.randFloat()-1)
+	return time.Duration(float64(interval) * factor)
+}
+
+// reportSecretAge reports the age of the current secret. Caller must hold sm.mut.
+func (sm *SecretManager) reportSecretAge(now time.Time) {
+	if sm.secret == nil || sm.reportAge == nil {
+		return
+	}
+	sm.reportAge(now.Round(0).Sub(sm.secretObtainedAt))
+}
@@ -4,7 +4,9 @@
 package secretmanager
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -51,6 +53,103 @@ func TestGetSecretUpdatesAfterLifetime(t *testing.T) {
 	assert.NotEqual(secret3, secret4)
 }
 
+func TestSecretAgeReporter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	clock := testclock.NewFakeClock(time.Date(2024, 0, 0, 0, 0, 0, 0, time.UTC))
+
+	mock := &updateCounter{}
+	sut := New(mock.UpdateFn, false)
+	sut.clock = clock
+	age := time.Duration(-1)
+	sut.SetSecretAgeReporter(func(a time.Duration) { age = a })
+	ctx := t.Context()
+	require.NoError(sut.OfferAPIKey(ctx, "apikey"))
+
+	_, err := sut.LatestSecret(ctx)
+	require.NoError(err)
+	assert.Equal(time.Duration(0), age)
+
+	clock.Step(10 * time.Minute)
+	_, err = sut.LatestSecret(ctx)
+	require.NoError(err)
+	assert.Equal(10*time.Minute, age)
+
+	// age is reset when the secret is renewed
+	clock.Step(secretLifetime)
+	_, err = sut.LatestSecret(ctx)
+	require.NoError(err)
+	assert.Equal(2, mock.isCalled)
+	assert.Equal(time.Duration(0), age)
+}
+
+func TestSecretZero(t *testing.T) {
+	secret := Secret{ID: "id", Data: []byte{1, 2, 3}}
+	secret.Zero()
+	assert.Equal(t, []byte{0, 0, 0}, secret.Data)
+}
+
+func TestForceUpdateZeroesSupersededSecret(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var issued [][]byte
+	updateFn := func(context.Context, string) (string, []byte, error) {
+		data := bytes.Repeat([]byte{byte(len(issued) + 1)}, 32)
+		issued = append(issued, data)
+		return fmt.Sprintf("id%d", len(issued)), data, nil
+	}
+	sut := New(updateFn, false)
+	ctx := t.Context()
+	require.NoError(sut.OfferAPIKey(ctx, "apikey"))
+
+	secret, err := sut.LatestSecret(ctx)
+	require.NoError(err)
+	require.NoError(sut.ForceUpdate(ctx))
+
+	require.Len(issued, 2)
+	assert.Equal(make([]byte, 32), secret.Data, "superseded secret must be zeroed")
+	assert.Equal(bytes.Repeat([]byte{1}, 32), issued[0], "data owned by the update function must not be zeroed")
+	newSecret, err := sut.LatestSecret(ctx)
+	require.NoError(err)
+	assert.Equal("id2", newSecret.ID)
+	assert.Equal(bytes.Repeat([]byte{2}, 32), newSecret.Data)
+}
+
+func TestRefreshJitter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	now := time.Date(2024, 0, 0, 0, 0, 0, 0, time.UTC)
+	clock := testclock.NewFakeClock(now)
+
+	mock := &updateCounter{}
+	sut := New(mock.UpdateFn, false)
+	sut.clock = clock
+	require.NoError(sut.SetRefreshJitter(0.2))
+	ctx := t.Context()
+	require.NoError(sut.OfferAPIKey(ctx, "apikey"))
+
+	base := secretLifetime - secretRefreshBuffer
+	minInterval := time.Duration(float64(base) * 0.8)
+	maxInterval := time.Duration(float64(base) * 1.2)
+	intervals := map[time.Duration]struct{}{}
+	for range 20 {
+		secret, err := sut.LatestSecret(ctx)
+		require.NoError(err)
+		interval := secret.ExpirationDate.Sub(clock.Now())
+		assert.GreaterOrEqual(interval, minInterval)
+		assert.LessOrEqual(interval, maxInterval)
+		assert.Less(interval, secretLifetime, "refresh must happen before the lifetime ends")
+		intervals[interval] = struct{}{}
+		clock.SetTime(secret.ExpirationDate)
+	}
+	assert.Greater(len(intervals), 1, "intervals must vary")
+	assert.Equal(20, mock.isCalled)
+
+	assert.Error(sut.SetRefreshJitter(-0.1))
+	assert.Error(sut.SetRefreshJitter(MaxRefreshJitter + 0.01))
+}
+
 type updateCounter struct {
 	isCalled int
 }
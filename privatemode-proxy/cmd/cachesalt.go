@@ -0,0 +1,72 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+)
+
+// promptCacheSaltFile is the file in the workspace that stores the prompt cache salt if --persistCacheSalt is set.
+const promptCacheSaltFile = "prompt-cache-salt"
+
+// loadOrCreatePromptCacheSalt returns the prompt cache salt stored at path.
+// If the file doesn't exist, a random salt is generated and stored, so it is reused on the next start.
+// The file is only accessible by the owner, and a file accessible by others is rejected.
+func loadOrCreatePromptCacheSalt(path string) (string, error) {
+	salt, err := readPromptCacheSalt(path)
+	if err == nil {
+		return salt, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("creating directory for prompt cache salt: %w", err)
+	}
+	salt = openai.RandomPromptCacheSalt()
+	// O_EXCL ensures that a salt stored concurrently by another process isn't overwritten.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return readPromptCacheSalt(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("creating prompt cache salt file: %w", err)
+	}
+	_, err = file.WriteString(salt + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("writing prompt cache salt: %w", err)
+	}
+	return salt, nil
+}
+
+func readPromptCacheSalt(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("reading prompt cache salt: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("prompt cache salt file %q must only be accessible by its owner, but has permissions %s", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading prompt cache salt: %w", err)
+	}
+	salt := strings.TrimSpace(string(data))
+	if len(salt) < 32 {
+		return "", fmt.Errorf("prompt cache salt in %q must be at least 32 characters long", path)
+	}
+	return salt, nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreatePromptCacheSalt(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "workspace", promptCacheSaltFile)
+
+	salt, err := loadOrCreatePromptCacheSalt(path)
+	require.NoError(err)
+	assert.GreaterOrEqual(len(salt), 32)
+	info, err := os.Stat(path)
+	require.NoError(err)
+	assert.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// Simulate a restart of the proxy.
+	restartedSalt, err := loadOrCreatePromptCacheSalt(path)
+	require.NoError(err)
+	assert.Equal(salt, restartedSalt)
+
+	otherSalt, err := loadOrCreatePromptCacheSalt(filepath.Join(t.TempDir(), promptCacheSaltFile))
+	require.NoError(err)
+	assert.NotEqual(salt, otherSalt, "salts of different workspaces must differ")
+}
+
+func TestLoadOrCreatePromptCacheSaltInvalidFile(t *testing.T) {
+	testCases := map[string]struct {
+		content string
+		perm    os.FileMode
+	}{
+		"accessible by others": {
+			content: "a-salt-that-is-at-least-32-characters-long",
+			perm:    0o644,
+		},
+		"too short": {
+			content: "short",
+			perm:    0o600,
+		},
+		"empty": {
+			perm: 0o600,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			path := filepath.Join(t.TempDir(), promptCacheSaltFile)
+			require.NoError(os.WriteFile(path, []byte(tc.content), tc.perm))
+			require.NoError(os.Chmod(path, tc.perm)) // not affected by umask
+
+			_, err := loadOrCreatePromptCacheSalt(path)
+			require.Error(err)
+			content, err := os.ReadFile(path)
+			require.NoError(err)
+			require.Equal(tc.content, string(content), "invalid salt must not be overwritten")
+		})
+	}
+}
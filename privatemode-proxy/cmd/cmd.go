@@ -5,37 +5,115 @@
 package cmd
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"mime"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/httputil"
 	"github.com/edgelesssys/continuum/internal/oss/logging"
+	"github.com/edgelesssys/continuum/internal/oss/mutators"
 	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/process"
+	"github.com/edgelesssys/continuum/internal/oss/requestid"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
 	"github.com/edgelesssys/continuum/privatemode-proxy/internal/setup"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
+// maxStreamBufferSize is the maximum value of the streamBufferSize flag.
+const maxStreamBufferSize = 1024 * 1024
+
 var (
 	logLevel                     string
 	logFormat                    string
 	apiKeyStr                    string
+	adminTokenStr                string
 	workspace                    string
 	apiEndpoint                  string
 	port                         string
+	unixSocket                   string
 	manifestPath                 string
 	nvidiaOCSPAllowUnknown       bool
 	nvidiaOCSPRevokedGracePeriod int
 	tlsCertPath                  string
 	tlsKeyPath                   string
+	tlsMinVersion                string
+	tlsCipherSuites              []string
 	insecureAPIConnection        bool
 	dumpRequests                 bool
+	dumpCompress                 bool
+	dumpMaxBytes                 int64
+	dumpMaxFiles                 int
+	maxHeaderBytes               int
+	rejectLargeHeaders           bool
+	maxResponseBytes             int64
+	maxBatchSize                 int
+	batchConcurrency             int
+	preserveClientRequestID      bool
+	strictJSON                   bool
+	metricsPort                  string
+	enabledEndpoints             []string
+	disabledEndpointStatus       int
+	streamHeartbeatInterval      time.Duration
+	streamBufferSize             int
+	requestTimeout               time.Duration
+	retryBudget                  time.Duration
+	overloadRetries              int
+	maxRetryAfter                time.Duration
+	streamErrorEvents            bool
+	retryMetrics                 bool
+	forwardTrailers              bool
+	exposeShardKey               bool
+	echoUpstreamRequestID        bool
+	streamCompatMode             bool
+	encryptedHeader              string
+	plaintextByDefault           bool
+	coalesceRequests             bool
+	idempotencyWindow            time.Duration
+	idempotencyCacheSize         int
+	verifyDecryptedResponse      bool
+	verifyModelMatch             bool
+	stripForwardedFor            bool
+	maxPromptChars               int
+	maxTools                     int
+	unstructuredContentTypes     []string
+	shardKeyWarnFraction         float64
+	mockBackend                  bool
+	checkRoutes                  bool
+	allowDegradedStart           bool
+	requireMinVersion            bool
+	secretRefreshJitter          int
+	secretWaitTimeout            time.Duration
+	printConfig                  bool
+	modelDefaultsStr             string
+	upstreamProxy                string
+	extraHeaders                 []string
+	forwardHeaders               []string
+	modelQuotaEntries            []string
+	corsAllowedOrigins           []string
+	quiet                        bool
+	modelPrefixStrip             string
+	allowModelHeader             bool
+	timingHeaders                bool
+	auditLog                     string
 
 	// sharedPromptCache is used to share the cache between users.
 	// When true, all users of the proxy will share the same cache.
@@ -44,7 +122,19 @@ var (
 	// use request param cache_salt to enable caching.
 	sharedPromptCache bool
 	promptCacheSalt   string
-	cdnBaseURL        string
+	// cacheSaltPerAPIKey derives the shared cache salt per API key,
+	// so that only users of the same API key share a cache.
+	cacheSaltPerAPIKey bool
+	// disablePromptCache sends a fresh random salt with every request,
+	// overriding cache salts set by clients.
+	disablePromptCache bool
+	// persistCacheSalt stores the random shared cache salt in the workspace,
+	// so the cache is kept across restarts.
+	persistCacheSalt bool
+	// requireClientCacheSalt rejects requests without a 'cache_salt',
+	// so clients don't share the cache unknowingly.
+	requireClientCacheSalt bool
+	cdnBaseURL             string
 )
 
 // New returns the root command of the privatemode-proxy.
@@ -60,6 +150,7 @@ func New() *cobra.Command {
 		RunE:         runProxy,
 		SilenceUsage: true,
 	}
+	cmd.AddCommand(newGenSaltCmd())
 
 	cmd.Flags().StringVarP(&logLevel, logging.Flag, logging.FlagShorthand, logging.DefaultFlagValue, logging.FlagInfo)
 	must(logging.RegisterFlagCompletionFunc(cmd))
@@ -68,11 +159,19 @@ func New() *cobra.Command {
 
 	cmd.Flags().StringVar(&apiKeyStr, "apiKey", "",
 		"The API key for the Privatemode API. Accepts either a direct literal or a file path prefixed with '@'. If no key is set, the proxy will not authenticate with the API.")
+	cmd.Flags().StringVar(&adminTokenStr, "adminToken", "",
+		fmt.Sprintf("The token protecting admin endpoints such as '%s', sent in the '%s' header. Accepts either a direct literal or a file path prefixed with '@'. "+
+			"If no token is set, admin endpoints are not served.", server.PrewarmEndpoint, server.AdminTokenHeader))
 	cmd.Flags().String("ssEndpoint", "", "")
 	must(cmd.Flags().MarkDeprecated("ssEndpoint", "direct connection to the secret-service is no longer required"))
-	cmd.Flags().StringVar(&apiEndpoint, "apiEndpoint", constants.APIEndpoint, "The endpoint for the Privatemode API")
+	cmd.Flags().StringVar(&apiEndpoint, "apiEndpoint", constants.APIEndpoint,
+		"The endpoint for the Privatemode API, either 'host:port' or an 'https://' URL. The API is always accessed with HTTPS, "+
+			"so other URL schemes are rejected.")
 	cmd.Flags().StringVar(&port, "port", "8080",
 		"The port on which the proxy listens for incoming API requests.")
+	cmd.Flags().StringVar(&unixSocket, "unixSocket", "",
+		"The path of a Unix domain socket on which the proxy listens for incoming API requests instead of the TCP port, e.g. for local app integration. "+
+			"If 'port' is set explicitly, the proxy listens on both. The socket is only accessible by the user running the proxy.")
 	cmd.Flags().StringVar(&workspace, "workspace", ".",
 		fmt.Sprintf("The path into which the binary writes files. This includes the manifest log data in the '%s' subdirectory.", constants.ManifestDir))
 	cmd.Flags().StringVar(&manifestPath, "manifestPath", "",
@@ -87,7 +186,55 @@ func New() *cobra.Command {
 		"If set, caching of prompts between all users of the proxy is enabled. This reduces response times for long conversations or common documents.")
 	cmd.Flags().StringVar(&promptCacheSalt, "promptCacheSalt", "",
 		"The salt used to isolate prompt caches. If empty (default), the same random salt is used for all requests, "+
-			"enabling sharing the cache between all users of the same proxy. Requires 'sharedPromptCache' to be enabled!")
+			"enabling sharing the cache between all users of the same proxy. Requires 'sharedPromptCache' to be enabled! "+
+			"Use 'privatemode-proxy gen-salt' to generate a strong salt.")
+	cmd.Flags().BoolVar(&persistCacheSalt, "persistCacheSalt", false,
+		fmt.Sprintf("If set, the random salt used for the shared prompt cache is stored in '%s' in the workspace and reused on the next start, "+
+			"so the cache is kept across restarts. Requires 'sharedPromptCache' to be enabled! Can't be combined with 'promptCacheSalt'.", promptCacheSaltFile))
+	cmd.Flags().BoolVar(&cacheSaltPerAPIKey, "cacheSaltPerApiKey", false,
+		"If set, the cache salt of requests without an explicit 'cache_salt' is derived from the API key and 'promptCacheSalt', "+
+			"so that the cache is only shared between requests with the same API key. Requires 'sharedPromptCache' to be enabled! "+
+			"Set 'promptCacheSalt' to keep the derived salts stable across restarts.")
+	cmd.Flags().BoolVar(&disablePromptCache, "disablePromptCache", false,
+		"If set, prompts are never cached. No shard key is sent and every request gets a random cache salt, even if the client sets 'cache_salt'. "+
+			"Can't be combined with 'sharedPromptCache'.")
+	cmd.Flags().BoolVar(&requireClientCacheSalt, "requireClientCacheSalt", false,
+		"If set, requests without an explicit 'cache_salt' are rejected with status 400 instead of using the shared cache salt, "+
+			"so clients must opt in to caching explicitly. Requires 'sharedPromptCache' to be enabled!")
+
+	cmd.Flags().StringVar(&upstreamProxy, "upstreamProxy", "",
+		"The URL of a proxy through which all connections to the Privatemode API are made, e.g. 'http://proxy.example.com:3128' or 'socks5://127.0.0.1:1080'. "+
+			"Supported schemes are http, https and socks5. If not provided, the proxy settings from the environment are used.")
+	cmd.Flags().StringArrayVar(&extraHeaders, "extraHeader", nil,
+		"An extra header in the form 'key=value' that is sent with every request to the Privatemode API, e.g. for routing in a custom deployment. "+
+			"Can be repeated. Headers set by the proxy, such as 'Authorization' and the 'Privatemode-*' headers, can't be overridden.")
+	cmd.Flags().StringSliceVar(&forwardHeaders, "forwardHeaders", nil,
+		"A comma-separated list of client headers that are forwarded to the Privatemode API, e.g. 'X-Tenant-ID'. "+
+			"If set, all other client headers are dropped, except for those needed to process the request. If not set, all client headers are forwarded. "+
+			"Headers set by the proxy, such as 'Authorization' and the 'Privatemode-*' headers, can't be listed.")
+	cmd.Flags().StringArrayVar(&modelQuotaEntries, "modelQuota", nil,
+		"A quota in the form 'model=requestsPerMinute' limiting the requests for a model within a sliding window of one minute, e.g. 'gpt-oss-120b=60'. "+
+			"Requests exceeding the quota are rejected with 429. Can be repeated for multiple models. Models without a quota aren't limited.")
+	cmd.Flags().StringSliceVar(&corsAllowedOrigins, "corsAllowedOrigins", nil,
+		"A comma-separated list of origins of web apps that may make cross-origin requests to the proxy, e.g. 'https://app.example.com'. "+
+			"Preflight requests from these origins are answered, and preflight requests from other origins are rejected. Use '*' to allow all origins.")
+
+	cmd.Flags().StringVar(&modelPrefixStrip, "modelPrefixStrip", "",
+		"A prefix that is removed from the model of requests before they are forwarded to the Privatemode API, e.g. 'privatemode/' for clients behind a router. "+
+			"The prefix is added to the models listed by '/v1/models'. Doesn't apply to transcription requests.")
+	cmd.Flags().BoolVar(&allowModelHeader, "allowModelHeader", false,
+		fmt.Sprintf("If set, the model of chat, completions, and embeddings requests that don't specify one in the body is taken from the '%s' header. "+
+			"Use this for gateways that set the model via a header.", server.ModelHeader))
+	cmd.Flags().BoolVar(&timingHeaders, "timingHeaders", false,
+		"If set, inference responses carry a 'Server-Timing' header reporting the time spent encrypting the request, waiting for the Privatemode API, "+
+			"and decrypting the response. Only intended for performance debugging.")
+	cmd.Flags().StringVar(&auditLog, "auditLog", "",
+		"A file or an HTTP(S) URL to which an audit record is written after every inference request. "+
+			"Records are JSON objects with the timestamp, request ID, endpoint, model, status code and token counts of a request, but never its content. "+
+			"Records are appended to a file as JSON Lines, or sent to a URL in a POST request. "+
+			"Records are written in the background and dropped if the target can't keep up, which is counted in the privatemode_proxy_audit_records_dropped_total metric.")
+	cmd.Flags().BoolVar(&quiet, "quiet", false,
+		"If set, the info messages logged for every request are logged at debug level. Warnings and errors are still logged.")
 
 	cmd.Flags().BoolVar(&insecureAPIConnection, "insecureAPIConnection", false,
 		"If set, the server will accept self-signed certificates from the API endpoint. Only intended for testing.")
@@ -96,6 +243,10 @@ func New() *cobra.Command {
 	// TLS
 	cmd.Flags().StringVar(&tlsCertPath, "tlsCertPath", "", "The path to the TLS certificate. If not provided, the server will start without TLS.")
 	cmd.Flags().StringVar(&tlsKeyPath, "tlsKeyPath", "", "The path to the TLS key. If not provided, the server will start without TLS.")
+	cmd.Flags().StringVar(&tlsMinVersion, "tlsMinVersion", "1.2", "The minimum TLS version accepted by the server. Must be 1.2 or 1.3.")
+	cmd.Flags().StringSliceVar(&tlsCipherSuites, "tlsCipherSuites", nil,
+		"Comma-separated list of TLS 1.2 cipher suites accepted by the server, e.g. 'TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384'. "+
+			"If not provided, Go's defaults are used. TLS 1.3 cipher suites aren't configurable.")
 
 	// Contrast flags
 	cmd.Flags().String("coordinatorEndpoint", "", "")
@@ -103,24 +254,215 @@ func New() *cobra.Command {
 	cmd.Flags().StringVar(&cdnBaseURL, "cdnBaseURL", "https://cdn.confidential.cloud/privatemode/v2", "Base URL to retrieve deployment information from.")
 	must(cmd.Flags().MarkHidden("cdnBaseURL"))
 
+	cmd.Flags().IntVar(&maxHeaderBytes, "maxHeaderBytes", 8*1024,
+		"The maximum combined size (in bytes) of the headers sent to the API. If exceeded, the shard key used for prompt cache routing is shortened. "+
+			"Set this to match the header limits of proxies between the privatemode-proxy and the API. A value of 0 disables the check.")
+	cmd.Flags().BoolVar(&rejectLargeHeaders, "rejectLargeHeaders", false,
+		"If set, requests whose headers still exceed --maxHeaderBytes after shortening the shard key are rejected with status 431 "+
+			"instead of being sent to the API, where proxies might reject them with a less clear error.")
+
+	cmd.Flags().StringVar(&modelDefaultsStr, "modelDefaults", "",
+		"Default request parameters per model as a JSON object, e.g. '{\"<model>\": {\"temperature\": 0.7}}'. Accepts either a direct literal or a file path prefixed with '@'. "+
+			"Defaults are only applied to chat requests for parameters the client didn't set.")
+
+	cmd.Flags().Int64Var(&maxResponseBytes, "maxResponseBytes", constants.MaxUnaryResponseBodyBytes,
+		fmt.Sprintf("The maximum size (in bytes) of a non-streaming response body from the API. Larger responses are rejected. "+
+			"Streaming responses are not limited. Must be between 1 and %d.", constants.MaxUnaryResponseBodyBytes))
+
+	cmd.Flags().DurationVar(&streamHeartbeatInterval, "streamHeartbeatInterval", 0,
+		"If set, an SSE comment is sent on streaming responses whenever no data has been sent for this interval, e.g. '15s'. "+
+			"This prevents intermediaries from closing idle connections during long pauses of the model. A value of 0 (default) disables heartbeats.")
+
+	cmd.Flags().IntVar(&streamBufferSize, "streamBufferSize", forwarder.DefaultStreamBufferSize,
+		fmt.Sprintf("The buffer size (in bytes) used for copying streaming responses to the client. "+
+			"Smaller buffers pass on data in smaller chunks. Must be between 1 and %d.", maxStreamBufferSize))
+
+	cmd.Flags().DurationVar(&requestTimeout, "requestTimeout", 0,
+		"The maximum duration of a request to the API, including retries and reading the response, e.g. '5m'. Requests exceeding it are answered with 504. "+
+			"Streaming responses are exempt once the API has started responding. A value of 0 (default) disables the timeout.")
+
+	cmd.Flags().DurationVar(&retryBudget, "retryBudget", 0,
+		"The maximum total duration of sending a request to the API including retries, e.g. '30s'. "+
+			"Retries whose backoff would exceed it are skipped and the last error is returned. A value of 0 (default) only limits retries by the request context.")
+
+	cmd.Flags().IntVar(&overloadRetries, "overloadRetries", 0,
+		"The number of times a request is retried if the API responds with 429 or 503. The delay is taken from the Retry-After header of the response, "+
+			"capped by --maxRetryAfter, or grows exponentially from 500ms if there is none. A value of 0 (default) disables these retries.")
+	cmd.Flags().DurationVar(&maxRetryAfter, "maxRetryAfter", 10*time.Second,
+		"The maximum delay taken from a Retry-After header before retrying a request, see --overloadRetries.")
+
+	cmd.Flags().BoolVar(&streamErrorEvents, "streamErrorEvents", false,
+		fmt.Sprintf("If set, event streams that fail after the API has started responding end with a '%s' event instead of being cut off. "+
+			"The event contains the error, its status code and whether the request may be retried, so clients can keep the partial result and decide whether to resume.", forwarder.StreamErrorEvent))
+
+	cmd.Flags().BoolVar(&forwardTrailers, "forwardTrailers", false,
+		"If set, the HTTP trailers of non-streaming API responses, e.g. usage information, are forwarded to clients sending a 'TE: trailers' request header.")
+
+	cmd.Flags().BoolVar(&retryMetrics, "retryMetrics", false,
+		"If set, retries of requests to the API are counted in the 'privatemode_proxy_retries_total' metric, labeled by reason "+
+			"(status code class, e.g. '5xx', or 'connection_error').")
+
+	cmd.Flags().BoolVar(&exposeShardKey, "exposeShardKey", false,
+		fmt.Sprintf("If set, responses always include the '%s' header with the shard key used for routing the request, or '%s' if random sharding is used. "+
+			"The shard key is derived from the cache salt and the prompt prefix, so anyone who can see response headers can tell whether requests share a cache salt and prompt prefix. "+
+			"Only enable this for debugging or if response headers aren't exposed to untrusted parties.", constants.PrivatemodeShardKeyHeader, server.ShardKeyRandom))
+	cmd.Flags().BoolVar(&echoUpstreamRequestID, "echoUpstreamRequestID", false,
+		fmt.Sprintf("If set, inference responses include the '%s' header with the ID the Privatemode API assigned to the request, if any. "+
+			"Pass it to support to correlate a request with the logs of the Privatemode API.", server.UpstreamRequestIDHeader))
+	cmd.Flags().BoolVar(&streamCompatMode, "streamCompatMode", false,
+		"If set, the final 'data: [DONE]' event is removed from streaming responses. "+
+			"Enable this for older clients that fail to parse it because they expect every event to contain JSON.")
+	cmd.Flags().StringVar(&encryptedHeader, "encryptedHeader", forwarder.EncryptedHeader,
+		"The response header of the API that indicates whether a response is encrypted. Only change this for custom deployments whose backends use a different header.")
+	cmd.Flags().BoolVar(&plaintextByDefault, "plaintextByDefault", false,
+		"If set, responses are treated as unencrypted unless the 'encryptedHeader' is 'true'. "+
+			"By default, responses are treated as encrypted unless the header is 'false'.")
+
+	cmd.Flags().BoolVar(&coalesceRequests, "coalesceRequests", false,
+		"If set, identical concurrent non-streaming requests (same endpoint, forwarded headers and body) share a single request to the API and receive the same response. "+
+			"If --apiKey is set, only requests with a 'cache_salt' are coalesced, since clients can't be told apart otherwise. "+
+			"Each request is still counted against --modelQuota and audited with its own request ID. "+
+			"This only helps if clients send the exact same request at the same time.")
+
+	cmd.Flags().DurationVar(&idempotencyWindow, "idempotencyWindow", 0,
+		"If set, successful non-streaming chat responses to requests with an 'Idempotency-Key' header are cached for this duration, e.g. '10m'. "+
+			"Retries with the same key, endpoint and API key receive the cached response instead of sending another request to the API. A value of 0 (default) disables caching.")
+	cmd.Flags().IntVar(&idempotencyCacheSize, "idempotencyCacheSize", 1000,
+		"The maximum number of responses cached for idempotency keys. The least recently used responses are evicted first.")
+
+	cmd.Flags().BoolVar(&verifyDecryptedResponse, "verifyDecryptedResponse", false,
+		"If set, the proxy verifies that the decrypted 'choices' and 'data' fields of responses are arrays of objects. "+
+			"Responses failing the check are answered with an error instead of being passed to the client. "+
+			"Event streams failing the check end with an error event, as with --streamErrorEvents.")
+	cmd.Flags().BoolVar(&verifyModelMatch, "verifyModelMatch", false,
+		"If set, the proxy verifies that the decrypted 'model' field of responses matches the model of the request to detect misrouting. "+
+			"Case and organization prefixes such as 'openai/' are ignored. Mismatching responses are logged and answered with an error.")
+
+	cmd.Flags().BoolVar(&stripForwardedFor, "stripForwardedFor", false,
+		"If set, the 'X-Forwarded-For' and 'Forwarded' headers are removed from requests to the API instead of appending the client IP. "+
+			"Use this to avoid propagating client IPs to the API.")
+
+	cmd.Flags().IntVar(&maxPromptChars, "maxPromptChars", 0,
+		"The maximum number of characters in the prompt of chat and completions requests, including system prompt, messages and tools. "+
+			"Longer prompts are rejected with 413. Structured content such as messages is measured including its JSON encoding. A value of 0 (default) disables the check.")
+
+	cmd.Flags().IntVar(&maxTools, "maxTools", 0,
+		"The maximum number of tools in chat requests. Requests with more tools are rejected with 400. "+
+			"A value of 0 (default) disables the check.")
+
+	cmd.Flags().StringSliceVar(&unstructuredContentTypes, "unstructuredContentTypes", nil,
+		"Comma-separated list of request content types accepted by the Unstructured API, e.g. 'application/pdf,text/plain'. "+
+			"Requests with other content types are rejected with 415. Requests without a body, e.g. GET requests, are always accepted. "+
+			"If empty (default), all content types are accepted.")
+
+	cmd.Flags().Float64Var(&shardKeyWarnFraction, "shardKeyWarnFraction", mutators.DefaultShardKeyWarnFraction,
+		fmt.Sprintf("The fraction of the maximum prompt size of %d estimated tokens above which a warning is logged, before requests fail for exceeding it. "+
+			"A value of 0 disables the warning.", constants.ShardKeyMaxTokens))
+
+	// batch requests
+	cmd.Flags().IntVar(&maxBatchSize, "maxBatchSize", 0,
+		fmt.Sprintf("The maximum number of chat completion requests in a single request to the '%s' endpoint. "+
+			"A value of 0 (default) disables the endpoint.", server.ChatCompletionsBatchEndpoint))
+	cmd.Flags().IntVar(&batchConcurrency, "batchConcurrency", 4,
+		"The number of requests of a batch that are forwarded to the API concurrently.")
+
+	cmd.Flags().BoolVar(&preserveClientRequestID, "preserveClientRequestID", false,
+		fmt.Sprintf("If set, request IDs supplied by clients in the '%s' or '%s' header are forwarded to the API with a 'proxy_' prefix "+
+			"instead of being replaced by a generated ID.", requestid.UserHeader, requestid.Header))
+
+	cmd.Flags().BoolVar(&strictJSON, "strictJSON", false,
+		"If set, request bodies of the JSON endpoints (chat, completions, messages, embeddings) containing duplicate top-level keys are rejected, regardless of their Content-Type.")
+
+	// endpoint selection
+	cmd.Flags().StringSliceVar(&enabledEndpoints, "enabledEndpoints", nil,
+		fmt.Sprintf("Comma-separated list of endpoints served by the proxy. Requests to other endpoints are rejected. "+
+			"If not provided, all endpoints are enabled. Available endpoints: %s", strings.Join(server.Endpoints(), ", ")))
+	cmd.Flags().IntVar(&disabledEndpointStatus, "disabledEndpointStatus", http.StatusNotFound,
+		"The HTTP status code returned for requests to disabled endpoints. Must be 403 or 404.")
+
+	cmd.Flags().StringVar(&metricsPort, "metricsPort", "",
+		fmt.Sprintf("The port on which Prometheus metrics are served at '%s'. If not provided, metrics are not served.", constants.MetricsEndpoint))
+
+	cmd.Flags().BoolVar(&allowDegradedStart, "allowDegradedStart", false,
+		"If set, the proxy starts even if no secret can be fetched with the configured API key, e.g., because the API is unreachable. "+
+			"Fetching is retried in the background and requests fail until it succeeds. By default, the proxy exits instead.")
+	cmd.Flags().BoolVar(&requireMinVersion, "requireMinVersion", false,
+		"If set, the proxy refuses to start if its version is older than the minimum client version of the Privatemode deployment. "+
+			"By default, only a warning is logged.")
+	cmd.Flags().IntVar(&secretRefreshJitter, "secretRefreshJitter", 10,
+		fmt.Sprintf("The percentage by which the refresh interval of the secret is randomly varied in both directions, "+
+			"so that proxies started at the same time don't refresh their secrets at the same time. Must be between 0 and %d.",
+			int(secretmanager.MaxRefreshJitter*100)))
+	cmd.Flags().DurationVar(&secretWaitTimeout, "secretWaitTimeout", 0,
+		"How long a request waits for an inference secret if none can be obtained yet because the proxy has no API key, e.g., while another request is offering one. "+
+			"Errors of the secret service aren't retried. If no secret becomes available in time, the request fails. A value of 0 (default) fails requests immediately.")
+
+	cmd.Flags().BoolVar(&mockBackend, "mockBackend", false,
+		"If set, the proxy serves requests from a built-in stub that echoes requests instead of connecting to the Privatemode API. "+
+			"Attestation is skipped. Only intended for local development.")
+	cmd.Flags().BoolVar(&checkRoutes, "checkRoutes", false,
+		"If set, the proxy verifies at startup that all of its endpoints are routed to their handlers and unknown paths are answered with 404.")
+
+	cmd.Flags().BoolVar(&printConfig, "printConfig", false,
+		"If set, the effective configuration is logged as JSON at startup. The API key, prompt cache salt and upstream proxy credentials are redacted.")
+
 	// Request dumping
 	cmd.Flags().BoolVar(&dumpRequests, "dumpRequests", false,
 		"If set, the proxy dumps request and response logs to the '/requests' sub‑directory of the workspace. "+
 			"Leaving this flag unset disables request and response dumping.")
+	cmd.Flags().BoolVar(&dumpCompress, "dumpCompress", false,
+		"If set, request and response dumps are gzip-compressed and written with a '.gz' suffix.")
+	cmd.Flags().Int64Var(&dumpMaxBytes, "dumpMaxBytes", 0,
+		"The maximum total size in bytes of the request and response dumps. The oldest dumps are removed when it is exceeded. A value of 0 (default) disables the limit.")
+	cmd.Flags().IntVar(&dumpMaxFiles, "dumpMaxFiles", 0,
+		"The maximum number of request and response dump files. Each request produces two files, which are removed together when the limit is exceeded, oldest first. A value of 0 (default) disables the limit.")
 
 	return cmd
 }
 
+// literalOrFile returns value, or the trimmed contents of the file at path if value is '@path'.
+func literalOrFile(value string) (string, error) {
+	path, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %q: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 func getPromptCacheSalt() (string, error) {
+	if disablePromptCache && sharedPromptCache {
+		return "", fmt.Errorf("disablePromptCache and sharedPromptCache are mutually exclusive")
+	}
 	if promptCacheSalt != "" && !sharedPromptCache {
 		return "", fmt.Errorf("promptCacheSalt is set but sharedPromptCache is not enabled")
 	}
+	if cacheSaltPerAPIKey && !sharedPromptCache {
+		return "", fmt.Errorf("cacheSaltPerApiKey is set but sharedPromptCache is not enabled")
+	}
+	if requireClientCacheSalt && !sharedPromptCache {
+		return "", fmt.Errorf("requireClientCacheSalt is set but sharedPromptCache is not enabled")
+	}
+	if persistCacheSalt && !sharedPromptCache {
+		return "", fmt.Errorf("persistCacheSalt is set but sharedPromptCache is not enabled")
+	}
+	if persistCacheSalt && promptCacheSalt != "" {
+		return "", fmt.Errorf("persistCacheSalt and promptCacheSalt are mutually exclusive")
+	}
 
 	// if cache sharing is disabled, we must not use a salt but generate a random salt per-request
 	if !sharedPromptCache {
 		return "", nil
 	}
 
+	// if the salt is persisted, we reuse the random salt of a previous run
+	if persistCacheSalt {
+		return loadOrCreatePromptCacheSalt(filepath.Join(workspace, promptCacheSaltFile))
+	}
+
 	// if cache sharing is enabled, but no salt is set, we now generate a random salt
 	// to keep for the lifetime of the proxy
 	if promptCacheSalt == "" {
@@ -130,6 +472,35 @@ func getPromptCacheSalt() (string, error) {
 	return promptCacheSalt, nil
 }
 
+// serveMetrics serves Prometheus metrics on lis until ctx is canceled.
+func serveMetrics(ctx context.Context, lis net.Listener, log *slog.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle(constants.MetricsEndpoint, promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:     lis.Addr().String(),
+		Handler:  mux,
+		ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelError),
+	}
+	return process.HTTPServeContext(ctx, metricsServer, lis, log)
+}
+
+func getModelDefaults() (mutators.ModelDefaults, error) {
+	if modelDefaultsStr == "" {
+		return nil, nil
+	}
+
+	data := []byte(modelDefaultsStr)
+	// Trim '@' and read file contents
+	if path, ok := strings.CutPrefix(modelDefaultsStr, "@"); ok {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read model defaults file %q: %w", path, err)
+		}
+	}
+	return mutators.ParseModelDefaults(data)
+}
+
 func runProxy(cmd *cobra.Command, _ []string) error {
 	var log *slog.Logger
 	if logFormat == logging.FormatFlagValueText {
@@ -144,6 +515,12 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 		return errors.New("TLS certificate and key must be provided together")
 	}
 
+	endpoint, err := resolveAPIEndpoint(apiEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid apiEndpoint: %w", err)
+	}
+	apiEndpoint = endpoint
+
 	cacheSalt, err := getPromptCacheSalt()
 	if err != nil {
 		return fmt.Errorf("getting prompt cache salt: %w", err)
@@ -151,26 +528,131 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 
 	var apiKey *string
 	if cmd.Flags().Changed("apiKey") {
-		// Trim '@' and read file contents
-		if path, ok := strings.CutPrefix(apiKeyStr, "@"); ok {
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("failed to read API key file %q: %w", path, err)
-			}
-			key := strings.TrimSpace(string(data))
-			apiKey = &key
-		} else {
-			// Direct literal
-			apiKey = &apiKeyStr
+		key, err := literalOrFile(apiKeyStr)
+		if err != nil {
+			return fmt.Errorf("failed to read API key file: %w", err)
 		}
+		apiKey = &key
 	} else {
 		log.Warn("No API key provided. The proxy will not authenticate with the API.")
 	}
 
+	adminToken, err := literalOrFile(adminTokenStr)
+	if err != nil {
+		return fmt.Errorf("failed to read admin token file: %w", err)
+	}
+
 	if !nvidiaOCSPAllowUnknown && (nvidiaOCSPRevokedGracePeriod > 0) {
 		return errors.New("unknown OCSP statuses are disallowed, but revoked statuses are allowed. This is likely to be an erroneous configuration")
 	}
 
+	if maxResponseBytes <= 0 || maxResponseBytes > constants.MaxUnaryResponseBodyBytes {
+		return fmt.Errorf("maxResponseBytes must be between 1 and %d", constants.MaxUnaryResponseBodyBytes)
+	}
+
+	if maxPromptChars < 0 {
+		return errors.New("maxPromptChars must not be negative")
+	}
+
+	if dumpMaxBytes < 0 || dumpMaxFiles < 0 {
+		return errors.New("dumpMaxBytes and dumpMaxFiles must not be negative")
+	}
+
+	if maxTools < 0 {
+		return errors.New("maxTools must not be negative")
+	}
+	for _, contentType := range unstructuredContentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("invalid content type %q in unstructuredContentTypes: %w", contentType, err)
+		}
+	}
+
+	if shardKeyWarnFraction < 0 || shardKeyWarnFraction > 1 {
+		return errors.New("shardKeyWarnFraction must be between 0 and 1")
+	}
+
+	if streamHeartbeatInterval < 0 {
+		return errors.New("streamHeartbeatInterval must not be negative")
+	}
+
+	if streamBufferSize < 1 || streamBufferSize > maxStreamBufferSize {
+		return fmt.Errorf("streamBufferSize must be between 1 and %d", maxStreamBufferSize)
+	}
+
+	if strings.TrimSpace(encryptedHeader) == "" {
+		return errors.New("encryptedHeader must not be empty")
+	}
+	if requestTimeout < 0 {
+		return errors.New("requestTimeout must not be negative")
+	}
+
+	if retryBudget < 0 {
+		return errors.New("retryBudget must not be negative")
+	}
+	if overloadRetries < 0 || maxRetryAfter < 0 {
+		return errors.New("overloadRetries and maxRetryAfter must not be negative")
+	}
+	if secretWaitTimeout < 0 {
+		return errors.New("secretWaitTimeout must not be negative")
+	}
+
+	if idempotencyWindow < 0 {
+		return errors.New("idempotencyWindow must not be negative")
+	}
+	if idempotencyCacheSize < 1 {
+		return errors.New("idempotencyCacheSize must be positive")
+	}
+
+	for _, endpoint := range enabledEndpoints {
+		if !slices.Contains(server.Endpoints(), endpoint) {
+			return fmt.Errorf("unknown endpoint %q in enabledEndpoints, available endpoints: %s", endpoint, strings.Join(server.Endpoints(), ", "))
+		}
+	}
+	if disabledEndpointStatus != http.StatusForbidden && disabledEndpointStatus != http.StatusNotFound {
+		return fmt.Errorf("disabledEndpointStatus must be %d or %d", http.StatusForbidden, http.StatusNotFound)
+	}
+
+	modelDefaults, err := getModelDefaults()
+	if err != nil {
+		return fmt.Errorf("getting model defaults: %w", err)
+	}
+
+	extraHeader, err := server.ParseExtraHeaders(extraHeaders)
+	if err != nil {
+		return fmt.Errorf("parsing extra headers: %w", err)
+	}
+	forwardHeader, err := server.ParseForwardHeaders(forwardHeaders)
+	if err != nil {
+		return fmt.Errorf("parsing forwarded headers: %w", err)
+	}
+	modelQuotas, err := server.ParseModelQuotas(modelQuotaEntries)
+	if err != nil {
+		return fmt.Errorf("parsing model quotas: %w", err)
+	}
+	corsOrigins, err := server.ParseCORSAllowedOrigins(corsAllowedOrigins)
+	if err != nil {
+		return fmt.Errorf("parsing CORS allowed origins: %w", err)
+	}
+
+	var auditSink server.AuditSink
+	if auditLog != "" {
+		auditSink, err = server.NewAuditSink(auditLog, http.DefaultClient)
+		if err != nil {
+			return fmt.Errorf("setting up audit log: %w", err)
+		}
+		if closer, ok := auditSink.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+
+	var upstreamProxyURL *url.URL
+	if upstreamProxy != "" {
+		upstreamProxyURL, err = httputil.ParseProxyURL(upstreamProxy)
+		if err != nil {
+			return fmt.Errorf("parsing upstream proxy: %w", err)
+		}
+	}
+
 	log.Info("Starting proxy")
 	flags := setup.Flags{
 		Workspace:    workspace,
@@ -182,8 +664,59 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 		APIEndpoint:                  apiEndpoint,
 		APIKey:                       apiKey,
 		PromptCacheSalt:              cacheSalt,
+		CacheSaltPerAPIKey:           cacheSaltPerAPIKey,
+		DisablePromptCache:           disablePromptCache,
+		RequireClientCacheSalt:       requireClientCacheSalt,
 		NvidiaOCSPAllowUnknown:       nvidiaOCSPAllowUnknown,
 		NvidiaOCSPRevokedGracePeriod: time.Duration(nvidiaOCSPRevokedGracePeriod) * time.Hour,
+		MaxHeaderBytes:               maxHeaderBytes,
+		RejectLargeHeaders:           rejectLargeHeaders,
+		MaxResponseBytes:             maxResponseBytes,
+		MaxBatchSize:                 maxBatchSize,
+		BatchConcurrency:             batchConcurrency,
+		PreserveClientRequestID:      preserveClientRequestID,
+		StrictJSON:                   strictJSON,
+		EnabledEndpoints:             enabledEndpoints,
+		DisabledEndpointStatus:       disabledEndpointStatus,
+		StreamHeartbeatInterval:      streamHeartbeatInterval,
+		StreamBufferSize:             streamBufferSize,
+		RequestTimeout:               requestTimeout,
+		RetryBudget:                  retryBudget,
+		OverloadRetries:              overloadRetries,
+		MaxRetryAfter:                maxRetryAfter,
+		StreamErrorEvents:            streamErrorEvents,
+		RetryMetrics:                 retryMetrics,
+		ForwardTrailers:              forwardTrailers,
+		ExposeShardKey:               exposeShardKey,
+		EchoUpstreamRequestID:        echoUpstreamRequestID,
+		StreamCompatMode:             streamCompatMode,
+		EncryptedHeader:              encryptedHeader,
+		PlaintextByDefault:           plaintextByDefault,
+		CoalesceRequests:             coalesceRequests,
+		StripForwardedFor:            stripForwardedFor,
+		MaxPromptChars:               maxPromptChars,
+		MaxTools:                     maxTools,
+		UnstructuredContentTypes:     unstructuredContentTypes,
+		ShardKeyWarnFraction:         shardKeyWarnFraction,
+		AdminToken:                   adminToken,
+		IdempotencyWindow:            idempotencyWindow,
+		IdempotencyCacheSize:         idempotencyCacheSize,
+		VerifyDecryptedResponse:      verifyDecryptedResponse,
+		VerifyModelMatch:             verifyModelMatch,
+		SecretRefreshJitter:          float64(secretRefreshJitter) / 100,
+		SecretWaitTimeout:            secretWaitTimeout,
+		ModelDefaults:                modelDefaults,
+		UpstreamProxy:                upstreamProxyURL,
+		ExtraHeaders:                 extraHeader,
+		ForwardHeaders:               forwardHeader,
+		ModelQuotas:                  modelQuotas,
+		CORSAllowedOrigins:           corsOrigins,
+		QuietRequestLogs:             quiet,
+		ModelPrefixStrip:             modelPrefixStrip,
+		AllowModelHeader:             allowModelHeader,
+		TimingHeaders:                timingHeaders,
+		AuditSink:                    auditSink,
+		AuditLog:                     auditLog,
 		// If request dumping is enabled, store dumps in a hard‑coded "/requests" sub‑directory
 		// under the workspace. Otherwise leave the directory empty to disable dumping.
 		DumpRequestsDir: func() string {
@@ -192,47 +725,218 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 			}
 			return ""
 		}(),
+		DumpCompress: dumpCompress,
+		DumpMaxBytes: dumpMaxBytes,
+		DumpMaxFiles: dumpMaxFiles,
 	}
-	manager, _, err := setup.SecretManager(cmd.Context(), flags, log)
-	if err != nil {
-		return fmt.Errorf("setting up secret manager configuration: %w", err)
+	if printConfig {
+		config, err := flags.RedactedJSON()
+		if err != nil {
+			return fmt.Errorf("marshaling configuration: %w", err)
+		}
+		log.Info("Effective configuration", "config", string(config))
 	}
+
 	const isApp = false
+	var manager *secretmanager.SecretManager
+	var srv *server.Server
+	if mockBackend {
+		printMockBackendWarning()
+		log.Warn("Using a mock backend, requests are not forwarded to the Privatemode API and no attestation is performed")
+		manager, srv, err = setup.MockBackend(cmd.Context(), flags, isApp, log)
+		if err != nil {
+			return fmt.Errorf("setting up mock backend: %w", err)
+		}
+	} else {
+		if err := setup.CheckVersion(cmd.Context(), flags, requireMinVersion, log); err != nil {
+			return fmt.Errorf("checking version: %w", err)
+		}
+		manager, flags.CurrentManifest, err = setup.SecretManager(flags, log)
+		if err != nil {
+			return fmt.Errorf("setting up secret manager configuration: %w", err)
+		}
+		srv, err = setup.NewServer(flags, isApp, manager, log)
+		if err != nil {
+			return fmt.Errorf("creating server: %w", err)
+		}
+		if apiKey != nil {
+			if err := setup.PrefetchSecret(cmd.Context(), manager, *apiKey, allowDegradedStart, log); err != nil {
+				return fmt.Errorf("prefetching secret: %w", err)
+			}
+		}
+	}
 
-	lis, err := net.Listen("tcp", net.JoinHostPort("", port))
-	if err != nil {
-		return fmt.Errorf("listening on port %q: %w", port, err)
+	if checkRoutes {
+		if err := srv.CheckRoutes(); err != nil {
+			return fmt.Errorf("checking routes: %w", err)
+		}
+	}
+
+	// Listeners are closed when serving stops. Close them explicitly in case setup fails early.
+	var listeners []net.Listener
+	if unixSocket == "" || cmd.Flags().Changed("port") {
+		lis, err := net.Listen("tcp", net.JoinHostPort("", port))
+		if err != nil {
+			return fmt.Errorf("listening on port %q: %w", port, err)
+		}
+		defer lis.Close()
+		listeners = append(listeners, lis)
+	}
+	if unixSocket != "" {
+		lis, err := listenUnix(unixSocket)
+		if err != nil {
+			return err
+		}
+		// The socket file is removed on close.
+		defer lis.Close()
+		listeners = append(listeners, lis)
 	}
-	tlsConfig, err := getTLSConfig(tlsCertPath, tlsKeyPath)
+	tlsConfig, err := getTLSConfig(tlsCertPath, tlsKeyPath, tlsMinVersion, tlsCipherSuites)
 	if err != nil {
 		return fmt.Errorf("loading TLS config: %w", err)
 	}
 
+	// If serving on one listener fails, everything is shut down, so the proxy doesn't keep running half-broken.
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
 	var wg sync.WaitGroup
 	wg.Go(func() {
 		loopLog := log.With("component", "secret-loop")
-		if err := manager.Loop(cmd.Context(), loopLog); err != nil {
+		if err := manager.Loop(ctx, loopLog); err != nil {
 			loopLog.Error("Secret update loop exited", "error", err)
 			// do not exit because the server will still keep the secrets up-to-date through incoming requests
 		}
 	})
 
-	wg.Go(func() {
-		err = setup.
-			NewServer(flags, isApp, manager, log).
-			Serve(cmd.Context(), lis, tlsConfig)
-	})
+	if metricsPort != "" {
+		metricsLis, err := net.Listen("tcp", net.JoinHostPort("", metricsPort))
+		if err != nil {
+			return fmt.Errorf("listening on metrics port %q: %w", metricsPort, err)
+		}
+		wg.Go(func() {
+			metricsLog := log.With("component", "metrics-server")
+			if err := serveMetrics(ctx, metricsLis, metricsLog); err != nil {
+				metricsLog.Error("Metrics server exited", "error", err)
+			}
+		})
+	}
+
+	serveErrs := make([]error, len(listeners))
+	for i, lis := range listeners {
+		wg.Go(func() {
+			serveErrs[i] = srv.Serve(ctx, lis, tlsConfig)
+			cancel()
+		})
+	}
 
 	wg.Wait()
-	return err
+	return errors.Join(serveErrs...)
+}
+
+func printMockBackendWarning() {
+	fmt.Println("-----------------------------------------------------")
+	fmt.Println("-----------------------WARNING-----------------------")
+	fmt.Println("Using a mock backend without attestation")
+	fmt.Println("This is insecure and should only be used for development")
+	fmt.Println("-----------------------WARNING-----------------------")
+	fmt.Println("-----------------------------------------------------")
+}
+
+// resolveAPIEndpoint returns the host and port of the Privatemode API for endpoint, which is either
+// "host:port" or an HTTPS URL without a path. The proxy always connects to the API with HTTPS,
+// so other URL schemes are rejected.
+func resolveAPIEndpoint(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		if endpoint == "" {
+			return "", errors.New("endpoint must not be empty")
+		}
+		return endpoint, nil
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if endpointURL.Host == "" {
+		return "", fmt.Errorf("endpoint %q has no host", endpoint)
+	}
+	if strings.TrimSuffix(endpointURL.Path, "/") != "" || endpointURL.RawQuery != "" || endpointURL.Fragment != "" {
+		return "", fmt.Errorf("endpoint %q must not have a path, query, or fragment", endpoint)
+	}
+	if endpointURL.Scheme != "https" {
+		return "", fmt.Errorf("endpoint %q must use HTTPS, got scheme %q", endpoint, endpointURL.Scheme)
+	}
+
+	hostPort := endpointURL.Host
+	if endpointURL.Port() == "" {
+		hostPort = net.JoinHostPort(endpointURL.Hostname(), "443")
+	}
+	return hostPort, nil
 }
 
 // getTLSConfig returns the TLS configuration for production.
-func getTLSConfig(tlsCertPath, tlsKeyPath string) (*tls.Config, error) {
+// The TLS version and cipher suite settings are validated even if TLS is disabled.
+func getTLSConfig(tlsCertPath, tlsKeyPath, minVersion string, cipherSuiteNames []string) (*tls.Config, error) {
+	version, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseTLSCipherSuites(cipherSuiteNames)
+	if err != nil {
+		return nil, err
+	}
+	if version == tls.VersionTLS13 && len(cipherSuites) > 0 {
+		// Go doesn't allow configuring TLS 1.3 cipher suites, so the setting would be silently ignored.
+		return nil, errors.New("tlsCipherSuites can't be used with tlsMinVersion 1.3")
+	}
+
 	if tlsCertPath == "" && tlsKeyPath == "" {
 		return nil, nil
 	}
-	return tlsFileReloadCfg(tlsCertPath, tlsKeyPath)
+	cfg, err := tlsFileReloadCfg(tlsCertPath, tlsKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinVersion = version
+	cfg.CipherSuites = cipherSuites
+	return cfg, nil
+}
+
+// parseTLSVersion parses a TLS version of the form "1.2" or "1.3".
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", version)
+	}
+}
+
+// parseTLSCipherSuites parses a list of cipher suite names as returned by [tls.CipherSuite.Name].
+// Only TLS 1.0-1.2 cipher suites without known security issues are accepted.
+// An empty list results in Go's default cipher suites.
+func parseTLSCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	available := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		if slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			available[suite.Name] = suite.ID
+		}
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS cipher suite %q, available cipher suites: %s", name, strings.Join(slices.Sorted(maps.Keys(available)), ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 // tlsFileReloadCfg returns a [*tls.Config] that loads the certificate and key from the given paths for every connection. It validates the paths on creation.
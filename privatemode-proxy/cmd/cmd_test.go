@@ -0,0 +1,255 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTLSConfig(t *testing.T) {
+	certPath, keyPath := writeTestCert(t)
+
+	testCases := map[string]struct {
+		certPath         string
+		keyPath          string
+		minVersion       string
+		cipherSuites     []string
+		wantMinVersion   uint16
+		wantCipherSuites []uint16
+		wantNil          bool
+		wantErr          bool
+	}{
+		"defaults": {
+			certPath:       certPath,
+			keyPath:        keyPath,
+			minVersion:     "1.2",
+			wantMinVersion: tls.VersionTLS12,
+		},
+		"TLS 1.3": {
+			certPath:       certPath,
+			keyPath:        keyPath,
+			minVersion:     "1.3",
+			wantMinVersion: tls.VersionTLS13,
+		},
+		"cipher suites": {
+			certPath:         certPath,
+			keyPath:          keyPath,
+			minVersion:       "1.2",
+			cipherSuites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+			wantMinVersion:   tls.VersionTLS12,
+			wantCipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+		"TLS disabled": {
+			minVersion: "1.3",
+			wantNil:    true,
+		},
+		"unsupported version": {
+			certPath:   certPath,
+			keyPath:    keyPath,
+			minVersion: "1.1",
+			wantErr:    true,
+		},
+		"unsupported version with TLS disabled": {
+			minVersion: "1.0",
+			wantErr:    true,
+		},
+		"unknown cipher suite": {
+			certPath:     certPath,
+			keyPath:      keyPath,
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_UNKNOWN"},
+			wantErr:      true,
+		},
+		"insecure cipher suite": {
+			certPath:     certPath,
+			keyPath:      keyPath,
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			wantErr:      true,
+		},
+		"TLS 1.3 cipher suite": {
+			certPath:     certPath,
+			keyPath:      keyPath,
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_AES_128_GCM_SHA256"},
+			wantErr:      true,
+		},
+		"cipher suites with TLS 1.3": {
+			certPath:     certPath,
+			keyPath:      keyPath,
+			minVersion:   "1.3",
+			cipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			wantErr:      true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			cfg, err := getTLSConfig(tc.certPath, tc.keyPath, tc.minVersion, tc.cipherSuites)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			if tc.wantNil {
+				assert.Nil(cfg)
+				return
+			}
+			require.NotNil(cfg)
+			assert.Equal(tc.wantMinVersion, cfg.MinVersion)
+			assert.Equal(tc.wantCipherSuites, cfg.CipherSuites)
+		})
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	certPath, keyPath := writeTestCert(t)
+
+	testCases := map[string]struct {
+		serverMinVersion string
+		clientMaxVersion uint16
+		wantErr          bool
+	}{
+		"TLS 1.2 client with min version 1.2": {
+			serverMinVersion: "1.2",
+			clientMaxVersion: tls.VersionTLS12,
+		},
+		"TLS 1.3 client with min version 1.3": {
+			serverMinVersion: "1.3",
+			clientMaxVersion: tls.VersionTLS13,
+		},
+		"TLS 1.2 client with min version 1.3": {
+			serverMinVersion: "1.3",
+			clientMaxVersion: tls.VersionTLS12,
+			wantErr:          true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			cfg, err := getTLSConfig(certPath, keyPath, tc.serverMinVersion, nil)
+			require.NoError(err)
+
+			lis, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+			require.NoError(err)
+			defer lis.Close()
+
+			go func() {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+
+			conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true, //nolint:gosec // self-signed test certificate
+				MaxVersion:         tc.clientMaxVersion,
+			})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(err)
+			defer conn.Close()
+			assert.Equal(t, tc.clientMaxVersion, conn.ConnectionState().Version)
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to a temporary directory and returns their paths.
+func writeTestCert(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	require.NoError(os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600))
+	require.NoError(os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, keyPath
+}
+
+func TestResolveAPIEndpoint(t *testing.T) {
+	testCases := map[string]struct {
+		endpoint     string
+		wantHostPort string
+		wantErr      bool
+	}{
+		"host and port": {
+			endpoint:     "api.privatemode.ai:443",
+			wantHostPort: "api.privatemode.ai:443",
+		},
+		"https URL": {
+			endpoint:     "https://api.privatemode.ai:8443",
+			wantHostPort: "api.privatemode.ai:8443",
+		},
+		"https URL without port": {
+			endpoint:     "https://api.privatemode.ai/",
+			wantHostPort: "api.privatemode.ai:443",
+		},
+		"http URL": {
+			endpoint: "http://api.privatemode.ai:80",
+			wantErr:  true,
+		},
+		"URL with path": {
+			endpoint: "https://api.privatemode.ai/v1",
+			wantErr:  true,
+		},
+		"URL without host": {
+			endpoint: "https://",
+			wantErr:  true,
+		},
+		"empty": {
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			hostPort, err := resolveAPIEndpoint(tc.endpoint)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(tc.wantHostPort, hostPort)
+		})
+	}
+}
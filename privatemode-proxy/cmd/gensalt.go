@@ -0,0 +1,43 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/spf13/cobra"
+)
+
+// newGenSaltCmd returns the gen-salt command, which prints random salts for use with --promptCacheSalt.
+func newGenSaltCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gen-salt",
+		Short: "Generate a random prompt cache salt for use with --promptCacheSalt.",
+		Long: "Generate a random base64-encoded 256-bit prompt cache salt, one per line. " +
+			"Use it with --sharedPromptCache and --promptCacheSalt to keep a shared prompt cache across restarts of the proxy.",
+		Args:         cobra.NoArgs,
+		RunE:         runGenSalt,
+		SilenceUsage: true,
+	}
+	cmd.Flags().IntP("count", "n", 1, "The number of salts to generate.")
+	return cmd
+}
+
+func runGenSalt(cmd *cobra.Command, _ []string) error {
+	count, err := cmd.Flags().GetInt("count")
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return errors.New("count must be at least 1")
+	}
+	for range count {
+		if _, err := fmt.Fprintln(cmd.OutOrStdout(), openai.RandomPromptCacheSalt()); err != nil {
+			return fmt.Errorf("writing salt: %w", err)
+		}
+	}
+	return nil
+}
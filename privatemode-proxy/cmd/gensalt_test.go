@@ -0,0 +1,77 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenSalt(t *testing.T) {
+	testCases := map[string]struct {
+		args      []string
+		wantCount int
+		wantErr   bool
+	}{
+		"default": {
+			args:      []string{"gen-salt"},
+			wantCount: 1,
+		},
+		"multiple": {
+			args:      []string{"gen-salt", "--count", "5"},
+			wantCount: 5,
+		},
+		"shorthand": {
+			args:      []string{"gen-salt", "-n", "3"},
+			wantCount: 3,
+		},
+		"zero": {
+			args:    []string{"gen-salt", "-n", "0"},
+			wantErr: true,
+		},
+		"positional argument": {
+			args:    []string{"gen-salt", "foo"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Run twice to check that salts differ between invocations.
+			seen := map[string]struct{}{}
+			for range 2 {
+				cmd := New()
+				var out bytes.Buffer
+				cmd.SetOut(&out)
+				cmd.SetErr(&bytes.Buffer{})
+				cmd.SetArgs(tc.args)
+
+				err := cmd.Execute()
+				if tc.wantErr {
+					assert.Error(err)
+					return
+				}
+				require.NoError(err)
+
+				salts := strings.Split(strings.TrimSpace(out.String()), "\n")
+				require.Len(salts, tc.wantCount)
+				for _, salt := range salts {
+					decoded, err := base64.StdEncoding.DecodeString(salt)
+					require.NoError(err)
+					assert.Len(decoded, 32)
+					assert.NotContains(seen, salt)
+					seen[salt] = struct{}{}
+				}
+			}
+		})
+	}
+}
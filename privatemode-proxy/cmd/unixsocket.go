@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// listenUnix listens on a Unix domain socket at path, which is only accessible by the owner.
+// The socket is created in a private temporary directory and then moved to path, so that it's
+// never accessible by others, regardless of the umask.
+// A socket file left behind by a previous run that wasn't shut down cleanly is removed.
+// The socket file is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".privatemode-proxy-")
+	if err != nil {
+		return nil, fmt.Errorf("creating directory for Unix socket %q: %w", path, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "proxy.sock")
+	lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("listening on Unix socket %q: %w", path, err)
+	}
+	// The socket file is moved, so it's removed by unixListener.Close instead.
+	lis.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		_ = lis.Close()
+		return nil, fmt.Errorf("restricting access to Unix socket %q: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = lis.Close()
+		return nil, fmt.Errorf("moving Unix socket to %q: %w", path, err)
+	}
+	return &unixListener{UnixListener: lis, path: path}, nil
+}
+
+// unixListener is a [net.UnixListener] whose socket file has been moved to path.
+type unixListener struct {
+	*net.UnixListener
+	path      string
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Addr returns the address of the moved socket.
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close closes the listener and removes the socket file.
+func (l *unixListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.UnixListener.Close()
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			l.closeErr = errors.Join(l.closeErr, err)
+		}
+	})
+	return l.closeErr
+}
+
+// removeStaleSocket removes the socket at path if no one is listening on it.
+// Other files are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking Unix socket %q: %w", path, err)
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%q exists and is not a Unix socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("another process is listening on Unix socket %q", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("removing stale Unix socket %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	testCases := map[string]struct {
+		prepare func(t *testing.T, path string)
+		wantErr bool
+	}{
+		"new socket": {},
+		"stale socket": {
+			prepare: func(t *testing.T, path string) {
+				lis, err := net.Listen("unix", path)
+				require.NoError(t, err)
+				lis.(*net.UnixListener).SetUnlinkOnClose(false) // simulate a proxy that wasn't shut down cleanly
+				require.NoError(t, lis.Close())
+			},
+		},
+		"socket in use": {
+			prepare: func(t *testing.T, path string) {
+				lis, err := net.Listen("unix", path)
+				require.NoError(t, err)
+				t.Cleanup(func() { _ = lis.Close() })
+			},
+			wantErr: true,
+		},
+		"regular file": {
+			prepare: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			path := filepath.Join(t.TempDir(), "proxy.sock")
+			if tc.prepare != nil {
+				tc.prepare(t, path)
+			}
+
+			lis, err := listenUnix(path)
+			if tc.wantErr {
+				require.Error(err)
+				_, err := os.Lstat(path)
+				assert.NoError(err, "existing file must not be removed")
+				return
+			}
+			require.NoError(err)
+
+			info, err := os.Lstat(path)
+			require.NoError(err)
+			assert.Equal(fs.ModeSocket, info.Mode().Type())
+			assert.Equal(os.FileMode(0o600), info.Mode().Perm())
+			assert.Equal(path, lis.Addr().String())
+
+			// The temporary directory the socket was created in is removed.
+			entries, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(err)
+			assert.Len(entries, 1)
+
+			conn, err := net.Dial("unix", path)
+			require.NoError(err)
+			require.NoError(conn.Close())
+
+			require.NoError(lis.Close())
+			_, err = os.Lstat(path)
+			assert.ErrorIs(err, fs.ErrNotExist, "socket file must be removed on close")
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/tidwall/gjson"
+)
+
+// AttestationEndpoint returns the attestation policy enforced by the proxy, see [AttestationInfo].
+const AttestationEndpoint = "/attestation"
+
+// AttestationInfo is the response of [AttestationEndpoint]. It doesn't contain any secrets.
+type AttestationInfo struct {
+	// Manifest summarizes the Contrast manifest the deployment was last verified against.
+	// It is nil if no manifest has been verified yet.
+	Manifest *ManifestSummary `json:"manifest"`
+	// NvidiaOCSPPolicy is the policy the API enforces for the OCSP status of NVIDIA attestation certificates.
+	NvidiaOCSPPolicy OCSPPolicy `json:"nvidia_ocsp_policy"`
+}
+
+// ManifestSummary identifies a Contrast manifest.
+type ManifestSummary struct {
+	// SHA256 is the hex-encoded SHA-256 hash of the manifest.
+	SHA256 string `json:"sha256"`
+	// Policies are the hashes of the workload policies allowed by the manifest.
+	Policies []string `json:"policies"`
+	// Platforms are the TEE platforms the manifest has reference values for.
+	Platforms []string `json:"platforms"`
+}
+
+// OCSPPolicy describes which OCSP statuses of NVIDIA attestation certificates are accepted.
+type OCSPPolicy struct {
+	// AllowedStatuses are the accepted OCSP statuses.
+	AllowedStatuses []ocspheader.AllowStatus `json:"allowed_statuses"`
+	// RevokedGracePeriodSeconds is the time after revocation for which revoked certificates are still accepted.
+	RevokedGracePeriodSeconds int64 `json:"revoked_grace_period_seconds"`
+}
+
+// attestationHandler handles requests to [AttestationEndpoint].
+func (s *Server) attestationHandler(w http.ResponseWriter, _ *http.Request) {
+	info := AttestationInfo{
+		NvidiaOCSPPolicy: OCSPPolicy{
+			AllowedStatuses:           s.ocspAllowedStatuses(),
+			RevokedGracePeriodSeconds: int64(s.nvidiaOCSPRevokedGracePeriod.Seconds()),
+		},
+	}
+	if s.currentManifest != nil {
+		if manifest := s.currentManifest(); manifest != "" {
+			info.Manifest = summarizeManifest([]byte(manifest))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		s.log.Error("Writing attestation info", "error", err)
+	}
+}
+
+// ocspAllowedStatuses returns the OCSP statuses allowed by [Opts.NvidiaOCSPAllowUnknown]
+// and [Opts.NvidiaOCSPRevokedGracePeriod].
+func (s *Server) ocspAllowedStatuses() []ocspheader.AllowStatus {
+	allowedStatuses := []ocspheader.AllowStatus{ocspheader.AllowStatusGood}
+	if s.nvidiaOCSPRevokedGracePeriod > 0 {
+		// In theory, we could always add the `revoked` status, since it will render
+		// ineffective if the grace period is 0, but it might look strange to the user
+		// to find a `revoked` status in the policy header, so we only add it if the
+		// grace period is set.
+		allowedStatuses = append(allowedStatuses, ocspheader.AllowStatusRevoked)
+	}
+	if s.nvidiaOCSPAllowUnknown {
+		allowedStatuses = append(allowedStatuses, ocspheader.AllowStatusUnknown)
+	}
+	return allowedStatuses
+}
+
+// summarizeManifest returns the hash of manifest and the policies and platforms it allows.
+func summarizeManifest(manifest []byte) *ManifestSummary {
+	hash := sha256.Sum256(manifest)
+	summary := &ManifestSummary{
+		SHA256:    hex.EncodeToString(hash[:]),
+		Policies:  []string{},
+		Platforms: []string{},
+	}
+	gjson.GetBytes(manifest, "Policies").ForEach(func(policyHash, _ gjson.Result) bool {
+		summary.Policies = append(summary.Policies, policyHash.String())
+		return true
+	})
+	gjson.GetBytes(manifest, "ReferenceValues").ForEach(func(platform, values gjson.Result) bool {
+		if len(values.Array()) > 0 {
+			summary.Platforms = append(summary.Platforms, platform.String())
+		}
+		return true
+	})
+	slices.Sort(summary.Policies)
+	slices.Sort(summary.Platforms)
+	return summary
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationEndpoint(t *testing.T) {
+	const manifest = `{"Policies":{"bb22":{"Role":"none"},"aa11":{"Role":"coordinator"}},` +
+		`"ReferenceValues":{"snp":[{"MinimumTCB":{}}],"tdx":[]},"WorkloadOwnerPubKeys":[]}`
+	manifestHash := sha256.Sum256([]byte(manifest))
+
+	testCases := map[string]struct {
+		allowUnknown       bool
+		revokedGracePeriod time.Duration
+		currentManifest    func() string
+		wantPolicy         OCSPPolicy
+		wantManifest       *ManifestSummary
+	}{
+		"strict policy without manifest": {
+			wantPolicy: OCSPPolicy{
+				AllowedStatuses: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			},
+		},
+		"lenient policy": {
+			allowUnknown:       true,
+			revokedGracePeriod: 48 * time.Hour,
+			wantPolicy: OCSPPolicy{
+				AllowedStatuses: []ocspheader.AllowStatus{
+					ocspheader.AllowStatusGood, ocspheader.AllowStatusRevoked, ocspheader.AllowStatusUnknown,
+				},
+				RevokedGracePeriodSeconds: 48 * 60 * 60,
+			},
+		},
+		"manifest not verified yet": {
+			currentManifest: func() string { return "" },
+			wantPolicy: OCSPPolicy{
+				AllowedStatuses: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			},
+		},
+		"verified manifest": {
+			currentManifest: func() string { return manifest },
+			wantPolicy: OCSPPolicy{
+				AllowedStatuses: []ocspheader.AllowStatus{ocspheader.AllowStatusGood},
+			},
+			wantManifest: &ManifestSummary{
+				SHA256:    hex.EncodeToString(manifestHash[:]),
+				Policies:  []string{"aa11", "bb22"},
+				Platforms: []string{"snp"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			sut := newTestServer(nil, secretmanager.Secret{}, "", "", false)
+			sut.nvidiaOCSPAllowUnknown = tc.allowUnknown
+			sut.nvidiaOCSPRevokedGracePeriod = tc.revokedGracePeriod
+			sut.currentManifest = tc.currentManifest
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, AttestationEndpoint, nil)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal("application/json", resp.Header().Get("Content-Type"))
+
+			var info AttestationInfo
+			require.NoError(json.Unmarshal(resp.Body.Bytes(), &info))
+			assert.Equal(tc.wantPolicy, info.NvidiaOCSPPolicy)
+			assert.Equal(tc.wantManifest, info.Manifest)
+		})
+	}
+}
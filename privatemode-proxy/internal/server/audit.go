@@ -0,0 +1,274 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// auditTimeout bounds writing a single record to an [AuditSink].
+	auditTimeout = 10 * time.Second
+	// auditQueueSize bounds the records waiting to be written to an [AuditSink].
+	auditQueueSize = 1024
+	// maxAuditLineBytes bounds the line of an event stream that is buffered to find the token usage.
+	maxAuditLineBytes = 1 << 20
+)
+
+// AuditRecord is the metadata of an inference request that is written to an [AuditSink].
+// It never contains the content of the request or the response.
+type AuditRecord struct {
+	Timestamp        time.Time `json:"timestamp"`
+	RequestID        string    `json:"request_id"`
+	Endpoint         string    `json:"endpoint"`
+	Model            string    `json:"model,omitzero"`
+	StatusCode       int       `json:"status"`
+	PromptTokens     int64     `json:"prompt_tokens,omitzero"`
+	CompletionTokens int64     `json:"completion_tokens,omitzero"`
+	TotalTokens      int64     `json:"total_tokens,omitzero"`
+}
+
+// AuditSink receives an [AuditRecord] after each inference request, see [Opts.AuditSink].
+// It must be safe for concurrent use.
+type AuditSink interface {
+	// Record writes record to the sink.
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// NewAuditSink returns an [HTTPAuditSink] if target is an HTTP(S) URL, and a [FileAuditSink] otherwise.
+func NewAuditSink(target string, client *http.Client) (AuditSink, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewHTTPAuditSink(target, client), nil
+	}
+	return NewFileAuditSink(target)
+}
+
+// FileAuditSink appends records as JSON Lines to a file.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink opens the file at path for appending records. The file is created if it doesn't
+// exist, and is only accessible by the owner.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Record appends record to the file.
+func (s *FileAuditSink) Record(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling audit record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing audit record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPAuditSink sends each record as JSON in a POST request to a URL.
+type HTTPAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPAuditSink returns a sink sending records to url with client.
+func NewHTTPAuditSink(url string, client *http.Client) *HTTPAuditSink {
+	return &HTTPAuditSink{url: url, client: client}
+}
+
+// Record sends record to the URL of the sink. Responses other than 2xx are treated as error.
+func (s *HTTPAuditSink) Record(ctx context.Context, record AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling audit record: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating audit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending audit record: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sending audit record: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// requestAudit collects the [AuditRecord] of a request while it is forwarded.
+// Only the model of the upstream request and the token usage of the response are read,
+// which are sent in plaintext.
+type requestAudit struct {
+	record AuditRecord
+	mu     sync.Mutex // guards usage updates from streaming bodies
+}
+
+// auditResponseMapper wraps next and records the model and token usage of the response.
+func (a *requestAudit) auditResponseMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		if resp.Request != nil {
+			a.record.Model = resp.Request.Header.Get(constants.PrivatemodeTargetModel)
+		}
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		switch r := dsResp.(type) {
+		case *forwarder.UnaryResponse:
+			a.recordUsage(r.Body)
+		case *forwarder.StreamingResponse:
+			r.Body = &usageObservingReader{ReadCloser: r.Body, audit: a}
+		}
+		return dsResp, nil
+	}
+}
+
+// recordUsage records the token usage of data if it has one. Both OpenAI and Anthropic field names are supported.
+func (a *requestAudit) recordUsage(data []byte) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	usage := gjson.GetBytes(data, "usage")
+	if !usage.IsObject() {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.record.PromptTokens = cmp.Or(usage.Get("prompt_tokens").Int(), usage.Get("input_tokens").Int())
+	a.record.CompletionTokens = cmp.Or(usage.Get("completion_tokens").Int(), usage.Get("output_tokens").Int())
+	a.record.TotalTokens = cmp.Or(usage.Get("total_tokens").Int(), a.record.PromptTokens+a.record.CompletionTokens)
+}
+
+// usageObservingReader passes a streaming body through unchanged and records the token usage
+// found in its lines, e.g., in the last event of an event stream.
+type usageObservingReader struct {
+	io.ReadCloser
+	audit *requestAudit
+	line  []byte
+}
+
+func (r *usageObservingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		before, after, found := bytes.Cut(data, []byte("\n"))
+		if len(r.line)+len(before) <= maxAuditLineBytes {
+			r.line = append(r.line, before...)
+		}
+		if !found {
+			break
+		}
+		r.observeLine()
+		data = after
+	}
+	if err != nil {
+		r.observeLine()
+	}
+	return n, err
+}
+
+func (r *usageObservingReader) observeLine() {
+	data, _ := bytes.CutPrefix(bytes.TrimSpace(r.line), []byte("data:"))
+	r.audit.recordUsage(bytes.TrimSpace(data))
+	r.line = r.line[:0]
+}
+
+// statusRecorder records the status code written to a [http.ResponseWriter].
+// The response body isn't recorded.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements [http.Flusher] if the underlying ResponseWriter supports flushing.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for [http.ResponseController].
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeAuditRecord queues the record of a finished request for [Opts.AuditSink].
+// Records are written in the background, so neither the client nor the other elements of a batch
+// wait for the sink. If the queue is full, e.g., because the sink is slow or unavailable,
+// the record is dropped and counted in [auditRecordsDroppedMetric].
+func (s *Server) writeAuditRecord(w *statusRecorder, audit *requestAudit) {
+	audit.mu.Lock()
+	record := audit.record
+	audit.mu.Unlock()
+	record.StatusCode = cmp.Or(w.statusCode, http.StatusOK)
+
+	s.auditWriterOnce.Do(func() {
+		if s.auditRecords == nil {
+			s.auditRecords = make(chan AuditRecord, auditQueueSize)
+		}
+		go s.drainAuditRecords()
+	})
+	select {
+	case s.auditRecords <- record:
+	default:
+		auditRecordsDroppedMetric.Inc()
+		s.log.Warn("Audit queue is full, dropping audit record", "requestID", record.RequestID)
+	}
+}
+
+// drainAuditRecords writes the queued audit records to [Opts.AuditSink] one at a time.
+func (s *Server) drainAuditRecords() {
+	for record := range s.auditRecords {
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		if err := s.auditSink.Record(ctx, record); err != nil {
+			s.log.Warn("Writing audit record failed", "requestID", record.RequestID, "error", err)
+		}
+		cancel()
+	}
+}
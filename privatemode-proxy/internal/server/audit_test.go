@@ -0,0 +1,273 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAuditSink(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileAuditSink(path)
+	require.NoError(err)
+	require.NoError(sink.Record(t.Context(), AuditRecord{RequestID: "first", StatusCode: http.StatusOK}))
+	require.NoError(sink.Record(t.Context(), AuditRecord{RequestID: "second", StatusCode: http.StatusBadRequest}))
+	require.NoError(sink.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(err)
+	assert.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// Records are appended to an existing file.
+	sink, err = NewFileAuditSink(path)
+	require.NoError(err)
+	require.NoError(sink.Record(t.Context(), AuditRecord{RequestID: "third", StatusCode: http.StatusOK}))
+	require.NoError(sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(lines, 3)
+	for i, wantID := range []string{"first", "second", "third"} {
+		var record AuditRecord
+		require.NoError(json.Unmarshal([]byte(lines[i]), &record))
+		assert.Equal(wantID, record.RequestID)
+	}
+}
+
+func TestHTTPAuditSink(t *testing.T) {
+	testCases := map[string]struct {
+		status  int
+		wantErr bool
+	}{
+		"accepted": {
+			status: http.StatusNoContent,
+		},
+		"rejected": {
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			var received AuditRecord
+			auditServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(http.MethodPost, r.Method)
+				assert.Equal("application/json", r.Header.Get("Content-Type"))
+				assert.NoError(json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(tc.status)
+			}))
+			defer auditServer.Close()
+
+			sink, err := NewAuditSink(auditServer.URL, auditServer.Client())
+			require.NoError(err)
+			require.IsType(&HTTPAuditSink{}, sink)
+
+			err = sink.Record(t.Context(), AuditRecord{RequestID: "abc", Model: "gpt", StatusCode: http.StatusOK, TotalTokens: 42})
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal("abc", received.RequestID)
+			assert.Equal("gpt", received.Model)
+			assert.EqualValues(42, received.TotalTokens)
+		})
+	}
+}
+
+func TestAuditRecords(t *testing.T) {
+	const prompt = "my secret prompt"
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+
+	testCases := map[string]struct {
+		stream         bool
+		omitCacheSalt  bool
+		wantStatusCode int
+		wantModel      string
+		wantTokens     [3]int64 // prompt, completion, total
+	}{
+		"unary": {
+			wantStatusCode: http.StatusOK,
+			wantModel:      "gpt-oss-120b",
+			wantTokens:     [3]int64{0, int64(len("Echo: " + prompt)), int64(len("Echo: " + prompt))},
+		},
+		"stream": {
+			stream:         true,
+			wantStatusCode: http.StatusOK,
+			wantModel:      "gpt-oss-120b",
+			wantTokens:     [3]int64{3, 5, 8},
+		},
+		"rejected before forwarding": {
+			omitCacheSalt:  true,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			backend := stub.EchoHandler(secret.Map(), slog.Default())
+			if tc.stream {
+				backend = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Header().Set("Privatemode-Encrypted", "false")
+					_, _ = io.WriteString(w, `data: {"choices":[{"delta":{"content":"Echo"}}]}`+"\n\n")
+					_, _ = io.WriteString(w, `data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`+"\n\n")
+					_, _ = io.WriteString(w, "data: [DONE]\n\n")
+				})
+			}
+			stubBackend := httptest.NewServer(backend)
+			defer stubBackend.Close()
+
+			sink := &stubAuditSink{}
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.auditSink = sink
+			sut.requireClientCacheSalt = true
+
+			cacheSalt := "r1234567890123456789012345678912"
+			if tc.omitCacheSalt {
+				cacheSalt = ""
+			}
+			req := prepareChatRequest(t.Context(), require, prompt, nil, cacheSalt)
+			start := time.Now()
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+
+			require.Eventually(func() bool { return len(sink.recorded()) == 1 }, 5*time.Second, time.Millisecond)
+			record := sink.recorded()[0]
+			assert.WithinRange(record.Timestamp, start, time.Now())
+			assert.NotEmpty(record.RequestID)
+			assert.Equal(openai.ChatCompletionsEndpoint, record.Endpoint)
+			assert.Equal(tc.wantStatusCode, record.StatusCode)
+			assert.Equal(tc.wantModel, record.Model)
+			assert.Equal(tc.wantTokens, [3]int64{record.PromptTokens, record.CompletionTokens, record.TotalTokens})
+
+			marshaled, err := json.Marshal(record)
+			require.NoError(err)
+			assert.NotContains(string(marshaled), prompt)
+			assert.NotContains(string(marshaled), "Echo")
+		})
+	}
+}
+
+func TestAuditRecordsDontBlockResponses(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+	defer stubBackend.Close()
+
+	release := make(chan struct{})
+	sink := &stubAuditSink{block: release}
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.auditSink = sink
+
+	req := prepareChatRequest(t.Context(), require, "hello", nil, "")
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req) // returns while the sink is blocked
+	assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.Empty(sink.recorded())
+
+	close(release)
+	require.Eventually(func() bool { return len(sink.recorded()) == 1 }, 5*time.Second, time.Millisecond)
+}
+
+func TestAuditRecordsDroppedWhenQueueIsFull(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.auditSink = &stubAuditSink{}
+	// Without a consumer, a queue of one record is full after the first request.
+	sut.auditRecords = make(chan AuditRecord, 1)
+	sut.auditWriterOnce.Do(func() {})
+
+	droppedBefore := auditRecordsDropped(t)
+	for range 3 {
+		req := prepareChatRequest(t.Context(), require, "hello", nil, "")
+		resp := httptest.NewRecorder()
+		sut.GetHandler().ServeHTTP(resp, req)
+		assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	assert.Len(sut.auditRecords, 1)
+	assert.InDelta(2, auditRecordsDropped(t)-droppedBefore, 0)
+}
+
+func auditRecordsDropped(t *testing.T) float64 {
+	var metric dto.Metric
+	require.NoError(t, auditRecordsDroppedMetric.Write(&metric))
+	return metric.GetCounter().GetValue()
+}
+
+type stubAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+	block   <-chan struct{} // if set, Record blocks until it's closed
+}
+
+func (s *stubAuditSink) Record(_ context.Context, record AuditRecord) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *stubAuditSink) recorded() []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.records)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/tidwall/gjson"
+)
+
+// ChatCompletionsBatchEndpoint accepts a JSON array of chat completion requests and returns a JSON
+// array of [BatchResponseElement]s in the same order.
+// This is a synchronous convenience endpoint of the proxy and unrelated to the OpenAI Batch API.
+const ChatCompletionsBatchEndpoint = openai.ChatCompletionsEndpoint + ":batch"
+
+// BatchResponseElement is the result of a single chat completion request of a batch.
+type BatchResponseElement struct {
+	// StatusCode is the HTTP status code the request would have received on its own.
+	StatusCode int `json:"status"`
+	// Body is the decrypted response body. Non-JSON error messages are encoded as JSON string.
+	Body json.RawMessage `json:"body"`
+}
+
+// chatCompletionsBatchHandler handles requests to [ChatCompletionsBatchEndpoint].
+// Each element of the batch is encrypted and forwarded independently, so a failing element
+// doesn't affect the others.
+func (s *Server) chatCompletionsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBatchBodyBytes))
+	if err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			forwarder.HTTPError(w, r, http.StatusRequestEntityTooLarge, "%s", constants.MaxBodySizeExceededMsg)
+			return
+		}
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "reading request body: %s", err)
+		return
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "request body must be a JSON array of chat completion requests: %s", err)
+		return
+	}
+	if len(batch) == 0 {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "batch is empty")
+		return
+	}
+	if len(batch) > s.maxBatchSize {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "batch size %d exceeds the maximum of %d", len(batch), s.maxBatchSize)
+		return
+	}
+
+	handleChatRequest := s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields)
+	concurrency := max(s.batchConcurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	results := make([]BatchResponseElement, len(batch))
+
+	var wg sync.WaitGroup
+	for i, element := range batch {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			results[i] = s.forwardBatchElement(r, element, handleChatRequest)
+		})
+	}
+	wg.Wait()
+
+	resp, err := json.Marshal(results)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusInternalServerError, "marshaling batch response: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp)
+}
+
+// forwardBatchElement forwards a single element of a batch as if it was sent on its own.
+func (s *Server) forwardBatchElement(r *http.Request, element json.RawMessage, handleChatRequest http.HandlerFunc) BatchResponseElement {
+	if !gjson.ParseBytes(element).IsObject() {
+		return newBatchErrorElement(http.StatusBadRequest, "batch element must be a JSON object")
+	}
+	if gjson.GetBytes(element, "stream").Bool() {
+		return newBatchErrorElement(http.StatusBadRequest, "streaming is not supported in batch requests")
+	}
+
+	req := r.Clone(r.Context())
+	req.URL.Path = openai.ChatCompletionsEndpoint
+	req.RequestURI = ""
+	req.Body = io.NopCloser(bytes.NewReader(element))
+	req.ContentLength = int64(len(element))
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept")             // element responses must not be event streams
+	req.Header.Del(idempotencyKeyHeader) // the key identifies the whole batch, not its elements
+
+	rw := &bufferedResponseWriter{header: http.Header{}}
+	handleChatRequest(rw, req)
+
+	statusCode := rw.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	body := rw.body.Bytes()
+	if !json.Valid(body) {
+		s.log.Warn("Batch element returned a non-JSON response", "statusCode", statusCode)
+		return newBatchErrorElement(statusCode, string(body))
+	}
+	return BatchResponseElement{StatusCode: statusCode, Body: body}
+}
+
+func newBatchErrorElement(statusCode int, msg string) BatchResponseElement {
+	body, err := json.Marshal(openai.APIErrorResponse{Error: openai.APIError{Message: msg}})
+	if err != nil {
+		body = fmt.Appendf(nil, "%q", msg)
+	}
+	return BatchResponseElement{StatusCode: statusCode, Body: body}
+}
+
+// bufferedResponseWriter is a [http.ResponseWriter] that keeps the response in memory.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header { return b.header }
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if b.statusCode == 0 {
+		b.statusCode = statusCode
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+	return b.body.Write(p)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatCompletionsBatch(t *testing.T) {
+	newChatRequest := func(prompt string, cacheSalt string) openai.ChatRequest {
+		return openai.ChatRequest{
+			ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b"},
+			Messages:             []openai.Message{{Role: "user", Content: prompt}},
+			CacheSalt:            cacheSalt,
+		}
+	}
+
+	testCases := map[string]struct {
+		batch            any
+		concurrency      int
+		maxBodyBytes     int64
+		idempotencyKey   string
+		wantStatusCode   int
+		wantElementCodes []int
+		wantContents     []string
+	}{
+		"sequential with failing element": {
+			batch: []openai.ChatRequest{
+				newChatRequest("one", ""),
+				newChatRequest("two", "too short"),
+				newChatRequest("three", ""),
+			},
+			concurrency:      1,
+			wantStatusCode:   http.StatusOK,
+			wantElementCodes: []int{http.StatusOK, http.StatusInternalServerError, http.StatusOK},
+			wantContents:     []string{"Echo: one", "", "Echo: three"},
+		},
+		"concurrent with failing element": {
+			batch: []openai.ChatRequest{
+				newChatRequest("one", ""),
+				newChatRequest("two", ""),
+				newChatRequest("three", "too short"),
+			},
+			concurrency:      3,
+			wantStatusCode:   http.StatusOK,
+			wantElementCodes: []int{http.StatusOK, http.StatusOK, http.StatusInternalServerError},
+			wantContents:     []string{"Echo: one", "Echo: two", ""},
+		},
+		"invalid elements": {
+			batch: []any{
+				"not an object",
+				map[string]any{"model": "gpt-oss-120b", "stream": true, "messages": []openai.Message{{Role: "user", Content: "two"}}},
+				newChatRequest("three", ""),
+			},
+			concurrency:      2,
+			wantStatusCode:   http.StatusOK,
+			wantElementCodes: []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusOK},
+			wantContents:     []string{"", "", "Echo: three"},
+		},
+		"idempotency key": {
+			batch: []openai.ChatRequest{
+				newChatRequest("one", ""),
+				newChatRequest("two", ""),
+				newChatRequest("three", ""),
+			},
+			concurrency:      1,
+			idempotencyKey:   "key",
+			wantStatusCode:   http.StatusOK,
+			wantElementCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			wantContents:     []string{"Echo: one", "Echo: two", "Echo: three"},
+		},
+		"batch too large": {
+			batch: []openai.ChatRequest{
+				newChatRequest("one", ""),
+				newChatRequest("two", ""),
+				newChatRequest("three", ""),
+				newChatRequest("four", ""),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"body too large": {
+			batch: []openai.ChatRequest{
+				newChatRequest("one", ""),
+			},
+			maxBodyBytes:   16,
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		"empty batch": {
+			batch:          []openai.ChatRequest{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"not an array": {
+			batch:          newChatRequest("one", ""),
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.maxBatchSize = 3
+			sut.batchConcurrency = tc.concurrency
+			if tc.maxBodyBytes > 0 {
+				sut.maxBatchBodyBytes = tc.maxBodyBytes
+			}
+
+			sut.idempotencyCache = newIdempotencyCache(time.Minute, 10)
+
+			req := prepareJSONRequest(t.Context(), require, ChatCompletionsBatchEndpoint, tc.batch)
+			if tc.idempotencyKey != "" {
+				req.Header.Set(idempotencyKeyHeader, tc.idempotencyKey)
+			}
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			if tc.wantStatusCode != http.StatusOK {
+				return
+			}
+
+			var results []BatchResponseElement
+			require.NoError(json.NewDecoder(resp.Body).Decode(&results))
+			require.Len(results, len(tc.wantElementCodes))
+			for i, result := range results {
+				assert.Equal(tc.wantElementCodes[i], result.StatusCode, string(result.Body))
+				if result.StatusCode != http.StatusOK {
+					var errResp openai.APIErrorResponse
+					require.NoError(json.Unmarshal(result.Body, &errResp))
+					assert.NotEmpty(errResp.Error.Message)
+					continue
+				}
+				var chatResp openai.ChatResponse
+				require.NoError(json.Unmarshal(result.Body, &chatResp))
+				require.Len(chatResp.Choices, 1)
+				assert.Equal(tc.wantContents[i], chatResp.Choices[0].Message.Content)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsBatchDisabled(t *testing.T) {
+	require := require.New(t)
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secretmanager.Secret{}, "192.0.2.1:8080", "", false)
+
+	req := prepareJSONRequest(t.Context(), require, ChatCompletionsBatchEndpoint, []openai.ChatRequest{})
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
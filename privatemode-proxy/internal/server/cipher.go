@@ -5,27 +5,44 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/edgelesssys/continuum/internal/oss/crypto"
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
 	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
 )
 
 // RenewableRequestCipher wraps a RequestCipher and that can be renewed when needed.
+// Once decryption of the response has started, the secret is pinned: the response must be
+// decrypted with the secret the request was encrypted with, even if the secret rotates mid-stream.
 type RenewableRequestCipher struct {
-	sm     secretManager
-	rc     *crypto.RequestCipher
-	secret *secretmanager.Secret
+	sm       SecretManager
+	rc       *crypto.RequestCipher
+	secretID string
+	// macKey is derived from the secret on initialization, as the secret manager zeroes
+	// the secret once it's superseded, even if requests using it are still in flight.
+	macKey    [32]byte
+	macKeyErr error
+	pinned    bool
 }
 
-type secretManager interface {
+// SecretManager provides the secrets used to encrypt requests to the API.
+// Besides [secretmanager.SecretManager], custom implementations can be plugged in to use
+// alternative secret backends, e.g., one backed by a KMS.
+type SecretManager interface {
+	// LatestSecret returns the secret to use for new requests.
+	// It returns an error wrapping [secretmanager.ErrNoAPIKey] if no secret can be obtained yet.
 	LatestSecret(ctx context.Context) (secretmanager.Secret, error)
+	// ForceUpdate replaces the latest secret, e.g., after the API didn't accept it anymore.
 	ForceUpdate(ctx context.Context) error
-	OfferAPIKey(context.Context, string) error
+	// OfferAPIKey offers an API key supplied by a client. Backends not requiring an API key may ignore it.
+	OfferAPIKey(ctx context.Context, apiKey string) error
 }
 
-// NewRenewableRequestCipher creates a new RenewableRequestCipher with the given secretManager.
-func NewRenewableRequestCipher(ctx context.Context, sm secretManager) (*RenewableRequestCipher, error) {
+// NewRenewableRequestCipher creates a new RenewableRequestCipher with the given [SecretManager].
+func NewRenewableRequestCipher(ctx context.Context, sm SecretManager) (*RenewableRequestCipher, error) {
 	c := &RenewableRequestCipher{sm: sm, rc: nil}
 	err := c.init(ctx)
 	if err != nil {
@@ -34,10 +51,52 @@ func NewRenewableRequestCipher(ctx context.Context, sm secretManager) (*Renewabl
 	return c, nil
 }
 
+// Bounds of the exponential backoff while waiting for a secret, see [Opts.SecretWaitTimeout].
+const (
+	secretWaitInitialBackoff = 50 * time.Millisecond
+	secretWaitMaxBackoff     = time.Second
+)
+
+// newRequestCipher creates a [RenewableRequestCipher]. If no secret is available yet, i.e., the secret
+// manager returns [secretmanager.ErrNoAPIKey], it retries with exponential backoff until
+// [Opts.SecretWaitTimeout] has passed. Other errors, e.g., of the secret service, aren't retried,
+// as each attempt would make another call to the secret service.
+func (s *Server) newRequestCipher(ctx context.Context) (*RenewableRequestCipher, error) {
+	rc, err := NewRenewableRequestCipher(ctx, s.sm)
+	if err == nil || s.secretWaitTimeout <= 0 || !errors.Is(err, secretmanager.ErrNoAPIKey) {
+		return rc, err
+	}
+
+	s.requestLog.Debug("No secret available yet, waiting for it", "error", err, "timeout", s.secretWaitTimeout)
+	deadline := time.Now().Add(s.secretWaitTimeout)
+	backoff := secretWaitInitialBackoff
+	for {
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return nil, fmt.Errorf("no secret available after waiting %s: %w", s.secretWaitTimeout, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		rc, err = NewRenewableRequestCipher(ctx, s.sm)
+		if err == nil || !errors.Is(err, secretmanager.ErrNoAPIKey) {
+			return rc, err
+		}
+		backoff = min(2*backoff, secretWaitMaxBackoff)
+	}
+}
+
 // ResetSecret clears the cached RequestCipher, forcing re-initialization on next use.
 func (c *RenewableRequestCipher) ResetSecret(ctx context.Context) error {
+	if c.pinned {
+		return errors.New("can't reset secret after response decryption started")
+	}
 	c.rc = nil
-	c.secret = nil
+	c.secretID = ""
 	err := c.sm.ForceUpdate(ctx)
 	if err != nil {
 		return fmt.Errorf("forcing secret update: %w", err)
@@ -49,16 +108,28 @@ func (c *RenewableRequestCipher) ResetSecret(ctx context.Context) error {
 // Reinitialize re-initializes the cached RequestCipher, only updating the secret if it is no
 // longer valid.
 func (c *RenewableRequestCipher) Reinitialize(ctx context.Context) error {
+	if c.pinned {
+		return errors.New("can't reinitialize secret after response decryption started")
+	}
 	return c.init(ctx)
 }
 
-// GetSecret returns the secret in use by the cached RequestCipher. An error is returned if none
-// has been initialized yet.
-func (c *RenewableRequestCipher) GetSecret() (secretmanager.Secret, error) {
-	if c.secret == nil {
-		return secretmanager.Secret{}, fmt.Errorf("RenewableRequestCipher not initialized")
+// GetSecretID returns the ID of the secret in use by the cached RequestCipher. An error is returned
+// if none has been initialized yet.
+func (c *RenewableRequestCipher) GetSecretID() (string, error) {
+	if c.rc == nil {
+		return "", fmt.Errorf("RenewableRequestCipher not initialized")
+	}
+	return c.secretID, nil
+}
+
+// GetMACKey returns the MAC key derived from the secret in use by the cached RequestCipher.
+// An error wrapping [ocspheader.ErrSecretTooShort] is returned if the secret can't be used as MAC key.
+func (c *RenewableRequestCipher) GetMACKey() ([32]byte, error) {
+	if c.rc == nil {
+		return [32]byte{}, fmt.Errorf("RenewableRequestCipher not initialized")
 	}
-	return *c.secret, nil
+	return c.macKey, c.macKeyErr
 }
 
 func (c *RenewableRequestCipher) init(ctx context.Context) error {
@@ -73,7 +144,8 @@ func (c *RenewableRequestCipher) init(ctx context.Context) error {
 	}
 
 	c.rc = rc
-	c.secret = &secret
+	c.secretID = secret.ID
+	c.macKey, c.macKeyErr = ocspheader.MACKey(secret.Data)
 	return nil
 }
 
@@ -85,10 +157,20 @@ func (c *RenewableRequestCipher) Encrypt(plaintext string) (string, error) {
 	return c.rc.Encrypt(plaintext)
 }
 
-// DecryptResponse decrypts the given ciphertext using the wrapped RequestCipher.
+// DecryptResponse decrypts the given ciphertext using the wrapped RequestCipher and pins its secret.
+// The secret ID included in the ciphertext must match the secret used for the request.
 func (c *RenewableRequestCipher) DecryptResponse(ciphertext string) (string, error) {
 	if c.rc == nil {
 		return "", fmt.Errorf("RenewableRequestCipher not initialized")
 	}
+	c.pinned = true
+
+	id, err := crypto.GetIDFromCipher(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("getting secret ID from response: %w", err)
+	}
+	if id != c.secretID {
+		return "", fmt.Errorf("response encrypted with secret %q, but request used secret %q", id, c.secretID)
+	}
 	return c.rc.DecryptResponse(ciphertext)
 }
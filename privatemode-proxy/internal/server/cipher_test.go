@@ -0,0 +1,198 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/crypto"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewableRequestCipherSecretRotationMidStream(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	oldSecret := secretmanager.Secret{ID: "old", Data: bytes.Repeat([]byte{0x42}, 32)}
+	newSecret := secretmanager.Secret{ID: "new", Data: bytes.Repeat([]byte{0x43}, 32)}
+	sm := &stubSecretManager{secrets: []secretmanager.Secret{oldSecret, newSecret}}
+
+	rc, err := NewRenewableRequestCipher(t.Context(), sm)
+	require.NoError(err)
+	encryptedRequest, err := rc.Encrypt(`"Hello"`)
+	require.NoError(err)
+
+	// The upstream encrypts all chunks with the secret used for the request.
+	upstreamEncrypt, upstreamDecrypt := stub.GetEncryptionFunctions(map[string][]byte{
+		oldSecret.ID: oldSecret.Data,
+		newSecret.ID: newSecret.Data,
+	})
+	_, err = upstreamDecrypt(encryptedRequest)
+	require.NoError(err)
+
+	const numChunks = 5
+	for i := range numChunks {
+		chunk, err := upstreamEncrypt(fmt.Sprintf(`"chunk %d"`, i))
+		require.NoError(err)
+
+		plainText, err := rc.DecryptResponse(chunk)
+		require.NoError(err)
+		assert.Equal(fmt.Sprintf(`"chunk %d"`, i), plainText)
+
+		if i == 1 {
+			// Rotate the secret mid-stream.
+			require.NoError(sm.ForceUpdate(t.Context()))
+			latest, err := sm.LatestSecret(t.Context())
+			require.NoError(err)
+			require.Equal(newSecret.ID, latest.ID)
+
+			assert.Error(rc.Reinitialize(t.Context()))
+			assert.Error(rc.ResetSecret(t.Context()))
+		}
+	}
+
+	secretID, err := rc.GetSecretID()
+	require.NoError(err)
+	assert.Equal(oldSecret.ID, secretID)
+
+	// A chunk encrypted with another secret is rejected.
+	nonce, err := crypto.GetNonceFromCipher(encryptedRequest)
+	require.NoError(err)
+	chunk, err := crypto.EncryptMessage(`"chunk"`, newSecret.Data, newSecret.ID, nonce, numChunks)
+	require.NoError(err)
+	_, err = rc.DecryptResponse(chunk)
+	assert.ErrorContains(err, `response encrypted with secret "new", but request used secret "old"`)
+}
+
+func TestRenewableRequestCipherOutlivesSupersededSecret(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Like the mock backend, hand out the same data on every update.
+	data := bytes.Repeat([]byte{0x42}, 32)
+	sm := secretmanager.New(func(context.Context, string) (string, []byte, error) {
+		return "id", data, nil
+	}, false)
+	require.NoError(sm.OfferAPIKey(t.Context(), "apikey"))
+
+	rc, err := NewRenewableRequestCipher(t.Context(), sm)
+	require.NoError(err)
+
+	// The manager zeroes the superseded secret, but neither the data handed out by
+	// the update function nor the state derived by the cipher.
+	require.NoError(sm.ForceUpdate(t.Context()))
+	assert.Equal(bytes.Repeat([]byte{0x42}, 32), data)
+
+	encryptedRequest, err := rc.Encrypt(`"Hello"`)
+	require.NoError(err)
+	_, upstreamDecrypt := stub.GetEncryptionFunctions(map[string][]byte{"id": bytes.Repeat([]byte{0x42}, 32)})
+	plainText, err := upstreamDecrypt(encryptedRequest)
+	require.NoError(err)
+	assert.Equal(`"Hello"`, plainText)
+
+	macKey, err := rc.GetMACKey()
+	require.NoError(err)
+	assert.Equal([32]byte(bytes.Repeat([]byte{0x42}, 32)), macKey)
+}
+
+func TestNewRequestCipherWaitsForSecret(t *testing.T) {
+	testCases := map[string]struct {
+		waitTimeout    time.Duration
+		availableAfter time.Duration
+		err            error
+		wantErr        bool
+		wantMaxCalls   int
+	}{
+		"secret available": {
+			waitTimeout: time.Second,
+		},
+		"secret available after delay": {
+			waitTimeout:    time.Second,
+			availableAfter: 250 * time.Millisecond,
+		},
+		"secret not available in time": {
+			waitTimeout:    200 * time.Millisecond,
+			availableAfter: time.Hour,
+			wantErr:        true,
+		},
+		"waiting disabled": {
+			availableAfter: 50 * time.Millisecond,
+			wantErr:        true,
+			wantMaxCalls:   1,
+		},
+		"secret service error is not retried": {
+			waitTimeout:    time.Second,
+			availableAfter: time.Hour,
+			err:            errors.New("secret service unavailable"),
+			wantErr:        true,
+			wantMaxCalls:   1,
+		},
+		"backoff limits calls": {
+			waitTimeout:    700 * time.Millisecond,
+			availableAfter: time.Hour,
+			wantErr:        true,
+			// 50ms, 100ms, 200ms, 400ms, capped by the timeout
+			wantMaxCalls: 6,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 32)}
+			sut := newTestServer(nil, secret, "", "", false)
+			sm := &delayedSecretManager{
+				SecretManager: sut.sm,
+				availableAt:   time.Now().Add(tc.availableAfter),
+				err:           cmp.Or(tc.err, error(secretmanager.ErrNoAPIKey)),
+			}
+			sut.sm = sm
+			sut.secretWaitTimeout = tc.waitTimeout
+
+			start := time.Now()
+			rc, err := sut.newRequestCipher(t.Context())
+			if tc.wantErr {
+				assert.Error(err)
+				assert.Less(time.Since(start), tc.waitTimeout+time.Second)
+				if tc.wantMaxCalls > 0 {
+					assert.LessOrEqual(int(sm.calls.Load()), tc.wantMaxCalls)
+				}
+				return
+			}
+			require.NoError(err)
+			gotSecretID, err := rc.GetSecretID()
+			require.NoError(err)
+			assert.Equal(secret.ID, gotSecretID)
+			assert.GreaterOrEqual(time.Since(start), tc.availableAfter)
+		})
+	}
+}
+
+// delayedSecretManager fails with err to return a secret until availableAt.
+type delayedSecretManager struct {
+	SecretManager
+	availableAt time.Time
+	err         error
+	calls       atomic.Int32
+}
+
+func (s *delayedSecretManager) LatestSecret(ctx context.Context) (secretmanager.Secret, error) {
+	s.calls.Add(1)
+	if time.Now().Before(s.availableAt) {
+		return secretmanager.Secret{}, fmt.Errorf("get secret: %w", s.err)
+	}
+	return s.SecretManager.LatestSecret(ctx)
+}
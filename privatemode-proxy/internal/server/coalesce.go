@@ -0,0 +1,145 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/persist"
+	"github.com/tidwall/gjson"
+)
+
+// coalesce serves r with next, sharing a single call of next between identical concurrent
+// non-streaming requests. The key is derived from the plaintext request as received from the
+// client, because encryption and random cache salts make every upstream request unique.
+//
+// If the proxy sends its own API key, the Authorization header of the client doesn't identify it.
+// Only requests with a cache salt, which is part of the key, are coalesced then, so that
+// responses are only shared between clients using the same cache salt.
+//
+// The shared call is detached from the cancellation of the request that started it, so that
+// the other waiting requests aren't affected if that client disconnects. The other callers are
+// counted against the model quota and audited with their own request ID once the shared
+// response is available.
+func (s *Server) coalesce(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusInternalServerError, "reading request body: %s", err)
+		return
+	}
+	if !isUnaryJSONRequest(r, body) || (s.apiKey != nil && gjson.GetBytes(body, "cache_salt").String() == "") {
+		next(w, r)
+		return
+	}
+
+	start := time.Now()
+	leader := false
+	v, _, shared := s.requestGroup.Do(s.coalesceKey(r, body), func() (any, error) {
+		leader = true
+		call := &coalescedCall{rw: &bufferedResponseWriter{header: http.Header{}}}
+		ctx := context.WithValue(context.WithoutCancel(r.Context()), coalescedCallKey{}, call)
+		next(call.rw, r.WithContext(ctx))
+		return call, nil
+	})
+	call := v.(*coalescedCall)
+	if shared {
+		s.log.Debug("Coalesced identical request", "path", r.URL.Path)
+	}
+	if !leader {
+		w = s.followCoalescedCall(w, r, call, start)
+		if w == nil {
+			return
+		}
+	}
+
+	for k, vs := range call.rw.header {
+		w.Header()[k] = slices.Clone(vs)
+	}
+	w.WriteHeader(cmp.Or(call.rw.statusCode, http.StatusOK))
+	_, _ = w.Write(call.rw.body.Bytes())
+}
+
+// coalescedCall is the result of a call shared between coalesced requests.
+// Its fields are set by the request that made the call.
+type coalescedCall struct {
+	rw *bufferedResponseWriter
+	// model is the target model of the upstream request.
+	model string
+	// usage holds the token usage of the response if audit records are written.
+	usage AuditRecord
+}
+
+// coalescedCallKey is the context key of the [*coalescedCall] of the request making a shared call.
+type coalescedCallKey struct{}
+
+// coalescedCallFrom returns the [*coalescedCall] to report to, or nil if the request of ctx isn't coalesced.
+func coalescedCallFrom(ctx context.Context) *coalescedCall {
+	call, _ := ctx.Value(coalescedCallKey{}).(*coalescedCall)
+	return call
+}
+
+// followCoalescedCall does the per-request work for a request that received the response of call,
+// which was made by another request. It returns the writer for the response, or nil if r was
+// rejected for exceeding the model quota.
+func (s *Server) followCoalescedCall(w http.ResponseWriter, r *http.Request, call *coalescedCall, start time.Time) http.ResponseWriter {
+	if s.auditSink != nil {
+		audit := &requestAudit{record: call.usage}
+		audit.record.Timestamp = start
+		audit.record.RequestID = s.requestIDFor(r)
+		audit.record.Endpoint = r.URL.Path
+		audit.record.Model = call.model
+		recorder := &statusRecorder{ResponseWriter: w}
+		w = recorder
+		defer s.writeAuditRecord(recorder, audit)
+	}
+	if err := s.checkModelQuota(call.model); err != nil {
+		forwarder.HTTPError(w, r, http.StatusTooManyRequests, "%s", err)
+		return nil
+	}
+	return w
+}
+
+// isUnaryJSONRequest reports whether r is a JSON request expecting a non-streaming response.
+// Only the responses of such requests can be buffered and shared.
+func isUnaryJSONRequest(r *http.Request, body []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json" &&
+		!gjson.GetBytes(body, "stream").Bool() &&
+		!strings.Contains(r.Header.Get("Accept"), "event-stream")
+}
+
+// coalesceKey identifies requests that can share a response.
+// It covers all client headers forwarded to the API, see [Server.keepsClientHeader], so that
+// responses are never shared between different API keys, accepted formats, or forwarded headers.
+func (s *Server) coalesceKey(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.RequestURI()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	keys := slices.Sorted(maps.Keys(r.Header))
+	for _, key := range keys {
+		if !s.keepsClientHeader(key) {
+			continue
+		}
+		h.Write([]byte(http.CanonicalHeaderKey(key)))
+		for _, value := range r.Header[key] {
+			h.Write([]byte{0})
+			h.Write([]byte(value))
+		}
+		h.Write([]byte{0, 0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,251 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesceRequests(t *testing.T) {
+	const numRequests = 5
+
+	const cacheSalt = "0123456789abcdef0123456789abcdef"
+
+	chatRequest := func(prompt string, stream bool) openai.ChatRequest {
+		return openai.ChatRequest{
+			ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b", Stream: stream},
+			Messages:             []openai.Message{{Role: "user", Content: prompt}},
+			CacheSalt:            cacheSalt,
+		}
+	}
+
+	testCases := map[string]struct {
+		coalesce  bool
+		request   func(i int) openai.ChatRequest
+		wantCalls int32
+	}{
+		"identical requests": {
+			coalesce:  true,
+			request:   func(int) openai.ChatRequest { return chatRequest("Hello", false) },
+			wantCalls: 1,
+		},
+		"different requests": {
+			coalesce:  true,
+			request:   func(i int) openai.ChatRequest { return chatRequest(fmt.Sprintf("Hello %d", i), false) },
+			wantCalls: numRequests,
+		},
+		"streaming requests": {
+			coalesce:  true,
+			request:   func(int) openai.ChatRequest { return chatRequest("Hello", true) },
+			wantCalls: numRequests,
+		},
+		"identical requests without cache salt": {
+			coalesce: true,
+			request: func(int) openai.ChatRequest {
+				req := chatRequest("Hello", false)
+				req.CacheSalt = ""
+				return req
+			},
+			wantCalls: numRequests,
+		},
+		"coalescing disabled": {
+			request:   func(int) openai.ChatRequest { return chatRequest("Hello", false) },
+			wantCalls: numRequests,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var calls atomic.Int32
+			release := make(chan struct{})
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				<-release
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.coalesceRequests = tc.coalesce
+			handler := sut.GetHandler()
+
+			responses := make([]*httptest.ResponseRecorder, numRequests)
+			var wg sync.WaitGroup
+			for i := range numRequests {
+				req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, tc.request(i))
+				responses[i] = httptest.NewRecorder()
+				wg.Go(func() {
+					handler.ServeHTTP(responses[i], req)
+				})
+			}
+
+			require.Eventually(func() bool { return calls.Load() == tc.wantCalls }, 5*time.Second, time.Millisecond)
+			// give the remaining requests time to join the in-flight request before it completes
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			assert.Equal(tc.wantCalls, calls.Load())
+			for i, resp := range responses {
+				require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+				var chatResp openai.ChatResponse
+				require.NoError(json.Unmarshal(resp.Body.Bytes(), &chatResp))
+				require.Len(chatResp.Choices, 1)
+				assert.Equal(fmt.Sprintf("Echo: %s", tc.request(i).Messages[0].Content), chatResp.Choices[0].Message.Content)
+			}
+		})
+	}
+}
+
+func TestCoalesceRequestsPerCaller(t *testing.T) {
+	const numRequests = 5
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	echo := stub.EchoHandler(secret.Map(), slog.Default())
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		echo.ServeHTTP(w, r)
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.coalesceRequests = true
+	sink := &stubAuditSink{}
+	sut.auditSink = sink
+	sut.modelQuotas = newModelQuotas(map[string]int{"gpt-oss-120b": 3})
+	handler := sut.GetHandler()
+
+	request := openai.ChatRequest{
+		ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b"},
+		Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+		CacheSalt:            "0123456789abcdef0123456789abcdef",
+	}
+	responses := make([]*httptest.ResponseRecorder, numRequests)
+	var wg sync.WaitGroup
+	for i := range numRequests {
+		req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, request)
+		responses[i] = httptest.NewRecorder()
+		wg.Go(func() {
+			handler.ServeHTTP(responses[i], req)
+		})
+	}
+
+	require.Eventually(func() bool { return calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	// give the remaining requests time to join the in-flight request before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(1, calls.Load())
+	statusCodes := map[int]int{}
+	for _, resp := range responses {
+		statusCodes[resp.Code]++
+	}
+	assert.Equal(map[int]int{http.StatusOK: 3, http.StatusTooManyRequests: 2}, statusCodes, "only the quota of 3 requests is served")
+
+	require.Eventually(func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return len(sink.records) == numRequests
+	}, 5*time.Second, time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	requestIDs := map[string]struct{}{}
+	recordedStatusCodes := map[int]int{}
+	for _, record := range sink.records {
+		requestIDs[record.RequestID] = struct{}{}
+		recordedStatusCodes[record.StatusCode]++
+		assert.Equal("gpt-oss-120b", record.Model)
+	}
+	assert.Len(requestIDs, numRequests, "every caller must be audited with its own request ID")
+	assert.Equal(statusCodes, recordedStatusCodes)
+}
+
+func TestCoalesceKey(t *testing.T) {
+	newRequest := func(path string, header http.Header, body string) (*http.Request, []byte) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer a")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return req, []byte(body)
+	}
+
+	sut := &Server{}
+	base := sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, nil, `{"a":1}`))
+	assert.Equal(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, nil, `{"a":1}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"Authorization": {"Bearer b"}}, `{"a":1}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, nil, `{"a":2}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.LegacyCompletionsEndpoint, nil, `{"a":1}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"Accept": {"application/x-ndjson"}}, `{"a":1}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"X-Tenant": {"a"}}, `{"a":1}`)))
+
+	// Only headers forwarded to the API are part of the key.
+	sut = &Server{forwardHeaders: []string{"X-Tenant"}}
+	base = sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"X-Tenant": {"a"}}, `{"a":1}`))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"X-Tenant": {"b"}}, `{"a":1}`)))
+	assert.NotEqual(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"X-Tenant": {"a"}, "Accept": {"application/x-ndjson"}}, `{"a":1}`)))
+	assert.Equal(t, base, sut.coalesceKey(newRequest(openai.ChatCompletionsEndpoint, http.Header{"X-Tenant": {"a"}, "User-Agent": {"b"}}, `{"a":1}`)))
+}
+
+func TestIsUnaryJSONRequest(t *testing.T) {
+	testCases := map[string]struct {
+		contentType string
+		accept      string
+		body        string
+		want        bool
+	}{
+		"json":                  {contentType: "application/json", body: `{}`, want: true},
+		"json with parameters":  {contentType: "Application/JSON; charset=utf-8", body: `{}`, want: true},
+		"json suffix":           {contentType: "application/jsonl", body: `{}`},
+		"form":                  {contentType: "multipart/form-data; boundary=x", body: `{}`},
+		"stream":                {contentType: "application/json", body: `{"stream":true}`},
+		"event stream accepted": {contentType: "application/json", accept: "text/event-stream", body: `{}`},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, openai.ChatCompletionsEndpoint, nil)
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			assert.Equal(t, tc.want, isUnaryJSONRequest(req, []byte(tc.body)))
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// corsAllowedMethods are the methods allowed for cross-origin requests. The proxy only serves GET and POST endpoints.
+	corsAllowedMethods = "GET, POST"
+	// corsMaxAge is how long browsers may cache the result of a preflight request.
+	corsMaxAge = 10 * time.Minute
+)
+
+// ParseCORSAllowedOrigins parses the origins that are allowed to make cross-origin requests to the proxy.
+// An origin has the form scheme://host[:port], e.g. 'https://app.example.com'. '*' allows all origins.
+func ParseCORSAllowedOrigins(origins []string) ([]string, error) {
+	var allowed []string
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			allowed = append(allowed, origin)
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid CORS origin %q: expected scheme://host[:port]", origin)
+		}
+		allowed = append(allowed, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return allowed, nil
+}
+
+// corsMiddleware allows cross-origin requests from the origins in [Opts.CORSAllowedOrigins].
+// Preflight requests are answered by the middleware and aren't passed to next.
+// Preflight requests from other origins are rejected with status 403.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		isPreflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+		w.Header().Add("Vary", "Origin")
+
+		if origin == "" || !s.corsOriginAllowed(origin) {
+			if isPreflight {
+				http.Error(w, fmt.Sprintf("origin %s is not allowed", origin), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if !isPreflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		if requestedHeaders := r.Header.Get("Access-Control-Request-Headers"); requestedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Server) corsOriginAllowed(origin string) bool {
+	return slices.Contains(s.corsAllowedOrigins, "*") || slices.Contains(s.corsAllowedOrigins, strings.ToLower(origin))
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCORSAllowedOrigins(t *testing.T) {
+	testCases := map[string]struct {
+		origins     []string
+		wantOrigins []string
+		wantErr     bool
+	}{
+		"empty": {},
+		"origins": {
+			origins:     []string{"https://App.example.com", " http://localhost:3000/ ", "*"},
+			wantOrigins: []string{"https://app.example.com", "http://localhost:3000", "*"},
+		},
+		"missing scheme": {
+			origins: []string{"app.example.com"},
+			wantErr: true,
+		},
+		"path": {
+			origins: []string{"https://app.example.com/chat"},
+			wantErr: true,
+		},
+		"query": {
+			origins: []string{"https://app.example.com?x=1"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			origins, err := ParseCORSAllowedOrigins(tc.origins)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantOrigins, origins)
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	testCases := map[string]struct {
+		allowedOrigins []string
+		origin         string
+		wantStatus     int
+		wantAllowed    bool
+	}{
+		"allowed origin": {
+			allowedOrigins: []string{"https://app.example.com"},
+			origin:         "https://app.example.com",
+			wantStatus:     http.StatusNoContent,
+			wantAllowed:    true,
+		},
+		"any origin": {
+			allowedOrigins: []string{"*"},
+			origin:         "https://app.example.com",
+			wantStatus:     http.StatusNoContent,
+			wantAllowed:    true,
+		},
+		"disallowed origin": {
+			allowedOrigins: []string{"https://app.example.com"},
+			origin:         "https://evil.example.com",
+			wantStatus:     http.StatusForbidden,
+		},
+		"CORS not configured": {
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			sut := newTestServer(nil, secretmanager.Secret{}, "", "", false)
+			sut.corsAllowedOrigins = tc.allowedOrigins
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, openai.ChatCompletionsEndpoint, nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			assert.Equal(tc.wantStatus, resp.Code)
+			if !tc.wantAllowed {
+				assert.Empty(resp.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(resp.Header().Get("Access-Control-Allow-Methods"))
+				return
+			}
+			assert.Equal(tc.origin, resp.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(corsAllowedMethods, resp.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal("authorization, content-type", resp.Header().Get("Access-Control-Allow-Headers"))
+		})
+	}
+}
+
+func TestCORSAllowOriginOnRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	sut := newTestServer(nil, secretmanager.Secret{}, "", "", false)
+	sut.corsAllowedOrigins = []string{"https://app.example.com"}
+
+	send := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, AttestationEndpoint, nil)
+		req.Header.Set("Origin", origin)
+		resp := httptest.NewRecorder()
+		sut.GetHandler().ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := send("https://app.example.com")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = send("https://evil.example.com")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Empty(resp.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// protectedHeaders can't be set with [ParseExtraHeaders], since they are set by the proxy
+// or the HTTP client. Headers with the Privatemode- prefix are protected, too.
+var protectedHeaders = []string{
+	"Authorization",
+	"Connection",
+	"Content-Length",
+	"Content-Type",
+	"Host",
+	"Transfer-Encoding",
+}
+
+// ParseExtraHeaders parses extra headers in the form key=value that are sent with every request to the API.
+// Multiple entries with the same key add multiple values. Headers set by the proxy, such as Authorization
+// and the OCSP policy headers, can't be overridden.
+func ParseExtraHeaders(entries []string) (http.Header, error) {
+	header := http.Header{}
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid extra header %q: expected key=value", entry)
+		}
+		if !validHeaderName(key) {
+			return nil, fmt.Errorf("invalid extra header name %q", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid value for extra header %q: must not contain line breaks", key)
+		}
+		if isProtectedHeader(key) {
+			return nil, fmt.Errorf("extra header %q is set by the proxy and can't be overridden", key)
+		}
+		header.Add(key, value)
+	}
+	return header, nil
+}
+
+// alwaysForwardedHeaders are forwarded even if they aren't listed in [Opts.ForwardHeaders],
+// since they are needed to process the request or are managed by the forwarder.
+var alwaysForwardedHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Forwarded",
+	"X-Forwarded-For",
+}
+
+// ParseForwardHeaders parses the names of the client headers that are forwarded to the API.
+// Headers set by the proxy can't be listed, since they are always handled by the proxy.
+func ParseForwardHeaders(names []string) ([]string, error) {
+	var headers []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !validHeaderName(name) || name == "" {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if isProtectedHeader(name) {
+			return nil, fmt.Errorf("header %q is set by the proxy and can't be forwarded from the client", name)
+		}
+		headers = append(headers, http.CanonicalHeaderKey(name))
+	}
+	return headers, nil
+}
+
+// filterClientHeaders removes the client headers that aren't listed in [Opts.ForwardHeaders] from r.
+// Headers set by the proxy, including the extra headers, are kept. If no headers are listed, r is unchanged.
+func (s *Server) filterClientHeaders(r *http.Request) error {
+	if len(s.forwardHeaders) == 0 {
+		return nil
+	}
+	for key := range r.Header {
+		if !s.keepsClientHeader(key) {
+			delete(r.Header, key)
+		}
+	}
+	return nil
+}
+
+// keepsClientHeader reports whether the client header key is kept by [Server.filterClientHeaders].
+func (s *Server) keepsClientHeader(key string) bool {
+	if len(s.forwardHeaders) == 0 {
+		return true
+	}
+	canonicalKey := http.CanonicalHeaderKey(key)
+	_, isExtraHeader := s.extraHeaders[canonicalKey]
+	return isExtraHeader ||
+		isProtectedHeader(canonicalKey) ||
+		slices.Contains(alwaysForwardedHeaders, canonicalKey) ||
+		slices.Contains(s.forwardHeaders, canonicalKey)
+}
+
+func isProtectedHeader(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	return slices.Contains(protectedHeaders, key) || strings.HasPrefix(key, "Privatemode-")
+}
+
+// validHeaderName reports whether name is a valid token as defined by RFC 9110.
+func validHeaderName(name string) bool {
+	return !strings.ContainsFunc(name, func(r rune) bool {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return false
+		default:
+			return !strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtraHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		entries    []string
+		wantHeader http.Header
+		wantErr    bool
+	}{
+		"no entries": {
+			wantHeader: http.Header{},
+		},
+		"single header": {
+			entries:    []string{"X-Deployment=eu-1"},
+			wantHeader: http.Header{"X-Deployment": {"eu-1"}},
+		},
+		"repeated header": {
+			entries:    []string{"x-deployment=eu-1", "X-Deployment=eu-2"},
+			wantHeader: http.Header{"X-Deployment": {"eu-1", "eu-2"}},
+		},
+		"value with equals sign and empty value": {
+			entries:    []string{"X-Token=a=b", "X-Empty="},
+			wantHeader: http.Header{"X-Token": {"a=b"}, "X-Empty": {""}},
+		},
+		"missing value": {
+			entries: []string{"X-Deployment"},
+			wantErr: true,
+		},
+		"missing key": {
+			entries: []string{"=eu-1"},
+			wantErr: true,
+		},
+		"invalid key": {
+			entries: []string{"X Deployment=eu-1"},
+			wantErr: true,
+		},
+		"line break in value": {
+			entries: []string{"X-Deployment=eu-1\r\nX-Other: value"},
+			wantErr: true,
+		},
+		"authorization": {
+			entries: []string{"authorization=Bearer key"},
+			wantErr: true,
+		},
+		"host": {
+			entries: []string{"Host=example.com"},
+			wantErr: true,
+		},
+		"OCSP policy": {
+			entries: []string{constants.PrivatemodeNvidiaOCSPPolicyHeader + "=good"},
+			wantErr: true,
+		},
+		"privatemode header": {
+			entries: []string{"privatemode-shard-key=abc"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			header, err := ParseExtraHeaders(tc.entries)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantHeader, header)
+		})
+	}
+}
+
+func TestExtraHeaders(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	var forwarded http.Header
+	echo := stub.EchoHandler(secret.Map(), slog.Default())
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+		echo.ServeHTTP(w, r)
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	// Protected headers are rejected by ParseExtraHeaders. Set them anyway to ensure the proxy's values take precedence.
+	sut.extraHeaders = http.Header{
+		"X-Deployment":  {"eu-1", "eu-2"},
+		"Authorization": {"Bearer other"},
+		constants.PrivatemodeNvidiaOCSPPolicyHeader: {"other"},
+		constants.PrivatemodeSecretIDHeader:         {"other"},
+	}
+
+	req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	require.NotNil(forwarded)
+	assert.Equal([]string{"eu-1", "eu-2"}, forwarded.Values("X-Deployment"))
+	assert.Equal("Bearer "+testAPIKey, forwarded.Get("Authorization"))
+	assert.Equal(secret.ID, forwarded.Get(constants.PrivatemodeSecretIDHeader))
+	assert.NotEqual("other", forwarded.Get(constants.PrivatemodeNvidiaOCSPPolicyHeader))
+	assert.NotEmpty(forwarded.Get(constants.PrivatemodeNvidiaOCSPPolicyHeader))
+}
+
+func TestParseForwardHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		names       []string
+		wantHeaders []string
+		wantErr     bool
+	}{
+		"no names": {},
+		"canonicalized names": {
+			names:       []string{"x-tenant-id", " X-Team "},
+			wantHeaders: []string{"X-Tenant-Id", "X-Team"},
+		},
+		"empty name": {
+			names:   []string{""},
+			wantErr: true,
+		},
+		"invalid name": {
+			names:   []string{"X Tenant"},
+			wantErr: true,
+		},
+		"authorization": {
+			names:   []string{"Authorization"},
+			wantErr: true,
+		},
+		"privatemode header": {
+			names:   []string{"privatemode-shard-key"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			headers, err := ParseForwardHeaders(tc.names)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantHeaders, headers)
+		})
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		forwardHeaders []string
+		wantOther      string
+	}{
+		"allowlist": {
+			forwardHeaders: []string{"X-Tenant-Id"},
+		},
+		"no allowlist forwards all headers": {
+			wantOther: "other",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var forwarded http.Header
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header.Clone()
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.extraHeaders = http.Header{"X-Deployment": {"eu-1"}}
+			sut.forwardHeaders = tc.forwardHeaders
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			req.Header.Set("X-Tenant-ID", "tenant-1")
+			req.Header.Set("X-Other", "other")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+			require.NotNil(forwarded)
+			assert.Equal("tenant-1", forwarded.Get("X-Tenant-ID"))
+			assert.Equal(tc.wantOther, forwarded.Get("X-Other"))
+			assert.Equal("eu-1", forwarded.Get("X-Deployment"))
+			assert.Equal("Bearer "+testAPIKey, forwarded.Get("Authorization"))
+			assert.Equal("application/json", forwarded.Get("Content-Type"))
+			assert.Equal(secret.ID, forwarded.Get(constants.PrivatemodeSecretIDHeader))
+		})
+	}
+}
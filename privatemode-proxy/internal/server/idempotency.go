@@ -0,0 +1,156 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/persist"
+)
+
+const (
+	// idempotencyKeyHeader is set by clients to mark retries of the same request.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses that are replayed from the idempotency cache.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotent serves r with next and caches successful responses by the Idempotency-Key header of r.
+// Retries with the same key receive the cached response instead of calling next again.
+// Retries arriving while the first request is still in flight share its call of next.
+// The key is only valid for the same request body.
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusInternalServerError, "reading request body: %s", err)
+		return
+	}
+	if !isUnaryJSONRequest(r, body) {
+		next(w, r)
+		return
+	}
+
+	key := idempotencyKey(r)
+	bodyHash := sha256.Sum256(body)
+
+	rw, replayed := s.idempotencyCache.get(key, bodyHash)
+	if rw == nil && replayed {
+		forwarder.HTTPError(w, r, http.StatusUnprocessableEntity, "%s was already used for a different request", idempotencyKeyHeader)
+		return
+	}
+	if rw == nil {
+		v, _, _ := s.requestGroup.Do(key+hex.EncodeToString(bodyHash[:]), func() (any, error) {
+			rw := &bufferedResponseWriter{header: http.Header{}}
+			next(rw, r.WithContext(context.WithoutCancel(r.Context())))
+			// Only cache successful responses, so failed requests can be retried.
+			if status := cmp.Or(rw.statusCode, http.StatusOK); status >= 200 && status < 300 {
+				s.idempotencyCache.add(key, bodyHash, rw)
+			}
+			return rw, nil
+		})
+		rw = v.(*bufferedResponseWriter)
+	}
+	if replayed {
+		s.log.Debug("Replaying response for idempotent request", "path", r.URL.Path)
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
+
+	for k, vs := range rw.header {
+		w.Header()[k] = slices.Clone(vs)
+	}
+	w.WriteHeader(cmp.Or(rw.statusCode, http.StatusOK))
+	_, _ = w.Write(rw.body.Bytes())
+}
+
+// idempotencyKey identifies the cache entry of r.
+// Authorization is included so that responses are never shared between different API keys.
+func idempotencyKey(r *http.Request) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get(idempotencyKeyHeader)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyCache is a bounded LRU cache of responses to idempotent requests.
+// Entries expire after the configured window.
+type idempotencyCache struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // of *idempotencyEntry, most recently used first
+	now        func() time.Time
+}
+
+type idempotencyEntry struct {
+	key      string
+	bodyHash [sha256.Size]byte
+	resp     *bufferedResponseWriter
+	expires  time.Time
+}
+
+func newIdempotencyCache(window time.Duration, maxEntries int) *idempotencyCache {
+	return &idempotencyCache{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// get returns the cached response for key. found reports whether a valid entry exists for key,
+// and resp is nil if the entry was created for a different request body.
+func (c *idempotencyCache) get(key string, bodyHash [sha256.Size]byte) (resp *bufferedResponseWriter, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*idempotencyEntry)
+	if c.now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	if entry.bodyHash != bodyHash {
+		return nil, true
+	}
+	return entry.resp, true
+}
+
+// add caches resp for key and evicts the least recently used entries if the cache is full.
+func (c *idempotencyCache) add(key string, bodyHash [sha256.Size]byte, resp *bufferedResponseWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&idempotencyEntry{
+		key:      key,
+		bodyHash: bodyHash,
+		resp:     resp,
+		expires:  c.now().Add(c.window),
+	})
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*idempotencyEntry).key)
+	}
+}
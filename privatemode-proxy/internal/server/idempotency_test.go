@@ -0,0 +1,160 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	chatRequest := func(prompt string, stream bool) openai.ChatRequest {
+		return openai.ChatRequest{
+			ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b", Stream: stream},
+			Messages:             []openai.Message{{Role: "user", Content: prompt}},
+		}
+	}
+
+	testCases := map[string]struct {
+		keys            [2]string
+		requests        [2]openai.ChatRequest
+		failFirstCall   bool
+		wantCalls       int32
+		wantStatusCodes [2]int
+		wantReplayed    bool
+	}{
+		"same key twice": {
+			keys:            [2]string{"key", "key"},
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", false), chatRequest("Hello", false)},
+			wantCalls:       1,
+			wantStatusCodes: [2]int{http.StatusOK, http.StatusOK},
+			wantReplayed:    true,
+		},
+		"different keys": {
+			keys:            [2]string{"key1", "key2"},
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", false), chatRequest("Hello", false)},
+			wantCalls:       2,
+			wantStatusCodes: [2]int{http.StatusOK, http.StatusOK},
+		},
+		"no key": {
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", false), chatRequest("Hello", false)},
+			wantCalls:       2,
+			wantStatusCodes: [2]int{http.StatusOK, http.StatusOK},
+		},
+		"same key with different body": {
+			keys:            [2]string{"key", "key"},
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", false), chatRequest("Bye", false)},
+			wantCalls:       1,
+			wantStatusCodes: [2]int{http.StatusOK, http.StatusUnprocessableEntity},
+		},
+		"failed response is not cached": {
+			keys:            [2]string{"key", "key"},
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", false), chatRequest("Hello", false)},
+			failFirstCall:   true,
+			wantCalls:       2,
+			wantStatusCodes: [2]int{http.StatusServiceUnavailable, http.StatusOK},
+		},
+		"streaming requests": {
+			keys:            [2]string{"key", "key"},
+			requests:        [2]openai.ChatRequest{chatRequest("Hello", true), chatRequest("Hello", true)},
+			wantCalls:       2,
+			wantStatusCodes: [2]int{http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var calls atomic.Int32
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 && tc.failFirstCall {
+					forwarder.HTTPError(w, r, http.StatusServiceUnavailable, "unavailable")
+					return
+				}
+				assert.Equal(tc.keys[calls.Load()-1], r.Header.Get(idempotencyKeyHeader))
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.idempotencyCache = newIdempotencyCache(time.Minute, 10)
+			handler := sut.GetHandler()
+
+			var responses [2]*httptest.ResponseRecorder
+			for i := range responses {
+				req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, tc.requests[i])
+				if tc.keys[i] != "" {
+					req.Header.Set(idempotencyKeyHeader, tc.keys[i])
+				}
+				responses[i] = httptest.NewRecorder()
+				handler.ServeHTTP(responses[i], req)
+				require.Equal(tc.wantStatusCodes[i], responses[i].Code, responses[i].Body.String())
+			}
+
+			assert.Equal(tc.wantCalls, calls.Load())
+			assert.Empty(responses[0].Header().Get(idempotentReplayedHeader))
+			if tc.wantReplayed {
+				assert.Equal("true", responses[1].Header().Get(idempotentReplayedHeader))
+				assert.Equal(responses[0].Body.String(), responses[1].Body.String())
+				var chatResp openai.ChatResponse
+				require.NoError(json.Unmarshal(responses[1].Body.Bytes(), &chatResp))
+				require.Len(chatResp.Choices, 1)
+				assert.Equal("Echo: Hello", chatResp.Choices[0].Message.Content)
+			} else {
+				assert.Empty(responses[1].Header().Get(idempotentReplayedHeader))
+			}
+		})
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	cache := newIdempotencyCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+	hash := sha256.Sum256([]byte("body"))
+	resp := &bufferedResponseWriter{header: http.Header{}}
+
+	cache.add("a", hash, resp)
+	cache.add("b", hash, resp)
+	_, found := cache.get("a", hash) // a is now the most recently used entry
+	assert.True(found)
+	cache.add("c", hash, resp)
+
+	_, found = cache.get("b", hash)
+	assert.False(found, "least recently used entry must be evicted")
+	got, found := cache.get("a", hash)
+	assert.True(found)
+	assert.Same(resp, got)
+	got, found = cache.get("a", sha256.Sum256([]byte("other")))
+	assert.True(found)
+	assert.Nil(got, "entry must not be returned for a different body")
+
+	now = now.Add(2 * time.Minute)
+	_, found = cache.get("c", hash)
+	assert.False(found, "expired entry must not be returned")
+}
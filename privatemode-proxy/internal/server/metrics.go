@@ -0,0 +1,76 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ocspComponentPolicy is the component label of rejections because the OCSP policy
+// of the proxy is weaker than the minimum policy of the API.
+const ocspComponentPolicy = "policy"
+
+var ocspRejectionsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "privatemode_proxy_ocsp_rejections_total",
+	Help: "Number of requests rejected by the API because of the NVIDIA OCSP policy, by component (gpu, driver, vbios, policy)",
+}, []string{"component"})
+
+var activeStreamsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "privatemode_proxy_active_streams",
+	Help: "Number of streaming (SSE) responses currently being sent to clients",
+})
+
+var retriesMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "privatemode_proxy_retries_total",
+	Help: "Number of retried requests to the API, by reason (status code class, e.g. 5xx, or connection_error)",
+}, []string{"reason"})
+
+var auditRecordsDroppedMetric = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "privatemode_proxy_audit_records_dropped_total",
+	Help: "Number of audit records dropped because the audit sink couldn't keep up",
+})
+
+var secretAgeMetric = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "privatemode_proxy_secret_age_seconds",
+	Help: "Time since the current secret was obtained from the secret service",
+})
+
+// RecordSecretAge sets the secret age metric. It can be passed to [secretmanager.SecretManager.SetSecretAgeReporter].
+func RecordSecretAge(age time.Duration) {
+	secretAgeMetric.Set(age.Seconds())
+}
+
+// recordOCSPRejection increments [ocspRejectionsMetric] if errMsg is the error body of an OCSP rejection by the API.
+func recordOCSPRejection(errMsg string) {
+	if component, ok := ocspRejectionComponent(errMsg); ok {
+		ocspRejectionsMetric.WithLabelValues(component).Inc()
+	}
+}
+
+// ocspRejectionComponent returns the component whose OCSP status caused the rejection described by errMsg.
+func ocspRejectionComponent(errMsg string) (string, bool) {
+	if strings.Contains(errMsg, constants.ErrorOCSPPolicyTooWeak) {
+		return ocspComponentPolicy, true
+	}
+	before, _, found := strings.Cut(errMsg, " "+constants.ErrorOCSPStatusNotAccepted)
+	if !found {
+		return "", false
+	}
+	// The component is the last word before the message, e.g., "GPU attestation returned a driver OCSP status ...".
+	fields := strings.Fields(before)
+	if len(fields) == 0 {
+		return "", false
+	}
+	switch component := strings.ToLower(fields[len(fields)-1]); component {
+	case "gpu", "driver", "vbios":
+		return component, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCSPRejectionMetric(t *testing.T) {
+	components := []string{"gpu", "driver", "vbios", ocspComponentPolicy}
+
+	testCases := map[string]struct {
+		errMsg        string
+		wantComponent string
+	}{
+		"driver": {
+			errMsg:        "GPU attestation returned a driver " + constants.ErrorOCSPStatusNotAccepted + ": revoked",
+			wantComponent: "driver",
+		},
+		"GPU": {
+			errMsg:        "GPU attestation returned a GPU " + constants.ErrorOCSPStatusNotAccepted + ": unknown",
+			wantComponent: "gpu",
+		},
+		"VBIOS": {
+			errMsg:        "GPU attestation returned a VBIOS " + constants.ErrorOCSPStatusNotAccepted + ": revoked",
+			wantComponent: "vbios",
+		},
+		"policy too weak": {
+			errMsg:        constants.ErrorOCSPPolicyTooWeak + ": allow-revoked is not allowed",
+			wantComponent: ocspComponentPolicy,
+		},
+		"unrelated error": {
+			errMsg: "internal error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarder.HTTPError(w, r, http.StatusInternalServerError, "%s", tc.errMsg)
+			}))
+			defer stubBackend.Close()
+
+			before := map[string]float64{}
+			for _, component := range components {
+				before[component] = ocspRejections(t, component)
+			}
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusInternalServerError, resp.Code)
+
+			for _, component := range components {
+				var want float64
+				if component == tc.wantComponent {
+					want = 1
+				}
+				got := ocspRejections(t, component) - before[component]
+				assert.Equal(want, got, component)
+			}
+		})
+	}
+}
+
+func ocspRejections(t *testing.T, component string) float64 {
+	var metric dto.Metric
+	require.NoError(t, ocspRejectionsMetric.WithLabelValues(component).Write(&metric))
+	return metric.GetCounter().GetValue()
+}
+
+func TestRecordSecretAge(t *testing.T) {
+	RecordSecretAge(90 * time.Second)
+	var metric dto.Metric
+	require.NoError(t, secretAgeMetric.Write(&metric))
+	assert.InDelta(t, 90, metric.GetGauge().GetValue(), 0)
+}
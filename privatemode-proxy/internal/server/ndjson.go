@@ -0,0 +1,161 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/tidwall/gjson"
+)
+
+// ndjsonContentType is the media type clients accept to receive embeddings as JSON Lines.
+const ndjsonContentType = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the Accept header of r lists [ndjsonContentType].
+func acceptsNDJSON(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for mediaRange := range strings.SplitSeq(value, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeAcceptHeader lets the API respond in its default format, which is converted to NDJSON by the proxy.
+func removeAcceptHeader(r *http.Request) error {
+	r.Header.Del("Accept")
+	return nil
+}
+
+// ndjsonEmbeddingsMapper wraps next and converts successful embeddings responses to NDJSON with one
+// embedding object of the 'data' array per line. Event streams are converted event by event, so
+// lines are sent as soon as the corresponding event was decrypted. Other fields of the response,
+// e.g., 'usage', are omitted. Error responses are returned unchanged.
+func ndjsonEmbeddingsMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		if dsResp.GetStatusCode() < 200 || dsResp.GetStatusCode() >= 300 {
+			return dsResp, nil
+		}
+
+		var body *ndjsonEmbeddingsReader
+		switch r := dsResp.(type) {
+		case *forwarder.UnaryResponse:
+			body = newNDJSONEmbeddingsReader(unaryDocument(r.Body), nil)
+		case *forwarder.StreamingResponse:
+			if !strings.Contains(r.Header.Get("Content-Type"), "event-stream") {
+				return dsResp, nil
+			}
+			body = newNDJSONEmbeddingsReader(eventStreamDocuments(bufio.NewReader(r.Body)), r.Body)
+		default:
+			return dsResp, nil
+		}
+
+		header := dsResp.GetHeader().Clone()
+		header.Set("Content-Type", ndjsonContentType)
+		header.Del("Content-Length")
+		return &forwarder.StreamingResponse{
+			StatusCode: dsResp.GetStatusCode(),
+			Header:     header,
+			Body:       body,
+		}, nil
+	}
+}
+
+// ndjsonEmbeddingsReader reads the embedding objects of the documents returned by next as NDJSON.
+// Only the embeddings of one document are held in memory at a time.
+type ndjsonEmbeddingsReader struct {
+	next     func() ([]byte, error)
+	closer   io.Closer
+	pending  []gjson.Result
+	leftover []byte
+}
+
+func newNDJSONEmbeddingsReader(next func() ([]byte, error), closer io.Closer) *ndjsonEmbeddingsReader {
+	return &ndjsonEmbeddingsReader{next: next, closer: closer}
+}
+
+// Close closes the underlying response body, if any.
+func (r *ndjsonEmbeddingsReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Read returns one line per Read, so each embedding is flushed to the client on its own.
+func (r *ndjsonEmbeddingsReader) Read(b []byte) (int, error) {
+	for len(r.leftover) == 0 {
+		if len(r.pending) > 0 {
+			var line bytes.Buffer
+			if err := json.Compact(&line, []byte(r.pending[0].Raw)); err != nil {
+				return 0, fmt.Errorf("compacting embedding: %w", err)
+			}
+			line.WriteByte('\n')
+			r.leftover = line.Bytes()
+			r.pending = r.pending[1:]
+			continue
+		}
+
+		doc, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		data := gjson.GetBytes(doc, "data")
+		if data.Exists() && !data.IsArray() {
+			return 0, errors.New("embeddings response field 'data' is not an array")
+		}
+		r.pending = data.Array()
+	}
+	n := copy(b, r.leftover)
+	r.leftover = r.leftover[n:]
+	return n, nil
+}
+
+// unaryDocument returns body once and [io.EOF] afterwards.
+func unaryDocument(body []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if body == nil {
+			return nil, io.EOF
+		}
+		doc := body
+		body = nil
+		return doc, nil
+	}
+}
+
+// eventStreamDocuments returns the data of the events in r one at a time until the stream ends.
+func eventStreamDocuments(r *bufio.Reader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) == 0 && err != nil {
+				return nil, err
+			}
+			data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+			data = bytes.TrimSpace(data)
+			if ok && len(data) > 0 && !bytes.Equal(data, []byte("[DONE]")) {
+				return data, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+)
+
+const (
+	// PrewarmEndpoint populates the prompt cache of the API with a shared document.
+	// It is only served if an admin token is configured, see [Opts.AdminToken].
+	PrewarmEndpoint = "/admin/prewarm"
+	// AdminTokenHeader carries the admin token for admin endpoints. A separate header is used
+	// since the Authorization header is passed to the API as API key.
+	AdminTokenHeader = "Privatemode-Admin-Token"
+)
+
+// PrewarmRequest is the request body of [PrewarmEndpoint].
+type PrewarmRequest struct {
+	// Model is the model whose cache is populated.
+	Model string `json:"model"`
+	// Document is sent as system message, so later requests starting with the same system message hit the cache.
+	// Since the shard key is derived from the raw bytes of the messages, later requests must not set tools,
+	// and their messages must start with exactly
+	//
+	//	[{"role":"system","content":<document>}
+	//
+	// without whitespace, where <document> is the document encoded as a JSON string that only escapes
+	// quotes, backslashes, control characters, U+2028, and U+2029, as Go's encoding/json does with HTML
+	// escaping disabled. In particular, '<', '>', '&', and other non-ASCII characters must not be escaped,
+	// e.g., Python's json.dumps needs ensure_ascii=False and separators=(",", ":").
+	Document string `json:"document"`
+	// CacheSalt is the cache salt of later requests. If empty, the proxy's default cache salt is used.
+	CacheSalt string `json:"cache_salt,omitempty"`
+}
+
+// prewarmHandler handles requests to [PrewarmEndpoint].
+// It sends a minimal chat completion request with the document as system message, which is
+// routed by its shard key to the same backend as later requests with the same prefix.
+func (s *Server) prewarmHandler(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(s.adminToken)) != 1 {
+		forwarder.HTTPError(w, r, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	if s.disablePromptCache {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "prompt caching is disabled")
+		return
+	}
+
+	prewarm, err := unmarshalJSONBody[PrewarmRequest](r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "parsing prewarm request: %s", err)
+		return
+	}
+	if prewarm.Model == "" || prewarm.Document == "" {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "model and document are required")
+		return
+	}
+	if prewarm.CacheSalt == "" && s.defaultCacheSalt == "" {
+		// A random cache salt would be generated, which can't be shared with later requests.
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "cache_salt is required if the proxy has no default cache salt")
+		return
+	}
+
+	// json.Marshal would escape HTML characters in the document, which clients don't, see [PrewarmRequest.Document].
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(openai.ChatRequest{
+		ChatRequestPlainData: openai.ChatRequestPlainData{
+			Model:     prewarm.Model,
+			MaxTokens: 1,
+		},
+		Messages:  []openai.Message{{Role: "system", Content: prewarm.Document}},
+		CacheSalt: prewarm.CacheSalt,
+	}); err != nil {
+		forwarder.HTTPError(w, r, http.StatusInternalServerError, "marshaling chat request: %s", err)
+		return
+	}
+	body := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	req := r.Clone(r.Context())
+	req.URL.Path = openai.ChatCompletionsEndpoint
+	req.RequestURI = ""
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept")
+	req.Header.Del(AdminTokenHeader)
+
+	rw := &bufferedResponseWriter{header: http.Header{}}
+	s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields)(rw, req)
+
+	if rw.statusCode != 0 && rw.statusCode != http.StatusOK {
+		// Relay the error of the chat completion request as is.
+		s.log.Warn("Prewarming the prompt cache failed", "statusCode", rw.statusCode)
+		w.Header().Set("Content-Type", rw.header.Get("Content-Type"))
+		w.WriteHeader(rw.statusCode)
+		_, _ = w.Write(rw.body.Bytes())
+		return
+	}
+	s.requestLog.Info("Prewarmed the prompt cache", "model", prewarm.Model)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrewarm(t *testing.T) {
+	const (
+		adminToken = "admin-token"
+		cacheSalt  = "r1234567890123456789012345678912"
+	)
+	document := strings.Repeat("shared document ", 2000)
+
+	testCases := map[string]struct {
+		adminToken         string
+		requestToken       string
+		request            PrewarmRequest
+		disablePromptCache bool
+		wantStatusCode     int
+	}{
+		"prewarm": {
+			adminToken:     adminToken,
+			requestToken:   adminToken,
+			request:        PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt},
+			wantStatusCode: http.StatusNoContent,
+		},
+		"wrong admin token": {
+			adminToken:     adminToken,
+			requestToken:   "wrong",
+			request:        PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		"missing admin token": {
+			adminToken:     adminToken,
+			request:        PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		"missing cache salt": {
+			adminToken:     adminToken,
+			requestToken:   adminToken,
+			request:        PrewarmRequest{Model: "gpt-oss-120b", Document: document},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"missing document": {
+			adminToken:     adminToken,
+			requestToken:   adminToken,
+			request:        PrewarmRequest{Model: "gpt-oss-120b", CacheSalt: cacheSalt},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"prompt cache disabled": {
+			adminToken:         adminToken,
+			requestToken:       adminToken,
+			request:            PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt},
+			disablePromptCache: true,
+			wantStatusCode:     http.StatusBadRequest,
+		},
+		"admin endpoints disabled": {
+			request:        PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var mu sync.Mutex
+			var shardKeys []string
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				shardKeys = append(shardKeys, r.Header.Get(constants.PrivatemodeShardKeyHeader))
+				mu.Unlock()
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.adminToken = tc.adminToken
+			sut.disablePromptCache = tc.disablePromptCache
+
+			req := prepareJSONRequest(t.Context(), require, PrewarmEndpoint, tc.request)
+			if tc.requestToken != "" {
+				req.Header.Set(AdminTokenHeader, tc.requestToken)
+			}
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			if tc.wantStatusCode != http.StatusNoContent {
+				assert.Empty(shardKeys)
+				return
+			}
+			require.Len(shardKeys, 1)
+			assert.Contains(shardKeys[0], "-", "shard key must cover the document")
+
+			// A later request starting with the same document is routed with the same shard key.
+			req = prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
+				ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b"},
+				Messages:             []openai.Message{{Role: "system", Content: document}},
+				CacheSalt:            cacheSalt,
+			})
+			resp = httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			require.Len(shardKeys, 2)
+			assert.Equal(shardKeys[1], shardKeys[0])
+		})
+	}
+}
+
+func TestPrewarmMatchesClientRequests(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	const (
+		adminToken = "admin-token"
+		cacheSalt  = "r1234567890123456789012345678912"
+	)
+	// HTML characters and non-ASCII characters are sent unescaped by clients.
+	document := strings.Repeat("<b>Terms & conditions</b> – Übersicht ", 1000)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	var mu sync.Mutex
+	var shardKeys []string
+	echo := stub.EchoHandler(secret.Map(), slog.Default())
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		shardKeys = append(shardKeys, r.Header.Get(constants.PrivatemodeShardKeyHeader))
+		mu.Unlock()
+		echo.ServeHTTP(w, r)
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.adminToken = adminToken
+
+	req := prepareJSONRequest(t.Context(), require, PrewarmEndpoint,
+		PrewarmRequest{Model: "gpt-oss-120b", Document: document, CacheSalt: cacheSalt})
+	req.Header.Set(AdminTokenHeader, adminToken)
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusNoContent, resp.Code, resp.Body.String())
+
+	// The request body as written by a client, with a question following the document.
+	body := `{"model":"gpt-oss-120b","cache_salt":"` + cacheSalt + `","messages":[` +
+		`{"role":"system","content":"` + document + `"},` +
+		`{"role":"user","content":"What are the terms?"}]}`
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodPost, openai.ChatCompletionsEndpoint, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	require.Len(shardKeys, 2)
+	assert.Contains(shardKeys[0], "-", "shard key must cover the document")
+	assert.True(strings.HasPrefix(shardKeys[1], shardKeys[0]), "shard key of the client request must extend the prewarmed one")
+}
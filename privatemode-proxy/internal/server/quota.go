@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+)
+
+// quotaWindow is the sliding window in which requests are counted against [Opts.ModelQuotas].
+const quotaWindow = time.Minute
+
+// ParseModelQuotas parses quotas in the form model=requestsPerMinute.
+func ParseModelQuotas(entries []string) (map[string]int, error) {
+	quotas := map[string]int{}
+	for _, entry := range entries {
+		model, limit, ok := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			return nil, fmt.Errorf("invalid model quota %q: expected model=requestsPerMinute", entry)
+		}
+		requestsPerMinute, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || requestsPerMinute <= 0 {
+			return nil, fmt.Errorf("invalid model quota %q: requests per minute must be a positive integer", entry)
+		}
+		if _, ok := quotas[model]; ok {
+			return nil, fmt.Errorf("duplicate model quota for %q", model)
+		}
+		quotas[model] = requestsPerMinute
+	}
+	return quotas, nil
+}
+
+// modelQuotas limits the number of requests per model within a sliding window of [quotaWindow].
+// Models without a quota aren't limited.
+type modelQuotas struct {
+	limits   map[string]int
+	now      func() time.Time
+	mu       sync.Mutex
+	requests map[string][]time.Time // start times of the requests in the window, oldest first
+}
+
+func newModelQuotas(limits map[string]int) *modelQuotas {
+	return &modelQuotas{
+		limits:   limits,
+		now:      time.Now,
+		requests: map[string][]time.Time{},
+	}
+}
+
+// allow reports whether a request for model is within its quota, and counts it if so.
+func (q *modelQuotas) allow(model string) bool {
+	limit, ok := q.limits[model]
+	if !ok {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	requests := q.requests[model]
+	expired := 0
+	for expired < len(requests) && !requests[expired].After(now.Add(-quotaWindow)) {
+		expired++
+	}
+	requests = requests[expired:]
+	if len(requests) >= limit {
+		q.requests[model] = requests
+		return false
+	}
+	q.requests[model] = append(requests, now)
+	return true
+}
+
+// checkModelQuota rejects a request for model with status 429 if the quota of the model is exceeded,
+// see [Opts.ModelQuotas].
+func (s *Server) checkModelQuota(model string) error {
+	if s.modelQuotas == nil {
+		return nil
+	}
+	if !s.modelQuotas.allow(model) {
+		return &forwarder.StatusError{
+			StatusCode: http.StatusTooManyRequests,
+			Err:        fmt.Errorf("request quota of model %q exceeded", model),
+		}
+	}
+	return nil
+}
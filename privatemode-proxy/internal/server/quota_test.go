@@ -0,0 +1,127 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModelQuotas(t *testing.T) {
+	testCases := map[string]struct {
+		entries    []string
+		wantQuotas map[string]int
+		wantErr    bool
+	}{
+		"empty": {
+			wantQuotas: map[string]int{},
+		},
+		"multiple models": {
+			entries:    []string{"gpt-oss-120b=60", " qwen3-embedding-4b = 600 "},
+			wantQuotas: map[string]int{"gpt-oss-120b": 60, "qwen3-embedding-4b": 600},
+		},
+		"missing limit": {
+			entries: []string{"gpt-oss-120b"},
+			wantErr: true,
+		},
+		"missing model": {
+			entries: []string{"=60"},
+			wantErr: true,
+		},
+		"zero limit": {
+			entries: []string{"gpt-oss-120b=0"},
+			wantErr: true,
+		},
+		"invalid limit": {
+			entries: []string{"gpt-oss-120b=many"},
+			wantErr: true,
+		},
+		"duplicate model": {
+			entries: []string{"gpt-oss-120b=60", "gpt-oss-120b=30"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			quotas, err := ParseModelQuotas(tc.entries)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantQuotas, quotas)
+		})
+	}
+}
+
+func TestModelQuotasSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	quotas := newModelQuotas(map[string]int{"limited": 2})
+	quotas.now = func() time.Time { return now }
+
+	assert.True(quotas.allow("limited"))
+	now = now.Add(30 * time.Second)
+	assert.True(quotas.allow("limited"))
+	assert.False(quotas.allow("limited"))
+	assert.True(quotas.allow("unlimited"))
+
+	// the first request leaves the window
+	now = now.Add(30 * time.Second)
+	assert.True(quotas.allow("limited"))
+	assert.False(quotas.allow("limited"))
+
+	// rejected requests aren't counted
+	now = now.Add(30 * time.Second)
+	assert.True(quotas.allow("limited"))
+}
+
+func TestModelQuotaEnforcement(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.modelQuotas = newModelQuotas(map[string]int{"gpt-oss-120b": 2, "gemma-3-27b": 1})
+
+	send := func(model string) int {
+		req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, map[string]any{
+			"model":    model,
+			"messages": []map[string]any{{"role": "user", "content": "Hello"}},
+		})
+		resp := httptest.NewRecorder()
+		sut.GetHandler().ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(http.StatusOK, send("gpt-oss-120b"))
+	assert.Equal(http.StatusOK, send("gemma-3-27b"))
+	assert.Equal(http.StatusTooManyRequests, send("gemma-3-27b"))
+	// the quota of each model is independent
+	assert.Equal(http.StatusOK, send("gpt-oss-120b"))
+	assert.Equal(http.StatusTooManyRequests, send("gpt-oss-120b"))
+	// models without a quota aren't limited
+	for range 3 {
+		assert.Equal(http.StatusOK, send("llama-3.3-70b"))
+	}
+}
@@ -5,21 +5,31 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/edgelesssys/continuum/internal/oss/anthropic"
 	"github.com/edgelesssys/continuum/internal/oss/auth"
 	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/logging"
 	"github.com/edgelesssys/continuum/internal/oss/middleware"
 	"github.com/edgelesssys/continuum/internal/oss/mutators"
 	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
@@ -27,32 +37,256 @@ import (
 	"github.com/edgelesssys/continuum/internal/oss/persist"
 	"github.com/edgelesssys/continuum/internal/oss/process"
 	"github.com/edgelesssys/continuum/internal/oss/requestid"
-	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"golang.org/x/sync/singleflight"
 )
 
+// unstructuredEndpoint is the prefix of all endpoints of the Unstructured API.
+const unstructuredEndpoint = "/unstructured/"
+
+// unknownEndpoint is a path that must not be routed to any handler, see [Server.CheckRoutes].
+const unknownEndpoint = "/unknown"
+
+// requestIDPrefix is prepended to all request IDs sent by the proxy.
+const requestIDPrefix = "proxy_"
+
+// ShardKeyRandom is the value of the [constants.PrivatemodeShardKeyHeader] response header if
+// [Opts.ExposeShardKey] is set and no shard key was sent to the API, i.e., random sharding is used.
+const ShardKeyRandom = "random"
+
+// UpstreamRequestIDHeader is the response header carrying the ID the API assigned to the request,
+// see [Opts.EchoUpstreamRequestID].
+const UpstreamRequestIDHeader = "Privatemode-Upstream-Request-ID"
+
+// ModelHeader is the request header specifying the model of requests without a model in the body,
+// see [Opts.AllowModelHeader].
+const ModelHeader = "X-Model"
+
 // Server implements the HTTP server for the API gateway.
 type Server struct {
 	apiKey                       *string
 	defaultCacheSalt             string // if no salt is set, a random salt will be used
+	cacheSaltPerAPIKey           bool
+	disablePromptCache           bool
+	requireClientCacheSalt       bool
 	forwarder                    apiForwarder
-	sm                           secretManager
+	sm                           SecretManager
 	log                          *slog.Logger
+	requestLog                   *slog.Logger // logs once per request, see [Opts.QuietRequestLogs]
 	isApp                        bool
 	nvidiaOCSPAllowUnknown       bool
 	nvidiaOCSPRevokedGracePeriod time.Duration
 	dumpRequestsDir              string
+	dumpOpts                     []middleware.DumpOpts
+	maxHeaderBytes               int
+	rejectLargeHeaders           bool
+	modelDefaults                mutators.ModelDefaults
+	maxResponseBytes             int64
+	maxBatchSize                 int
+	maxBatchBodyBytes            int64
+	batchConcurrency             int
+	preserveClientRequestID      bool
+	strictJSON                   bool
+	enabledEndpoints             []string // nil enables all endpoints
+	disabledEndpointStatus       int
+	streamHeartbeatInterval      time.Duration
+	streamBufferSize             int
+	requestTimeout               time.Duration
+	retryBudget                  time.Duration
+	overloadRetries              int
+	maxRetryAfter                time.Duration
+	streamErrorEvents            bool
+	retryMetrics                 bool
+	forwardTrailers              bool
+	exposeShardKey               bool
+	coalesceRequests             bool
+	stripForwardedFor            bool
+	maxPromptChars               int
+	maxTools                     int
+	unstructuredContentTypes     []string // nil accepts all content types
+	shardKeyWarnFraction         float64
+	adminToken                   string
+	verifyDecryptedResponse      bool
+	verifyModelMatch             bool
+	extraHeaders                 http.Header
+	forwardHeaders               []string
+	modelPrefixStrip             string
+	allowModelHeader             bool
+	timingHeaders                bool
+	auditSink                    AuditSink
+	auditRecords                 chan AuditRecord // queue of records for auditSink, created on first use
+	auditWriterOnce              sync.Once
+	currentManifest              func() string
+	modelQuotas                  *modelQuotas
+	corsAllowedOrigins           []string
+	secretWaitTimeout            time.Duration
+	echoUpstreamRequestID        bool
+	streamCompatMode             bool
+	encryptedHeader              string
+	plaintextByDefault           bool
+	idempotencyCache             *idempotencyCache
+	requestGroup                 singleflight.Group
 }
 
 // Opts are the options for creating a new [Server].
 type Opts struct {
-	APIEndpoint                  string
-	APIKey                       *string
-	ProtocolScheme               forwarder.ProtocolScheme
-	PromptCacheSalt              string
+	APIEndpoint     string
+	APIKey          *string
+	ProtocolScheme  forwarder.ProtocolScheme
+	PromptCacheSalt string
+	// CacheSaltPerAPIKey derives the default cache salt from the API key of a request and
+	// PromptCacheSalt, so that only requests with the same API key share a cache.
+	// Has no effect if PromptCacheSalt is empty.
+	CacheSaltPerAPIKey bool
+	// DisablePromptCache skips shard key and cache salt handling. Every request is sent with a
+	// random cache salt instead, overriding any salt set by the client, so the API doesn't cache it.
+	DisablePromptCache bool
+	// RequireClientCacheSalt rejects chat requests without a cache salt with status 400,
+	// instead of using the default cache salt.
+	RequireClientCacheSalt       bool
 	IsApp                        bool
 	NvidiaOCSPAllowUnknown       bool
 	NvidiaOCSPRevokedGracePeriod time.Duration
 	DumpRequestsDir              string
+	// DumpCompress gzip-compresses the files written to DumpRequestsDir.
+	DumpCompress bool
+	// DumpMaxBytes is the maximum total size of the files in DumpRequestsDir. The oldest files
+	// are removed when it is exceeded. A value <= 0 disables the limit.
+	DumpMaxBytes int64
+	// DumpMaxFiles is the maximum number of files in DumpRequestsDir. The oldest files
+	// are removed when it is exceeded. A value <= 0 disables the limit.
+	DumpMaxFiles int
+	// MaxHeaderBytes is the maximum combined size of the upstream request headers.
+	// If the limit would be exceeded, the shard key is shortened. A value <= 0 disables the check.
+	MaxHeaderBytes int
+	// RejectLargeHeaders rejects requests with 431 if their headers still exceed MaxHeaderBytes
+	// after shortening the shard key. Otherwise, they are sent to the API anyway.
+	RejectLargeHeaders bool
+	// ModelDefaults are default request parameters per model, applied to chat requests
+	// for fields the client didn't set.
+	ModelDefaults mutators.ModelDefaults
+	// MaxResponseBytes is the maximum size of a non-streaming response body from the API.
+	// A value <= 0 disables the check.
+	MaxResponseBytes int64
+	// MaxBatchSize is the maximum number of requests in a batch sent to [ChatCompletionsBatchEndpoint].
+	// A value <= 0 disables the endpoint.
+	MaxBatchSize int
+	// BatchConcurrency is the number of requests of a batch forwarded concurrently.
+	BatchConcurrency int
+	// PreserveClientRequestID keeps request IDs supplied by clients instead of generating new ones.
+	PreserveClientRequestID bool
+	// StrictJSON rejects JSON request bodies with duplicate top-level keys.
+	StrictJSON bool
+	// EnabledEndpoints lists the endpoints (see [Endpoints]) served by the proxy. If nil, all endpoints are enabled.
+	EnabledEndpoints []string
+	// DisabledEndpointStatus is the HTTP status code returned for requests to disabled endpoints.
+	// Defaults to 404.
+	DisabledEndpointStatus int
+	// StreamHeartbeatInterval is the idle interval after which an SSE comment is sent on streaming
+	// responses. A value <= 0 disables heartbeats.
+	StreamHeartbeatInterval time.Duration
+	// StreamBufferSize is the buffer size in bytes used for copying streaming responses to the client.
+	// A value <= 0 uses [forwarder.DefaultStreamBufferSize].
+	StreamBufferSize int
+	// RequestTimeout is the maximum duration of forwarding a request to the API. Streaming responses
+	// are exempt once the response headers have been received. A value of 0 disables the timeout.
+	RequestTimeout time.Duration
+	// RetryBudget is the maximum total duration of sending a request to the API including retries.
+	// A value of 0 only limits retries by the request context.
+	RetryBudget time.Duration
+	// OverloadRetries is the number of retries of requests the API answered with 429 or 503.
+	// The delay is taken from the Retry-After header, capped by MaxRetryAfter. If 0, these requests aren't retried.
+	OverloadRetries int
+	// MaxRetryAfter caps the delay taken from a Retry-After header. If 0, the forwarder's default is used.
+	MaxRetryAfter time.Duration
+	// StreamErrorEvents ends event streams failing after the API has started responding with a
+	// [forwarder.StreamErrorEvent] containing the error, its status code and whether to retry.
+	StreamErrorEvents bool
+	// RetryMetrics counts retries of requests to the API in the privatemode_proxy_retries_total metric.
+	RetryMetrics bool
+	// ForwardTrailers forwards the HTTP trailers of non-streaming API responses to clients accepting them.
+	ForwardTrailers bool
+	// ExposeShardKey sets the [constants.PrivatemodeShardKeyHeader] response header to the shard key
+	// sent to the API, or [ShardKeyRandom] if none was sent.
+	// The shard key is derived from the cache salt and the prompt prefix. Anyone who can see the
+	// response headers can thus tell whether two requests share a cache salt and prompt prefix.
+	ExposeShardKey bool
+	// CoalesceRequests lets identical concurrent non-streaming requests share a single upstream request.
+	// If the proxy has its own API key, only requests with a cache salt are coalesced.
+	CoalesceRequests bool
+	// StripForwardedFor removes the X-Forwarded-For and Forwarded headers instead of appending the client IP.
+	StripForwardedFor bool
+	// MaxPromptChars is the maximum number of characters in the prompt of chat and completions requests.
+	// A value <= 0 disables the check.
+	MaxPromptChars int
+	// MaxTools is the maximum number of tools in chat requests. A value <= 0 disables the check.
+	MaxTools int
+	// UnstructuredContentTypes lists the media types of requests with a body accepted by the Unstructured API.
+	// Requests with other content types are rejected with 415. If empty, all content types are accepted.
+	UnstructuredContentTypes []string
+	// ShardKeyWarnFraction is the fraction of [constants.ShardKeyMaxTokens] above which a warning is logged
+	// for the prompt of a request, before requests fail for exceeding the limit. A value <= 0 disables the warning.
+	ShardKeyWarnFraction float64
+	// AdminToken protects admin endpoints such as [PrewarmEndpoint]. Admin endpoints are only served if it is set.
+	AdminToken string
+	// IdempotencyWindow is the duration for which responses to chat requests with an Idempotency-Key
+	// header are cached and replayed to retries. A value of 0 disables idempotency keys.
+	IdempotencyWindow time.Duration
+	// IdempotencyCacheSize is the maximum number of cached responses for idempotency keys.
+	IdempotencyCacheSize int
+	// VerifyDecryptedResponse checks that decrypted responses have the expected structure.
+	// It implies StreamErrorEvents, so that failing streams aren't silently truncated.
+	VerifyDecryptedResponse bool
+	// VerifyModelMatch checks that the model of decrypted responses matches the requested model,
+	// see [modelsMatch].
+	VerifyModelMatch bool
+	// ExtraHeaders are sent with every request to the API. See [ParseExtraHeaders].
+	ExtraHeaders http.Header
+	// ForwardHeaders are the client headers that are forwarded to the API. See [ParseForwardHeaders].
+	// Other client headers are removed, except for those needed to process the request.
+	// If empty, all client headers are forwarded.
+	ForwardHeaders []string
+	// QuietRequestLogs logs the info messages emitted for every request at debug level.
+	// Warnings and errors are still logged.
+	QuietRequestLogs bool
+	// ModelPrefixStrip is removed from the model of JSON requests before they are forwarded,
+	// and added to the model IDs listed by [openai.ModelsEndpoint].
+	ModelPrefixStrip string
+	// AllowModelHeader sets the model of chat, completions, and embeddings requests without a model
+	// in the body to the value of the [ModelHeader] request header.
+	AllowModelHeader bool
+	// TimingHeaders sets a Server-Timing header on inference responses, which reports the time
+	// spent encrypting the request, waiting for the API, and decrypting the response.
+	TimingHeaders bool
+	// AuditSink receives the metadata of every inference request after the response was sent.
+	// Records are written in the background and dropped if the sink can't keep up, which is counted
+	// in the privatemode_proxy_audit_records_dropped_total metric. If nil, no audit records are written.
+	AuditSink AuditSink
+	// CurrentManifest returns the Contrast manifest the deployment was last verified against,
+	// or an empty string if none was verified yet. It is summarized by [AttestationEndpoint].
+	CurrentManifest func() string
+	// ModelQuotas are the maximum numbers of requests per minute for each model, see [ParseModelQuotas].
+	// Requests exceeding the quota of their model are rejected with status 429. Other models aren't limited.
+	ModelQuotas map[string]int
+	// CORSAllowedOrigins are the origins of web apps that may make cross-origin requests to the proxy,
+	// see [ParseCORSAllowedOrigins]. If empty, no CORS headers are set.
+	CORSAllowedOrigins []string
+	// SecretWaitTimeout is how long a request waits for a secret if none can be obtained yet,
+	// see [secretmanager.ErrNoAPIKey]. Other errors aren't retried. If 0, requests fail immediately.
+	SecretWaitTimeout time.Duration
+	// EchoUpstreamRequestID sets the [UpstreamRequestIDHeader] response header to the ID the API assigned
+	// to the request, if the API returned one. Clients can pass it to support to correlate their requests.
+	EchoUpstreamRequestID bool
+	// StreamCompatMode removes the final "data: [DONE]" event from streaming responses for older clients
+	// that expect every event to contain JSON.
+	StreamCompatMode bool
+	// EncryptedHeader is the response header of the API indicating whether a response is encrypted.
+	// If empty, [forwarder.EncryptedHeader] is used.
+	EncryptedHeader string
+	// PlaintextByDefault treats responses as unencrypted unless the [Opts.EncryptedHeader] is "true".
+	// By default, responses are treated as encrypted unless the header is "false".
+	PlaintextByDefault bool
 }
 
 type apiForwarder interface {
@@ -63,22 +297,98 @@ type apiForwarder interface {
 	)
 }
 
+// plainFieldSelectors are the selectors of OpenAI fields the server sends and receives in plaintext.
+var plainFieldSelectors = []forwarder.FieldSelector{
+	openai.PlainCompletionsRequestFields,
+	openai.PlainCompletionsResponseFields,
+	openai.PlainEmbeddingsRequestFields,
+	openai.PlainEmbeddingsResponseFields,
+	openai.PlainTranscriptionRequestFields,
+	openai.PlainTranscriptionResponseFields,
+}
+
 // New sets up a new Server.
-func New(client *http.Client, sm secretManager, opts Opts, log *slog.Logger) *Server {
+// It returns an error if a plain field selector of the server would expose user content, see [openai.ValidatePlainFields].
+func New(client *http.Client, sm SecretManager, opts Opts, log *slog.Logger) (*Server, error) {
+	for _, plain := range plainFieldSelectors {
+		if err := openai.ValidatePlainFields(plain); err != nil {
+			return nil, fmt.Errorf("validating plain fields: %w", err)
+		}
+	}
+
 	log.Info("Version", slog.String("version", constants.Version()))
-	fwd := forwarder.New(client, opts.APIEndpoint, opts.ProtocolScheme, log)
+	requestLog := log
+	if opts.QuietRequestLogs {
+		requestLog = logging.InfoAsDebug(log)
+	}
+	fwd := forwarder.New(client, opts.APIEndpoint, opts.ProtocolScheme, requestLog)
 
-	return &Server{
+	s := &Server{
 		apiKey:                       opts.APIKey,
 		defaultCacheSalt:             opts.PromptCacheSalt,
+		cacheSaltPerAPIKey:           opts.CacheSaltPerAPIKey,
+		disablePromptCache:           opts.DisablePromptCache,
+		requireClientCacheSalt:       opts.RequireClientCacheSalt,
 		forwarder:                    fwd,
 		sm:                           sm,
 		log:                          log,
+		requestLog:                   requestLog,
 		isApp:                        opts.IsApp,
 		nvidiaOCSPAllowUnknown:       opts.NvidiaOCSPAllowUnknown,
 		nvidiaOCSPRevokedGracePeriod: opts.NvidiaOCSPRevokedGracePeriod,
 		dumpRequestsDir:              opts.DumpRequestsDir,
+		dumpOpts:                     dumpOpts(opts),
+		maxHeaderBytes:               opts.MaxHeaderBytes,
+		rejectLargeHeaders:           opts.RejectLargeHeaders,
+		modelDefaults:                opts.ModelDefaults,
+		maxResponseBytes:             opts.MaxResponseBytes,
+		maxBatchSize:                 opts.MaxBatchSize,
+		maxBatchBodyBytes:            constants.MaxFileSizeBytes,
+		batchConcurrency:             opts.BatchConcurrency,
+		preserveClientRequestID:      opts.PreserveClientRequestID,
+		strictJSON:                   opts.StrictJSON,
+		enabledEndpoints:             opts.EnabledEndpoints,
+		disabledEndpointStatus:       cmp.Or(opts.DisabledEndpointStatus, http.StatusNotFound),
+		streamHeartbeatInterval:      opts.StreamHeartbeatInterval,
+		streamBufferSize:             opts.StreamBufferSize,
+		requestTimeout:               opts.RequestTimeout,
+		retryBudget:                  opts.RetryBudget,
+		overloadRetries:              opts.OverloadRetries,
+		maxRetryAfter:                opts.MaxRetryAfter,
+		streamErrorEvents:            opts.StreamErrorEvents,
+		retryMetrics:                 opts.RetryMetrics,
+		forwardTrailers:              opts.ForwardTrailers,
+		exposeShardKey:               opts.ExposeShardKey,
+		coalesceRequests:             opts.CoalesceRequests,
+		stripForwardedFor:            opts.StripForwardedFor,
+		maxPromptChars:               opts.MaxPromptChars,
+		maxTools:                     opts.MaxTools,
+		unstructuredContentTypes:     opts.UnstructuredContentTypes,
+		shardKeyWarnFraction:         opts.ShardKeyWarnFraction,
+		adminToken:                   opts.AdminToken,
+		verifyDecryptedResponse:      opts.VerifyDecryptedResponse,
+		verifyModelMatch:             opts.VerifyModelMatch,
+		extraHeaders:                 opts.ExtraHeaders,
+		forwardHeaders:               opts.ForwardHeaders,
+		modelPrefixStrip:             opts.ModelPrefixStrip,
+		allowModelHeader:             opts.AllowModelHeader,
+		timingHeaders:                opts.TimingHeaders,
+		auditSink:                    opts.AuditSink,
+		currentManifest:              opts.CurrentManifest,
+		corsAllowedOrigins:           opts.CORSAllowedOrigins,
+		secretWaitTimeout:            opts.SecretWaitTimeout,
+		echoUpstreamRequestID:        opts.EchoUpstreamRequestID,
+		streamCompatMode:             opts.StreamCompatMode,
+		encryptedHeader:              opts.EncryptedHeader,
+		plaintextByDefault:           opts.PlaintextByDefault,
+	}
+	if opts.IdempotencyWindow > 0 {
+		s.idempotencyCache = newIdempotencyCache(opts.IdempotencyWindow, opts.IdempotencyCacheSize)
 	}
+	if len(opts.ModelQuotas) > 0 {
+		s.modelQuotas = newModelQuotas(opts.ModelQuotas)
+	}
+	return s, nil
 }
 
 // Serve starts the server on the given port.
@@ -93,16 +403,24 @@ func (s *Server) Serve(ctx context.Context, lis net.Listener, tlsConfig *tls.Con
 	return process.HTTPServeContext(ctx, server, lis, s.log)
 }
 
+// Endpoints returns the endpoints served by the proxy, which can be selected with [Opts.EnabledEndpoints].
+func Endpoints() []string {
+	return []string{
+		openai.ChatCompletionsEndpoint,
+		openai.LegacyCompletionsEndpoint,
+		unstructuredEndpoint,
+		openai.ModelsEndpoint,
+		openai.EmbeddingsEndpoint,
+		openai.TranscriptionsEndpoint,
+		anthropic.MessagesEndpoint,
+		ChatCompletionsBatchEndpoint,
+	}
+}
+
 // GetHandler returns an HTTP handler that routes requests to the appropriate handler.
+// Requests with a method not allowed for an endpoint are rejected with 405.
 func (s *Server) GetHandler() http.Handler {
-	mux := http.NewServeMux()
-	mux.HandleFunc(openai.ChatCompletionsEndpoint, s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields))
-	mux.HandleFunc(openai.LegacyCompletionsEndpoint, s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields))
-	mux.HandleFunc("/unstructured/", s.unstructuredHandler)
-	mux.HandleFunc(openai.ModelsEndpoint, s.noEncryptionHandler)
-	mux.HandleFunc(openai.EmbeddingsEndpoint, s.embeddingsHandler)
-	mux.HandleFunc(openai.TranscriptionsEndpoint, s.transcriptionsHandler)
-	mux.HandleFunc(anthropic.MessagesEndpoint, s.chatRequestHandler(anthropic.PlainMessagesRequestFields, anthropic.PlainMessagesResponseFields))
+	mux, _ := s.newMux()
 
 	// Apply middlewares below, handler holds the chain entrypoint
 	var handler http.Handler = mux
@@ -111,15 +429,110 @@ func (s *Server) GetHandler() http.Handler {
 
 	// Only apply dumping middleware when a dump directory is configured.
 	if strings.TrimSpace(s.dumpRequestsDir) != "" {
-		handler = middleware.DumpRequestAndResponse(handler, s.log, s.dumpRequestsDir)
+		handler = middleware.DumpRequestAndResponse(handler, s.log, s.dumpRequestsDir, s.dumpOpts...)
+	}
+
+	// Answer preflight requests before any other handling, since browsers don't send credentials with them.
+	if len(s.corsAllowedOrigins) > 0 {
+		handler = s.corsMiddleware(handler)
 	}
 
 	return handler
 }
 
+// dumpOpts returns the [middleware.DumpOpts] for the request dumping configured in opts.
+func dumpOpts(opts Opts) []middleware.DumpOpts {
+	var dumpOpts []middleware.DumpOpts
+	if opts.DumpCompress {
+		dumpOpts = append(dumpOpts, middleware.WithDumpCompression())
+	}
+	if opts.DumpMaxBytes > 0 || opts.DumpMaxFiles > 0 {
+		dumpOpts = append(dumpOpts, middleware.WithDumpRotation(opts.DumpMaxBytes, opts.DumpMaxFiles))
+	}
+	return dumpOpts
+}
+
+// route is an endpoint registered on the mux of the [Server]. An empty method allows all methods.
+type route struct {
+	method   string
+	endpoint string
+}
+
+// pattern returns the [http.ServeMux] pattern of r.
+func (r route) pattern() string {
+	if r.method == "" {
+		return r.endpoint
+	}
+	return r.method + " " + r.endpoint
+}
+
+// newMux returns the mux routing requests to the handlers of s, and the routes registered on it.
+func (s *Server) newMux() (*http.ServeMux, []route) {
+	mux := http.NewServeMux()
+	var routes []route
+	register := func(method, endpoint string, handler http.HandlerFunc) {
+		r := route{method: method, endpoint: endpoint}
+		mux.HandleFunc(r.pattern(), handler)
+		routes = append(routes, r)
+	}
+	// handle registers handler for an endpoint that can be selected with [Opts.EnabledEndpoints].
+	handle := func(method, endpoint string, handler http.HandlerFunc) {
+		if s.enabledEndpoints != nil && !slices.Contains(s.enabledEndpoints, endpoint) {
+			handler = s.disabledEndpointHandler
+		}
+		register(method, endpoint, handler)
+	}
+	handle(http.MethodPost, openai.ChatCompletionsEndpoint, s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields))
+	handle(http.MethodPost, openai.LegacyCompletionsEndpoint, s.chatRequestHandler(openai.PlainCompletionsRequestFields, openai.PlainCompletionsResponseFields))
+	handle("", unstructuredEndpoint, s.unstructuredHandler) // the Unstructured API has GET and POST endpoints
+	handle(http.MethodGet, openai.ModelsEndpoint, s.noEncryptionHandler)
+	handle(http.MethodPost, openai.EmbeddingsEndpoint, s.embeddingsHandler)
+	handle(http.MethodPost, openai.TranscriptionsEndpoint, s.transcriptionsHandler)
+	handle(http.MethodPost, anthropic.MessagesEndpoint, s.chatRequestHandler(anthropic.PlainMessagesRequestFields, anthropic.PlainMessagesResponseFields))
+	if s.maxBatchSize > 0 {
+		handle(http.MethodPost, ChatCompletionsBatchEndpoint, s.chatCompletionsBatchHandler)
+	}
+	register(http.MethodGet, AttestationEndpoint, s.attestationHandler)
+	if s.adminToken != "" {
+		register(http.MethodPost, PrewarmEndpoint, s.prewarmHandler)
+	}
+	return mux, routes
+}
+
+// CheckRoutes verifies that every endpoint registered by [Server.GetHandler] is routed to its own
+// handler and that requests to unknown paths aren't routed to any handler, i.e., are answered with 404.
+// This catches routing regressions, e.g., patterns shadowing each other.
+func (s *Server) CheckRoutes() error {
+	mux, routes := s.newMux()
+	var errs []error
+	for _, r := range routes {
+		req, err := http.NewRequest(cmp.Or(r.method, http.MethodGet), r.endpoint, nil)
+		if err != nil {
+			return fmt.Errorf("creating request for %q: %w", r.pattern(), err)
+		}
+		if _, pattern := mux.Handler(req); pattern != r.pattern() {
+			errs = append(errs, fmt.Errorf("request to %q is routed to %q", r.pattern(), pattern))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, unknownEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("creating request for %q: %w", unknownEndpoint, err)
+	}
+	if _, pattern := mux.Handler(req); pattern != "" {
+		errs = append(errs, fmt.Errorf("request to unknown path %q is routed to %q", unknownEndpoint, pattern))
+	}
+	return errors.Join(errs...)
+}
+
+// disabledEndpointHandler rejects requests to endpoints that aren't enabled in [Opts.EnabledEndpoints].
+func (s *Server) disabledEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	forwarder.HTTPError(w, r, s.disabledEndpointStatus, "endpoint %s is disabled", r.URL.Path)
+}
+
 // passAuthToSecretManagerMiddleware extracts the bearer token from the request and passes it to
 // the secret manager.
-func passAuthToSecretManagerMiddleware(next http.Handler, sm secretManager) http.Handler {
+func passAuthToSecretManagerMiddleware(next http.Handler, sm SecretManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if apiKey, err := auth.GetAuth(auth.Bearer, r.Header); err == nil {
 			if err := sm.OfferAPIKey(r.Context(), apiKey); err != nil {
@@ -135,40 +548,64 @@ func (s *Server) inferenceHandler(
 	requestMutator func(*RenewableRequestCipher) forwarder.RequestMutator,
 	responseMapper func(*RenewableRequestCipher) forwarder.ResponseMapper,
 ) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
+	handle := func(w http.ResponseWriter, r *http.Request) {
 		s.setStaticRequestHeaders(r)
+		requestID := s.requestIDFor(r)
+
+		var audit *requestAudit
+		if s.auditSink != nil {
+			audit = &requestAudit{record: AuditRecord{Timestamp: time.Now(), RequestID: requestID, Endpoint: r.URL.Path}}
+			recorder := &statusRecorder{ResponseWriter: w}
+			w = recorder
+			defer s.writeAuditRecord(recorder, audit)
+		}
 
-		rc, err := NewRenewableRequestCipher(r.Context(), s.sm)
+		rc, err := s.newRequestCipher(r.Context())
 		if err != nil {
 			forwarder.HTTPError(w, r, http.StatusInternalServerError, "creating request cipher: %s", err)
 			return
 		}
+		if !s.validateSecret(w, r, rc) {
+			return
+		}
 		suppliedRequestMutator := requestMutator(rc)
 
-		requestID := newRequestID()
 		attempt := 0
+		quotaChecked := false
+		coalesced := coalescedCallFrom(r.Context())
 
 		// Set up retry logic for specific status codes
 		//nolint:contextcheck // retryCallback is only called within the Forward() call so r.Context() does not leak
 		retryCallback := func(statusCode int, errMsg string, callbackAttempt int) (bool, time.Duration) {
 			attempt = callbackAttempt
+			recordOCSPRejection(errMsg)
 			switch {
 			case attempt <= 1 && (statusCode == 500 && strings.Contains(errMsg, constants.ErrorNoSecretForID)):
 				return s.noSecretForIDCallback(r.Context(), rc)
 			case attempt <= 1 && strings.Contains(errMsg, "read: connection reset by peer"):
 				return s.connectionResetCallback(r.Context(), rc)
+			case attempt <= s.overloadRetries && (statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable):
+				return s.overloadCallback(r.Context(), rc, attempt)
 			default:
 				return false, 0
 			}
 		}
 
 		fullRequestMutator := func(req *http.Request) error {
-			secret, err := rc.GetSecret()
+			if err := s.filterClientHeaders(req); err != nil {
+				return err
+			}
+
+			secretID, err := rc.GetSecretID()
+			if err != nil {
+				return fmt.Errorf("getting exchange secret: %w", err)
+			}
+			macKey, err := rc.GetMACKey()
 			if err != nil {
 				return fmt.Errorf("getting exchange secret: %w", err)
 			}
 
-			if err := s.setDynamicHeaders(req, secret, requestID, attempt); err != nil {
+			if err := s.setDynamicHeaders(req, secretID, macKey, requestID, attempt); err != nil {
 				return fmt.Errorf("setting headers on upstream request: %w", err)
 			}
 
@@ -176,18 +613,252 @@ func (s *Server) inferenceHandler(
 				return err
 			}
 
-			return nil
+			if !quotaChecked {
+				// retries of the same request aren't counted again
+				quotaChecked = true
+				// the model header was set by the supplied request mutator
+				if err := s.checkModelQuota(req.Header.Get(constants.PrivatemodeTargetModel)); err != nil {
+					return err
+				}
+			}
+			if coalesced != nil {
+				coalesced.model = req.Header.Get(constants.PrivatemodeTargetModel)
+			}
+
+			return s.limitHeaderSize(req)
+		}
+
+		mapper := responseMapper(rc)
+		encryptedHeader := cmp.Or(http.CanonicalHeaderKey(s.encryptedHeader), forwarder.EncryptedHeader)
+		if encryptedHeader != forwarder.EncryptedHeader || s.plaintextByDefault {
+			mapper = forwarder.EncryptedHeaderMapper(mapper, encryptedHeader, !s.plaintextByDefault)
+		}
+		if s.verifyDecryptedResponse {
+			mapper = verifyDecryptedResponseMapper(mapper)
+		}
+		if s.verifyModelMatch {
+			mapper = s.verifyModelMatchMapper(mapper)
+		}
+		if s.exposeShardKey {
+			mapper = exposeShardKeyMapper(mapper)
+		}
+		if s.echoUpstreamRequestID {
+			mapper = echoUpstreamRequestIDMapper(mapper)
+		}
+		if s.streamCompatMode {
+			mapper = skipStreamDoneMapper(mapper)
+		}
+		if s.timingHeaders {
+			timing := &requestTiming{}
+			fullRequestMutator = timing.timedRequestMutator(fullRequestMutator)
+			mapper = timing.timedResponseMapper(mapper)
+		}
+		if audit != nil {
+			mapper = audit.auditResponseMapper(mapper)
 		}
 
 		s.forwarder.Forward(
 			w, r,
 			fullRequestMutator,
-			responseMapper(rc),
-			forwarder.WithRetryCallback(retryCallback),
+			mapper,
+			append(s.forwardOpts(), forwarder.WithRetryCallback(retryCallback))...,
 		)
+		if coalesced != nil && audit != nil {
+			audit.mu.Lock()
+			coalesced.usage = audit.record
+			audit.mu.Unlock()
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.strictJSON && !s.validateStrictJSON(w, r) {
+			return
+		}
+		if s.coalesceRequests {
+			s.coalesce(w, r, handle)
+			return
+		}
+		handle(w, r)
 	}
 }
 
+// exposeShardKeyMapper wraps next and sets the shard key of the upstream request on the downstream
+// response. Because the header is read from the final upstream request, it reflects the shard key
+// after any shortening by [Server.limitHeaderSize].
+func exposeShardKeyMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		shardKey := ShardKeyRandom
+		if resp.Request != nil {
+			shardKey = cmp.Or(resp.Request.Header.Get(constants.PrivatemodeShardKeyHeader), ShardKeyRandom)
+		}
+		dsResp.GetHeader().Set(constants.PrivatemodeShardKeyHeader, shardKey)
+		return dsResp, nil
+	}
+}
+
+// echoUpstreamRequestIDMapper wraps next and sets the [UpstreamRequestIDHeader] on the downstream
+// response to the request ID of the upstream response, if there is one.
+func echoUpstreamRequestIDMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		if upstreamID := resp.Header.Get(requestid.Header); upstreamID != "" {
+			dsResp.GetHeader().Set(UpstreamRequestIDHeader, upstreamID)
+		}
+		return dsResp, nil
+	}
+}
+
+// errInvalidDecryptedResponse is returned by [verifyDecryptedResponseMapper] for decrypted responses
+// that don't have the expected structure.
+var errInvalidDecryptedResponse = errors.New("decrypted response failed integrity check")
+
+// verifyDecryptedResponseMapper wraps next and verifies that the decrypted 'choices' and 'data'
+// fields of successful responses are arrays of objects. For event streams, each event is verified.
+// Streams failing the check end with a non-retriable [forwarder.StreamErrorEvent].
+func verifyDecryptedResponseMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	verify := func(data string) (string, error) {
+		if err := verifyDecryptedJSON(data); err != nil {
+			return "", fmt.Errorf("%w: %w", errInvalidDecryptedResponse, err)
+		}
+		return data, nil
+	}
+	verifyEvent := func(data string) (string, error) {
+		data, err := verify(data)
+		if err != nil {
+			return "", &forwarder.StreamError{Err: err}
+		}
+		return data, nil
+	}
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		if dsResp.GetStatusCode() < 200 || dsResp.GetStatusCode() >= 300 {
+			return dsResp, nil
+		}
+		switch r := dsResp.(type) {
+		case *forwarder.UnaryResponse:
+			if _, err := verify(string(r.Body)); err != nil {
+				return nil, err
+			}
+		case *forwarder.StreamingResponse:
+			if strings.Contains(r.Header.Get("Content-Type"), "event-stream") {
+				r.Body = forwarder.NewRawMutatingReader(verifyEvent).Reader(r.Body)
+			}
+		}
+		return dsResp, nil
+	}
+}
+
+// verifyDecryptedJSON checks the structure of the 'choices' and 'data' fields of a JSON object.
+// With multiple choices, i.e., n > 1, the index of each choice must be unique, so that clients
+// can assign them, including the deltas of an event stream.
+// Other documents, e.g., non-JSON responses, aren't checked.
+func verifyDecryptedJSON(data string) error {
+	doc := gjson.Parse(data)
+	if !doc.IsObject() {
+		return nil
+	}
+	for _, field := range []string{"choices", "data"} {
+		value := doc.Get(field)
+		if !value.Exists() {
+			continue
+		}
+		if !value.IsArray() {
+			return fmt.Errorf("field %q is not an array", field)
+		}
+		choiceIndices := map[int64]struct{}{}
+		for i, element := range value.Array() {
+			if !element.IsObject() {
+				return fmt.Errorf("element %d of field %q is not an object", i, field)
+			}
+			if field != "choices" {
+				continue
+			}
+			if index := element.Get("index"); index.Exists() {
+				if index.Type != gjson.Number || index.Num != float64(index.Int()) || index.Int() < 0 {
+					return fmt.Errorf("index of choice %d is not a non-negative integer", i)
+				}
+				if _, ok := choiceIndices[index.Int()]; ok {
+					return fmt.Errorf("duplicate choice index %d", index.Int())
+				}
+				choiceIndices[index.Int()] = struct{}{}
+			}
+			for _, sub := range []string{"message", "delta"} {
+				if v := element.Get(sub); v.Exists() && !v.IsObject() && v.Type != gjson.Null {
+					return fmt.Errorf("field %q of choice %d is not an object", sub, i)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// errModelMismatch is returned by [Server.verifyModelMatchMapper] for responses of another model than requested.
+var errModelMismatch = errors.New("response model doesn't match the requested model")
+
+// verifyModelMatchMapper wraps next and verifies that the decrypted 'model' field of successful
+// responses matches the model of the request. For event streams, each event is verified.
+// Responses without a model, or requests whose model is unknown, aren't checked.
+func (s *Server) verifyModelMatchMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		if dsResp.GetStatusCode() < 200 || dsResp.GetStatusCode() >= 300 || resp.Request == nil {
+			return dsResp, nil
+		}
+		requestedModel := resp.Request.Header.Get(constants.PrivatemodeTargetModel)
+		if requestedModel == "" {
+			return dsResp, nil
+		}
+
+		verify := func(data string) (string, error) {
+			responseModel := gjson.Get(data, "model")
+			if responseModel.Type != gjson.String || modelsMatch(requestedModel, responseModel.Str) {
+				return data, nil
+			}
+			s.log.Warn("Response model doesn't match the requested model",
+				"requestedModel", requestedModel, "responseModel", responseModel.Str)
+			return "", fmt.Errorf("%w: requested %q, got %q", errModelMismatch, requestedModel, responseModel.Str)
+		}
+		switch r := dsResp.(type) {
+		case *forwarder.UnaryResponse:
+			if _, err := verify(string(r.Body)); err != nil {
+				return nil, err
+			}
+		case *forwarder.StreamingResponse:
+			if strings.Contains(r.Header.Get("Content-Type"), "event-stream") {
+				r.Body = forwarder.NewRawMutatingReader(verify).Reader(r.Body)
+			}
+		}
+		return dsResp, nil
+	}
+}
+
+// modelsMatch reports whether the model of a response matches the requested model.
+// Backends may normalize model names, so the comparison is case-insensitive and ignores
+// an organization prefix, e.g., "openai/gpt-oss-120b" matches "gpt-oss-120b".
+func modelsMatch(requested, got string) bool {
+	normalize := func(model string) string {
+		model = strings.TrimSpace(model)
+		if i := strings.LastIndex(model, "/"); i >= 0 {
+			model = model[i+1:]
+		}
+		return strings.ToLower(model)
+	}
+	return normalize(requested) == normalize(got)
+}
+
 func modelFromRequest(req *http.Request) (string, error) {
 	type modelRequest struct {
 		Model string `json:"model"`
@@ -208,37 +879,131 @@ func (s *Server) chatRequestHandler(
 	plainReqFields, plainRespFields forwarder.FieldSelector,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.inferenceHandler(
+		if s.allowModelHeader && !s.applyModelHeader(w, r) {
+			return
+		}
+		if s.maxPromptChars > 0 && !s.validatePromptLength(w, r) {
+			return
+		}
+		if s.maxTools > 0 && !s.validateToolCount(w, r) {
+			return
+		}
+		cacheMutator := s.promptCacheMutator(r)
+		handle := s.inferenceHandler(
 			func(cw *RenewableRequestCipher) forwarder.RequestMutator {
 				return forwarder.RequestMutatorChain(
-					mutators.ShardKeyInjector(s.defaultCacheSalt, s.log), // we don't want a shard key for random cache salts, so we inject before
-					openai.CacheSaltInjector(func() string {
-						if s.defaultCacheSalt == "" {
-							return openai.RandomPromptCacheSalt()
-						}
-						return s.defaultCacheSalt
-					}, s.log),
+					cacheMutator,
+					s.modelPrefixMutator(),
+					// inject defaults before encryption so they end up in the same plain/encrypted bucket as client-set fields
+					mutators.ModelDefaultsInjector(s.modelDefaults, s.requestLog),
 					mutators.ModelHeaderInjector(modelFromRequest),
-					forwarder.WithJSONRequestMutation(cw.Encrypt, plainReqFields, s.log),
+					openai.ResponseFormatValidator(s.requestLog), // response_format is sent in plaintext
+					forwarder.WithJSONRequestMutation(cw.Encrypt, plainReqFields, s.requestLog),
 				)
 			},
 			func(cw *RenewableRequestCipher) forwarder.ResponseMapper {
 				return forwarder.JSONResponseMapper(cw.DecryptResponse, plainRespFields)
 			},
-		)(w, r)
+		)
+		if s.idempotencyCache != nil && r.Header.Get(idempotencyKeyHeader) != "" {
+			s.idempotent(w, r, handle)
+			return
+		}
+		handle(w, r)
 	}
 }
 
+// promptCacheMutator returns the mutator setting the shard key and cache salt of r.
+func (s *Server) promptCacheMutator(r *http.Request) forwarder.RequestMutator {
+	if s.disablePromptCache {
+		// The API requires a cache salt, so a random salt is the signal not to cache.
+		return openai.RandomCacheSaltInjector(s.requestLog)
+	}
+	defaultCacheSalt := s.defaultCacheSaltFor(r)
+	return forwarder.RequestMutatorChain(
+		s.clientCacheSaltValidator(),
+		mutators.ShardKeyInjector(defaultCacheSalt, s.shardKeyWarnFraction, s.requestLog), // we don't want a shard key for random cache salts, so we inject before
+		openai.CacheSaltInjector(func() string {
+			if defaultCacheSalt == "" {
+				return openai.RandomPromptCacheSalt()
+			}
+			return defaultCacheSalt
+		}, s.requestLog),
+	)
+}
+
+// clientCacheSaltValidator returns the mutator rejecting requests without a valid cache salt
+// with status 400 if [Opts.RequireClientCacheSalt] is set.
+func (s *Server) clientCacheSaltValidator() forwarder.RequestMutator {
+	if !s.requireClientCacheSalt {
+		return forwarder.NoRequestMutation
+	}
+	validate := openai.CacheSaltValidator(s.requestLog)
+	return func(r *http.Request) error {
+		if err := validate(r); err != nil {
+			return &forwarder.StatusError{
+				StatusCode: http.StatusBadRequest,
+				Err:        fmt.Errorf("this proxy requires requests to set 'cache_salt': %w", err),
+			}
+		}
+		return nil
+	}
+}
+
+// defaultCacheSaltFor returns the cache salt for r if the request body doesn't set one.
+// An empty string means that a random salt is used.
+func (s *Server) defaultCacheSaltFor(r *http.Request) string {
+	if !s.cacheSaltPerAPIKey || s.defaultCacheSalt == "" {
+		return s.defaultCacheSalt
+	}
+	// The API key configured in the proxy takes precedence, see [Server.setStaticRequestHeaders].
+	var apiKey string
+	if s.apiKey != nil {
+		apiKey = *s.apiKey
+	} else {
+		apiKey, _ = auth.GetAuth(auth.Bearer, r.Header)
+	}
+	if apiKey == "" {
+		// Don't share a cache between unauthenticated requests.
+		return ""
+	}
+	return deriveCacheSalt(s.defaultCacheSalt, apiKey)
+}
+
+// deriveCacheSalt derives the cache salt for apiKey.
+// The server secret ensures that the salt can't be derived from the API key alone.
+func deriveCacheSalt(serverSecret, apiKey string) string {
+	mac := hmac.New(sha256.New, []byte(serverSecret))
+	mac.Write([]byte(apiKey))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// embeddingsHandler handles embeddings requests. Streaming (SSE) responses are decrypted per event,
+// so the data of each event is decrypted on its own.
+// Clients that accept [ndjsonContentType] receive one embedding object per line instead.
 func (s *Server) embeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.allowModelHeader && !s.applyModelHeader(w, r) {
+		return
+	}
+	ndjson := acceptsNDJSON(r)
 	s.inferenceHandler(
 		func(cw *RenewableRequestCipher) forwarder.RequestMutator {
-			return forwarder.RequestMutatorChain(
+			mutatorChain := []forwarder.RequestMutator{
+				s.modelPrefixMutator(),
 				mutators.ModelHeaderInjector(modelFromRequest),
-				forwarder.WithJSONRequestMutation(cw.Encrypt, openai.PlainEmbeddingsRequestFields, s.log),
-			)
+				forwarder.WithJSONRequestMutation(cw.Encrypt, openai.PlainEmbeddingsRequestFields, s.requestLog),
+			}
+			if ndjson {
+				mutatorChain = append(mutatorChain, removeAcceptHeader)
+			}
+			return forwarder.RequestMutatorChain(mutatorChain...)
 		},
 		func(cw *RenewableRequestCipher) forwarder.ResponseMapper {
-			return forwarder.JSONResponseMapper(cw.DecryptResponse, openai.PlainEmbeddingsResponseFields)
+			mapper := forwarder.JSONResponseMapper(cw.DecryptResponse, openai.PlainEmbeddingsResponseFields)
+			if ndjson {
+				mapper = ndjsonEmbeddingsMapper(mapper)
+			}
+			return mapper
 		},
 	)(w, r)
 }
@@ -267,7 +1032,7 @@ func (s *Server) transcriptionsHandler(w http.ResponseWriter, r *http.Request) {
 		func(cw *RenewableRequestCipher) forwarder.RequestMutator {
 			return forwarder.RequestMutatorChain(
 				mutators.ModelHeaderInjector(modelExtractor),
-				forwarder.WithFormRequestMutation(cw.Encrypt, openai.PlainTranscriptionRequestFields, s.log),
+				forwarder.WithLowMemoryFormRequestMutation(cw.Encrypt, openai.PlainTranscriptionRequestFields, s.requestLog),
 			)
 		},
 		func(cw *RenewableRequestCipher) forwarder.ResponseMapper {
@@ -277,28 +1042,96 @@ func (s *Server) transcriptionsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) unstructuredHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.validateUnstructuredContentType(w, r) {
+		return
+	}
 	s.inferenceHandler(
 		func(cw *RenewableRequestCipher) forwarder.RequestMutator {
-			return forwarder.WithRawRequestMutation(cw.Encrypt, s.log)
+			return forwarder.WithRawRequestMutation(cw.Encrypt, s.requestLog)
 		},
 		func(cw *RenewableRequestCipher) forwarder.ResponseMapper {
-			// currently only json response mutation is supported
-			return forwarder.JSONResponseMapper(cw.DecryptResponse, nil)
+			// JSON responses are decrypted per field, other responses (e.g., text/plain or CSV) as a whole
+			return forwarder.ContentTypeResponseMapper(cw.DecryptResponse, nil)
 		},
 	)(w, r)
 }
 
 func (s *Server) noEncryptionHandler(w http.ResponseWriter, r *http.Request) {
 	s.setStaticRequestHeaders(r)
-	r.Header.Set(requestid.UserHeader, newRequestID())
+	r.Header.Set(requestid.UserHeader, s.requestIDFor(r))
 
+	responseMapper := forwarder.PassthroughResponseMapper
+	if s.modelPrefixStrip != "" {
+		responseMapper = s.modelsResponseMapper
+	}
 	s.forwarder.Forward(
 		w, r,
-		forwarder.NoRequestMutation,
-		forwarder.PassthroughResponseMapper,
+		s.filterClientHeaders,
+		responseMapper,
+		s.forwardOpts()...,
 	)
 }
 
+// modelPrefixMutator returns the mutator removing [Opts.ModelPrefixStrip] from the model of a request.
+func (s *Server) modelPrefixMutator() forwarder.RequestMutator {
+	if s.modelPrefixStrip == "" {
+		return forwarder.NoRequestMutation
+	}
+	return mutators.ModelPrefixStripper(s.modelPrefixStrip, s.requestLog)
+}
+
+// modelsResponseMapper adds [Opts.ModelPrefixStrip] to the model IDs listed by [openai.ModelsEndpoint],
+// so clients can use the listed IDs in their requests.
+func (s *Server) modelsResponseMapper(resp *http.Response) (forwarder.Response, error) {
+	r, err := forwarder.ReadUnaryResponseWithHeaders(resp, constants.MaxUnaryResponseBodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream response body: %w", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		return r, nil
+	}
+	for i, id := range gjson.GetBytes(r.Body, "data.#.id").Array() {
+		r.Body, err = sjson.SetBytes(r.Body, fmt.Sprintf("data.%d.id", i), s.modelPrefixStrip+id.String())
+		if err != nil {
+			return nil, fmt.Errorf("adding model prefix: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// forwardOpts returns the [forwarder.Opts] applied to all requests forwarded to the API.
+func (s *Server) forwardOpts() []forwarder.Opts {
+	opts := []forwarder.Opts{
+		forwarder.WithMaxResponseBytes(s.maxResponseBytes),
+		forwarder.WithStreamHeartbeat(s.streamHeartbeatInterval),
+		forwarder.WithStreamBufferSize(s.streamBufferSize),
+		forwarder.WithActiveStreams(activeStreamsMetric),
+	}
+	if s.requestTimeout > 0 {
+		opts = append(opts, forwarder.WithRequestTimeout(s.requestTimeout))
+	}
+	if s.retryBudget > 0 {
+		opts = append(opts, forwarder.WithRetryBudget(s.retryBudget))
+	}
+	if s.maxRetryAfter > 0 {
+		opts = append(opts, forwarder.WithMaxRetryAfter(s.maxRetryAfter))
+	}
+	if s.retryMetrics {
+		opts = append(opts, forwarder.WithRetryCounter(retriesMetric))
+	}
+	// Streams failing verification must not end silently, so they always use error events.
+	if s.streamErrorEvents || s.verifyDecryptedResponse {
+		opts = append(opts, forwarder.WithStreamErrorEvents())
+	}
+	if s.stripForwardedFor {
+		opts = append(opts, forwarder.WithStripForwardedHeaders())
+	}
+	if s.forwardTrailers {
+		opts = append(opts, forwarder.WithTrailers())
+	}
+	return opts
+}
+
 func (s *Server) getClientHeader() string {
 	if s.isApp {
 		return constants.PrivatemodeClientApp
@@ -310,6 +1143,10 @@ func (s *Server) getClientHeader() string {
 // setStaticRequestHeaders sets static headers for the request. These are the header values
 // that are guaranteed to be immutable over a request's lifetime.
 func (s *Server) setStaticRequestHeaders(r *http.Request) {
+	// Set extra headers first, so they can't override the headers set by the proxy.
+	for key, values := range s.extraHeaders {
+		r.Header[http.CanonicalHeaderKey(key)] = slices.Clone(values)
+	}
 	if s.apiKey != nil {
 		r.Header.Set("Authorization", fmt.Sprintf("%s %s", auth.Bearer, *s.apiKey))
 	}
@@ -319,26 +1156,30 @@ func (s *Server) setStaticRequestHeaders(r *http.Request) {
 	r.Header.Set(constants.PrivatemodeClientHeader, s.getClientHeader())
 }
 
-// setDynamicHeaders sets the dynamic headers for the request.
-func (s *Server) setDynamicHeaders(r *http.Request, secret secretmanager.Secret, requestID string, attempt int) error {
-	ocspAllowedStatuses := []ocspheader.AllowStatus{ocspheader.AllowStatusGood}
-	if s.nvidiaOCSPRevokedGracePeriod > 0 {
-		// In theory, we could always add the `revoked` status, since it will render
-		// ineffective if the grace period is 0, but it might look strange to the user
-		// to find a `revoked` status in the policy header, so we only add it if the
-		// grace period is set.
-		ocspAllowedStatuses = append(ocspAllowedStatuses, ocspheader.AllowStatusRevoked)
-	}
-	if s.nvidiaOCSPAllowUnknown {
-		ocspAllowedStatuses = append(ocspAllowedStatuses, ocspheader.AllowStatusUnknown)
+// validateSecret rejects the request if the secret obtained for rc can't be used as MAC key.
+// A secret wrapping [ocspheader.ErrSecretTooShort] was issued by the API and results in a 502
+// response with an "invalid inference secret" message; any other error is a bug in the proxy
+// and results in a 500 response. Otherwise, the request would fail with an unspecific error when
+// setting the headers of the upstream request. Returns false if the request was rejected.
+func (s *Server) validateSecret(w http.ResponseWriter, r *http.Request, rc *RenewableRequestCipher) bool {
+	_, err := rc.GetMACKey()
+	switch {
+	case errors.Is(err, ocspheader.ErrSecretTooShort):
+		secretID, _ := rc.GetSecretID()
+		s.log.Error("Inference secret is too short", "secretID", secretID, "error", err)
+		forwarder.HTTPError(w, r, http.StatusBadGateway, "invalid inference secret: %s", err)
+		return false
+	case err != nil:
+		forwarder.HTTPError(w, r, http.StatusInternalServerError, "getting exchange secret: %s", err)
+		return false
 	}
+	return true
+}
 
-	if len(secret.Data) < 32 {
-		return fmt.Errorf("secret data too short: got %d bytes, need at least 32", len(secret.Data))
-	}
+// setDynamicHeaders sets the dynamic headers for the request.
+func (s *Server) setDynamicHeaders(r *http.Request, secretID string, macKey [32]byte, requestID string, attempt int) error {
 	ocspPolicyHeader, ocspMACHeader, err := getOcspHeaders(
-		ocspAllowedStatuses, time.Now().Add(-s.nvidiaOCSPRevokedGracePeriod),
-		[32]byte(secret.Data[:32]),
+		s.ocspAllowedStatuses(), time.Now().Add(-s.nvidiaOCSPRevokedGracePeriod), macKey,
 	)
 	if err != nil {
 		return fmt.Errorf("generating OCSP headers: %w", err)
@@ -346,11 +1187,157 @@ func (s *Server) setDynamicHeaders(r *http.Request, secret secretmanager.Secret,
 
 	r.Header.Set(constants.PrivatemodeNvidiaOCSPPolicyHeader, ocspPolicyHeader)
 	r.Header.Set(constants.PrivatemodeNvidiaOCSPPolicyMACHeader, ocspMACHeader)
-	r.Header.Set(constants.PrivatemodeSecretIDHeader, secret.ID)
+	r.Header.Set(constants.PrivatemodeSecretIDHeader, secretID)
 	r.Header.Set(requestid.UserHeader, fmt.Sprintf("%s_%d", requestID, attempt))
 	return nil
 }
 
+// validateStrictJSON rejects request bodies with duplicate top-level keys with a 400 response.
+// Only one of the values would be encrypted, so a duplicate key could be used to send plaintext
+// data to the API. The body is validated regardless of its Content-Type header, as it is
+// processed as JSON on the JSON endpoints either way. Returns false if the request was rejected.
+func (s *Server) validateStrictJSON(w http.ResponseWriter, r *http.Request) bool {
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "reading request body: %s", err)
+		return false
+	}
+	if key, ok := forwarder.FindDuplicateTopLevelKey(body); ok {
+		s.log.Warn("Rejecting request with duplicate JSON key", "key", key)
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "duplicate key %q in request body", key)
+		return false
+	}
+	return true
+}
+
+// validatePromptLength rejects requests whose prompt exceeds s.maxPromptChars characters.
+// The prompt is measured the same way it is assembled for the shard key, so structured content
+// such as messages and tools is counted including its JSON encoding.
+// It returns false if the request was rejected.
+func (s *Server) validatePromptLength(w http.ResponseWriter, r *http.Request) bool {
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "reading request body: %s", err)
+		return false
+	}
+	if chars := utf8.RuneCountInString(mutators.PromptContent(string(body))); chars > s.maxPromptChars {
+		s.log.Warn("Rejecting request with too long prompt", "chars", chars, "maxPromptChars", s.maxPromptChars)
+		forwarder.HTTPError(w, r, http.StatusRequestEntityTooLarge, "prompt too long: %d characters exceed the limit of %d", chars, s.maxPromptChars)
+		return false
+	}
+	return true
+}
+
+// applyModelHeader sets the model of r to the value of the [ModelHeader] header if the body doesn't
+// specify one. This is done before any other processing, so the model is, e.g., part of the key for
+// coalescing requests. It returns false if the request was rejected.
+func (s *Server) applyModelHeader(w http.ResponseWriter, r *http.Request) bool {
+	if err := mutators.ModelHeaderDefaulter(ModelHeader, s.requestLog)(r); err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "setting model from %s header: %s", ModelHeader, err)
+		return false
+	}
+	return true
+}
+
+// validateToolCount rejects requests with more than s.maxTools tools.
+// Tools are encrypted and part of the shard key, so large tool definitions bloat both.
+// It returns false if the request was rejected.
+func (s *Server) validateToolCount(w http.ResponseWriter, r *http.Request) bool {
+	body, err := persist.ReadBodyUnlimited(r)
+	if err != nil {
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "reading request body: %s", err)
+		return false
+	}
+	tools := gjson.GetBytes(body, "tools")
+	if !tools.IsArray() {
+		return true
+	}
+	if count := len(tools.Array()); count > s.maxTools {
+		s.log.Warn("Rejecting request with too many tools", "tools", count, "maxTools", s.maxTools)
+		forwarder.HTTPError(w, r, http.StatusBadRequest, "too many tools: %d tools exceed the limit of %d", count, s.maxTools)
+		return false
+	}
+	return true
+}
+
+// validateUnstructuredContentType rejects requests to the Unstructured API whose content type
+// isn't listed in s.unstructuredContentTypes. Requests without a body are always accepted.
+func (s *Server) validateUnstructuredContentType(w http.ResponseWriter, r *http.Request) bool {
+	hasBody := r.ContentLength != 0 || (r.Body != nil && r.Body != http.NoBody)
+	if len(s.unstructuredContentTypes) == 0 || !hasBody {
+		return true
+	}
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && slices.ContainsFunc(s.unstructuredContentTypes, func(allowed string) bool {
+		allowedType, _, _ := mime.ParseMediaType(allowed)
+		return allowedType == mediaType
+	}) {
+		return true
+	}
+	s.requestLog.Info("Rejecting Unstructured request with disallowed content type", "contentType", contentType)
+	forwarder.HTTPError(w, r, http.StatusUnsupportedMediaType, "unsupported content type %q, supported content types: %s",
+		contentType, strings.Join(s.unstructuredContentTypes, ", "))
+	return false
+}
+
+// limitHeaderSize shortens the shard key header if the combined size of the request headers
+// exceeds the configured limit. Upstream proxies, e.g., nginx, reject requests with large
+// headers, which can happen for large contexts in combination with the OCSP policy headers.
+// Shortening the shard key only reduces the cache routing precision for the tail of the prompt.
+// If the headers still exceed the limit and rejectLargeHeaders is set, a [*forwarder.StatusError]
+// is returned, so the request is rejected with a clear error instead of an opaque one from an upstream proxy.
+func (s *Server) limitHeaderSize(r *http.Request) error {
+	if s.maxHeaderBytes <= 0 {
+		return nil
+	}
+	size := headerSize(r.Header)
+	if size <= s.maxHeaderBytes {
+		return nil
+	}
+
+	shardKey := r.Header.Get(constants.PrivatemodeShardKeyHeader)
+	// The first part of the shard key is the cache salt hash, followed by '-' and one char per cache block.
+	minLen := min(len(shardKey), constants.CacheSaltHashLength)
+	excess := size - s.maxHeaderBytes
+	newLen := max(len(shardKey)-excess, minLen)
+	if newLen <= constants.CacheSaltHashLength+1 {
+		// Drop the trailing separator if no blocks are left.
+		newLen = minLen
+	}
+
+	if newLen < len(shardKey) {
+		r.Header.Set(constants.PrivatemodeShardKeyHeader, shardKey[:newLen])
+		s.log.Warn("Request headers exceed size limit, shortened shard key",
+			"headerBytes", size, "maxHeaderBytes", s.maxHeaderBytes,
+			"shardKeyLength", len(shardKey), "newShardKeyLength", newLen,
+		)
+	}
+	if size := headerSize(r.Header); size > s.maxHeaderBytes {
+		if !s.rejectLargeHeaders {
+			s.log.Warn("Request headers exceed size limit", "headerBytes", size, "maxHeaderBytes", s.maxHeaderBytes)
+			return nil
+		}
+		return &forwarder.StatusError{
+			StatusCode: http.StatusRequestHeaderFieldsTooLarge,
+			Err: fmt.Errorf("the request headers sent to the API are %d bytes and exceed the limit of %d bytes: "+
+				"send fewer or smaller headers, or increase the limit with the proxy's maxHeaderBytes setting", size, s.maxHeaderBytes),
+		}
+	}
+	return nil
+}
+
+// headerSize returns the size of the headers as sent on the wire in HTTP/1.1.
+func headerSize(h http.Header) int {
+	var size int
+	for name, values := range h {
+		for _, v := range values {
+			size += len(name) + len(": ") + len(v) + len("\r\n")
+		}
+	}
+	return size
+}
+
 func (s *Server) connectionResetCallback(
 	ctx context.Context, rc *RenewableRequestCipher,
 ) (bool, time.Duration) {
@@ -364,6 +1351,23 @@ func (s *Server) connectionResetCallback(
 	return true, 50 * time.Millisecond
 }
 
+// overloadInitialBackoff is the delay before the first retry of a request the API answered with
+// 429 or 503 without a Retry-After header. It doubles with each attempt.
+const overloadInitialBackoff = 500 * time.Millisecond
+
+// overloadCallback retries a request the API answered with 429 or 503, see [Opts.OverloadRetries].
+// The forwarder replaces the returned delay with the one of a Retry-After header, if any.
+func (s *Server) overloadCallback(
+	ctx context.Context, rc *RenewableRequestCipher, attempt int,
+) (bool, time.Duration) {
+	// Force a new rc for the next attempt, but keep the same secret
+	if err := rc.Reinitialize(ctx); err != nil {
+		s.log.Error("Resetting request cipher", "error", err)
+		return false, 0
+	}
+	return true, overloadInitialBackoff << min(attempt-1, 6)
+}
+
 func (s *Server) noSecretForIDCallback(
 	ctx context.Context, rc *RenewableRequestCipher,
 ) (bool, time.Duration) {
@@ -396,8 +1400,28 @@ func getOcspHeaders(allowedStatuses []ocspheader.AllowStatus, revocNbf time.Time
 	return policyHeader, policyMACHeader, nil
 }
 
+// requestIDFor returns the ID used to identify r towards the API.
+// If preserveClientRequestID is set, a request ID supplied by the client is kept with the proxy's
+// prefix. Otherwise, a new ID is generated. Client supplied IDs are logged at debug level in both cases
+// to allow correlating them with the API's request ID.
+func (s *Server) requestIDFor(r *http.Request) string {
+	clientRequestID := requestid.FromClientHeaders(r)
+	if clientRequestID == requestid.Unknown {
+		return newRequestID()
+	}
+
+	var requestID string
+	if s.preserveClientRequestID {
+		requestID = requestIDPrefix + clientRequestID
+	} else {
+		requestID = newRequestID()
+	}
+	s.log.Debug("Client supplied a request ID", "clientRequestID", clientRequestID, "requestID", requestID, "preserved", s.preserveClientRequestID)
+	return requestID
+}
+
 func newRequestID() string {
-	return "proxy_" + requestid.New()
+	return requestIDPrefix + requestid.New()
 }
 
 // unmarshalJSONBody uses [persist.ReadBodyUnlimited] to read r's body and then unmarshals it.
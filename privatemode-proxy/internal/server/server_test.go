@@ -4,23 +4,33 @@ package server
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/edgelesssys/continuum/internal/oss/anthropic"
 	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/mutators"
 	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
 	"github.com/edgelesssys/continuum/internal/oss/openai"
 	"github.com/edgelesssys/continuum/internal/oss/requestid"
@@ -28,6 +38,7 @@ import (
 	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 )
 
 const (
@@ -209,6 +220,219 @@ func TestChatCompletionsPromptEncryption(t *testing.T) {
 	}
 }
 
+func TestExposeShardKey(t *testing.T) {
+	const cacheSalt = "0123456789abcdef0123456789abcdef"
+	hash := sha256.Sum256([]byte(cacheSalt))
+	saltShardKey := hex.EncodeToString(hash[:8])
+
+	testCases := map[string]struct {
+		exposeShardKey   bool
+		proxyCacheSalt   string
+		requestCacheSalt string
+		wantShardKey     string
+	}{
+		"explicit request salt": {
+			exposeShardKey:   true,
+			requestCacheSalt: cacheSalt,
+			wantShardKey:     saltShardKey,
+		},
+		"proxy salt": {
+			exposeShardKey: true,
+			proxyCacheSalt: cacheSalt,
+			wantShardKey:   saltShardKey,
+		},
+		"random salt": {
+			exposeShardKey: true,
+			wantShardKey:   ShardKeyRandom,
+		},
+		"not exposed": {
+			requestCacheSalt: cacheSalt,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), tc.proxyCacheSalt, false)
+			sut.exposeShardKey = tc.exposeShardKey
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, tc.requestCacheSalt)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, tc.wantShardKey, resp.Header().Get(constants.PrivatemodeShardKeyHeader))
+		})
+	}
+}
+
+func TestForwardTrailers(t *testing.T) {
+	testCases := map[string]struct {
+		forwardTrailers bool
+		wantTrailer     string
+	}{
+		"trailers forwarded": {
+			forwardTrailers: true,
+			wantTrailer:     "42",
+		},
+		"trailers not forwarded": {},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "Usage-Tokens")
+				echo.ServeHTTP(w, r)
+				w.Header().Set("Usage-Tokens", "42")
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.forwardTrailers = tc.forwardTrailers
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			req.Header.Set("TE", "trailers")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, tc.wantTrailer, resp.Result().Trailer.Get("Usage-Tokens"))
+		})
+	}
+}
+
+func TestEchoUpstreamRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		echoUpstreamRequestID bool
+		upstreamRequestID     string
+		wantRequestID         string
+	}{
+		"upstream request ID": {
+			echoUpstreamRequestID: true,
+			upstreamRequestID:     "0198c9a2-7f3e-7b1c-9d4e-5a6b7c8d9e0f",
+			wantRequestID:         "0198c9a2-7f3e-7b1c-9d4e-5a6b7c8d9e0f",
+		},
+		"no upstream request ID": {
+			echoUpstreamRequestID: true,
+		},
+		"not echoed": {
+			upstreamRequestID: "0198c9a2-7f3e-7b1c-9d4e-5a6b7c8d9e0f",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			echoHandler := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.upstreamRequestID != "" {
+					w.Header().Set(requestid.Header, tc.upstreamRequestID)
+				}
+				echoHandler.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.echoUpstreamRequestID = tc.echoUpstreamRequestID
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, tc.wantRequestID, resp.Header().Get(UpstreamRequestIDHeader))
+		})
+	}
+}
+
+func TestEncryptedHeader(t *testing.T) {
+	const customHeader = "X-Backend-Encrypted"
+
+	testCases := map[string]struct {
+		encryptedHeader    string
+		plaintextByDefault bool
+		upstreamHeaders    map[string]string
+		wantDecrypted      bool
+	}{
+		"default convention": {
+			wantDecrypted: true,
+		},
+		"custom header, encrypted by default": {
+			encryptedHeader: customHeader,
+			wantDecrypted:   true,
+		},
+		"custom header, plaintext by default": {
+			encryptedHeader:    customHeader,
+			plaintextByDefault: true,
+		},
+		"custom header marks response as encrypted": {
+			encryptedHeader:    customHeader,
+			plaintextByDefault: true,
+			upstreamHeaders:    map[string]string{customHeader: "true"},
+			wantDecrypted:      true,
+		},
+		"standard header marks response as encrypted": {
+			plaintextByDefault: true,
+			upstreamHeaders:    map[string]string{forwarder.EncryptedHeader: "true"},
+			wantDecrypted:      true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			echoHandler := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tc.upstreamHeaders {
+					w.Header().Set(key, value)
+				}
+				echoHandler.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.encryptedHeader = tc.encryptedHeader
+			sut.plaintextByDefault = tc.plaintextByDefault
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, tc.wantDecrypted, strings.Contains(resp.Body.String(), "Echo: Hello"), resp.Body.String())
+		})
+	}
+}
+
 func TestInvalidSecretRetry(t *testing.T) {
 	require := require.New(t)
 	assert := assert.New(t)
@@ -249,6 +473,7 @@ func TestInvalidSecretRetry(t *testing.T) {
 		sm:                           &stubSecretManager{secrets: []secretmanager.Secret{secretInvalid, secretValid}},
 		forwarder:                    forwarder.New(http.DefaultClient, stubAuthBackendServer.Listener.Addr().String(), forwarder.SchemeHTTP, slog.Default()),
 		log:                          slog.Default(),
+		requestLog:                   slog.Default(),
 		isApp:                        false,
 		nvidiaOCSPAllowUnknown:       true,
 		nvidiaOCSPRevokedGracePeriod: 24 * time.Hour,
@@ -282,6 +507,107 @@ func TestInvalidSecretRetry(t *testing.T) {
 	)
 }
 
+func TestOverloadRetries(t *testing.T) {
+	testCases := map[string]struct {
+		overloadRetries int
+		maxRetryAfter   time.Duration
+		statusCode      int
+		retryAfter      string
+		failures        int
+		wantCode        int
+		wantCalls       int
+		wantMinElapsed  time.Duration
+	}{
+		"retries disabled": {
+			statusCode: http.StatusServiceUnavailable,
+			retryAfter: "0",
+			failures:   1,
+			wantCode:   http.StatusServiceUnavailable,
+			wantCalls:  1,
+		},
+		"503 retried": {
+			overloadRetries: 2,
+			statusCode:      http.StatusServiceUnavailable,
+			retryAfter:      "0",
+			failures:        2,
+			wantCode:        http.StatusOK,
+			wantCalls:       3,
+		},
+		"429 retried": {
+			overloadRetries: 1,
+			statusCode:      http.StatusTooManyRequests,
+			retryAfter:      "0",
+			failures:        1,
+			wantCode:        http.StatusOK,
+			wantCalls:       2,
+		},
+		"retries exhausted": {
+			overloadRetries: 1,
+			statusCode:      http.StatusServiceUnavailable,
+			retryAfter:      "0",
+			failures:        3,
+			wantCode:        http.StatusServiceUnavailable,
+			wantCalls:       2,
+		},
+		"Retry-After capped": {
+			overloadRetries: 1,
+			maxRetryAfter:   200 * time.Millisecond,
+			statusCode:      http.StatusServiceUnavailable,
+			retryAfter:      "3600",
+			failures:        1,
+			wantCode:        http.StatusOK,
+			wantCalls:       2,
+			wantMinElapsed:  200 * time.Millisecond,
+		},
+		"other status not retried": {
+			overloadRetries: 2,
+			statusCode:      http.StatusBadGateway,
+			failures:        1,
+			wantCode:        http.StatusBadGateway,
+			wantCalls:       1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var calls atomic.Int32
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(calls.Add(1)) <= tc.failures {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					forwarder.HTTPError(w, r, tc.statusCode, "overloaded")
+					return
+				}
+				stub.EchoHandler(secret.Map(), slog.Default()).ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.overloadRetries = tc.overloadRetries
+			sut.maxRetryAfter = tc.maxRetryAfter
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			start := time.Now()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			assert.Equal(tc.wantCode, resp.Code, resp.Body.String())
+			assert.Equal(tc.wantCalls, int(calls.Load()))
+			assert.GreaterOrEqual(time.Since(start), tc.wantMinElapsed)
+			assert.Less(time.Since(start), tc.wantMinElapsed+5*time.Second)
+		})
+	}
+}
+
 func TestTools(t *testing.T) {
 	strPtr := func(s string) *string { return &s }
 
@@ -499,6 +825,151 @@ func TestUnstructuredEncrypted(t *testing.T) {
 	}
 }
 
+func TestUnstructuredResponseContentType(t *testing.T) {
+	secret := secretmanager.Secret{
+		ID:   "456",
+		Data: bytes.Repeat([]byte{0x24}, 32),
+	}
+
+	testCases := map[string]struct {
+		contentType string
+		// encryptBody encrypts the plain response body like the inference-proxy does for the content type
+		encryptBody func(encrypt forwarder.MutationFunc, body []byte) ([]byte, error)
+		body        string
+	}{
+		"text/plain": {
+			contentType: "text/plain; charset=utf-8",
+			encryptBody: func(encrypt forwarder.MutationFunc, body []byte) ([]byte, error) {
+				encrypted, err := encrypt(string(body))
+				return []byte(encrypted), err
+			},
+			body: "Title: some content\nNarrativeText: more content",
+		},
+		"application/json": {
+			contentType: "application/json",
+			encryptBody: func(encrypt forwarder.MutationFunc, body []byte) ([]byte, error) {
+				return forwarder.MutateJSONFields(body, encrypt, nil)
+			},
+			body: `{"type":"Title","text":"some content"}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			stubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				if err := forwarder.WithRawRequestMutation(decrypt, slog.Default())(r); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				encrypted, err := tc.encryptBody(encrypt, []byte(tc.body))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", tc.contentType)
+				_, _ = w.Write(encrypted)
+			}))
+			defer stubServer.Close()
+
+			sut := newTestServer(nil, secret, stubServer.Listener.Addr().String(), "", false)
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/unstructured/general/v0/general", strings.NewReader(`{"testField":"test field"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(tc.contentType, resp.Header().Get("Content-Type"))
+			assert.Equal(tc.body, resp.Body.String())
+		})
+	}
+}
+
+func TestUnstructuredContentTypes(t *testing.T) {
+	secret := secretmanager.Secret{
+		ID:   "456",
+		Data: bytes.Repeat([]byte{0x24}, 32),
+	}
+
+	testCases := map[string]struct {
+		allowedContentTypes []string
+		method              string
+		body                string
+		contentType         string
+		wantCode            int
+	}{
+		"allowed content type": {
+			allowedContentTypes: []string{"application/pdf", "text/plain"},
+			method:              http.MethodPost,
+			body:                "some content",
+			contentType:         "text/plain; charset=utf-8",
+			wantCode:            http.StatusOK,
+		},
+		"disallowed content type": {
+			allowedContentTypes: []string{"application/pdf", "text/plain"},
+			method:              http.MethodPost,
+			body:                "some content",
+			contentType:         "application/zip",
+			wantCode:            http.StatusUnsupportedMediaType,
+		},
+		"missing content type": {
+			allowedContentTypes: []string{"application/pdf"},
+			method:              http.MethodPost,
+			body:                "some content",
+			wantCode:            http.StatusUnsupportedMediaType,
+		},
+		"request without body": {
+			allowedContentTypes: []string{"application/pdf"},
+			method:              http.MethodPost,
+			wantCode:            http.StatusOK,
+		},
+		"GET request with body": {
+			allowedContentTypes: []string{"application/pdf"},
+			method:              http.MethodGet,
+			body:                "some content",
+			contentType:         "application/zip",
+			wantCode:            http.StatusUnsupportedMediaType,
+		},
+		"no allowlist": {
+			method:      http.MethodPost,
+			body:        "some content",
+			contentType: "application/zip",
+			wantCode:    http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			stubServer := fullEncryptionStubServer(secret, func(*http.Request) (map[string]string, error) {
+				return map[string]string{"text": "some content"}, nil
+			})
+			defer stubServer.Close()
+
+			sut := newTestServer(nil, secret, stubServer.Listener.Addr().String(), "", false)
+			sut.unstructuredContentTypes = tc.allowedContentTypes
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req := httptest.NewRequestWithContext(t.Context(), tc.method, "/unstructured/general/v0/general", body)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantCode, resp.Code, resp.Body.String())
+		})
+	}
+}
+
 func TestGetOCSPHeaders(t *testing.T) {
 	testCases := map[string]struct {
 		OCSPAllowedStatuses []ocspheader.AllowStatus
@@ -533,136 +1004,1689 @@ func TestGetOCSPHeaders(t *testing.T) {
 }
 
 func TestSetDynamicHeaders(t *testing.T) {
-	testCases := map[string]struct {
-		secret  secretmanager.Secret
-		wantErr bool
-	}{
-		"ok": {
-			secret: secretmanager.Secret{
-				ID:   "123",
-				Data: bytes.Repeat([]byte{0x42}, 32),
-			},
-		},
-		"too short secret": {
-			secret: secretmanager.Secret{
-				ID:   "123",
-				Data: bytes.Repeat([]byte{0x42}, 8),
-			},
-			wantErr: true,
-		},
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
 	}
+	server := newTestServer(nil, secret, "", "", false)
 
-	for name, tc := range testCases {
-		t.Run(name, func(t *testing.T) {
-			require := require.New(t)
-			assert := assert.New(t)
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/test", nil)
+	requestID := newRequestID()
+	attempt := 1
+	err := server.setDynamicHeaders(req, secret.ID, [32]byte(secret.Data), requestID, attempt)
+	require.NoError(err)
+	assert.Equal(req.Header.Get(constants.PrivatemodeSecretIDHeader), secret.ID)
+	assert.NotEmpty(req.Header.Get(constants.PrivatemodeNvidiaOCSPPolicyHeader))
+	assert.NotEmpty(req.Header.Get(constants.PrivatemodeNvidiaOCSPPolicyMACHeader))
+	assert.Equal(req.Header.Get(requestid.UserHeader), fmt.Sprintf("%s_%d", requestID, attempt))
+}
 
-			server := newTestServer(nil, tc.secret, "", "", false)
+func TestEmbeddingsStreaming(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
 
-			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/test", nil)
-			requestID := newRequestID()
-			attempt := 1
-			err := server.setDynamicHeaders(req, tc.secret, requestID, attempt)
-			if tc.wantErr {
-				require.Error(err)
-			} else {
-				require.NoError(err)
-				assert.Equal(req.Header.Get(constants.PrivatemodeSecretIDHeader), tc.secret.ID)
-				assert.NotEmpty(req.Header.Get(constants.PrivatemodeNvidiaOCSPPolicyHeader))
-				assert.NotEmpty(req.Header.Get(constants.PrivatemodeNvidiaOCSPPolicyMACHeader))
-				assert.Equal(req.Header.Get(requestid.UserHeader), fmt.Sprintf("%s_%d", requestID, attempt))
-			}
-		})
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
 	}
-}
+	inputs := []string{"first", "second", "third"}
 
-func TestTargetModelHeader(t *testing.T) {
-	// Random string to check verbatim inclusion in header
-	randomModel := "Cu1pS7yT"
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+		if err := forwarder.WithJSONRequestMutation(decrypt, openai.PlainEmbeddingsRequestFields, slog.Default())(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var req openai.EmbeddingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Stream one embedding per event.
+		var events strings.Builder
+		for i, input := range req.Input {
+			fmt.Fprintf(&events, `data: {"id":"emb-1","object":"list","data":[{"object":"embedding","index":%d,"embedding":[%d.5],"input":%q}]}`+"\n\n", i, i, input)
+		}
+		events.WriteString(`data: {"id":"emb-1","object":"list","data":[],"usage":{"prompt_tokens":3,"total_tokens":3}}` + "\n\n")
+		events.WriteString("data: [DONE]\n\n")
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		encrypted := forwarder.NewJSONMutatingReader(encrypt, openai.PlainEmbeddingsResponseFields).
+			Reader(io.NopCloser(strings.NewReader(events.String())))
+		_, _ = io.Copy(w, encrypted)
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+	req := prepareJSONRequest(t.Context(), require, openai.EmbeddingsEndpoint, openai.EmbeddingsRequest{
+		EmbeddingsRequestPlainData: openai.EmbeddingsRequestPlainData{Model: "embed"},
+		Input:                      inputs,
+	})
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	var embeddings []string
+	var sawUsage, sawDone bool
+	for event := range strings.SplitSeq(strings.TrimSpace(resp.Body.String()), "\n\n") {
+		data, ok := strings.CutPrefix(event, "data: ")
+		require.True(ok, event)
+		if data == "[DONE]" {
+			sawDone = true
+			continue
+		}
+		var chunk struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+				Input     string    `json:"input"`
+			} `json:"data"`
+			Usage *struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		require.NoError(json.Unmarshal([]byte(data), &chunk), data)
+		if chunk.Usage != nil {
+			sawUsage = true
+			assert.Equal(3, chunk.Usage.TotalTokens)
+		}
+		for _, d := range chunk.Data {
+			assert.Equal([]float64{float64(d.Index) + 0.5}, d.Embedding)
+			embeddings = append(embeddings, d.Input)
+		}
+	}
+	assert.Equal(inputs, embeddings)
+	assert.True(sawUsage)
+	assert.True(sawDone)
+}
+
+func TestEmbeddingsNDJSON(t *testing.T) {
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	inputs := []string{"first", "second", "third"}
+	embedding := func(i int, input string) string {
+		return fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%d.5],"input":%q}`, i, i, input)
+	}
+
+	testCases := map[string]struct {
+		stream bool
+	}{
+		"unary":        {},
+		"event stream": {stream: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			var upstreamAccept string
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamAccept = r.Header.Get("Accept")
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				if err := forwarder.WithJSONRequestMutation(decrypt, openai.PlainEmbeddingsRequestFields, slog.Default())(r); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				var req openai.EmbeddingsRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				if !tc.stream {
+					data := make([]string, len(req.Input))
+					for i, input := range req.Input {
+						data[i] = embedding(i, input)
+					}
+					body := `{"id":"emb-1","object":"list","data":[` + strings.Join(data, ",") + `],"usage":{"prompt_tokens":3,"total_tokens":3}}`
+					encrypted, err := forwarder.MutateJSONFields([]byte(body), encrypt, openai.PlainEmbeddingsResponseFields)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write(encrypted)
+					return
+				}
+
+				var events strings.Builder
+				for i, input := range req.Input {
+					fmt.Fprintf(&events, `data: {"id":"emb-1","object":"list","data":[%s]}`+"\n\n", embedding(i, input))
+				}
+				events.WriteString(`data: {"id":"emb-1","object":"list","data":[],"usage":{"prompt_tokens":3,"total_tokens":3}}` + "\n\n")
+				events.WriteString("data: [DONE]\n\n")
+				w.Header().Set("Content-Type", "text/event-stream")
+				encrypted := forwarder.NewJSONMutatingReader(encrypt, openai.PlainEmbeddingsResponseFields).
+					Reader(io.NopCloser(strings.NewReader(events.String())))
+				_, _ = io.Copy(w, encrypted)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+			req := prepareJSONRequest(t.Context(), require, openai.EmbeddingsEndpoint, openai.EmbeddingsRequest{
+				EmbeddingsRequestPlainData: openai.EmbeddingsRequestPlainData{Model: "embed"},
+				Input:                      inputs,
+			})
+			req.Header.Set("Accept", "application/json;q=0.5, application/x-ndjson")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal("application/x-ndjson", resp.Header().Get("Content-Type"))
+			assert.Empty(upstreamAccept)
+
+			lines := strings.Split(strings.TrimSuffix(resp.Body.String(), "\n"), "\n")
+			require.Len(lines, len(inputs), resp.Body.String())
+			for i, line := range lines {
+				assert.JSONEq(embedding(i, inputs[i]), line)
+			}
+		})
+	}
+}
+
+func TestCacheSaltPerAPIKey(t *testing.T) {
+	const proxyCacheSalt = "p1234567890123456789012345678912"
+
+	t.Run("derive cache salt", func(t *testing.T) {
+		assert := assert.New(t)
+
+		salt := deriveCacheSalt(proxyCacheSalt, "key1")
+		assert.GreaterOrEqual(len(salt), 32)
+		assert.Equal(salt, deriveCacheSalt(proxyCacheSalt, "key1"))
+		assert.NotEqual(salt, deriveCacheSalt(proxyCacheSalt, "key2"))
+		assert.NotEqual(salt, deriveCacheSalt("q1234567890123456789012345678912", "key1"))
+	})
+
+	testCases := map[string]struct {
+		cacheSaltPerAPIKey bool
+		proxyCacheSalt     string
+		requestCacheSalt   string
+		apiKeys            [3]string
+		wantShared         [3]bool // whether the request shares the shard key with the first request
+		wantRandomSalt     bool
+	}{
+		"per API key": {
+			cacheSaltPerAPIKey: true,
+			proxyCacheSalt:     proxyCacheSalt,
+			apiKeys:            [3]string{"key1", "key1", "key2"},
+			wantShared:         [3]bool{true, true, false},
+		},
+		"shared between API keys": {
+			proxyCacheSalt: proxyCacheSalt,
+			apiKeys:        [3]string{"key1", "key1", "key2"},
+			wantShared:     [3]bool{true, true, true},
+		},
+		"request cache salt takes precedence": {
+			cacheSaltPerAPIKey: true,
+			proxyCacheSalt:     proxyCacheSalt,
+			requestCacheSalt:   "r1234567890123456789012345678912",
+			apiKeys:            [3]string{"key1", "key1", "key2"},
+			wantShared:         [3]bool{true, true, true},
+		},
+		"shared prompt cache disabled": {
+			cacheSaltPerAPIKey: true,
+			apiKeys:            [3]string{"key1", "key1", "key2"},
+			wantRandomSalt:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var shardKeys []string
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				shardKeys = append(shardKeys, r.Header.Get(constants.PrivatemodeShardKeyHeader))
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			sut := newTestServer(nil, secret, stubBackend.Listener.Addr().String(), tc.proxyCacheSalt, false)
+			sut.cacheSaltPerAPIKey = tc.cacheSaltPerAPIKey
+
+			for _, apiKey := range tc.apiKeys {
+				req := prepareChatRequest(t.Context(), require, "Hello", nil, tc.requestCacheSalt)
+				req.Header.Set("Authorization", "Bearer "+apiKey)
+				resp := httptest.NewRecorder()
+				sut.GetHandler().ServeHTTP(resp, req)
+				require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			}
+
+			require.Len(shardKeys, len(tc.apiKeys))
+			if tc.wantRandomSalt {
+				// No shard key is sent for random cache salts.
+				assert.Equal([]string{"", "", ""}, shardKeys)
+				return
+			}
+			for i, wantShared := range tc.wantShared {
+				if !wantShared {
+					assert.NotEqual(shardKeys[0], shardKeys[i], "request %d", i)
+					continue
+				}
+				assert.Equal(shardKeys[0], shardKeys[i], "request %d", i)
+			}
+		})
+	}
+}
+
+func TestRequireClientCacheSalt(t *testing.T) {
+	const (
+		proxyCacheSalt   = "p1234567890123456789012345678912"
+		requestCacheSalt = "r1234567890123456789012345678912"
+	)
+
+	testCases := map[string]struct {
+		requireClientCacheSalt bool
+		requestCacheSalt       string
+		wantCode               int
+		wantCacheSalt          string
+	}{
+		"required and set": {
+			requireClientCacheSalt: true,
+			requestCacheSalt:       requestCacheSalt,
+			wantCode:               http.StatusOK,
+			wantCacheSalt:          requestCacheSalt,
+		},
+		"required and missing": {
+			requireClientCacheSalt: true,
+			wantCode:               http.StatusBadRequest,
+		},
+		"required and too short": {
+			requireClientCacheSalt: true,
+			requestCacheSalt:       "short",
+			wantCode:               http.StatusBadRequest,
+		},
+		"not required and missing": {
+			wantCode:      http.StatusOK,
+			wantCacheSalt: proxyCacheSalt,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			backendCalled := false
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				backendCalled = true
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), proxyCacheSalt, false)
+			sut.requireClientCacheSalt = tc.requireClientCacheSalt
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, tc.requestCacheSalt)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(tc.wantCode, resp.Code, resp.Body.String())
+
+			if tc.wantCode != http.StatusOK {
+				assert.False(backendCalled)
+				assert.Contains(resp.Body.String(), "cache_salt")
+				return
+			}
+			assert.Equal(tc.wantCacheSalt, resp.Header().Get("Request-Cache-Salt"))
+		})
+	}
+}
+
+func TestDisablePromptCache(t *testing.T) {
+	const (
+		proxyCacheSalt   = "p1234567890123456789012345678912"
+		requestCacheSalt = "r1234567890123456789012345678912"
+	)
+
+	testCases := map[string]struct {
+		disablePromptCache bool
+		requestCacheSalt   string
+	}{
+		"enabled": {
+			requestCacheSalt: requestCacheSalt,
+		},
+		"disabled": {
+			disablePromptCache: true,
+		},
+		"disabled overrides request cache salt": {
+			disablePromptCache: true,
+			requestCacheSalt:   requestCacheSalt,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var shardKeys, cacheSalts []string
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				shardKeys = append(shardKeys, r.Header.Get(constants.PrivatemodeShardKeyHeader))
+
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				_, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				plainBody, err := forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+				var plainReq openai.ChatRequest
+				require.NoError(json.Unmarshal(plainBody, &plainReq))
+				cacheSalts = append(cacheSalts, plainReq.CacheSalt)
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer stubBackend.Close()
+
+			sut := newTestServer(nil, secret, stubBackend.Listener.Addr().String(), proxyCacheSalt, false)
+			sut.disablePromptCache = tc.disablePromptCache
+
+			for range 2 {
+				req := prepareChatRequest(t.Context(), require, "Hello", nil, tc.requestCacheSalt)
+				resp := httptest.NewRecorder()
+				sut.GetHandler().ServeHTTP(resp, req)
+				require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			}
+
+			require.Len(shardKeys, 2)
+			require.Len(cacheSalts, 2)
+			if !tc.disablePromptCache {
+				assert.NotEmpty(shardKeys[0])
+				assert.Equal(shardKeys[0], shardKeys[1])
+				assert.Equal([]string{requestCacheSalt, requestCacheSalt}, cacheSalts)
+				return
+			}
+			assert.Equal([]string{"", ""}, shardKeys)
+			for _, salt := range cacheSalts {
+				assert.GreaterOrEqual(len(salt), 32)
+				assert.NotEqual(requestCacheSalt, salt)
+				assert.NotEqual(proxyCacheSalt, salt)
+			}
+			assert.NotEqual(cacheSalts[0], cacheSalts[1])
+		})
+	}
+}
+
+func TestCompletionsPromptArray(t *testing.T) {
+	const cacheSalt = "p1234567890123456789012345678912"
+	// Prompts must be long enough to be part of the shard key.
+	longPrompt := strings.Repeat("Once upon a time ", 32)
+	tokens := make([]int, 64)
+	for i := range tokens {
+		tokens[i] = i
+	}
+
+	testCases := map[string]struct {
+		prompt          any
+		wantSameShard   bool
+		wantShardPrefix bool // the shard key of the plain prompt is a prefix
+	}{
+		"array with a single prompt": {
+			prompt:        []string{longPrompt},
+			wantSameShard: true,
+		},
+		"array with multiple prompts": {
+			prompt:          []string{longPrompt, strings.Repeat("the end ", 64)},
+			wantShardPrefix: true,
+		},
+		"tokens": {
+			prompt: tokens,
+		},
+		"token arrays": {
+			prompt: [][]int{tokens, tokens},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var shardKeys []string
+			var prompts []json.RawMessage
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				shardKeys = append(shardKeys, r.Header.Get(constants.PrivatemodeShardKeyHeader))
+
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				assert.False(gjson.GetBytes(body, "prompt").IsArray(), "prompt must be encrypted")
+				_, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				plainBody, err := forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+				prompts = append(prompts, json.RawMessage(gjson.GetBytes(plainBody, "prompt").Raw))
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer stubBackend.Close()
+
+			sut := newTestServer(nil, secret, stubBackend.Listener.Addr().String(), cacheSalt, false)
+
+			for _, prompt := range []any{longPrompt, tc.prompt} {
+				req := prepareJSONRequest(t.Context(), require, openai.LegacyCompletionsEndpoint, map[string]any{
+					"model":  "gpt-oss-120b",
+					"prompt": prompt,
+				})
+				resp := httptest.NewRecorder()
+				sut.GetHandler().ServeHTTP(resp, req)
+				require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			}
+
+			require.Len(prompts, 2)
+			wantPrompt, err := json.Marshal(tc.prompt)
+			require.NoError(err)
+			assert.JSONEq(string(wantPrompt), string(prompts[1]))
+
+			require.Len(shardKeys, 2)
+			assert.Contains(shardKeys[1], "-", "shard key must include the prompt")
+			if tc.wantSameShard {
+				assert.Equal(shardKeys[0], shardKeys[1])
+				return
+			}
+			assert.NotEqual(shardKeys[0], shardKeys[1])
+			assert.Equal(tc.wantShardPrefix, strings.HasPrefix(shardKeys[1], shardKeys[0]))
+		})
+	}
+}
+
+func TestShortSecret(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 16),
+	}
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		assert.Fail("request must not be forwarded")
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+	req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusBadGateway, resp.Code)
+	assert.Contains(resp.Body.String(), "invalid inference secret: secret data too short")
+}
+
+func TestValidateSecretUninitialized(t *testing.T) {
+	assert := assert.New(t)
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secretmanager.Secret{}, "", "", false)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, openai.ChatCompletionsEndpoint, nil)
+	resp := httptest.NewRecorder()
+	assert.False(sut.validateSecret(resp, req, &RenewableRequestCipher{}))
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.NotContains(resp.Body.String(), "invalid inference secret")
+}
+
+func TestLimitHeaderSize(t *testing.T) {
+	saltHash := "0123456789abcdef"
+	longShardKey := saltHash + "-" + string(bytes.Repeat([]byte{'A'}, 100))
+
+	newRequest := func(shardKey string) *http.Request {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/test", nil)
+		req.Header.Set(constants.PrivatemodeNvidiaOCSPPolicyHeader, "rules=allow-good; revocation-time-not-before=0")
+		if shardKey != "" {
+			req.Header.Set(constants.PrivatemodeShardKeyHeader, shardKey)
+		}
+		return req
+	}
+	sizeWith := func(shardKey string) int {
+		return headerSize(newRequest(shardKey).Header)
+	}
+
+	testCases := map[string]struct {
+		shardKey       string
+		maxHeaderBytes int
+		reject         bool
+		wantShardKey   string
+		wantErr        bool
+	}{
+		"limit disabled": {
+			shardKey:     longShardKey,
+			wantShardKey: longShardKey,
+		},
+		"exactly at limit": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(longShardKey),
+			wantShardKey:   longShardKey,
+		},
+		"one byte over limit": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(longShardKey) - 1,
+			wantShardKey:   longShardKey[:len(longShardKey)-1],
+		},
+		"only one block left": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(saltHash + "-A"),
+			wantShardKey:   saltHash + "-A",
+		},
+		"shortened to cache salt hash": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(saltHash),
+			wantShardKey:   saltHash,
+		},
+		"cache salt hash is never removed": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(saltHash) - 1,
+			wantShardKey:   saltHash,
+		},
+		"still over limit is rejected": {
+			shardKey:       longShardKey,
+			maxHeaderBytes: sizeWith(saltHash) - 1,
+			reject:         true,
+			wantShardKey:   saltHash,
+			wantErr:        true,
+		},
+		"no shard key at limit": {
+			maxHeaderBytes: sizeWith(""),
+			reject:         true,
+		},
+		"no shard key over limit": {
+			maxHeaderBytes: sizeWith("") - 1,
+		},
+		"no shard key over limit is rejected": {
+			maxHeaderBytes: sizeWith("") - 1,
+			reject:         true,
+			wantErr:        true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(nil, secretmanager.Secret{}, "", "", false)
+			server.maxHeaderBytes = tc.maxHeaderBytes
+			server.rejectLargeHeaders = tc.reject
+
+			req := newRequest(tc.shardKey)
+			err := server.limitHeaderSize(req)
+
+			assert.Equal(t, tc.wantShardKey, req.Header.Get(constants.PrivatemodeShardKeyHeader))
+			if !tc.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var statusErr *forwarder.StatusError
+			require.ErrorAs(t, err, &statusErr)
+			assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, statusErr.StatusCode)
+		})
+	}
+}
+
+func TestHeaderSizeExceeded(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	called := false
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.maxHeaderBytes = 1024
+	sut.rejectLargeHeaders = true
+	sut.extraHeaders = http.Header{"X-Large": {strings.Repeat("a", 1024)}}
+
+	req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+	resp := httptest.NewRecorder()
+	sut.GetHandler().ServeHTTP(resp, req)
+
+	assert.False(called, "request must not be sent to the API")
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, resp.Code)
+	assert.Contains(resp.Body.String(), "exceed the limit of 1024 bytes")
+	assert.Contains(resp.Body.String(), "maxHeaderBytes")
+}
+
+func TestTargetModelHeader(t *testing.T) {
+	// Random string to check verbatim inclusion in header
+	randomModel := "Cu1pS7yT"
+
+	testCases := map[string]struct {
+		buildRequest  func(t *testing.T, require *require.Assertions) *http.Request
+		expectedModel string
+	}{
+		"chat completions": {
+			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
+				return prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
+					ChatRequestPlainData: openai.ChatRequestPlainData{
+						Model: randomModel,
+					},
+					Messages: []openai.Message{
+						{
+							Role:    "user",
+							Content: "Hello",
+						},
+					},
+				})
+			},
+			expectedModel: randomModel,
+		},
+		"legacy (chat) completions": {
+			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
+				return prepareJSONRequest(t.Context(), require, openai.LegacyCompletionsEndpoint, openai.ChatRequest{
+					ChatRequestPlainData: openai.ChatRequestPlainData{
+						Model: randomModel,
+					},
+					Messages: []openai.Message{
+						{
+							Role:    "user",
+							Content: "Hello",
+						},
+					},
+				})
+			},
+			expectedModel: randomModel,
+		},
+		"embeddings": {
+			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
+				return prepareJSONRequest(t.Context(), require, openai.EmbeddingsEndpoint, openai.EmbeddingsRequest{
+					EmbeddingsRequestPlainData: openai.EmbeddingsRequestPlainData{
+						Model: randomModel,
+					},
+					Input: []string{"Hello"},
+				})
+			},
+			expectedModel: randomModel,
+		},
+		"transcriptions": {
+			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
+				return prepareMultiPartRequest(t.Context(), require, openai.TranscriptionsEndpoint, func(writer *multipart.Writer) error {
+					if err := writer.WriteField("model", randomModel); err != nil {
+						return err
+					}
+
+					part, err := writer.CreateFormFile("file", "audio.mp3")
+					if err != nil {
+						return err
+					}
+					fakeMP3 := []byte{0x49, 0x44, 0x33, 0x03, 0x00, 0x00} // Start of MP3 header
+					if _, err := part.Write(fakeMP3); err != nil {
+						return err
+					}
+
+					return nil
+				})
+			},
+			expectedModel: randomModel,
+		},
+		"anthropic messages": {
+			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
+				return prepareJSONRequest(t.Context(), require, anthropic.MessagesEndpoint, anthropic.MessagesRequest{
+					MessagesRequestPlainData: anthropic.MessagesRequestPlainData{
+						Model: randomModel,
+					},
+					Messages: []anthropic.Message{
+						{
+							Role:    "user",
+							Content: "Hello",
+						},
+					},
+				})
+			},
+			expectedModel: randomModel,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(tc.expectedModel, r.Header.Get(constants.PrivatemodeTargetModel))
+
+				stub.EchoHandler(secret.Map(), slog.Default()).ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+			req := tc.buildRequest(t, require)
+
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			assert.Equal(http.StatusOK, resp.Code)
+		})
+	}
+}
+
+func TestModelDefaults(t *testing.T) {
+	defaults := mutators.ModelDefaults{
+		"gpt-oss-120b": {
+			"temperature": json.RawMessage(`0.2`),
+			"max_tokens":  json.RawMessage(`512`),
+		},
+	}
+
+	testCases := map[string]struct {
+		model           string
+		clientParams    map[string]any
+		wantTemperature any
+		wantMaxTokens   any
+	}{
+		"defaults applied when absent": {
+			model:           "gpt-oss-120b",
+			wantTemperature: 0.2,
+			wantMaxTokens:   512.0,
+		},
+		"client values are not overridden": {
+			model:           "gpt-oss-120b",
+			clientParams:    map[string]any{"temperature": 0.9, "max_tokens": 64},
+			wantTemperature: 0.9,
+			wantMaxTokens:   64.0,
+		},
+		"explicit null is kept": {
+			model:           "gpt-oss-120b",
+			clientParams:    map[string]any{"temperature": nil},
+			wantTemperature: nil,
+			wantMaxTokens:   512.0,
+		},
+		"no defaults for other models": {
+			model: "other-model",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				var fields map[string]any
+				require.NoError(json.Unmarshal(body, &fields))
+
+				// max_tokens is a plain field, temperature must be encrypted
+				assert.Equal(tc.wantMaxTokens, fields["max_tokens"])
+				if temperature, ok := fields["temperature"]; ok {
+					assert.IsType("", temperature)
+				}
+
+				_, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				plainBody, err := forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+				var plainFields map[string]any
+				require.NoError(json.Unmarshal(plainBody, &plainFields))
+				assert.Equal(tc.wantTemperature, plainFields["temperature"])
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.modelDefaults = defaults
+
+			payload := map[string]any{
+				"model":    tc.model,
+				"messages": []map[string]any{{"role": "user", "content": "Hello"}},
+			}
+			maps.Copy(payload, tc.clientParams)
+			req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, payload)
+
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestLegacyCompletionsEncryption(t *testing.T) {
+	testCases := map[string]struct {
+		prompt any
+		suffix any
+	}{
+		"string prompt with suffix": {
+			prompt: "def add(a, b):",
+			suffix: "return result",
+		},
+		"prompt array with suffix": {
+			prompt: []any{"def add(a, b):", "def sub(a, b):"},
+			suffix: "return result",
+		},
+		"prompt without suffix": {
+			prompt: "def add(a, b):",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				assert.NotContains(string(body), "def add", "content must be encrypted")
+				assert.NotContains(string(body), "return result", "content must be encrypted")
+				for _, field := range []string{openai.CompletionsRequestPromptField, openai.CompletionsRequestSuffixField} {
+					if value := gjson.GetBytes(body, field); value.Exists() {
+						assert.Equal(gjson.String, value.Type, "%s must be encrypted", field)
+					}
+				}
+
+				_, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				plainBody, err := forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+				var plainFields map[string]any
+				require.NoError(json.Unmarshal(plainBody, &plainFields))
+				assert.Equal(tc.prompt, plainFields["prompt"])
+				assert.Equal(tc.suffix, plainFields["suffix"])
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+			payload := map[string]any{
+				"model":  "gpt-oss-120b",
+				"prompt": tc.prompt,
+			}
+			if tc.suffix != nil {
+				payload["suffix"] = tc.suffix
+			}
+			req := prepareJSONRequest(t.Context(), require, openai.LegacyCompletionsEndpoint, payload)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"name": map[string]any{"type": "string"}},
+		"required":   []any{"name"},
+	}
+
+	testCases := map[string]struct {
+		schema         map[string]any
+		wantStatusCode int
+	}{
+		"structural schema": {
+			schema:         schema,
+			wantStatusCode: http.StatusOK,
+		},
+		"schema with description": {
+			schema: map[string]any{
+				"type":        "object",
+				"description": "secret",
+			},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			responseFormat := map[string]any{
+				"type":        "json_schema",
+				"json_schema": map[string]any{"name": "person", "schema": tc.schema},
+			}
+
+			var forwarded bool
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				var fields map[string]any
+				require.NoError(json.Unmarshal(body, &fields))
+
+				// response_format is forwarded in plaintext, messages are encrypted
+				assert.Equal(responseFormat, fields["response_format"])
+				assert.IsType("", fields["messages"])
+
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				_, err = forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+
+				respBody, err := forwarder.MutateJSONFields(
+					[]byte(`{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"{\"name\":\"Alice\"}"}}]}`),
+					encrypt, openai.PlainCompletionsResponseFields,
+				)
+				require.NoError(err)
+				var respFields map[string]any
+				require.NoError(json.Unmarshal(respBody, &respFields))
+				assert.IsType("", respFields["choices"])
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write(respBody)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+			req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, map[string]any{
+				"model":           "gpt-oss-120b",
+				"messages":        []map[string]any{{"role": "user", "content": "Hello"}},
+				"response_format": responseFormat,
+			})
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			if tc.wantStatusCode != http.StatusOK {
+				assert.False(forwarded)
+				assert.Contains(resp.Body.String(), "description is not allowed")
+				return
+			}
+			assert.JSONEq(`{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"{\"name\":\"Alice\"}"}}]}`, resp.Body.String())
+		})
+	}
+}
+
+func TestClientRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		preserve      bool
+		clientHeaders map[string]string
+		wantRequestID func(t *testing.T, requestID string)
+	}{
+		"preserved from user header": {
+			preserve:      true,
+			clientHeaders: map[string]string{requestid.UserHeader: "trace-123"},
+			wantRequestID: func(t *testing.T, requestID string) {
+				assert.Equal(t, "proxy_trace-123_0", requestID)
+			},
+		},
+		"preserved from request ID header": {
+			preserve:      true,
+			clientHeaders: map[string]string{requestid.Header: "trace-456"},
+			wantRequestID: func(t *testing.T, requestID string) {
+				assert.Equal(t, "proxy_trace-456_0", requestID)
+			},
+		},
+		"preserved and sanitized": {
+			preserve:      true,
+			clientHeaders: map[string]string{requestid.UserHeader: "trace 789"},
+			wantRequestID: func(t *testing.T, requestID string) {
+				assert.Equal(t, "proxy_trace?789_0", requestID)
+			},
+		},
+		"overwritten if not preserved": {
+			clientHeaders: map[string]string{requestid.UserHeader: "trace-123"},
+			wantRequestID: func(t *testing.T, requestID string) {
+				assert.NotContains(t, requestID, "trace-123")
+				assert.True(t, strings.HasPrefix(requestID, "proxy_"))
+			},
+		},
+		"generated if client sent none": {
+			preserve: true,
+			wantRequestID: func(t *testing.T, requestID string) {
+				assert.True(t, strings.HasPrefix(requestID, "proxy_"))
+				assert.Len(t, requestID, len("proxy_")+36+len("_0"))
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.wantRequestID(t, r.Header.Get(requestid.UserHeader))
+				stub.EchoHandler(secret.Map(), slog.Default()).ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.preserveClientRequestID = tc.preserve
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			for key, value := range tc.clientHeaders {
+				req.Header.Set(key, value)
+			}
+
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	duplicateMessages := `{"model": "gpt-oss-120b", "messages": [{"role": "user", "content": "Hello"}], "messages": [{"role": "user", "content": "smuggled"}]}`
+
+	testCases := map[string]struct {
+		strictJSON     bool
+		endpoint       string
+		contentType    string
+		body           string
+		wantStatusCode int
+		wantForwarded  bool
+	}{
+		"duplicate key rejected": {
+			strictJSON:     true,
+			body:           duplicateMessages,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"duplicate key rejected with mixed case content type": {
+			strictJSON:     true,
+			contentType:    "Application/JSON; charset=utf-8",
+			body:           duplicateMessages,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"duplicate key rejected with other content type": {
+			strictJSON:     true,
+			contentType:    "text/plain",
+			body:           duplicateMessages,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"duplicate key rejected without content type": {
+			strictJSON:     true,
+			contentType:    "-",
+			body:           duplicateMessages,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"duplicate key rejected for embeddings": {
+			strictJSON:     true,
+			endpoint:       openai.EmbeddingsEndpoint,
+			body:           `{"model": "m", "input": "Hello", "input": "smuggled"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		"valid request accepted": {
+			strictJSON:     true,
+			body:           `{"model": "gpt-oss-120b", "messages": [{"role": "user", "content": "Hello"}]}`,
+			wantStatusCode: http.StatusOK,
+			wantForwarded:  true,
+		},
+		"duplicate key forwarded if not strict": {
+			body:          duplicateMessages,
+			wantForwarded: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+
+			forwarded := false
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				stub.EchoHandler(secret.Map(), slog.Default()).ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.strictJSON = tc.strictJSON
+
+			endpoint := cmp.Or(tc.endpoint, openai.ChatCompletionsEndpoint)
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, endpoint, strings.NewReader(tc.body))
+			switch tc.contentType {
+			case "":
+				req.Header.Set("Content-Type", "application/json")
+			case "-":
+			default:
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			if tc.wantStatusCode != 0 {
+				assert.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			}
+			assert.Equal(tc.wantForwarded, forwarded)
+			if tc.wantStatusCode == http.StatusBadRequest {
+				assert.Contains(resp.Body.String(), `duplicate key`)
+			}
+		})
+	}
+}
+
+func TestMaxPromptChars(t *testing.T) {
+	testCases := map[string]struct {
+		maxPromptChars int
+		prompt         string
+		wantStatusCode int
+	}{
+		"disabled": {
+			prompt:         strings.Repeat("a", 1000),
+			wantStatusCode: http.StatusOK,
+		},
+		"under limit": {
+			maxPromptChars: 100,
+			prompt:         "Hello",
+			wantStatusCode: http.StatusOK,
+		},
+		"over limit": {
+			maxPromptChars: 100,
+			prompt:         strings.Repeat("a", 100),
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		"multi-byte characters count once": {
+			maxPromptChars: 100,
+			prompt:         strings.Repeat("ä", 50),
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var forwarded bool
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.maxPromptChars = tc.maxPromptChars
+
+			req := prepareChatRequest(t.Context(), require, tc.prompt, nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			if tc.wantStatusCode == http.StatusOK {
+				assert.True(forwarded)
+				return
+			}
+			assert.False(forwarded)
+			assert.Contains(resp.Body.String(), "prompt too long")
+		})
+	}
+}
+
+func TestMaxTools(t *testing.T) {
+	tool := map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}
+
+	testCases := map[string]struct {
+		maxTools       int
+		tools          []any
+		wantStatusCode int
+	}{
+		"disabled": {
+			tools:          []any{tool, tool, tool},
+			wantStatusCode: http.StatusOK,
+		},
+		"no tools": {
+			maxTools:       2,
+			wantStatusCode: http.StatusOK,
+		},
+		"within limit": {
+			maxTools:       2,
+			tools:          []any{tool, tool},
+			wantStatusCode: http.StatusOK,
+		},
+		"over limit": {
+			maxTools:       2,
+			tools:          []any{tool, tool, tool},
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var forwarded bool
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.maxTools = tc.maxTools
+
+			req := prepareChatRequest(t.Context(), require, "Hello", tc.tools, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			if tc.wantStatusCode == http.StatusOK {
+				assert.True(forwarded)
+				return
+			}
+			assert.False(forwarded)
+			assert.Contains(resp.Body.String(), "too many tools")
+		})
+	}
+}
+
+func TestEnabledEndpoints(t *testing.T) {
+	testCases := map[string]struct {
+		enabledEndpoints       []string
+		disabledEndpointStatus int
+		wantChatStatus         int
+		wantEmbeddingsStatus   int
+		wantTranscriptStatus   int
+	}{
+		"all enabled by default": {
+			wantChatStatus:       http.StatusOK,
+			wantEmbeddingsStatus: http.StatusOK,
+			wantTranscriptStatus: http.StatusOK,
+		},
+		"transcriptions disabled with 404": {
+			enabledEndpoints:       []string{openai.ChatCompletionsEndpoint, openai.EmbeddingsEndpoint},
+			disabledEndpointStatus: http.StatusNotFound,
+			wantChatStatus:         http.StatusOK,
+			wantEmbeddingsStatus:   http.StatusOK,
+			wantTranscriptStatus:   http.StatusNotFound,
+		},
+		"only chat enabled with 403": {
+			enabledEndpoints:       []string{openai.ChatCompletionsEndpoint},
+			disabledEndpointStatus: http.StatusForbidden,
+			wantChatStatus:         http.StatusOK,
+			wantEmbeddingsStatus:   http.StatusForbidden,
+			wantTranscriptStatus:   http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.enabledEndpoints = tc.enabledEndpoints
+			sut.disabledEndpointStatus = tc.disabledEndpointStatus
+			handler := sut.GetHandler()
+
+			requests := map[*http.Request]int{
+				prepareChatRequest(t.Context(), require, "Hello", nil, ""): tc.wantChatStatus,
+				prepareJSONRequest(t.Context(), require, openai.EmbeddingsEndpoint, openai.EmbeddingsRequest{
+					EmbeddingsRequestPlainData: openai.EmbeddingsRequestPlainData{Model: "embed"},
+					Input:                      []string{"Hello"},
+				}): tc.wantEmbeddingsStatus,
+				prepareMultiPartRequest(t.Context(), require, openai.TranscriptionsEndpoint, func(writer *multipart.Writer) error {
+					if err := writer.WriteField("model", "whisper"); err != nil {
+						return err
+					}
+					part, err := writer.CreateFormFile("file", "audio.mp3")
+					if err != nil {
+						return err
+					}
+					_, err = part.Write([]byte{0x49, 0x44, 0x33, 0x03, 0x00, 0x00})
+					return err
+				}): tc.wantTranscriptStatus,
+			}
+
+			for req, wantStatus := range requests {
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, req)
+				assert.Equal(wantStatus, resp.Code, "%s: %s", req.URL.Path, resp.Body.String())
+				if wantStatus != http.StatusOK {
+					assert.Contains(resp.Body.String(), "is disabled")
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyDecryptedResponse(t *testing.T) {
+	const validChunk = `{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Hi"}}]}`
+	const invalidChunk = `{"id":"chatcmpl-1","choices":[{"index":0,"delta":"tampered"}]}`
+
+	testCases := map[string]struct {
+		verify         bool
+		stream         bool
+		respBody       string
+		wantStatusCode int
+		wantBody       string
+		wantNotInBody  string
+		wantErrorEvent bool
+	}{
+		"valid response": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"}}]}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hi"`,
+		},
+		"choices not an array": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":"tampered"}`,
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       "decrypted response failed integrity check",
+		},
+		"message not an object": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":[{"index":0,"message":"tampered"}]}`,
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       "decrypted response failed integrity check",
+		},
+		"multiple choices": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"content":"Hi"}},{"index":1,"message":{"content":"Hey"}}]}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hey"`,
+		},
+		"duplicate choice index": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"content":"Hi"}},{"index":0,"message":{"content":"Hey"}}]}`,
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       "decrypted response failed integrity check",
+		},
+		"invalid choice index": {
+			verify:         true,
+			respBody:       `{"id":"chatcmpl-1","choices":[{"index":"0","message":{"content":"Hi"}}]}`,
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       "decrypted response failed integrity check",
+		},
+		"invalid response without verification": {
+			respBody:       `{"id":"chatcmpl-1","choices":"tampered"}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"choices":"tampered"`,
+		},
+		"valid stream": {
+			verify:         true,
+			stream:         true,
+			respBody:       "data: " + validChunk + "\n\ndata: [DONE]\n\n",
+			wantStatusCode: http.StatusOK,
+			wantBody:       "data: [DONE]",
+		},
+		"invalid stream event": {
+			verify:         true,
+			stream:         true,
+			respBody:       "data: " + validChunk + "\n\ndata: " + invalidChunk + "\n\ndata: [DONE]\n\n",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hi"`,
+			wantNotInBody:  "tampered",
+			wantErrorEvent: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				_, err = forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+
+				if !tc.stream {
+					// The upstream encrypts the tampered fields with valid ciphertexts.
+					respBody, err := forwarder.MutateJSONFields([]byte(tc.respBody), encrypt, openai.PlainCompletionsResponseFields)
+					require.NoError(err)
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write(respBody)
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				respBody, err := io.ReadAll(forwarder.NewJSONMutatingReader(encrypt, openai.PlainCompletionsResponseFields).
+					Reader(io.NopCloser(strings.NewReader(tc.respBody))))
+				require.NoError(err)
+				_, _ = w.Write(respBody)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.verifyDecryptedResponse = tc.verify
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			assert.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			assert.Contains(resp.Body.String(), tc.wantBody)
+			if tc.wantNotInBody != "" {
+				assert.NotContains(resp.Body.String(), tc.wantNotInBody)
+			}
+			if tc.wantErrorEvent {
+				_, eventData, ok := strings.Cut(resp.Body.String(), "event: "+forwarder.StreamErrorEvent+"\ndata: ")
+				require.True(ok, resp.Body.String())
+				var data forwarder.StreamErrorEventData
+				require.NoError(json.NewDecoder(strings.NewReader(eventData)).Decode(&data))
+				assert.Contains(data.Error.Message, "decrypted response failed integrity check")
+				assert.False(data.Retriable)
+				assert.NotContains(resp.Body.String(), "data: [DONE]")
+			} else {
+				assert.NotContains(resp.Body.String(), forwarder.StreamErrorEvent)
+			}
+		})
+	}
+}
+
+func TestModelsMatch(t *testing.T) {
+	testCases := map[string]struct {
+		requested string
+		got       string
+		wantMatch bool
+	}{
+		"equal":                      {requested: "gpt-oss-120b", got: "gpt-oss-120b", wantMatch: true},
+		"different case":             {requested: "gpt-oss-120b", got: "GPT-OSS-120B", wantMatch: true},
+		"organization prefix":        {requested: "gpt-oss-120b", got: "openai/gpt-oss-120b", wantMatch: true},
+		"requested with prefix":      {requested: "openai/gpt-oss-120b", got: "gpt-oss-120b", wantMatch: true},
+		"different model":            {requested: "gpt-oss-120b", got: "gemma-3-27b"},
+		"different size":             {requested: "gpt-oss-120b", got: "gpt-oss-20b"},
+		"requested is prefix of got": {requested: "gpt-oss", got: "gpt-oss-120b"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.wantMatch, modelsMatch(tc.requested, tc.got))
+		})
+	}
+}
+
+func TestVerifyModelMatch(t *testing.T) {
+	const requestedModel = "gpt-oss-120b" // set by prepareChatRequest
 
 	testCases := map[string]struct {
-		buildRequest  func(t *testing.T, require *require.Assertions) *http.Request
-		expectedModel string
+		verify         bool
+		stream         bool
+		responseModel  string
+		wantStatusCode int
+		wantBody       string
+		wantNotInBody  string
 	}{
-		"chat completions": {
-			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
-				return prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
-					ChatRequestPlainData: openai.ChatRequestPlainData{
-						Model: randomModel,
-					},
-					Messages: []openai.Message{
-						{
-							Role:    "user",
-							Content: "Hello",
-						},
-					},
-				})
-			},
-			expectedModel: randomModel,
+		"matching model": {
+			verify:         true,
+			responseModel:  requestedModel,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hi"`,
 		},
-		"legacy (chat) completions": {
-			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
-				return prepareJSONRequest(t.Context(), require, openai.LegacyCompletionsEndpoint, openai.ChatRequest{
-					ChatRequestPlainData: openai.ChatRequestPlainData{
-						Model: randomModel,
-					},
-					Messages: []openai.Message{
-						{
-							Role:    "user",
-							Content: "Hello",
-						},
-					},
-				})
-			},
-			expectedModel: randomModel,
+		"normalized model": {
+			verify:         true,
+			responseModel:  "openai/GPT-OSS-120B",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hi"`,
 		},
-		"embeddings": {
-			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
-				return prepareJSONRequest(t.Context(), require, openai.EmbeddingsEndpoint, openai.EmbeddingsRequest{
-					EmbeddingsRequestPlainData: openai.EmbeddingsRequestPlainData{
-						Model: randomModel,
-					},
-					Input: []string{"Hello"},
-				})
-			},
-			expectedModel: randomModel,
+		"mismatched model": {
+			verify:         true,
+			responseModel:  "gemma-3-27b",
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       "response model doesn't match the requested model",
+			wantNotInBody:  `"content":"Hi"`,
 		},
-		"transcriptions": {
-			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
-				return prepareMultiPartRequest(t.Context(), require, openai.TranscriptionsEndpoint, func(writer *multipart.Writer) error {
-					if err := writer.WriteField("model", randomModel); err != nil {
-						return err
-					}
+		"mismatched model without verification": {
+			responseModel:  "gemma-3-27b",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `"content":"Hi"`,
+		},
+		"matching model in stream": {
+			verify:         true,
+			stream:         true,
+			responseModel:  requestedModel,
+			wantStatusCode: http.StatusOK,
+			wantBody:       "data: [DONE]",
+		},
+		"mismatched model in stream": {
+			verify:         true,
+			stream:         true,
+			responseModel:  "gemma-3-27b",
+			wantStatusCode: http.StatusOK,
+			wantNotInBody:  `"content":"Hi"`,
+		},
+	}
 
-					part, err := writer.CreateFormFile("file", "audio.mp3")
-					if err != nil {
-						return err
-					}
-					fakeMP3 := []byte{0x49, 0x44, 0x33, 0x03, 0x00, 0x00} // Start of MP3 header
-					if _, err := part.Write(fakeMP3); err != nil {
-						return err
-					}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
 
-					return nil
-				})
-			},
-			expectedModel: randomModel,
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				_, err = forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+
+				if !tc.stream {
+					respBody := fmt.Sprintf(`{"id":"chatcmpl-1","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"}}]}`, tc.responseModel)
+					encrypted, err := forwarder.MutateJSONFields([]byte(respBody), encrypt, openai.PlainCompletionsResponseFields)
+					require.NoError(err)
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write(encrypted)
+					return
+				}
+				events := fmt.Sprintf(`data: {"id":"chatcmpl-1","model":%q,"choices":[{"index":0,"delta":{"content":"Hi"}}]}`, tc.responseModel) +
+					"\n\ndata: [DONE]\n\n"
+				w.Header().Set("Content-Type", "text/event-stream")
+				encrypted, err := io.ReadAll(forwarder.NewJSONMutatingReader(encrypt, openai.PlainCompletionsResponseFields).
+					Reader(io.NopCloser(strings.NewReader(events))))
+				require.NoError(err)
+				_, _ = w.Write(encrypted)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.verifyModelMatch = tc.verify
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			assert.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			assert.Contains(resp.Body.String(), tc.wantBody)
+			if tc.wantNotInBody != "" {
+				assert.NotContains(resp.Body.String(), tc.wantNotInBody)
+			}
+		})
+	}
+}
+
+func TestQuietRequestLogs(t *testing.T) {
+	testCases := map[string]struct {
+		quiet        bool
+		wantInfoLogs bool
+	}{
+		"default": {
+			wantInfoLogs: true,
 		},
-		"anthropic messages": {
-			buildRequest: func(t *testing.T, require *require.Assertions) *http.Request {
-				return prepareJSONRequest(t.Context(), require, anthropic.MessagesEndpoint, anthropic.MessagesRequest{
-					MessagesRequestPlainData: anthropic.MessagesRequestPlainData{
-						Model: randomModel,
-					},
-					Messages: []anthropic.Message{
-						{
-							Role:    "user",
-							Content: "Hello",
-						},
-					},
-				})
-			},
-			expectedModel: randomModel,
+		"quiet": {
+			quiet: true,
 		},
 	}
 
@@ -675,22 +2699,454 @@ func TestTargetModelHeader(t *testing.T) {
 				ID:   "123",
 				Data: bytes.Repeat([]byte{0x42}, 32),
 			}
-
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
 			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				assert.Equal(tc.expectedModel, r.Header.Get(constants.PrivatemodeTargetModel))
+				if r.Header.Get("X-Fail") != "" {
+					forwarder.HTTPError(w, r, http.StatusBadRequest, "bad request")
+					return
+				}
+				echo.ServeHTTP(w, r)
+			}))
+			defer stubBackend.Close()
 
-				stub.EchoHandler(secret.Map(), slog.Default()).ServeHTTP(w, r)
+			var logs bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+			apiKey := testAPIKey
+			sut, err := New(http.DefaultClient, &stubSecretManager{secrets: []secretmanager.Secret{secret}}, Opts{
+				APIEndpoint:            stubBackend.Listener.Addr().String(),
+				ProtocolScheme:         forwarder.SchemeHTTP,
+				APIKey:                 &apiKey,
+				NvidiaOCSPAllowUnknown: true,
+				QuietRequestLogs:       tc.quiet,
+			}, log)
+			require.NoError(err)
+			handler := sut.GetHandler()
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+			req = prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			req.Header.Set("X-Fail", "true")
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(http.StatusBadRequest, resp.Code, resp.Body.String())
+
+			assert.Equal(tc.wantInfoLogs, strings.Contains(logs.String(), "Forwarding request"))
+			assert.Equal(tc.wantInfoLogs, strings.Contains(logs.String(), "Forwarding finished successfully"))
+			assert.Contains(logs.String(), "level=WARN msg=\"Upstream returned an error status code\"", "warnings must be logged in quiet mode")
+		})
+	}
+}
+
+func TestModelPrefixStrip(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	var models []string
+	echo := stub.EchoHandler(secret.Map(), slog.Default())
+	stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == openai.ModelsEndpoint {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-oss-120b","object":"model"},{"id":"qwen3-embedding-4b","object":"model"}]}`))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(err)
+		models = append(models, gjson.GetBytes(body, "model").String())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		echo.ServeHTTP(w, r)
+	}))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+	sut.modelPrefixStrip = "privatemode/"
+	handler := sut.GetHandler()
+
+	for _, model := range []string{"privatemode/gpt-oss-120b", "gpt-oss-120b"} {
+		req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
+			ChatRequestPlainData: openai.ChatRequestPlainData{Model: model},
+			Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+		})
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	}
+	assert.Equal([]string{"gpt-oss-120b", "gpt-oss-120b"}, models)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, openai.ModelsEndpoint, nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(`{"object":"list","data":[{"id":"privatemode/gpt-oss-120b","object":"model"},{"id":"privatemode/qwen3-embedding-4b","object":"model"}]}`, resp.Body.String())
+}
+
+func TestAllowModelHeader(t *testing.T) {
+	testCases := map[string]struct {
+		allowModelHeader bool
+		bodyModel        string
+		headerModel      string
+		wantModel        string
+		wantOK           bool
+	}{
+		"header model without body model": {
+			allowModelHeader: true,
+			headerModel:      "gpt-oss-120b",
+			wantModel:        "gpt-oss-120b",
+			wantOK:           true,
+		},
+		"body model takes precedence": {
+			allowModelHeader: true,
+			bodyModel:        "qwen3-coder",
+			headerModel:      "gpt-oss-120b",
+			wantModel:        "qwen3-coder",
+			wantOK:           true,
+		},
+		"header ignored if not allowed": {
+			headerModel: "gpt-oss-120b",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			var model, modelHeader string
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				model = gjson.GetBytes(body, "model").String()
+				modelHeader = r.Header.Get(ModelHeader)
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				echo.ServeHTTP(w, r)
 			}))
 			defer stubBackend.Close()
 
 			apiKey := testAPIKey
 			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.allowModelHeader = tc.allowModelHeader
 
-			req := tc.buildRequest(t, require)
+			req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
+				ChatRequestPlainData: openai.ChatRequestPlainData{Model: tc.bodyModel},
+				Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+			})
+			req.Header.Set(ModelHeader, tc.headerModel)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			if !tc.wantOK {
+				assert.NotEqual(http.StatusOK, resp.Code)
+				assert.Contains(resp.Body.String(), "no model specified")
+				return
+			}
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(tc.wantModel, model)
+			assert.Empty(modelHeader)
+		})
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+	defer stubBackend.Close()
+
+	apiKey := testAPIKey
+	sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+
+	socketPath := filepath.Join(t.TempDir(), "proxy.sock")
+	lis, err := net.Listen("unix", socketPath)
+	require.NoError(err)
+	ctx, cancel := context.WithCancel(t.Context())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- sut.Serve(ctx, lis, nil) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+	resp, err := client.Do(req)
+	require.NoError(err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(http.StatusOK, resp.StatusCode, string(body))
+
+	var chatResp openai.ChatResponse
+	require.NoError(json.Unmarshal(body, &chatResp))
+	require.Len(chatResp.Choices, 1)
+	assert.Equal("Echo: Hello", chatResp.Choices[0].Message.Content)
+
+	cancel()
+	require.NoError(<-serveErr)
+	_, err = os.Lstat(socketPath)
+	assert.ErrorIs(err, fs.ErrNotExist, "socket file must be removed on shutdown")
+}
+
+func TestMultipleChoices(t *testing.T) {
+	const n = 3
+	secret := secretmanager.Secret{
+		ID:   "123",
+		Data: bytes.Repeat([]byte{0x42}, 32),
+	}
+	chatRequest := func(stream bool) openai.ChatRequest {
+		return openai.ChatRequest{
+			ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b", N: n, Stream: stream},
+			Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+		}
+	}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		stubBackend := httptest.NewServer(stub.EchoHandler(secret.Map(), slog.Default()))
+		defer stubBackend.Close()
+
+		apiKey := testAPIKey
+		sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+		sut.verifyDecryptedResponse = true
+
+		req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, chatRequest(false))
+		resp := httptest.NewRecorder()
+		sut.GetHandler().ServeHTTP(resp, req)
+		require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+		var chatResp openai.ChatResponse
+		require.NoError(json.Unmarshal(resp.Body.Bytes(), &chatResp))
+		require.Len(chatResp.Choices, n)
+		for i, choice := range chatResp.Choices {
+			assert.Equal(i, choice.Index)
+			assert.Equal("Echo: Hello", choice.Message.Content)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		// vLLM interleaves the deltas of the choices, each event carries a single choice.
+		deltas := []struct {
+			index   int
+			content string
+		}{{0, "A"}, {2, "C"}, {1, "B"}, {2, "c"}, {0, "a"}, {1, "b"}}
+		var events strings.Builder
+		for _, delta := range deltas {
+			fmt.Fprintf(&events, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":%d,\"delta\":{\"content\":%q}}]}\n\n", delta.index, delta.content)
+		}
+		events.WriteString("data: [DONE]\n\n")
+
+		stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+			body, err := io.ReadAll(r.Body)
+			require.NoError(err)
+			_, err = forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+			require.NoError(err)
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			respBody, err := io.ReadAll(forwarder.NewJSONMutatingReader(encrypt, openai.PlainCompletionsResponseFields).
+				Reader(io.NopCloser(strings.NewReader(events.String()))))
+			require.NoError(err)
+			assert.NotContains(string(respBody), `"delta"`, "choices must be encrypted")
+			_, _ = w.Write(respBody)
+		}))
+		defer stubBackend.Close()
+
+		apiKey := testAPIKey
+		sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+		sut.verifyDecryptedResponse = true
+
+		req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, chatRequest(true))
+		resp := httptest.NewRecorder()
+		sut.GetHandler().ServeHTTP(resp, req)
+		require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+		contents := map[int]string{}
+		for line := range strings.Lines(resp.Body.String()) {
+			data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+			if !ok || data == "[DONE]" {
+				continue
+			}
+			var chunk struct {
+				Choices []struct {
+					Index int `json:"index"`
+					Delta struct {
+						Content string `json:"content"`
+					} `json:"delta"`
+				} `json:"choices"`
+			}
+			require.NoError(json.Unmarshal([]byte(data), &chunk), data)
+			require.Len(chunk.Choices, 1)
+			contents[chunk.Choices[0].Index] += chunk.Choices[0].Delta.Content
+		}
+		assert.Equal(map[int]string{0: "Aa", 1: "Bb", 2: "Cc"}, contents)
+	})
+}
+
+func TestEndpointMethods(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		path           string
+		wantStatusCode int
+	}{
+		"GET chat completions": {
+			method:         http.MethodGet,
+			path:           openai.ChatCompletionsEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"PUT legacy completions": {
+			method:         http.MethodPut,
+			path:           openai.LegacyCompletionsEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET embeddings": {
+			method:         http.MethodGet,
+			path:           openai.EmbeddingsEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET transcriptions": {
+			method:         http.MethodGet,
+			path:           openai.TranscriptionsEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET messages": {
+			method:         http.MethodGet,
+			path:           anthropic.MessagesEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET batch": {
+			method:         http.MethodGet,
+			path:           ChatCompletionsBatchEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET prewarm": {
+			method:         http.MethodGet,
+			path:           PrewarmEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"POST models": {
+			method:         http.MethodPost,
+			path:           openai.ModelsEndpoint,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		"GET models": {
+			method:         http.MethodGet,
+			path:           openai.ModelsEndpoint,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var forwarded bool
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				forwarded = true
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"object":"list","data":[]}`))
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 32)}, stubBackend.Listener.Addr().String(), "", false)
+			sut.maxBatchSize = 1
+			sut.adminToken = "admin-token"
 
+			req := httptest.NewRequestWithContext(t.Context(), tc.method, tc.path, nil)
 			resp := httptest.NewRecorder()
 			sut.GetHandler().ServeHTTP(resp, req)
-			assert.Equal(http.StatusOK, resp.Code)
+
+			assert.Equal(tc.wantStatusCode, resp.Code, resp.Body.String())
+			assert.Equal(tc.wantStatusCode == http.StatusOK, forwarded)
+			if tc.wantStatusCode == http.StatusMethodNotAllowed {
+				assert.NotEmpty(resp.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	testCases := map[string]struct {
+		maxBatchSize int
+		adminToken   string
+		wantRoutes   []route
+	}{
+		"default": {
+			wantRoutes: []route{
+				{http.MethodPost, openai.ChatCompletionsEndpoint},
+				{http.MethodPost, openai.LegacyCompletionsEndpoint},
+				{"", unstructuredEndpoint},
+				{http.MethodGet, openai.ModelsEndpoint},
+				{http.MethodPost, openai.EmbeddingsEndpoint},
+				{http.MethodPost, openai.TranscriptionsEndpoint},
+				{http.MethodPost, anthropic.MessagesEndpoint},
+				{http.MethodGet, AttestationEndpoint},
+			},
+		},
+		"batch and admin endpoints": {
+			maxBatchSize: 1,
+			adminToken:   "admin-token",
+			wantRoutes: []route{
+				{http.MethodPost, openai.ChatCompletionsEndpoint},
+				{http.MethodPost, openai.LegacyCompletionsEndpoint},
+				{"", unstructuredEndpoint},
+				{http.MethodGet, openai.ModelsEndpoint},
+				{http.MethodPost, openai.EmbeddingsEndpoint},
+				{http.MethodPost, openai.TranscriptionsEndpoint},
+				{http.MethodPost, anthropic.MessagesEndpoint},
+				{http.MethodPost, ChatCompletionsBatchEndpoint},
+				{http.MethodGet, AttestationEndpoint},
+				{http.MethodPost, PrewarmEndpoint},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 32)}, "", "", false)
+			sut.maxBatchSize = tc.maxBatchSize
+			sut.adminToken = tc.adminToken
+
+			_, routes := sut.newMux()
+			assert.Equal(tc.wantRoutes, routes)
+			assert.NoError(sut.CheckRoutes())
+
+			// all selectable endpoints are registered, unless they are optional
+			for _, endpoint := range Endpoints() {
+				if endpoint == ChatCompletionsBatchEndpoint && tc.maxBatchSize == 0 {
+					continue
+				}
+				assert.True(slices.ContainsFunc(routes, func(r route) bool { return r.endpoint == endpoint }), endpoint)
+			}
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, unknownEndpoint, nil)
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			assert.Equal(http.StatusNotFound, resp.Code)
 		})
 	}
 }
@@ -703,9 +3159,11 @@ func newTestServer(apiKey *string, secret secretmanager.Secret, backendAddr stri
 		sm:                           &stubSecretManager{secrets: []secretmanager.Secret{secret}},
 		forwarder:                    forwarder.New(http.DefaultClient, backendAddr, forwarder.SchemeHTTP, slog.Default()),
 		log:                          slog.Default(),
+		requestLog:                   slog.Default(),
 		isApp:                        isApp,
 		nvidiaOCSPAllowUnknown:       true,
 		nvidiaOCSPRevokedGracePeriod: time.Hour * 24,
+		maxBatchBodyBytes:            constants.MaxFileSizeBytes,
 	}
 }
 
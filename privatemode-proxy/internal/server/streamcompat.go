@@ -0,0 +1,73 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+)
+
+// skipStreamDoneMapper wraps next and removes the final "data: [DONE]" event from event streams.
+// This is for older clients that parse the data of every event as JSON. Other responses are returned unchanged.
+func skipStreamDoneMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := dsResp.(*forwarder.StreamingResponse)
+		if !ok || !strings.Contains(r.Header.Get("Content-Type"), "event-stream") {
+			return dsResp, nil
+		}
+		r.Body = &skipStreamDoneReader{reader: bufio.NewReader(r.Body), closer: r.Body}
+		return r, nil
+	}
+}
+
+// skipStreamDoneReader reads an event stream without "[DONE]" events.
+type skipStreamDoneReader struct {
+	reader    *bufio.Reader
+	closer    io.Closer
+	leftover  []byte
+	skipBlank bool
+}
+
+// Read returns at most one line per Read, so events are flushed to the client as they arrive.
+func (r *skipStreamDoneReader) Read(b []byte) (int, error) {
+	for len(r.leftover) == 0 {
+		line, err := r.reader.ReadBytes('\n')
+		trimmed := bytes.TrimSpace(line)
+		data, isData := bytes.CutPrefix(trimmed, []byte("data:"))
+		switch {
+		case isData && openai.IsStreamDone(bytes.TrimSpace(data)):
+			// Also skip the blank line terminating the event.
+			r.skipBlank = true
+		case r.skipBlank && len(line) > 0 && len(trimmed) == 0:
+			r.skipBlank = false
+		default:
+			r.skipBlank = false
+			r.leftover = line
+		}
+		if err != nil {
+			if len(r.leftover) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	n := copy(b, r.leftover)
+	r.leftover = r.leftover[n:]
+	return n, nil
+}
+
+// Close closes the underlying response body.
+func (r *skipStreamDoneReader) Close() error {
+	return r.closer.Close()
+}
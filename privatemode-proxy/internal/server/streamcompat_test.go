@@ -0,0 +1,135 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCompatMode(t *testing.T) {
+	const chunk = `data: {"id":"chatcmpl-1","choices":[{"delta":{"content":"Hello"}}]}` + "\n\n"
+
+	testCases := map[string]struct {
+		streamCompatMode bool
+		wantBody         string
+	}{
+		"disabled": {
+			wantBody: chunk + "data: [DONE]\n\n",
+		},
+		"enabled": {
+			streamCompatMode: true,
+			wantBody:         chunk,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encrypt, decrypt := stub.GetEncryptionFunctions(secret.Map())
+				body, err := io.ReadAll(r.Body)
+				require.NoError(err)
+				_, err = forwarder.MutateJSONFields(body, decrypt, openai.PlainCompletionsRequestFields)
+				require.NoError(err)
+
+				w.Header().Set("Content-Type", "text/event-stream")
+				respBody, err := io.ReadAll(forwarder.NewJSONMutatingReader(encrypt, openai.PlainCompletionsResponseFields).
+					Reader(io.NopCloser(strings.NewReader(chunk + "data: [DONE]\n\n"))))
+				require.NoError(err)
+				_, _ = w.Write(respBody)
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.streamCompatMode = tc.streamCompatMode
+
+			req := prepareChatRequest(t.Context(), require, "Hello", nil, "")
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestSkipStreamDoneMapper(t *testing.T) {
+	testCases := map[string]struct {
+		contentType string
+		body        string
+		wantBody    string
+	}{
+		"done event removed": {
+			contentType: "text/event-stream",
+			body:        "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			wantBody:    "data: {\"a\":1}\n\n",
+		},
+		"done event without space and CRLF": {
+			contentType: "text/event-stream; charset=utf-8",
+			body:        "data: {\"a\":1}\r\n\r\ndata:[DONE]\r\n\r\n",
+			wantBody:    "data: {\"a\":1}\r\n\r\n",
+		},
+		"no trailing newline": {
+			contentType: "text/event-stream",
+			body:        "data: {\"a\":1}\n\ndata: [DONE]",
+			wantBody:    "data: {\"a\":1}\n\n",
+		},
+		"other event stream data unchanged": {
+			contentType: "text/event-stream",
+			body:        "event: message\ndata: {\"text\":\"[DONE]\"}\n\n",
+			wantBody:    "event: message\ndata: {\"text\":\"[DONE]\"}\n\n",
+		},
+		"not an event stream": {
+			contentType: "text/plain",
+			body:        "data: [DONE]\n\n",
+			wantBody:    "data: [DONE]\n\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			upstream := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{tc.contentType}},
+				Body:       io.NopCloser(iotest.OneByteReader(strings.NewReader(tc.body))),
+			}
+			dsResp, err := skipStreamDoneMapper(forwarder.PassthroughResponseMapper)(upstream)
+			require.NoError(err)
+
+			var body []byte
+			switch r := dsResp.(type) {
+			case *forwarder.StreamingResponse:
+				body, err = io.ReadAll(r.Body)
+				require.NoError(err)
+				require.NoError(r.Body.Close())
+			case *forwarder.UnaryResponse:
+				body = r.Body
+			default:
+				require.Failf("unexpected response type", "%T", dsResp)
+			}
+			assert.Equal(t, tc.wantBody, string(body))
+		})
+	}
+}
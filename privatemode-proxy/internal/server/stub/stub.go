@@ -71,16 +71,18 @@ func openAIChatCompletionsHandler(secrets map[string][]byte, log *slog.Logger) f
 			responseMsg = "Echo: nil"
 		}
 
-		choices := []openai.Choice{
-			{
-				Index: 0,
+		// Return n identical choices, as requested with the "n" parameter.
+		choices := make([]openai.Choice, max(request.N, 1))
+		for i := range choices {
+			choices[i] = openai.Choice{
+				Index: i,
 				Message: openai.Message{
 					Role:      "assistant",
 					Content:   responseMsg,
 					ToolCalls: make([]any, len(request.Tools)),
 				},
 				FinishReason: "stop",
-			},
+			}
 		}
 
 		inputTokens := 0
@@ -98,7 +100,9 @@ func openAIChatCompletionsHandler(secrets map[string][]byte, log *slog.Logger) f
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			choices[0].Message.ToolCalls[i] = string(toolJSON)
+			for c := range choices {
+				choices[c].Message.ToolCalls[i] = string(toolJSON)
+			}
 			inputTokens += len(toolJSON)
 		}
 
@@ -0,0 +1,87 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+)
+
+// serverTimingHeader reports the time spent in the proxy and the API, see [Opts.TimingHeaders].
+const serverTimingHeader = "Server-Timing"
+
+// requestTiming records the time spent handling a request for the [serverTimingHeader].
+// If a request is retried, only the last attempt is recorded.
+type requestTiming struct {
+	encrypt      time.Duration // preparing and encrypting the request
+	encryptedAt  time.Time     // when the request was sent to the API
+	upstreamRead time.Duration // reading the response body during response mapping
+}
+
+// timedRequestMutator wraps next and records its duration as encryption time.
+func (t *requestTiming) timedRequestMutator(next forwarder.RequestMutator) forwarder.RequestMutator {
+	return func(r *http.Request) error {
+		start := time.Now()
+		err := next(r)
+		t.encryptedAt = time.Now()
+		t.encrypt = t.encryptedAt.Sub(start)
+		return err
+	}
+}
+
+// timedResponseMapper wraps next and sets the [serverTimingHeader] on the downstream response.
+// The time until the response is received and spent reading its body is reported as upstream time,
+// the remaining time of next as decryption time. Streaming responses are decrypted after the
+// headers are sent, so only the time until the response headers are received is reported for them.
+func (t *requestTiming) timedResponseMapper(next forwarder.ResponseMapper) forwarder.ResponseMapper {
+	return func(resp *http.Response) (forwarder.Response, error) {
+		start := time.Now()
+		upstream := start.Sub(t.encryptedAt)
+		t.upstreamRead = 0 // drop the reads of previous attempts
+		body := &timedReadCloser{ReadCloser: resp.Body, d: &t.upstreamRead}
+		resp.Body = body
+
+		dsResp, err := next(resp)
+		if err != nil {
+			return nil, err
+		}
+		body.d = nil // reads of streaming responses happen after the headers are sent
+
+		metrics := []string{
+			serverTimingMetric("encrypt", t.encrypt),
+			serverTimingMetric("upstream", upstream+t.upstreamRead),
+		}
+		if _, streaming := dsResp.(*forwarder.StreamingResponse); !streaming {
+			metrics = append(metrics, serverTimingMetric("decrypt", time.Since(start)-t.upstreamRead))
+		}
+		dsResp.GetHeader().Set(serverTimingHeader, strings.Join(metrics, ", "))
+		return dsResp, nil
+	}
+}
+
+// serverTimingMetric formats a metric of the [serverTimingHeader] with the duration in milliseconds.
+func serverTimingMetric(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", name, float64(d.Microseconds())/1000)
+}
+
+// timedReadCloser adds the time spent in Read to d, if d is set.
+type timedReadCloser struct {
+	io.ReadCloser
+	d *time.Duration
+}
+
+func (r *timedReadCloser) Read(p []byte) (int, error) {
+	if r.d == nil {
+		return r.ReadCloser.Read(p)
+	}
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	*r.d += time.Since(start)
+	return n, err
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		timingHeaders bool
+		stream        bool
+		wantMetrics   []string
+	}{
+		"disabled": {},
+		"non-streaming": {
+			timingHeaders: true,
+			wantMetrics:   []string{"encrypt", "upstream", "decrypt"},
+		},
+		"streaming": {
+			timingHeaders: true,
+			stream:        true,
+			wantMetrics:   []string{"encrypt", "upstream"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			secret := secretmanager.Secret{
+				ID:   "123",
+				Data: bytes.Repeat([]byte{0x42}, 32),
+			}
+			echo := stub.EchoHandler(secret.Map(), slog.Default())
+			stubBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tc.stream {
+					echo.ServeHTTP(w, r)
+					return
+				}
+				// The echo handler doesn't stream, so send an unencrypted event instead.
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Privatemode-Encrypted", "false")
+				_, _ = w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Echo: Hello\"}}]}\n\ndata: [DONE]\n\n"))
+			}))
+			defer stubBackend.Close()
+
+			apiKey := testAPIKey
+			sut := newTestServer(&apiKey, secret, stubBackend.Listener.Addr().String(), "", false)
+			sut.timingHeaders = tc.timingHeaders
+
+			req := prepareJSONRequest(t.Context(), require, openai.ChatCompletionsEndpoint, openai.ChatRequest{
+				ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt-oss-120b", Stream: tc.stream},
+				Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+			})
+			resp := httptest.NewRecorder()
+			sut.GetHandler().ServeHTTP(resp, req)
+			require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+			assert.Contains(resp.Body.String(), "Echo: Hello")
+
+			var metrics []string
+			for _, match := range regexp.MustCompile(`(\w+);dur=\d+\.\d{3}`).FindAllStringSubmatch(resp.Header().Get(serverTimingHeader), -1) {
+				metrics = append(metrics, match[1])
+			}
+			assert.Equal(tc.wantMetrics, metrics)
+		})
+	}
+}
+
+func TestTimedResponseMapperResetsUpstreamReads(t *testing.T) {
+	require := require.New(t)
+
+	readBody := func(resp *http.Response) (forwarder.Response, error) {
+		return forwarder.ReadUnaryResponse(resp, 1024)
+	}
+	timing := &requestTiming{encryptedAt: time.Now()}
+	mapper := timing.timedResponseMapper(readBody)
+
+	// A previous attempt spent a long time reading its response body
+	timing.upstreamRead = time.Hour
+
+	resp, err := mapper(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("body"))})
+	require.NoError(err)
+	header := resp.GetHeader().Get(serverTimingHeader)
+	require.Contains(header, "upstream;dur=")
+	require.NotContains(header, "upstream;dur=3600", "reads of previous attempts must not be reported")
+}
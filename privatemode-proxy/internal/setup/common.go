@@ -5,13 +5,16 @@
 package setup
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/edgelesssys/continuum/internal/oss/forwarder"
 	"github.com/edgelesssys/continuum/internal/oss/httputil"
-	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/internal/oss/mutators"
 	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
 )
 
@@ -24,9 +27,102 @@ type Flags struct {
 	APIEndpoint                  string
 	APIKey                       *string
 	PromptCacheSalt              string
+	CacheSaltPerAPIKey           bool
+	DisablePromptCache           bool
+	RequireClientCacheSalt       bool
 	NvidiaOCSPAllowUnknown       bool
 	NvidiaOCSPRevokedGracePeriod time.Duration
 	DumpRequestsDir              string
+	DumpCompress                 bool
+	DumpMaxBytes                 int64
+	DumpMaxFiles                 int
+	MaxHeaderBytes               int
+	RejectLargeHeaders           bool
+	ModelDefaults                mutators.ModelDefaults
+	MaxResponseBytes             int64
+	MaxBatchSize                 int
+	BatchConcurrency             int
+	PreserveClientRequestID      bool
+	StrictJSON                   bool
+	EnabledEndpoints             []string
+	DisabledEndpointStatus       int
+	StreamHeartbeatInterval      time.Duration
+	StreamBufferSize             int
+	RequestTimeout               time.Duration
+	RetryBudget                  time.Duration
+	OverloadRetries              int
+	MaxRetryAfter                time.Duration
+	StreamErrorEvents            bool
+	RetryMetrics                 bool
+	ForwardTrailers              bool
+	ExposeShardKey               bool
+	CoalesceRequests             bool
+	StripForwardedFor            bool
+	MaxPromptChars               int
+	MaxTools                     int
+	UnstructuredContentTypes     []string
+	ShardKeyWarnFraction         float64
+	AdminToken                   string
+	IdempotencyWindow            time.Duration
+	IdempotencyCacheSize         int
+	VerifyDecryptedResponse      bool
+	VerifyModelMatch             bool
+	SecretRefreshJitter          float64  // fraction, see [secretmanager.SecretManager.SetRefreshJitter]
+	UpstreamProxy                *url.URL // if set, all connections to the API are made through this proxy
+	ExtraHeaders                 http.Header
+	ForwardHeaders               []string
+	ModelQuotas                  map[string]int
+	CORSAllowedOrigins           []string
+	SecretWaitTimeout            time.Duration
+	EchoUpstreamRequestID        bool
+	StreamCompatMode             bool
+	EncryptedHeader              string
+	PlaintextByDefault           bool
+	QuietRequestLogs             bool
+	ModelPrefixStrip             string
+	AllowModelHeader             bool
+	TimingHeaders                bool
+	AuditSink                    server.AuditSink `json:"-"` // created from the auditLog flag, which is printed instead
+	AuditLog                     string
+	CurrentManifest              func() string `json:"-"` // returned by [SecretManager]
+}
+
+// redacted replaces secret values in [Flags.RedactedJSON].
+const redacted = "<redacted>"
+
+// RedactedJSON returns the flags as JSON with the API key, the prompt cache salt, the admin token, the values of
+// extra headers, and credentials of the upstream proxy redacted. Unset secrets stay empty to show that they aren't configured.
+func (f Flags) RedactedJSON() ([]byte, error) {
+	if f.APIKey != nil {
+		apiKey := redacted
+		f.APIKey = &apiKey
+	}
+	if f.PromptCacheSalt != "" {
+		f.PromptCacheSalt = redacted
+	}
+	if f.AdminToken != "" {
+		f.AdminToken = redacted
+	}
+	if f.ExtraHeaders != nil {
+		// Extra headers may carry credentials of a custom deployment.
+		extraHeaders := make(http.Header, len(f.ExtraHeaders))
+		for key := range f.ExtraHeaders {
+			extraHeaders.Set(key, redacted)
+		}
+		f.ExtraHeaders = extraHeaders
+	}
+	config := struct {
+		Flags
+		UpstreamProxy string // shadows the embedded *url.URL to print it as string
+	}{Flags: f}
+	if f.UpstreamProxy != nil {
+		config.UpstreamProxy = f.UpstreamProxy.Redacted()
+	}
+	if auditURL, err := url.Parse(f.AuditLog); err == nil && auditURL.Scheme != "" {
+		// Audit endpoints may carry credentials, too.
+		config.AuditLog = auditURL.Redacted()
+	}
+	return json.Marshal(config)
 }
 
 // ContrastFlags holds the configuration for the Contrast deployment.
@@ -35,22 +131,89 @@ type ContrastFlags struct {
 }
 
 // NewServer creates a new server instance.
-func NewServer(flags Flags, isApp bool, manager *secretmanager.SecretManager, log *slog.Logger) *server.Server {
-	client := http.DefaultClient
-	if flags.InsecureAPIConnection {
-		client = httputil.InsecureNewSkipVerifyClient()
-	}
+// Any [server.SecretManager] implementation can be used as secret backend, e.g., the one returned
+// by [SecretManager] or a [StaticSecretManager].
+func NewServer(flags Flags, isApp bool, manager server.SecretManager, log *slog.Logger) (*server.Server, error) {
+	client := apiClient(flags)
 
 	opts := server.Opts{
 		APIEndpoint:                  flags.APIEndpoint,
 		APIKey:                       flags.APIKey,
 		ProtocolScheme:               forwarder.SchemeHTTPS,
 		PromptCacheSalt:              flags.PromptCacheSalt,
+		CacheSaltPerAPIKey:           flags.CacheSaltPerAPIKey,
+		DisablePromptCache:           flags.DisablePromptCache,
+		RequireClientCacheSalt:       flags.RequireClientCacheSalt,
 		IsApp:                        isApp,
 		NvidiaOCSPAllowUnknown:       flags.NvidiaOCSPAllowUnknown,
 		NvidiaOCSPRevokedGracePeriod: flags.NvidiaOCSPRevokedGracePeriod,
 		DumpRequestsDir:              flags.DumpRequestsDir,
+		DumpCompress:                 flags.DumpCompress,
+		DumpMaxBytes:                 flags.DumpMaxBytes,
+		DumpMaxFiles:                 flags.DumpMaxFiles,
+		MaxHeaderBytes:               flags.MaxHeaderBytes,
+		RejectLargeHeaders:           flags.RejectLargeHeaders,
+		ModelDefaults:                flags.ModelDefaults,
+		MaxResponseBytes:             flags.MaxResponseBytes,
+		MaxBatchSize:                 flags.MaxBatchSize,
+		BatchConcurrency:             flags.BatchConcurrency,
+		PreserveClientRequestID:      flags.PreserveClientRequestID,
+		StrictJSON:                   flags.StrictJSON,
+		EnabledEndpoints:             flags.EnabledEndpoints,
+		DisabledEndpointStatus:       flags.DisabledEndpointStatus,
+		StreamHeartbeatInterval:      flags.StreamHeartbeatInterval,
+		StreamBufferSize:             flags.StreamBufferSize,
+		RequestTimeout:               flags.RequestTimeout,
+		RetryBudget:                  flags.RetryBudget,
+		OverloadRetries:              flags.OverloadRetries,
+		MaxRetryAfter:                flags.MaxRetryAfter,
+		StreamErrorEvents:            flags.StreamErrorEvents,
+		RetryMetrics:                 flags.RetryMetrics,
+		ForwardTrailers:              flags.ForwardTrailers,
+		ExposeShardKey:               flags.ExposeShardKey,
+		CoalesceRequests:             flags.CoalesceRequests,
+		StripForwardedFor:            flags.StripForwardedFor,
+		MaxPromptChars:               flags.MaxPromptChars,
+		MaxTools:                     flags.MaxTools,
+		UnstructuredContentTypes:     flags.UnstructuredContentTypes,
+		ShardKeyWarnFraction:         flags.ShardKeyWarnFraction,
+		AdminToken:                   flags.AdminToken,
+		IdempotencyWindow:            flags.IdempotencyWindow,
+		IdempotencyCacheSize:         flags.IdempotencyCacheSize,
+		VerifyDecryptedResponse:      flags.VerifyDecryptedResponse,
+		VerifyModelMatch:             flags.VerifyModelMatch,
+		ExtraHeaders:                 flags.ExtraHeaders,
+		ForwardHeaders:               flags.ForwardHeaders,
+		ModelQuotas:                  flags.ModelQuotas,
+		CORSAllowedOrigins:           flags.CORSAllowedOrigins,
+		SecretWaitTimeout:            flags.SecretWaitTimeout,
+		EchoUpstreamRequestID:        flags.EchoUpstreamRequestID,
+		StreamCompatMode:             flags.StreamCompatMode,
+		EncryptedHeader:              flags.EncryptedHeader,
+		PlaintextByDefault:           flags.PlaintextByDefault,
+		QuietRequestLogs:             flags.QuietRequestLogs,
+		ModelPrefixStrip:             flags.ModelPrefixStrip,
+		AllowModelHeader:             flags.AllowModelHeader,
+		TimingHeaders:                flags.TimingHeaders,
+		AuditSink:                    flags.AuditSink,
+		CurrentManifest:              flags.CurrentManifest,
 	}
 
 	return server.New(client, manager, opts, log)
 }
+
+// apiClient returns the HTTP client used to connect to the Privatemode API.
+func apiClient(flags Flags) *http.Client {
+	if !flags.InsecureAPIConnection && flags.UpstreamProxy == nil {
+		return http.DefaultClient
+	}
+
+	transport := httputil.NewTransport()
+	if flags.InsecureAPIConnection {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if flags.UpstreamProxy != nil {
+		transport.Proxy = http.ProxyURL(flags.UpstreamProxy)
+	}
+	return &http.Client{Transport: transport}
+}
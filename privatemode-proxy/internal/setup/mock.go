@@ -0,0 +1,130 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/edgelesssys/continuum/internal/oss/forwarder"
+	"github.com/edgelesssys/continuum/internal/oss/process"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+)
+
+// mockAPIKey is offered to the mock secret manager so that it never waits for a real API key.
+const mockAPIKey = "mock"
+
+// MockBackend starts a stubbed Privatemode backend that echoes requests and returns a secret manager
+// and server using it. Attestation and the secret exchange are skipped entirely.
+// This is strictly for local development and must never be used in production.
+// The backend is shut down when ctx is canceled.
+func MockBackend(ctx context.Context, flags Flags, isApp bool, log *slog.Logger) (*secretmanager.SecretManager, *server.Server, error) {
+	secret := secretmanager.Secret{
+		ID:   hex.EncodeToString(randomBytes(16)),
+		Data: randomBytes(32),
+	}
+	sm := secretmanager.New(func(context.Context, string) (string, []byte, error) {
+		return secret.ID, secret.Data, nil
+	}, false)
+	if err := sm.OfferAPIKey(ctx, mockAPIKey); err != nil {
+		return nil, nil, fmt.Errorf("setting up mock secret manager: %w", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, fmt.Errorf("listening for mock backend: %w", err)
+	}
+	backendLog := log.With("component", "mock-backend")
+	backend := &http.Server{
+		Handler:  stub.EchoHandler(secret.Map(), backendLog),
+		ErrorLog: slog.NewLogLogger(backendLog.Handler(), slog.LevelError),
+	}
+	go func() {
+		if err := process.HTTPServeContext(ctx, backend, lis, backendLog); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			backendLog.Error("Mock backend exited", "error", err)
+		}
+	}()
+
+	opts := server.Opts{
+		APIEndpoint:                  lis.Addr().String(),
+		APIKey:                       flags.APIKey,
+		ProtocolScheme:               forwarder.SchemeHTTP,
+		PromptCacheSalt:              flags.PromptCacheSalt,
+		CacheSaltPerAPIKey:           flags.CacheSaltPerAPIKey,
+		DisablePromptCache:           flags.DisablePromptCache,
+		RequireClientCacheSalt:       flags.RequireClientCacheSalt,
+		IsApp:                        isApp,
+		NvidiaOCSPAllowUnknown:       flags.NvidiaOCSPAllowUnknown,
+		NvidiaOCSPRevokedGracePeriod: flags.NvidiaOCSPRevokedGracePeriod,
+		DumpRequestsDir:              flags.DumpRequestsDir,
+		DumpCompress:                 flags.DumpCompress,
+		DumpMaxBytes:                 flags.DumpMaxBytes,
+		DumpMaxFiles:                 flags.DumpMaxFiles,
+		MaxHeaderBytes:               flags.MaxHeaderBytes,
+		RejectLargeHeaders:           flags.RejectLargeHeaders,
+		ModelDefaults:                flags.ModelDefaults,
+		MaxResponseBytes:             flags.MaxResponseBytes,
+		MaxBatchSize:                 flags.MaxBatchSize,
+		BatchConcurrency:             flags.BatchConcurrency,
+		PreserveClientRequestID:      flags.PreserveClientRequestID,
+		StrictJSON:                   flags.StrictJSON,
+		EnabledEndpoints:             flags.EnabledEndpoints,
+		DisabledEndpointStatus:       flags.DisabledEndpointStatus,
+		StreamHeartbeatInterval:      flags.StreamHeartbeatInterval,
+		StreamBufferSize:             flags.StreamBufferSize,
+		RequestTimeout:               flags.RequestTimeout,
+		RetryBudget:                  flags.RetryBudget,
+		OverloadRetries:              flags.OverloadRetries,
+		MaxRetryAfter:                flags.MaxRetryAfter,
+		StreamErrorEvents:            flags.StreamErrorEvents,
+		RetryMetrics:                 flags.RetryMetrics,
+		ForwardTrailers:              flags.ForwardTrailers,
+		ExposeShardKey:               flags.ExposeShardKey,
+		CoalesceRequests:             flags.CoalesceRequests,
+		StripForwardedFor:            flags.StripForwardedFor,
+		MaxPromptChars:               flags.MaxPromptChars,
+		MaxTools:                     flags.MaxTools,
+		UnstructuredContentTypes:     flags.UnstructuredContentTypes,
+		ShardKeyWarnFraction:         flags.ShardKeyWarnFraction,
+		AdminToken:                   flags.AdminToken,
+		IdempotencyWindow:            flags.IdempotencyWindow,
+		IdempotencyCacheSize:         flags.IdempotencyCacheSize,
+		VerifyDecryptedResponse:      flags.VerifyDecryptedResponse,
+		VerifyModelMatch:             flags.VerifyModelMatch,
+		ExtraHeaders:                 flags.ExtraHeaders,
+		ForwardHeaders:               flags.ForwardHeaders,
+		ModelQuotas:                  flags.ModelQuotas,
+		CORSAllowedOrigins:           flags.CORSAllowedOrigins,
+		SecretWaitTimeout:            flags.SecretWaitTimeout,
+		EchoUpstreamRequestID:        flags.EchoUpstreamRequestID,
+		StreamCompatMode:             flags.StreamCompatMode,
+		EncryptedHeader:              flags.EncryptedHeader,
+		PlaintextByDefault:           flags.PlaintextByDefault,
+		QuietRequestLogs:             flags.QuietRequestLogs,
+		ModelPrefixStrip:             flags.ModelPrefixStrip,
+		AllowModelHeader:             flags.AllowModelHeader,
+		TimingHeaders:                flags.TimingHeaders,
+		AuditSink:                    flags.AuditSink,
+	}
+
+	srv, err := server.New(http.DefaultClient, sm, opts, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating server: %w", err)
+	}
+	return sm, srv, nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // never returns an error
+	return b
+}
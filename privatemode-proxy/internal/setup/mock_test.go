@@ -0,0 +1,50 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockBackend(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	_, srv, err := MockBackend(t.Context(), Flags{}, false, slog.Default())
+	require.NoError(err)
+
+	payload, err := json.Marshal(openai.ChatRequest{
+		ChatRequestPlainData: openai.ChatRequestPlainData{
+			Model: "gpt",
+		},
+		Messages: []openai.Message{
+			{
+				Role:    "user",
+				Content: "Hello",
+			},
+		},
+	})
+	require.NoError(err)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, openai.ChatCompletionsEndpoint, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := httptest.NewRecorder()
+	srv.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	var chatResp openai.ChatResponse
+	require.NoError(json.NewDecoder(resp.Body).Decode(&chatResp))
+	require.Len(chatResp.Choices, 1)
+	assert.Equal("Echo: Hello", chatResp.Choices[0].Message.Content)
+}
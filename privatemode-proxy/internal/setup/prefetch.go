@@ -0,0 +1,76 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
+)
+
+const (
+	// secretPrefetchTimeout bounds the secret fetch on startup, including attestation.
+	secretPrefetchTimeout = time.Minute
+	// degradedRetryInterval is the interval in which the secret fetch is retried after a degraded start.
+	degradedRetryInterval = 10 * time.Second
+)
+
+// PrefetchSecret offers apiKey to manager and fetches a secret before the server starts accepting
+// traffic, so that the first request doesn't bear the cost of attestation and key exchange.
+//
+// If allowDegradedStart is set, a failed fetch is logged instead of returned, and retried in the
+// background until it succeeds or ctx is canceled. Until then, requests fail.
+func PrefetchSecret(ctx context.Context, manager server.SecretManager, apiKey string, allowDegradedStart bool, log *slog.Logger) error {
+	return prefetchSecret(ctx, manager, apiKey, allowDegradedStart, secretPrefetchTimeout, degradedRetryInterval, log)
+}
+
+func prefetchSecret(
+	ctx context.Context, manager server.SecretManager, apiKey string,
+	allowDegradedStart bool, timeout, retryInterval time.Duration, log *slog.Logger,
+) error {
+	err := fetchSecret(ctx, manager, apiKey, timeout)
+	if err == nil {
+		log.Info("Prefetched secret")
+		return nil
+	}
+	if !allowDegradedStart {
+		return err
+	}
+
+	log.Warn("Prefetching secret failed, starting in degraded mode", "error", err)
+	go func() {
+		ticker := time.NewTicker(retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := fetchSecret(ctx, manager, apiKey, timeout); err != nil {
+				log.Warn("Prefetching secret failed, retrying", "error", err, "retryInterval", retryInterval)
+				continue
+			}
+			log.Info("Prefetched secret, leaving degraded mode")
+			return
+		}
+	}()
+	return nil
+}
+
+func fetchSecret(ctx context.Context, manager server.SecretManager, apiKey string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := manager.OfferAPIKey(ctx, apiKey); err != nil {
+		return fmt.Errorf("trying API key: %w", err)
+	}
+	if _, err := manager.LatestSecret(ctx); err != nil {
+		return fmt.Errorf("fetching secret: %w", err)
+	}
+	return nil
+}
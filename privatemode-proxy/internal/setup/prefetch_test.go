@@ -0,0 +1,106 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetchSecret(t *testing.T) {
+	testCases := map[string]struct {
+		serviceDown        bool
+		allowDegradedStart bool
+		wantErr            bool
+		wantSecret         bool
+	}{
+		"success": {
+			wantSecret: true,
+		},
+		"success with degraded start allowed": {
+			allowDegradedStart: true,
+			wantSecret:         true,
+		},
+		"secret service down": {
+			serviceDown: true,
+			wantErr:     true,
+		},
+		"secret service down with degraded start": {
+			serviceDown:        true,
+			allowDegradedStart: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var calls atomic.Int32
+			sm := secretmanager.New(func(context.Context, string) (string, []byte, error) {
+				calls.Add(1)
+				if tc.serviceDown {
+					return "", nil, errors.New("connection refused")
+				}
+				return "123", bytes.Repeat([]byte{0x42}, 32), nil
+			}, false)
+
+			err := prefetchSecret(t.Context(), sm, "key", tc.allowDegradedStart, time.Second, time.Hour, slog.Default())
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			assert.EqualValues(1, calls.Load())
+
+			_, err = sm.LatestSecret(t.Context())
+			if tc.wantSecret {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+		})
+	}
+}
+
+func TestPrefetchSecretDegradedRecovery(t *testing.T) {
+	require := require.New(t)
+
+	var serviceUp atomic.Bool
+	sm := secretmanager.New(func(context.Context, string) (string, []byte, error) {
+		if !serviceUp.Load() {
+			return "", nil, errors.New("connection refused")
+		}
+		return "123", bytes.Repeat([]byte{0x42}, 32), nil
+	}, false)
+
+	require.NoError(prefetchSecret(t.Context(), sm, "key", true, time.Second, 5*time.Millisecond, slog.Default()))
+	_, err := sm.LatestSecret(t.Context())
+	require.Error(err)
+
+	serviceUp.Store(true)
+	require.Eventually(func() bool {
+		_, err := sm.LatestSecret(t.Context())
+		return err == nil
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestPrefetchSecretTimeout(t *testing.T) {
+	sm := secretmanager.New(func(ctx context.Context, _ string) (string, []byte, error) {
+		<-ctx.Done()
+		return "", nil, ctx.Err()
+	}, false)
+
+	err := prefetchSecret(t.Context(), sm, "key", false, 10*time.Millisecond, time.Hour, slog.Default())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
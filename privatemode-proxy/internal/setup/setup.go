@@ -4,17 +4,15 @@
 package setup
 
 import (
-	"context"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"path/filepath"
 
 	"github.com/edgelesssys/continuum/internal/oss/attest"
-	"github.com/edgelesssys/continuum/internal/oss/httputil"
 	"github.com/edgelesssys/continuum/internal/oss/secretclient"
 	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
 	"github.com/edgelesssys/continuum/internal/oss/secretmanager/updater"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
 	contrastsdk "github.com/edgelesssys/contrast/sdk"
 	"github.com/spf13/afero"
 )
@@ -25,11 +23,9 @@ const (
 )
 
 // SecretManager sets up the secret manager for the Contrast deployment.
-func SecretManager(ctx context.Context, flags Flags, log *slog.Logger) (*secretmanager.SecretManager, func() string, error) {
-	httpClient := http.DefaultClient
-	if flags.InsecureAPIConnection {
-		httpClient = httputil.InsecureNewSkipVerifyClient()
-	}
+// It doesn't fetch a secret yet. Use [PrefetchSecret] to fetch one with the configured API key.
+func SecretManager(flags Flags, log *slog.Logger) (*secretmanager.SecretManager, func() string, error) {
+	httpClient := apiClient(flags)
 
 	contrastClient := contrastsdk.New().
 		WithSlog(log.With("component", "contrast-client")).
@@ -56,11 +52,10 @@ func SecretManager(ctx context.Context, flags Flags, log *slog.Logger) (*secretm
 
 	secretUpdater := updater.New(ssClient, caGetter, log)
 	apiKeyDropOnUnauthorized := flags.APIKey == nil
-	sm := secretmanager.New(secretUpdater.UpdateSecret, apiKeyDropOnUnauthorized)
-	if flags.APIKey != nil {
-		if err := sm.OfferAPIKey(ctx, *flags.APIKey); err != nil {
-			return nil, nil, fmt.Errorf("trying API key: %w", err)
-		}
+	manager := secretmanager.New(secretUpdater.UpdateSecret, apiKeyDropOnUnauthorized)
+	if err := manager.SetRefreshJitter(flags.SecretRefreshJitter); err != nil {
+		return nil, nil, err
 	}
-	return sm, currentManifest, nil
+	manager.SetSecretAgeReporter(server.RecordSecretAge)
+	return manager, currentManifest, nil
 }
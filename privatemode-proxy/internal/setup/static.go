@@ -0,0 +1,49 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"context"
+	"errors"
+
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server"
+)
+
+// StaticSecretManager is a [server.SecretManager] that always serves the same secret, e.g.,
+// one provisioned out-of-band by a KMS. It serves as an example for custom secret backends that
+// replace the Contrast and secret-service flow of [SecretManager].
+type StaticSecretManager struct {
+	secret secretmanager.Secret
+}
+
+var _ server.SecretManager = (*StaticSecretManager)(nil)
+
+// NewStaticSecretManager creates a [StaticSecretManager] serving secret.
+// The secret must already be known to the API.
+func NewStaticSecretManager(secret secretmanager.Secret) (*StaticSecretManager, error) {
+	if secret.ID == "" {
+		return nil, errors.New("secret ID must not be empty")
+	}
+	if _, err := ocspheader.MACKey(secret.Data); err != nil {
+		return nil, err
+	}
+	return &StaticSecretManager{secret: secret}, nil
+}
+
+// LatestSecret returns the static secret.
+func (s *StaticSecretManager) LatestSecret(context.Context) (secretmanager.Secret, error) {
+	return s.secret, nil
+}
+
+// ForceUpdate always fails since a static secret can't be renewed.
+func (s *StaticSecretManager) ForceUpdate(context.Context) error {
+	return errors.New("static secret can't be renewed")
+}
+
+// OfferAPIKey ignores the API key since the secret isn't bound to it.
+func (s *StaticSecretManager) OfferAPIKey(context.Context, string) error {
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/ocspheader"
+	"github.com/edgelesssys/continuum/internal/oss/openai"
+	"github.com/edgelesssys/continuum/internal/oss/secretmanager"
+	"github.com/edgelesssys/continuum/privatemode-proxy/internal/server/stub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStaticSecretManager(t *testing.T) {
+	testCases := map[string]struct {
+		secret  secretmanager.Secret
+		wantErr bool
+		errIs   error
+	}{
+		"valid": {
+			secret: secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 32)},
+		},
+		"missing ID": {
+			secret:  secretmanager.Secret{Data: bytes.Repeat([]byte{0x42}, 32)},
+			wantErr: true,
+		},
+		"short secret": {
+			secret:  secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 16)},
+			wantErr: true,
+			errIs:   ocspheader.ErrSecretTooShort,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			sm, err := NewStaticSecretManager(tc.secret)
+			if tc.wantErr {
+				assert.Error(t, err)
+				if tc.errIs != nil {
+					assert.ErrorIs(t, err, tc.errIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			secret, err := sm.LatestSecret(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, tc.secret, secret)
+			assert.Error(t, sm.ForceUpdate(t.Context()))
+		})
+	}
+}
+
+func TestNewServerWithCustomSecretManager(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	secret := secretmanager.Secret{ID: "123", Data: bytes.Repeat([]byte{0x42}, 32)}
+	backend := httptest.NewTLSServer(stub.EchoHandler(secret.Map(), slog.Default()))
+	defer backend.Close()
+
+	sm, err := NewStaticSecretManager(secret)
+	require.NoError(err)
+	flags := Flags{
+		APIEndpoint:           backend.Listener.Addr().String(),
+		InsecureAPIConnection: true,
+	}
+	srv, err := NewServer(flags, false, sm, slog.Default())
+	require.NoError(err)
+
+	payload, err := json.Marshal(openai.ChatRequest{
+		ChatRequestPlainData: openai.ChatRequestPlainData{Model: "gpt"},
+		Messages:             []openai.Message{{Role: "user", Content: "Hello"}},
+	})
+	require.NoError(err)
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, openai.ChatCompletionsEndpoint, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := httptest.NewRecorder()
+	srv.GetHandler().ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	var chatResp openai.ChatResponse
+	require.NoError(json.NewDecoder(resp.Body).Decode(&chatResp))
+	require.Len(chatResp.Choices, 1)
+	assert.Equal("Echo: Hello", chatResp.Choices[0].Message.Content)
+}
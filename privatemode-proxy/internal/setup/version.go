@@ -0,0 +1,66 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/edgelesssys/continuum/internal/oss/constants"
+	"github.com/edgelesssys/continuum/internal/oss/privatemode"
+	"golang.org/x/mod/semver"
+)
+
+// versionCheckTimeout bounds fetching the version information on startup.
+const versionCheckTimeout = 10 * time.Second
+
+type versionInfoFetcher interface {
+	FetchVersionInfo(ctx context.Context) (privatemode.VersionInfo, error)
+}
+
+// CheckVersion compares the version of the proxy against the minimum client version published on the CDN.
+// If the proxy is outdated, a warning is logged, or an error is returned if requireMinVersion is set.
+// Failing to fetch the version information only logs a warning, so the CDN isn't required to start the proxy.
+func CheckVersion(ctx context.Context, flags Flags, requireMinVersion bool, log *slog.Logger) error {
+	fetcher := privatemode.
+		New(""). // API key is not required to fetch the version information
+		WithCDNBaseURL(flags.CDNBaseURL)
+	return checkVersion(ctx, fetcher, constants.Version(), requireMinVersion, log)
+}
+
+func checkVersion(ctx context.Context, fetcher versionInfoFetcher, version string, requireMinVersion bool, log *slog.Logger) error {
+	if !semver.IsValid(version) {
+		log.Debug("Skipping version check for development build", "version", version)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionCheckTimeout)
+	defer cancel()
+	info, err := fetcher.FetchVersionInfo(ctx)
+	if err != nil {
+		log.Warn("Couldn't check whether the proxy is up to date", "error", err)
+		return nil
+	}
+	if info.MinimumClientVersion == "" {
+		log.Debug("No minimum client version published")
+		return nil
+	}
+	if !semver.IsValid(info.MinimumClientVersion) {
+		log.Warn("Ignoring invalid minimum client version", "minimumVersion", info.MinimumClientVersion)
+		return nil
+	}
+
+	if semver.Compare(version, info.MinimumClientVersion) >= 0 {
+		log.Debug("Proxy is up to date", "version", version, "minimumVersion", info.MinimumClientVersion)
+		return nil
+	}
+	if requireMinVersion {
+		return fmt.Errorf("proxy version %s is outdated, the deployment requires at least %s", version, info.MinimumClientVersion)
+	}
+	log.Warn("The proxy is outdated and may stop working. Please update to the latest version.",
+		"version", version, "minimumVersion", info.MinimumClientVersion)
+	return nil
+}
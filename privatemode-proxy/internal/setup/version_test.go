@@ -0,0 +1,99 @@
+// Copyright (c) Edgeless Systems GmbH
+// SPDX-License-Identifier: MIT
+
+package setup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/edgelesssys/continuum/internal/oss/privatemode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version           string
+		info              privatemode.VersionInfo
+		fetchErr          error
+		requireMinVersion bool
+		wantErr           bool
+		wantWarning       bool
+	}{
+		"up to date": {
+			version: "v1.40.0",
+			info:    privatemode.VersionInfo{MinimumClientVersion: "v1.39.2"},
+		},
+		"up to date with require": {
+			version:           "v1.40.0",
+			info:              privatemode.VersionInfo{MinimumClientVersion: "v1.40.0"},
+			requireMinVersion: true,
+		},
+		"outdated": {
+			version:     "v1.39.2",
+			info:        privatemode.VersionInfo{MinimumClientVersion: "v1.40.0"},
+			wantWarning: true,
+		},
+		"outdated with require": {
+			version:           "v1.39.2",
+			info:              privatemode.VersionInfo{MinimumClientVersion: "v1.40.0"},
+			requireMinVersion: true,
+			wantErr:           true,
+		},
+		"no minimum version published": {
+			version:           "v1.39.2",
+			requireMinVersion: true,
+		},
+		"invalid minimum version": {
+			version:           "v1.39.2",
+			info:              privatemode.VersionInfo{MinimumClientVersion: "latest"},
+			requireMinVersion: true,
+			wantWarning:       true,
+		},
+		"fetching fails": {
+			version:           "v1.39.2",
+			fetchErr:          errors.New("connection refused"),
+			requireMinVersion: true,
+			wantWarning:       true,
+		},
+		"development build": {
+			version:           "0.0.0-dev",
+			info:              privatemode.VersionInfo{MinimumClientVersion: "v1.40.0"},
+			requireMinVersion: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var logs bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&logs, nil))
+			fetcher := stubVersionInfoFetcher{info: tc.info, err: tc.fetchErr}
+
+			err := checkVersion(t.Context(), fetcher, tc.version, tc.requireMinVersion, log)
+			if tc.wantErr {
+				assert.ErrorContains(err, "outdated")
+			} else {
+				assert.NoError(err)
+			}
+			if tc.wantWarning {
+				assert.Contains(logs.String(), "level=WARN")
+			} else {
+				assert.NotContains(logs.String(), "level=WARN")
+			}
+		})
+	}
+}
+
+type stubVersionInfoFetcher struct {
+	info privatemode.VersionInfo
+	err  error
+}
+
+func (s stubVersionInfoFetcher) FetchVersionInfo(context.Context) (privatemode.VersionInfo, error) {
+	return s.info, s.err
+}
@@ -21,6 +21,9 @@ const (
 	internalService
 )
 
+// MaxTxnOps is the maximum number of operations per branch of an etcd transaction.
+const MaxTxnOps = 256
+
 // newClusterConfig set up an etcd config to create a new cluster.
 func newClusterConfig(k8sNamespace, memberName, serverCrt, serverKey, caCrt string) (*embed.Config, error) {
 	cfg, err := baseEtcdConfig(map[string]etcdPeer{}, k8sNamespace, memberName, serverCrt, serverKey, caCrt)
@@ -53,7 +56,7 @@ func baseEtcdConfig(knownPeers map[string]etcdPeer, k8sNamespace, hostname, serv
 	cfg.Name = hostname
 	cfg.Dir = constants.EtcdBasePath()
 	cfg.SnapshotCount = 10 // Continuum does not perform a lot of transactions, so we should create snapshots more regularly
-	cfg.MaxTxnOps = 256
+	cfg.MaxTxnOps = MaxTxnOps
 
 	initialCluster, err := initialCluster(knownPeers, k8sNamespace, hostname)
 	if err != nil {
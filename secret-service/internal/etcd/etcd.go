@@ -6,12 +6,15 @@
 package etcd
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -27,6 +30,10 @@ import (
 	"google.golang.org/grpc/peer"
 )
 
+// cleanupTimeout bounds the cleanup of failed writes, which must not be
+// canceled together with the request that caused them.
+const cleanupTimeout = 10 * time.Second
+
 // JoinMethod defines how the etcd server should behave when starting up.
 type JoinMethod int
 
@@ -56,6 +63,7 @@ type Etcd struct {
 	server etcdInf
 
 	etcdMemberCert *x509.Certificate
+	maxTxnOps      int // defaults to [builder.MaxTxnOps]
 	log            *slog.Logger
 }
 
@@ -117,44 +125,60 @@ func New(ctx context.Context, joinMethod JoinMethod,
 // SetSecrets saves the given secrets in the etcd backend.
 // The operation will either succeed for all, or fail for all.
 // If any of the new secrets already exist, the operation will fail.
+//
+// Secrets are written in transactions of at most [builder.MaxTxnOps] secrets.
+// If a transaction fails, the secrets written by earlier transactions are deleted again.
+// Until the operation completes, other clients may observe a subset of the secrets.
 func (e *Etcd) SetSecrets(ctx context.Context, secrets map[string][]byte, ttl int64) (retErr error) {
-	var errs []error
-	var ifs []*pb.Compare
-	var thens []*pb.RequestOp
-	var elses []*pb.RequestOp
-
-	var leaseID int64
-	if ttl > 0 {
-		// Create a lease for the secrets
-		leaseResp, err := e.server.LeaseGrant(authCtx(ctx, e.etcdMemberCert), &pb.LeaseGrantRequest{
-			TTL: ttl,
-			ID:  0, // Let etcd generate a lease ID for us
-		})
-		if err != nil {
-			return fmt.Errorf("creating lease for secrets: %w", err)
+	leaseID, err := e.grantLease(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+			e.revokeLease(cleanupCtx, leaseID)
 		}
-		leaseID = leaseResp.ID
-
-		defer func() {
-			if retErr != nil {
-				if _, err = e.server.LeaseRevoke(authCtx(ctx, e.etcdMemberCert), &pb.LeaseRevokeRequest{ID: leaseResp.ID}); err != nil {
-					e.log.Warn("Failed to revoke lease after failed transaction", "error", err, "leaseID", leaseResp.ID)
+	}()
+
+	maxTxnOps := cmp.Or(e.maxTxnOps, builder.MaxTxnOps)
+	var written []string
+	for ids := range slices.Chunk(slices.Sorted(maps.Keys(secrets)), maxTxnOps) {
+		if err := e.setSecretsTxn(ctx, ids, secrets, leaseID); err != nil {
+			// Roll back earlier transactions to keep the all or nothing semantics
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+			for writtenIDs := range slices.Chunk(written, maxTxnOps) {
+				if rollbackErr := e.DeleteSecrets(cleanupCtx, writtenIDs); rollbackErr != nil {
+					return errors.Join(err, fmt.Errorf("rolling back written secrets: %w", rollbackErr))
 				}
 			}
-		}()
+			return err
+		}
+		written = append(written, ids...)
 	}
+	return nil
+}
+
+// setSecretsTxn saves the secrets with the given IDs in a single transaction.
+func (e *Etcd) setSecretsTxn(ctx context.Context, ids []string, secrets map[string][]byte, leaseID int64) error {
+	var errs []error
+	var ifs []*pb.Compare
+	var thens []*pb.RequestOp
+	var elses []*pb.RequestOp
 
-	for id, secret := range secrets {
+	for _, id := range ids {
 		keyID := constants.EtcdInferenceSecretPrefix + id
 
 		// IF the key does not exist (CreateRevision == 0)
-		cmp := clientv3.Compare(clientv3.CreateRevision(keyID), "=", 0)
-		ifs = append(ifs, (*pb.Compare)(&cmp))
+		cond := clientv3.Compare(clientv3.CreateRevision(keyID), "=", 0)
+		ifs = append(ifs, (*pb.Compare)(&cond))
 
 		// THEN put the secret
 		thens = append(thens, &pb.RequestOp{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{
 			Key:   []byte(keyID),
-			Value: secret,
+			Value: secrets[id],
 			Lease: leaseID,
 		}}})
 
@@ -199,6 +223,112 @@ func (e *Etcd) SetSecrets(ctx context.Context, secrets map[string][]byte, ttl in
 	return errors.Join(errs...)
 }
 
+// RotateSecrets replaces the values of the given existing secrets in a single transaction.
+// The secrets are bound to a new lease with the given TTL, or never expire if ttl <= 0.
+// The operation will either succeed for all, or fail for all.
+// If any of the secrets doesn't exist, the operation will fail.
+//
+// Since the operation isn't split across transactions, at most [builder.MaxTxnOps] secrets can be rotated at once.
+func (e *Etcd) RotateSecrets(ctx context.Context, secrets map[string][]byte, ttl int64) (retErr error) {
+	if maxTxnOps := cmp.Or(e.maxTxnOps, builder.MaxTxnOps); len(secrets) > maxTxnOps {
+		return fmt.Errorf("rotating %d secrets exceeds the limit of %d secrets per transaction", len(secrets), maxTxnOps)
+	}
+
+	leaseID, err := e.grantLease(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+			e.revokeLease(cleanupCtx, leaseID)
+		}
+	}()
+
+	var ifs []*pb.Compare
+	var thens []*pb.RequestOp
+	var elses []*pb.RequestOp
+
+	ids := slices.Sorted(maps.Keys(secrets))
+	for _, id := range ids {
+		keyID := constants.EtcdInferenceSecretPrefix + id
+
+		// IF the key exists (CreateRevision > 0)
+		cond := clientv3.Compare(clientv3.CreateRevision(keyID), ">", 0)
+		ifs = append(ifs, (*pb.Compare)(&cond))
+
+		// THEN overwrite the secret
+		thens = append(thens, &pb.RequestOp{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{
+			Key:   []byte(keyID),
+			Value: secrets[id],
+			Lease: leaseID,
+		}}})
+
+		// ELSE get the secret, so we can write an error message for the missing keys
+		elses = append(elses, &pb.RequestOp{Request: &pb.RequestOp_RequestRange{RequestRange: &pb.RangeRequest{Key: []byte(keyID)}}})
+	}
+
+	resp, err := e.server.Txn(authCtx(ctx, e.etcdMemberCert), &pb.TxnRequest{
+		Compare: ifs,
+		Success: thens,
+		Failure: elses,
+	})
+	if err != nil {
+		return fmt.Errorf("writing transaction to etcd: %w", err)
+	}
+
+	if !resp.Succeeded {
+		var errs []error
+		// The responses are in the same order as the requested keys
+		for i, r := range resp.Responses {
+			if get := r.GetResponseRange(); get != nil && len(get.Kvs) == 0 && i < len(ids) {
+				errs = append(errs, fmt.Errorf("secret %q does not exist", ids[i]))
+			}
+		}
+		if errs == nil {
+			return errors.New("failed rotating secrets in etcd")
+		}
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// grantLease creates a lease with the given TTL and returns its ID.
+// If ttl <= 0, no lease is created and 0 is returned.
+func (e *Etcd) grantLease(ctx context.Context, ttl int64) (int64, error) {
+	if ttl <= 0 {
+		return 0, nil
+	}
+	leaseResp, err := e.server.LeaseGrant(authCtx(ctx, e.etcdMemberCert), &pb.LeaseGrantRequest{
+		TTL: ttl,
+		ID:  0, // Let etcd generate a lease ID for us
+	})
+	if err != nil {
+		return 0, fmt.Errorf("creating lease for secrets: %w", err)
+	}
+	return leaseResp.ID, nil
+}
+
+// cleanupContext returns a context for cleaning up after a failed write.
+// It keeps the values of ctx, but isn't canceled with it, so a canceled request
+// doesn't leave partially written secrets or leases behind.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
+
+// revokeLease revokes the lease with the given ID after a failed transaction.
+// Errors are only logged, since the lease expires anyway.
+func (e *Etcd) revokeLease(ctx context.Context, leaseID int64) {
+	if leaseID == 0 {
+		return
+	}
+	if _, err := e.server.LeaseRevoke(authCtx(ctx, e.etcdMemberCert), &pb.LeaseRevokeRequest{ID: leaseID}); err != nil {
+		e.log.Warn("Failed to revoke lease after failed transaction", "error", err, "leaseID", leaseID)
+	}
+}
+
 // DeleteSecrets deletes the list of secrets from the etcd backend.
 // The operation will either succeed for all, or fail for all.
 // If any of the secret that should be deleted don't exist, the operation will fail.
@@ -209,8 +339,8 @@ func (e *Etcd) DeleteSecrets(ctx context.Context, secrets []string) error {
 	for _, id := range secrets {
 		keyID := constants.EtcdInferenceSecretPrefix + id
 		// IF the key exists (CreateRevision > 0)
-		cmp := clientv3.Compare(clientv3.CreateRevision(keyID), ">", 0)
-		ifs = append(ifs, (*pb.Compare)(&cmp))
+		cond := clientv3.Compare(clientv3.CreateRevision(keyID), ">", 0)
+		ifs = append(ifs, (*pb.Compare)(&cond))
 
 		// THEN delete the secret
 		thens = append(thens, &pb.RequestOp{Request: &pb.RequestOp_RequestDeleteRange{RequestDeleteRange: &pb.DeleteRangeRequest{
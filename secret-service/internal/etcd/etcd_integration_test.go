@@ -74,6 +74,11 @@ func TestEtcd(t *testing.T) {
 	err = etcdServer.SetSecrets(ctx, map[string][]byte{"16_byte_key": bytes.Repeat([]byte("A"), 16)}, 0)
 	assert.Error(err, "Setting an already existing key should fail")
 
+	// Test that rotation overwrites existing secrets
+	assert.NoError(etcdServer.RotateSecrets(ctx, map[string][]byte{"16_byte_key": bytes.Repeat([]byte("D"), 16)}, 0))
+	err = etcdServer.RotateSecrets(ctx, map[string][]byte{"16_byte_key": bytes.Repeat([]byte("E"), 16), "does_not_exist": []byte("F")}, 0)
+	assert.Error(err, "Rotating a non existent key should fail")
+
 	// Test that deletion works as expected
 	err = etcdServer.DeleteSecrets(ctx, []string{"does_not_exist"})
 	assert.Error(err, "Deletion of non existent key should fail")
@@ -3,9 +3,14 @@ package etcd
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"log/slog"
+	"strings"
 	"testing"
 
+	"github.com/edgelesssys/continuum/internal/oss/constants"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 )
@@ -91,6 +96,139 @@ func TestSetSecrets(t *testing.T) {
 	}
 }
 
+func TestSetSecretsChunked(t *testing.T) {
+	const maxTxnOps = 4
+	secrets := map[string][]byte{}
+	for i := range 10 {
+		secrets[fmt.Sprintf("key%d", i)] = bytes.Repeat([]byte{0x01}, 32)
+	}
+
+	testCases := map[string]struct {
+		server          *stubEtcdServer
+		wantErr         bool
+		wantRollbackErr bool
+		wantTxns        int
+	}{
+		"all chunks succeed": {
+			server:   &stubEtcdServer{txnResponse: &pb.TxnResponse{Succeeded: true}},
+			wantTxns: 3,
+		},
+		"first chunk fails": {
+			server: &stubEtcdServer{
+				txnResponse: &pb.TxnResponse{Succeeded: false},
+			},
+			wantErr:  true,
+			wantTxns: 1,
+		},
+		"last chunk fails": {
+			server: &stubEtcdServer{
+				txnResponses: []*pb.TxnResponse{{Succeeded: true}, {Succeeded: true}, {Succeeded: false}},
+				// Deleting the secrets of the first two chunks
+				txnResponse: &pb.TxnResponse{Succeeded: true},
+			},
+			wantErr:  true,
+			wantTxns: 5,
+		},
+		"rollback fails": {
+			server: &stubEtcdServer{
+				txnResponses: []*pb.TxnResponse{{Succeeded: true}, {Succeeded: false}},
+				txnResponse:  &pb.TxnResponse{Succeeded: false},
+			},
+			wantErr:         true,
+			wantRollbackErr: true,
+			wantTxns:        3,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			e := &Etcd{server: tc.server, maxTxnOps: maxTxnOps}
+
+			err := e.SetSecrets(t.Context(), secrets, 0)
+			require.Len(tc.server.txnRequests, tc.wantTxns)
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			written := map[string][]byte{}
+			deleted := map[string]struct{}{}
+			for _, req := range tc.server.txnRequests {
+				assert.LessOrEqual(len(req.Compare), maxTxnOps)
+				for _, op := range req.Success {
+					if put := op.GetRequestPut(); put != nil {
+						written[string(put.Key)] = put.Value
+					}
+					if del := op.GetRequestDeleteRange(); del != nil {
+						deleted[string(del.Key)] = struct{}{}
+					}
+				}
+			}
+			if !tc.wantErr {
+				assert.Len(written, len(secrets))
+				for id, secret := range secrets {
+					assert.Equal(secret, written[constants.EtcdInferenceSecretPrefix+id])
+				}
+				assert.Empty(deleted)
+				return
+			}
+
+			// All secrets of succeeded transactions must be deleted again
+			var succeeded []string
+			for i, req := range tc.server.txnRequests {
+				if i < len(tc.server.txnResponses) && tc.server.txnResponses[i].Succeeded && req.Failure != nil {
+					for _, op := range req.Success {
+						succeeded = append(succeeded, string(op.GetRequestPut().Key))
+					}
+				}
+			}
+			if tc.wantRollbackErr {
+				assert.ErrorContains(err, "rolling back written secrets")
+				return
+			}
+			assert.Len(deleted, len(succeeded))
+			for _, key := range succeeded {
+				assert.Contains(deleted, key)
+			}
+		})
+	}
+}
+
+func TestSetSecretsCleanupOutlivesCanceledRequest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secrets := map[string][]byte{}
+	for i := range 10 {
+		secrets[fmt.Sprintf("key%d", i)] = bytes.Repeat([]byte{0x01}, 32)
+	}
+
+	// The request is canceled after the first chunk was written
+	ctx, cancel := context.WithCancel(t.Context())
+	server := &stubEtcdServer{
+		txnResponses: []*pb.TxnResponse{{Succeeded: true}, {Succeeded: false}},
+		txnResponse:  &pb.TxnResponse{Succeeded: true},
+		leaseID:      42,
+		cancel:       cancel,
+	}
+	e := &Etcd{server: server, maxTxnOps: 4, log: slog.New(slog.DiscardHandler)}
+
+	err := e.SetSecrets(ctx, secrets, 60)
+	require.Error(err)
+	assert.NotContains(err.Error(), "rolling back written secrets")
+
+	// The rollback and lease revocation must not use the canceled context
+	require.Len(server.txnRequests, 3)
+	assert.NotEmpty(server.txnRequests[2].Success[0].GetRequestDeleteRange())
+	assert.True(server.leaseRevoked)
+	// Only the write of the second chunk sees the canceled context
+	assert.Equal(1, server.canceledCalls)
+}
+
 func TestDeleteSecrets(t *testing.T) {
 	testCases := map[string]struct {
 		server  *stubEtcdServer
@@ -138,22 +276,157 @@ func TestDeleteSecrets(t *testing.T) {
 	}
 }
 
+func TestRotateSecrets(t *testing.T) {
+	secrets := map[string][]byte{
+		"key1": bytes.Repeat([]byte{0x02}, 16),
+		"key2": bytes.Repeat([]byte{0x02}, 32),
+	}
+
+	testCases := map[string]struct {
+		server          *stubEtcdServer
+		secrets         map[string][]byte
+		ttl             int64
+		maxTxnOps       int
+		wantErr         string
+		wantLease       int64
+		wantLeaseRevoke bool
+		// cancelDuringTxn cancels the request context during the transaction.
+		cancelDuringTxn bool
+	}{
+		"rotate existing secrets": {
+			server:  &stubEtcdServer{txnResponse: &pb.TxnResponse{Succeeded: true}},
+			secrets: secrets,
+		},
+		"rotate with ttl": {
+			server:    &stubEtcdServer{txnResponse: &pb.TxnResponse{Succeeded: true}, leaseID: 42},
+			secrets:   secrets,
+			ttl:       60,
+			wantLease: 42,
+		},
+		"secret does not exist": {
+			server: &stubEtcdServer{
+				txnResponse: &pb.TxnResponse{
+					Succeeded: false,
+					Responses: []*pb.ResponseOp{
+						{Response: &pb.ResponseOp_ResponseRange{ResponseRange: &pb.RangeResponse{
+							Kvs: []*mvccpb.KeyValue{{Key: []byte(constants.EtcdInferenceSecretPrefix + "key1")}},
+						}}},
+						{Response: &pb.ResponseOp_ResponseRange{ResponseRange: &pb.RangeResponse{}}},
+					},
+				},
+				leaseID: 42,
+			},
+			secrets:         secrets,
+			ttl:             60,
+			wantErr:         `secret "key2" does not exist`,
+			wantLeaseRevoke: true,
+		},
+		"request canceled during transaction": {
+			server:          &stubEtcdServer{err: context.Canceled, leaseID: 42},
+			secrets:         secrets,
+			ttl:             60,
+			cancelDuringTxn: true,
+			wantErr:         context.Canceled.Error(),
+			wantLeaseRevoke: true,
+		},
+		"commit failure": {
+			server:  &stubEtcdServer{txnResponse: &pb.TxnResponse{Succeeded: false}},
+			secrets: secrets,
+			wantErr: "failed rotating secrets",
+		},
+		"commit error": {
+			server:  &stubEtcdServer{err: assert.AnError},
+			secrets: secrets,
+			wantErr: assert.AnError.Error(),
+		},
+		"too many secrets": {
+			server:    &stubEtcdServer{txnResponse: &pb.TxnResponse{Succeeded: true}},
+			secrets:   secrets,
+			maxTxnOps: 1,
+			wantErr:   "exceeds the limit",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if tc.cancelDuringTxn {
+				tc.server.cancel = cancel
+			}
+			e := &Etcd{server: tc.server, maxTxnOps: tc.maxTxnOps, log: slog.Default()}
+
+			err := e.RotateSecrets(ctx, tc.secrets, tc.ttl)
+			assert.Equal(tc.wantLeaseRevoke, tc.server.leaseRevoked)
+			assert.Zero(tc.server.canceledCalls, "the lease must be revoked even if the request is canceled")
+			if tc.wantErr != "" {
+				assert.ErrorContains(err, tc.wantErr)
+				return
+			}
+			require.NoError(err)
+
+			// All secrets are overwritten in a single transaction
+			require.Len(tc.server.txnRequests, 1)
+			req := tc.server.txnRequest
+			require.Len(req.Compare, len(tc.secrets))
+			require.Len(req.Success, len(tc.secrets))
+			require.Len(req.Failure, len(tc.secrets))
+			for _, compare := range req.Compare {
+				assert.Equal(pb.Compare_GREATER, compare.Result, "rotation must require the secret to exist")
+			}
+			for _, op := range req.Success {
+				put := op.GetRequestPut()
+				require.NotNil(put)
+				id := strings.TrimPrefix(string(put.Key), constants.EtcdInferenceSecretPrefix)
+				assert.Equal(tc.secrets[id], put.Value)
+				assert.Equal(tc.wantLease, put.Lease)
+			}
+		})
+	}
+}
+
 type stubEtcdServer struct {
 	txnRequest  *pb.TxnRequest
+	txnRequests []*pb.TxnRequest
 	txnResponse *pb.TxnResponse
-	err         error
+	// txnResponses overrides txnResponse for the first transactions, if set.
+	txnResponses []*pb.TxnResponse
+	err          error
+	leaseID      int64
+	leaseRevoked bool
+	// cancel is called after the first transaction, if set.
+	cancel context.CancelFunc
+	// canceledCalls counts the calls made with a canceled context.
+	canceledCalls int
 }
 
-func (s *stubEtcdServer) Txn(_ context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+func (s *stubEtcdServer) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if ctx.Err() != nil {
+		s.canceledCalls++
+	}
+	if s.cancel != nil {
+		defer s.cancel()
+	}
 	s.txnRequest = req
+	s.txnRequests = append(s.txnRequests, req)
+	if len(s.txnRequests) <= len(s.txnResponses) {
+		return s.txnResponses[len(s.txnRequests)-1], s.err
+	}
 	return s.txnResponse, s.err
 }
 
 func (s *stubEtcdServer) LeaseGrant(_ context.Context, _ *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
-	return nil, nil
+	return &pb.LeaseGrantResponse{ID: s.leaseID}, nil
 }
 
-func (s *stubEtcdServer) LeaseRevoke(_ context.Context, _ *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+func (s *stubEtcdServer) LeaseRevoke(ctx context.Context, _ *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	if ctx.Err() != nil {
+		s.canceledCalls++
+	}
+	s.leaseRevoked = true
 	return nil, nil
 }
 
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"os"
 	"path/filepath"
@@ -37,6 +38,13 @@ func main() {
 	k8sNamespace := flag.String("k8s-namespace", "", "kubernetes namespace of this secret-service instance")
 	logLevel := flag.String(logging.Flag, logging.DefaultFlagValue, logging.FlagInfo)
 	mayBootstrap := flag.Bool("may-bootstrap", false, "whether this instance is allowed to bootstrap the etcd cluster")
+	bootstrapWaitTimeout := flag.Duration("bootstrap-wait-timeout", 2*time.Minute,
+		"how long to wait for the bootstrapper instance to bootstrap the etcd cluster")
+	bootstrapPollInterval := flag.Duration("bootstrap-poll-interval", 10*time.Second,
+		"how often to check whether the etcd cluster has been bootstrapped while waiting for the bootstrapper instance, "+
+			"also the mean delay of the bootstrapper's final check before bootstrapping")
+	discoveryTimeout := flag.Duration("discovery-timeout", 10*time.Second,
+		"how long a single attempt to discover an existing etcd cluster may take")
 	flag.Parse()
 
 	log := logging.NewLogger(*logLevel)
@@ -50,6 +58,14 @@ func main() {
 		etcdCA:         *etcdCA,
 		k8sNamespace:   *k8sNamespace,
 		mayBootstrap:   *mayBootstrap,
+
+		bootstrapWaitTimeout:  *bootstrapWaitTimeout,
+		bootstrapPollInterval: *bootstrapPollInterval,
+		discoveryTimeout:      *discoveryTimeout,
+	}
+	if config.bootstrapWaitTimeout <= 0 || config.bootstrapPollInterval <= 0 || config.discoveryTimeout <= 0 {
+		log.Error("bootstrap-wait-timeout, bootstrap-poll-interval and discovery-timeout must be positive")
+		os.Exit(1)
 	}
 
 	if err := run(config, afero.Afero{Fs: afero.NewOsFs()}, log); err != nil {
@@ -66,13 +82,21 @@ type secretServiceConfig struct {
 	etcdCA         string
 	k8sNamespace   string
 	mayBootstrap   bool
+
+	bootstrapWaitTimeout  time.Duration
+	bootstrapPollInterval time.Duration
+	discoveryTimeout      time.Duration
 }
 
 func run(config secretServiceConfig, fs afero.Afero, log *slog.Logger) error {
 	ctx, cancel := process.SignalContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	etcdServer, etcdClose, err := joinOrBootstrapEtcd(ctx, config, fs, log)
+	newEtcd := func(ctx context.Context, joinMethod etcd.JoinMethod) (*etcd.Etcd, func(), error) {
+		return etcd.New(ctx, joinMethod, config.k8sNamespace,
+			config.etcdServerCert, config.etcdServerKey, config.etcdCA, fs, log)
+	}
+	etcdServer, etcdClose, err := joinOrBootstrapEtcd(ctx, config, newEtcd, log)
 	if err != nil {
 		return fmt.Errorf("joining or bootstrapping etcd: %w", err)
 	}
@@ -119,42 +143,57 @@ func run(config secretServiceConfig, fs afero.Afero, log *slog.Logger) error {
 	return err
 }
 
+// newEtcdFunc sets up etcd with the given join method, see [etcd.New].
+type newEtcdFunc func(ctx context.Context, joinMethod etcd.JoinMethod) (*etcd.Etcd, func(), error)
+
 // joinOrBootstrapEtcd sets up the etcd cluster by either joining an existing cluster or bootstrapping a new one.
 // It does so by performing the following steps:
 //
 //  1. Try to discover an existing etcd cluster in the network. If one exists, it will join the cluster.
 //  2. If no existing cluster is found, and if the current instance is marked as the etcd bootstrapper instance,
-//     it will bootstrap a new etcd cluster.
+//     it will bootstrap a new etcd cluster. Before bootstrapping, it waits for a jittered poll interval and
+//     repeats discovery. If a cluster appeared in the meantime, e.g., because another instance is misconfigured
+//     as bootstrapper, it fails instead of joining, so that the misconfiguration doesn't go unnoticed.
 //  3. If no existing cluster is found and the current instance is not the etcd bootstrapper instance,
 //     it will wait for the bootstrapper instance to bootstrap the cluster.
 //
 // The returned close function is expected to be handled by the caller to gracefully shut down the etcd server.
 func joinOrBootstrapEtcd(
-	ctx context.Context, config secretServiceConfig, fs afero.Afero, log *slog.Logger,
+	ctx context.Context, config secretServiceConfig, newEtcd newEtcdFunc, log *slog.Logger,
 ) (*etcd.Etcd, func(), error) {
 	// Step 1: Try to discover an existing etcd cluster
 	log.Info("Discovering existing etcd cluster")
-	joinCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	etcdServer, etcdClose, err := etcd.New(joinCtx, etcd.Join, config.k8sNamespace,
-		config.etcdServerCert, config.etcdServerKey, config.etcdCA, fs, log)
+	etcdServer, etcdClose, err := discoverEtcd(ctx, config.discoveryTimeout, newEtcd, log)
+	if err != nil {
+		return nil, nil, err
+	}
 	if etcdServer != nil {
-		// If an existing cluster is found, return the etcd server and a no-op close function
 		log.Info("Found existing etcd cluster, joining it")
 		return etcdServer, etcdClose, nil
 	}
-	var joinErr *etcd.JoinError
-	if !errors.As(err, &joinErr) {
-		return nil, nil, fmt.Errorf("unexpected error while discovering etcd cluster: %w", err)
-	}
-	log.Info("Etcd discovery failed, proceeding to bootstrap or wait for bootstrapper instance",
-		"error", err)
 
 	if config.mayBootstrap {
 		// Step 2: If no existing cluster is found, and this instance is the etcd bootstrapper instance, bootstrap a new cluster
+		// Wait before checking once more, so that a concurrently bootstrapping instance has time to become discoverable.
+		wait := config.bootstrapPollInterval/2 + rand.N(config.bootstrapPollInterval)
+		log.Info("No existing etcd cluster found, checking once more before bootstrapping a new cluster", "wait", wait)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		etcdServer, etcdClose, err := discoverEtcd(ctx, config.discoveryTimeout, newEtcd, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		if etcdServer != nil {
+			etcdClose()
+			return nil, nil, errors.New("an etcd cluster appeared while preparing to bootstrap: " +
+				"another instance may be misconfigured as bootstrapper, refusing to bootstrap or join")
+		}
+
 		log.Info("No existing etcd cluster found, bootstrapping a new cluster")
-		etcdServer, etcdClose, err := etcd.New(ctx, etcd.Bootstrap, config.k8sNamespace,
-			config.etcdServerCert, config.etcdServerKey, config.etcdCA, fs, log)
+		etcdServer, etcdClose, err = newEtcd(ctx, etcd.Bootstrap)
 		if err != nil {
 			return nil, nil, fmt.Errorf("bootstrapping etcd: %w", err)
 		}
@@ -162,10 +201,36 @@ func joinOrBootstrapEtcd(
 	}
 
 	// Step 3: If no existing cluster is found and this instance is not the etcd bootstrapper instance, wait for the bootstrapper instance
-	log.Info("No existing etcd cluster found, waiting for the bootstrapper instance to bootstrap a new cluster")
-	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	log.Info("No existing etcd cluster found, waiting for the bootstrapper instance to bootstrap a new cluster",
+		"timeout", config.bootstrapWaitTimeout)
+	return waitForBootstrap(ctx, config.bootstrapWaitTimeout, config.bootstrapPollInterval, config.discoveryTimeout, newEtcd, log)
+}
+
+// discoverEtcd tries to join an existing etcd cluster, giving up after timeout.
+// If no cluster is found, it returns a nil server and no error.
+func discoverEtcd(ctx context.Context, timeout time.Duration, newEtcd newEtcdFunc, log *slog.Logger) (*etcd.Etcd, func(), error) {
+	joinCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	etcdServer, etcdClose, err := newEtcd(joinCtx, etcd.Join)
+	if etcdServer != nil {
+		return etcdServer, etcdClose, nil
+	}
+	var joinErr *etcd.JoinError
+	if !errors.As(err, &joinErr) {
+		return nil, nil, fmt.Errorf("unexpected error while discovering etcd cluster: %w", err)
+	}
+	log.Info("Etcd discovery failed", "error", err)
+	return nil, nil, nil
+}
+
+// waitForBootstrap tries to join an existing cluster every pollInterval until it succeeds, or until timeout has passed.
+// Each attempt may take up to discoveryTimeout.
+func waitForBootstrap(
+	ctx context.Context, timeout, pollInterval, discoveryTimeout time.Duration, newEtcd newEtcdFunc, log *slog.Logger,
+) (*etcd.Etcd, func(), error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -173,10 +238,9 @@ func joinOrBootstrapEtcd(
 			return nil, nil, fmt.Errorf("timed out waiting for etcd bootstrapper instance to bootstrap a cluster: %w", waitCtx.Err())
 		case <-ticker.C:
 			log.Info("Checking if cluster has been bootstrapped yet")
-			joinCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
-			etcdServer, etcdClose, err := etcd.New(joinCtx, etcd.Join, config.k8sNamespace,
-				config.etcdServerCert, config.etcdServerKey, config.etcdCA, fs, log)
+			joinCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+			etcdServer, etcdClose, err := newEtcd(joinCtx, etcd.Join)
+			cancel()
 			if etcdServer != nil {
 				log.Info("Successfully joined etcd cluster")
 				return etcdServer, etcdClose, nil
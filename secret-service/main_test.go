@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/continuum/secret-service/internal/etcd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinOrBootstrapEtcd(t *testing.T) {
+	testCases := map[string]struct {
+		mayBootstrap  bool
+		joinAfter     int // number of failed join attempts before joining succeeds, -1 for never
+		joinErr       error
+		wantBootstrap bool
+		wantErr       bool
+	}{
+		"existing cluster is joined": {
+			mayBootstrap: true,
+		},
+		"bootstrapper bootstraps without cluster": {
+			mayBootstrap:  true,
+			joinAfter:     -1,
+			wantBootstrap: true,
+		},
+		"bootstrapper fails if cluster appears before bootstrap": {
+			mayBootstrap: true,
+			joinAfter:    1,
+			wantErr:      true,
+		},
+		"non-bootstrapper waits for cluster": {
+			joinAfter: 2,
+		},
+		"non-bootstrapper times out": {
+			joinAfter: -1,
+			wantErr:   true,
+		},
+		"unexpected discovery error": {
+			mayBootstrap: true,
+			joinAfter:    -1,
+			joinErr:      errors.New("setting up etcd"),
+			wantErr:      true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			joined := &etcd.Etcd{}
+			bootstrapped := &etcd.Etcd{}
+			joinAttempts := 0
+			didBootstrap := false
+			newEtcd := func(_ context.Context, joinMethod etcd.JoinMethod) (*etcd.Etcd, func(), error) {
+				switch joinMethod {
+				case etcd.Bootstrap:
+					didBootstrap = true
+					return bootstrapped, func() {}, nil
+				case etcd.Join:
+					joinAttempts++
+					if tc.joinErr != nil {
+						return nil, nil, tc.joinErr
+					}
+					if tc.joinAfter >= 0 && joinAttempts > tc.joinAfter {
+						return joined, func() {}, nil
+					}
+					return nil, nil, &etcd.JoinError{}
+				}
+				return nil, nil, errors.New("unexpected join method")
+			}
+
+			config := secretServiceConfig{
+				mayBootstrap:          tc.mayBootstrap,
+				bootstrapWaitTimeout:  100 * time.Millisecond,
+				bootstrapPollInterval: 10 * time.Millisecond,
+				discoveryTimeout:      time.Second,
+			}
+			start := time.Now()
+			etcdServer, _, err := joinOrBootstrapEtcd(t.Context(), config, newEtcd, slog.Default())
+			if tc.mayBootstrap && tc.joinAfter != 0 && tc.joinErr == nil {
+				// The bootstrapper waits at least half a poll interval before its final discovery.
+				assert.GreaterOrEqual(time.Since(start), config.bootstrapPollInterval/2)
+			}
+			if tc.wantErr {
+				assert.Error(err)
+				assert.False(didBootstrap)
+				return
+			}
+			require.NoError(err)
+			assert.Equal(tc.wantBootstrap, didBootstrap)
+			if tc.wantBootstrap {
+				assert.Same(bootstrapped, etcdServer)
+			} else {
+				assert.Same(joined, etcdServer)
+			}
+		})
+	}
+}
+
+func TestWaitForBootstrap(t *testing.T) {
+	const (
+		timeout      = 200 * time.Millisecond
+		pollInterval = 20 * time.Millisecond
+	)
+
+	testCases := map[string]struct {
+		joinAfter int // number of failed join attempts before joining succeeds, 0 for never
+		wantErr   bool
+	}{
+		"joins after bootstrap": {
+			joinAfter: 3,
+		},
+		"times out": {
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			attempts := 0
+			newEtcd := func(context.Context, etcd.JoinMethod) (*etcd.Etcd, func(), error) {
+				attempts++
+				if tc.joinAfter > 0 && attempts > tc.joinAfter {
+					return &etcd.Etcd{}, func() {}, nil
+				}
+				return nil, nil, &etcd.JoinError{}
+			}
+
+			start := time.Now()
+			etcdServer, _, err := waitForBootstrap(t.Context(), timeout, pollInterval, time.Second, newEtcd, slog.Default())
+			elapsed := time.Since(start)
+
+			if tc.wantErr {
+				require.Error(err)
+				assert.ErrorIs(err, context.DeadlineExceeded)
+				assert.Nil(etcdServer)
+				assert.GreaterOrEqual(elapsed, timeout)
+				assert.Less(elapsed, timeout+pollInterval*5)
+				// polls happen every pollInterval until the timeout
+				assert.InDelta(int(timeout/pollInterval), attempts, 3)
+				return
+			}
+			require.NoError(err)
+			assert.NotNil(etcdServer)
+			assert.Equal(tc.joinAfter+1, attempts)
+			assert.Less(elapsed, timeout)
+		})
+	}
+}
+
+func TestDiscoveryTimeout(t *testing.T) {
+	const discoveryTimeout = 3 * time.Second
+
+	testCases := map[string]struct {
+		mayBootstrap bool
+		wantAttempts int
+	}{
+		"initial discovery and discovery before bootstrap": {
+			mayBootstrap: true,
+			wantAttempts: 2,
+		},
+		"initial discovery and polling discovery": {
+			wantAttempts: 3,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			var timeouts []time.Duration
+			newEtcd := func(ctx context.Context, joinMethod etcd.JoinMethod) (*etcd.Etcd, func(), error) {
+				if joinMethod == etcd.Bootstrap {
+					return &etcd.Etcd{}, func() {}, nil
+				}
+				deadline, ok := ctx.Deadline()
+				require.True(ok)
+				timeouts = append(timeouts, time.Until(deadline))
+				// The bootstrapper bootstraps after its last discovery attempt.
+				if len(timeouts) == tc.wantAttempts && !tc.mayBootstrap {
+					return &etcd.Etcd{}, func() {}, nil
+				}
+				return nil, nil, &etcd.JoinError{}
+			}
+
+			config := secretServiceConfig{
+				mayBootstrap:          tc.mayBootstrap,
+				bootstrapWaitTimeout:  time.Second,
+				bootstrapPollInterval: 10 * time.Millisecond,
+				discoveryTimeout:      discoveryTimeout,
+			}
+			_, _, err := joinOrBootstrapEtcd(t.Context(), config, newEtcd, slog.Default())
+			require.NoError(err)
+
+			require.Len(timeouts, tc.wantAttempts)
+			for _, timeout := range timeouts {
+				assert.LessOrEqual(timeout, discoveryTimeout)
+				assert.Greater(timeout, discoveryTimeout-time.Second)
+			}
+		})
+	}
+}